@@ -32,6 +32,7 @@ var (
 	VMIP            string
 	ContainLibsPath []string
 	FuseMount       []string
+	ImageArch       string
 
 	IsBoot          bool
 	IsFakeroot      bool
@@ -48,6 +49,7 @@ var (
 	VMErr           bool
 	NoNet           bool
 	IsSyOS          bool
+	OCIConfig       bool
 	disableCache    bool
 
 	NetNamespace  bool
@@ -578,6 +580,17 @@ var actionDropCapsFlag = cmdline.Flag{
 	ExcludedOS:   []string{cmdline.Darwin},
 }
 
+// --arch
+var actionArchFlag = cmdline.Flag{
+	ID:           "actionArchFlag",
+	Value:        &ImageArch,
+	DefaultValue: "",
+	Name:         "arch",
+	Usage:        "select the system partition built for this architecture in a multi-architecture image (default: host architecture)",
+	EnvKeys:      []string{"ARCH"},
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
 // --allow-setuid
 var actionAllowSetuidFlag = cmdline.Flag{
 	ID:           "actionAllowSetuidFlag",
@@ -589,6 +602,17 @@ var actionAllowSetuidFlag = cmdline.Flag{
 	ExcludedOS:   []string{cmdline.Darwin},
 }
 
+// --oci-config
+var actionOCIConfigFlag = cmdline.Flag{
+	ID:           "actionOCIConfigFlag",
+	Value:        &OCIConfig,
+	DefaultValue: false,
+	Name:         "oci-config",
+	Usage:        "run the ENTRYPOINT and CMD of the OCI image config the container was built from, in its WORKDIR",
+	EnvKeys:      []string{"OCI_CONFIG"},
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
 func init() {
 	initializePlugins()
 
@@ -614,6 +638,7 @@ func init() {
 	cmdManager.RegisterFlagForCmd(&actionAllowSetuidFlag, actionsInstanceCmd...)
 	cmdManager.RegisterFlagForCmd(&actionAppFlag, actionsCmd...)
 	cmdManager.RegisterFlagForCmd(&actionApplyCgroupsFlag, actionsInstanceCmd...)
+	cmdManager.RegisterFlagForCmd(&actionArchFlag, actionsInstanceCmd...)
 	cmdManager.RegisterFlagForCmd(&actionBindFlag, actionsInstanceCmd...)
 	cmdManager.RegisterFlagForCmd(&actionCleanEnvFlag, actionsInstanceCmd...)
 	cmdManager.RegisterFlagForCmd(&actionContainAllFlag, actionsInstanceCmd...)
@@ -637,9 +662,12 @@ func init() {
 	cmdManager.RegisterFlagForCmd(&actionNoNvidiaFlag, actionsInstanceCmd...)
 	cmdManager.RegisterFlagForCmd(&actionNoPrivsFlag, actionsInstanceCmd...)
 	cmdManager.RegisterFlagForCmd(&actionNvidiaFlag, actionsInstanceCmd...)
+	cmdManager.RegisterFlagForCmd(&actionOCIConfigFlag, RunCmd)
 	cmdManager.RegisterFlagForCmd(&actionOverlayFlag, actionsInstanceCmd...)
 	cmdManager.RegisterFlagForCmd(&commonPromptForPassphraseFlag, actionsInstanceCmd...)
 	cmdManager.RegisterFlagForCmd(&commonPEMFlag, actionsInstanceCmd...)
+	cmdManager.RegisterFlagForCmd(&commonKeyProviderFlag, actionsInstanceCmd...)
+	cmdManager.RegisterFlagForCmd(&keyringFlag, actionsInstanceCmd...)
 	cmdManager.RegisterFlagForCmd(&actionPidNamespaceFlag, actionsInstanceCmd...)
 	cmdManager.RegisterFlagForCmd(&actionPwdFlag, actionsCmd...)
 	cmdManager.RegisterFlagForCmd(&actionScratchFlag, actionsInstanceCmd...)
@@ -248,11 +248,18 @@ func execStarter(cobraCmd *cobra.Command, image string, args []string, name stri
 		engineConfig.AppendFilesPath(nvidia.IpcsPath(userPath)...)
 	}
 
+	// process working directory set by the OCI image config with --oci-config
+	ociCwd := ""
+
+	if OCIConfig && engineConfig.GetInstanceJoin() {
+		sylog.Fatalf("--oci-config can't be used with a running instance")
+	}
+
 	// early check for key material before we start engine so we can fail fast if missing
 	// we do not need this check when joining a running instance, just for starting a container
 	if !engineConfig.GetInstanceJoin() {
 		sylog.Debugf("Checking for encrypted system partition")
-		img, err := imgutil.Init(engineConfig.GetImage(), false)
+		img, err := imgutil.InitArch(engineConfig.GetImage(), false, ImageArch)
 		if err != nil {
 			sylog.Fatalf("could not open image %s: %s", engineConfig.GetImage(), err)
 		}
@@ -261,11 +268,28 @@ func execStarter(cobraCmd *cobra.Command, image string, args []string, name stri
 			sylog.Fatalf("no root filesystem found in %s", engineConfig.GetImage())
 		}
 
+		// encryption material given on the command line, shared
+		// by the container image and the encrypted overlays
+		var cmdKeyInfo *crypt.KeyInfo
+
 		// ensure we have decryption material
 		if img.Partitions[0].Type == imgutil.ENCRYPTSQUASHFS {
 			sylog.Debugf("Encrypted container filesystem detected")
 
-			keyInfo, err := getEncryptionMaterial(cobraCmd)
+			// keys wrapped for recipients are decrypted with the keyring
+			hasPGPKey, err := crypt.HasPGPKey(engineConfig.GetImage())
+			if err != nil {
+				sylog.Fatalf("While checking encryption material: %v", err)
+			}
+
+			var keyInfo crypt.KeyInfo
+			if hasPGPKey {
+				sylog.Verbosef("Using private keyring for encrypted container")
+				keyInfo, err = getPGPDecryptionMaterial()
+			} else {
+				keyInfo, err = getEncryptionMaterial(cobraCmd, engineConfig.GetImage())
+				cmdKeyInfo = &keyInfo
+			}
 			if err != nil {
 				sylog.Fatalf("While handling encryption material: %v", err)
 			}
@@ -278,12 +302,24 @@ func execStarter(cobraCmd *cobra.Command, image string, args []string, name stri
 			engineConfig.SetEncryptionKey(plaintextKey)
 		}
 
+		// encrypted overlay partitions embedded in the container
+		// image or in the overlay images
+		setOverlayEncryptionKeys(cobraCmd, engineConfig, img, cmdKeyInfo)
+
+		if OCIConfig {
+			args, ociCwd, err = ociConfigProcess(img, args)
+			if err != nil {
+				sylog.Fatalf("While applying OCI image config: %s", err)
+			}
+		}
+
 		// don't defer this call as in all cases it won't be
 		// called before execing starter, so it would leak the
 		// image file descriptor to the container process
 		img.File.Close()
 	}
 
+	engineConfig.SetImageArch(ImageArch)
 	engineConfig.SetBindPath(BindPaths)
 	if len(FuseMount) > 0 {
 		/* If --fusemount is given, imply --pid */
@@ -481,6 +517,8 @@ func execStarter(cobraCmd *cobra.Command, image string, args []string, name stri
 		engineConfig.SetCwd(pwd)
 		if PwdPath != "" {
 			generator.SetProcessCwd(PwdPath)
+		} else if ociCwd != "" {
+			generator.SetProcessCwd(ociCwd)
 		} else {
 			if engineConfig.GetContain() {
 				generator.SetProcessCwd(engineConfig.GetHomeDest())
@@ -593,3 +631,94 @@ func execStarter(cobraCmd *cobra.Command, image string, args []string, name stri
 		sylog.Fatalf("%s", err)
 	}
 }
+
+// setOverlayEncryptionKeys unwraps the keys of the encrypted overlay
+// partitions of the container image img and of the overlay images with
+// the encryption material given on the command line, keyInfo is the
+// material already retrieved for the container image if any.
+func setOverlayEncryptionKeys(cobraCmd *cobra.Command, engineConfig *singularityConfig.EngineConfig, img *imgutil.Image, keyInfo *crypt.KeyInfo) {
+	setKey := func(img *imgutil.Image) {
+		encrypted := false
+		for _, p := range img.Partitions {
+			if p.Type == imgutil.ENCRYPTEXT3 {
+				encrypted = true
+				break
+			}
+		}
+		if !encrypted {
+			return
+		}
+
+		sylog.Debugf("Encrypted overlay partition detected in %s", img.Path)
+
+		// the same encryption material is used for all overlays
+		if keyInfo == nil {
+			k, err := getEncryptionMaterial(cobraCmd, img.Path)
+			if err != nil {
+				sylog.Fatalf("While handling encryption material: %v", err)
+			}
+			keyInfo = &k
+		}
+
+		plaintextKey, err := crypt.PlaintextOverlayKey(*keyInfo, img.Path)
+		if err != nil {
+			sylog.Fatalf("Cannot retrieve overlay key from image %s: %+v", img.Path, err)
+		}
+		engineConfig.SetOverlayEncryptionKey(img.Path, plaintextKey)
+	}
+
+	if img.Type == imgutil.SIF {
+		setKey(img)
+	}
+
+	for _, overlay := range OverlayPath {
+		path := strings.SplitN(overlay, ":", 2)[0]
+
+		overlayImg, err := imgutil.Init(path, false)
+		if err != nil {
+			sylog.Fatalf("could not open overlay image %s: %s", path, err)
+		}
+		if overlayImg.Type == imgutil.SIF {
+			setKey(overlayImg)
+		}
+		overlayImg.File.Close()
+	}
+}
+
+// ociConfigProcess returns the process arguments and working directory
+// of a container run as described by the OCI image config the image was
+// built from: the entrypoint followed by the run arguments, or by the
+// command when there is no argument, executed in the image working
+// directory. The first element of args is the run action.
+func ociConfigProcess(img *imgutil.Image, args []string) ([]string, string, error) {
+	imgSpec, err := imgutil.GetOCIImageConfig(img)
+	if err != nil {
+		return nil, "", err
+	} else if imgSpec == nil {
+		return nil, "", fmt.Errorf("%s wasn't built from an OCI image", img.Path)
+	}
+	imgConfig := imgSpec.Config
+
+	process := append([]string{}, imgConfig.Entrypoint...)
+	if len(args) > 1 {
+		process = append(process, args[1:]...)
+	} else {
+		process = append(process, imgConfig.Cmd...)
+	}
+	if len(process) == 0 {
+		return nil, "", fmt.Errorf("no ENTRYPOINT or CMD in the OCI image config and no command given")
+	}
+
+	if imgConfig.User != "" {
+		sylog.Verbosef("Ignoring user %s of the OCI image config, running as the current user", imgConfig.User)
+	}
+
+	cwd := imgConfig.WorkingDir
+	if cwd == "" {
+		cwd = "/"
+	}
+
+	// the exec action sets the container environment,
+	// including the environment of the OCI image config
+	return append([]string{"/.singularity.d/actions/exec"}, process...), cwd, nil
+}
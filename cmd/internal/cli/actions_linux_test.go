@@ -0,0 +1,100 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	imgutil "github.com/sylabs/singularity/pkg/image"
+)
+
+func TestOCIConfigProcess(t *testing.T) {
+	f, err := ioutil.TempFile("", "oci-config-")
+	if err != nil {
+		t.Fatalf("failed to create temporary file: %s", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	full := `{"config":{"Entrypoint":["/docker-entrypoint.sh"],"Cmd":["nginx","-g","daemon off;"],"WorkingDir":"/data","User":"nginx"}}`
+	cmdOnly := `{"config":{"Cmd":["/bin/sh"]}}`
+	empty := `{"config":{}}`
+	if _, err := f.WriteString(full + cmdOnly + empty); err != nil {
+		t.Fatalf("failed to write file: %s", err)
+	}
+
+	section := func(offset, size int) []imgutil.Section {
+		return []imgutil.Section{{Name: imgutil.OCIImageConfigSection, Offset: uint64(offset), Size: uint64(size)}}
+	}
+	exec := "/.singularity.d/actions/exec"
+
+	tests := []struct {
+		name     string
+		sections []imgutil.Section
+		args     []string
+		process  []string
+		cwd      string
+		fail     bool
+	}{
+		{
+			name:     "entrypoint and command",
+			sections: section(0, len(full)),
+			args:     []string{"/.singularity.d/actions/run"},
+			process:  []string{exec, "/docker-entrypoint.sh", "nginx", "-g", "daemon off;"},
+			cwd:      "/data",
+		},
+		{
+			name:     "entrypoint and arguments",
+			sections: section(0, len(full)),
+			args:     []string{"/.singularity.d/actions/run", "nginx", "-t"},
+			process:  []string{exec, "/docker-entrypoint.sh", "nginx", "-t"},
+			cwd:      "/data",
+		},
+		{
+			name:     "command only",
+			sections: section(len(full), len(cmdOnly)),
+			args:     []string{"/.singularity.d/actions/run"},
+			process:  []string{exec, "/bin/sh"},
+			cwd:      "/",
+		},
+		{
+			name:     "arguments replace command",
+			sections: section(len(full), len(cmdOnly)),
+			args:     []string{"/.singularity.d/actions/run", "/bin/true"},
+			process:  []string{exec, "/bin/true"},
+			cwd:      "/",
+		},
+		{
+			name:     "no process",
+			sections: section(len(full)+len(cmdOnly), len(empty)),
+			args:     []string{"/.singularity.d/actions/run"},
+			fail:     true,
+		},
+		{
+			name: "no OCI image config",
+			args: []string{"/.singularity.d/actions/run"},
+			fail: true,
+		},
+	}
+	for _, tt := range tests {
+		img := &imgutil.Image{Path: f.Name(), Type: imgutil.SIF, File: f, Sections: tt.sections}
+		process, cwd, err := ociConfigProcess(img, tt.args)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("%s: unexpected success", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.name, err)
+		} else if !reflect.DeepEqual(process, tt.process) || cwd != tt.cwd {
+			t.Errorf("%s: got process %q in %s instead of %q in %s", tt.name, process, cwd, tt.process, tt.cwd)
+		}
+	}
+}
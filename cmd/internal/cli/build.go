@@ -23,7 +23,9 @@ import (
 
 var buildArgs struct {
 	sections   []string
+	recipients []string
 	arch       string
+	archMerge  bool
 	builderURL string
 	libraryURL string
 	detached   bool
@@ -35,6 +37,7 @@ var buildArgs struct {
 	remote     bool
 	sandbox    bool
 	update     bool
+	verity     bool
 }
 
 // -s|--sandbox
@@ -111,6 +114,16 @@ var buildArchFlag = cmdline.Flag{
 	EnvKeys:      []string{"BUILD_ARCH"},
 }
 
+// --arch-merge
+var buildArchMergeFlag = cmdline.Flag{
+	ID:           "buildArchMergeFlag",
+	Value:        &buildArgs.archMerge,
+	DefaultValue: false,
+	Name:         "arch-merge",
+	Usage:        "merge SIF images built for different architectures into a multi-architecture image",
+	EnvKeys:      []string{"ARCH_MERGE"},
+}
+
 // -d|--detached
 var buildDetachedFlag = cmdline.Flag{
 	ID:           "buildDetachedFlag",
@@ -183,10 +196,31 @@ var buildEncryptFlag = cmdline.Flag{
 	Usage:        "build an image with an encrypted file system",
 }
 
+// --recipient
+var buildRecipientFlag = cmdline.Flag{
+	ID:           "buildRecipientFlag",
+	Value:        &buildArgs.recipients,
+	DefaultValue: []string{},
+	Name:         "recipient",
+	Usage:        "encrypt the file system key for the public key matching this fingerprint, may be given multiple times",
+	EnvKeys:      []string{"RECIPIENT"},
+}
+
+// --verity
+var buildVerityFlag = cmdline.Flag{
+	ID:           "buildVerityFlag",
+	Value:        &buildArgs.verity,
+	DefaultValue: false,
+	Name:         "verity",
+	Usage:        "store a dm-verity hash tree of the file system in the SIF image",
+	EnvKeys:      []string{"VERITY"},
+}
+
 func init() {
 	cmdManager.RegisterCmd(buildCmd)
 
 	cmdManager.RegisterFlagForCmd(&buildArchFlag, buildCmd)
+	cmdManager.RegisterFlagForCmd(&buildArchMergeFlag, buildCmd)
 	cmdManager.RegisterFlagForCmd(&buildBuilderFlag, buildCmd)
 	cmdManager.RegisterFlagForCmd(&buildDetachedFlag, buildCmd)
 	cmdManager.RegisterFlagForCmd(&buildDisableCacheFlag, buildCmd)
@@ -196,10 +230,12 @@ func init() {
 	cmdManager.RegisterFlagForCmd(&buildLibraryFlag, buildCmd)
 	cmdManager.RegisterFlagForCmd(&buildNoCleanupFlag, buildCmd)
 	cmdManager.RegisterFlagForCmd(&buildNoTestFlag, buildCmd)
+	cmdManager.RegisterFlagForCmd(&buildRecipientFlag, buildCmd)
 	cmdManager.RegisterFlagForCmd(&buildRemoteFlag, buildCmd)
 	cmdManager.RegisterFlagForCmd(&buildSandboxFlag, buildCmd)
 	cmdManager.RegisterFlagForCmd(&buildSectionFlag, buildCmd)
 	cmdManager.RegisterFlagForCmd(&buildUpdateFlag, buildCmd)
+	cmdManager.RegisterFlagForCmd(&buildVerityFlag, buildCmd)
 	cmdManager.RegisterFlagForCmd(&commonForceFlag, buildCmd)
 	cmdManager.RegisterFlagForCmd(&commonNoHTTPSFlag, buildCmd)
 	cmdManager.RegisterFlagForCmd(&commonTmpDirFlag, buildCmd)
@@ -210,12 +246,14 @@ func init() {
 
 	cmdManager.RegisterFlagForCmd(&commonPromptForPassphraseFlag, buildCmd)
 	cmdManager.RegisterFlagForCmd(&commonPEMFlag, buildCmd)
+	cmdManager.RegisterFlagForCmd(&commonKeyProviderFlag, buildCmd)
+	cmdManager.RegisterFlagForCmd(&keyringFlag, buildCmd)
 }
 
 // buildCmd represents the build command.
 var buildCmd = &cobra.Command{
 	DisableFlagsInUseLine: true,
-	Args:                  cobra.ExactArgs(2),
+	Args:                  buildArgsCheck,
 
 	Use:              docs.BuildUse,
 	Short:            docs.BuildShort,
@@ -226,6 +264,18 @@ var buildCmd = &cobra.Command{
 	TraverseChildren: true,
 }
 
+// buildArgsCheck requires an image path and a build spec, or an image path
+// followed by at least two images to merge with --arch-merge.
+func buildArgsCheck(cmd *cobra.Command, args []string) error {
+	if buildArgs.archMerge {
+		if len(args) < 3 {
+			return fmt.Errorf("requires an image path followed by at least two images to merge, received %d argument(s)", len(args))
+		}
+		return nil
+	}
+	return cobra.ExactArgs(2)(cmd, args)
+}
+
 func preRun(cmd *cobra.Command, args []string) {
 	if buildArgs.fakeroot && !buildArgs.remote {
 		fakerootExec(args)
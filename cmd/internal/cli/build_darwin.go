@@ -18,6 +18,10 @@ func fakerootExec(cmdArgs []string) {
 }
 
 func runBuild(cmd *cobra.Command, args []string) {
+	if buildArgs.archMerge {
+		sylog.Fatalf("--arch-merge is not supported on this platform")
+	}
+
 	dest := args[0]
 	spec := args[1]
 
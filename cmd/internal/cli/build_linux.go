@@ -15,6 +15,7 @@ import (
 	"syscall"
 
 	"github.com/spf13/cobra"
+	"github.com/sylabs/singularity/internal/app/singularity"
 	"github.com/sylabs/singularity/internal/pkg/build"
 	"github.com/sylabs/singularity/internal/pkg/build/remotebuilder"
 	"github.com/sylabs/singularity/internal/pkg/buildcfg"
@@ -88,6 +89,11 @@ func fakerootExec(cmdArgs []string) {
 func runBuild(cmd *cobra.Command, args []string) {
 	ctx := context.TODO()
 
+	if buildArgs.archMerge {
+		runBuildArchMerge(args[0], args[1:])
+		return
+	}
+
 	if buildArgs.arch != runtime.GOARCH && !buildArgs.remote {
 		sylog.Fatalf("Requested architecture (%s) does not match host (%s). Cannot build locally.", buildArgs.arch, runtime.GOARCH)
 	}
@@ -108,11 +114,30 @@ func runBuild(cmd *cobra.Command, args []string) {
 	sylog.Infof("Build complete: %s", dest)
 }
 
+func runBuildArchMerge(dst string, srcs []string) {
+	if buildArgs.remote || buildArgs.sandbox || buildArgs.encrypt || buildArgs.verity {
+		sylog.Fatalf("--arch-merge is not compatible with --remote, --sandbox, --encrypt and --verity")
+	}
+
+	// check if target collides with existing file
+	if err := checkBuildTarget(dst); err != nil {
+		sylog.Fatalf("%s", err)
+	}
+
+	if err := singularity.ArchMerge(dst, srcs); err != nil {
+		sylog.Fatalf("While merging images: %s", err)
+	}
+	sylog.Infof("Build complete: %s", dst)
+}
+
 func runBuildRemote(ctx context.Context, cmd *cobra.Command, dst, spec string) {
 	// building encrypted containers on the remote builder is not currently supported
-	if buildArgs.encrypt {
+	if buildArgs.encrypt || len(buildArgs.recipients) > 0 {
 		sylog.Fatalf("Building encrypted container with the remote builder is not currently supported.")
 	}
+	if buildArgs.verity {
+		sylog.Fatalf("Building container with a verity hash tree with the remote builder is not currently supported.")
+	}
 
 	handleRemoteBuildFlags(cmd)
 
@@ -187,16 +212,30 @@ func runBuildRemote(ctx context.Context, cmd *cobra.Command, dst, spec string) {
 
 func runBuildLocal(ctx context.Context, cmd *cobra.Command, dst, spec string) {
 	var keyInfo *crypt.KeyInfo
-	if buildArgs.encrypt || promptForPassphrase || cmd.Flags().Lookup("pem-path").Changed {
+	if buildArgs.encrypt || promptForPassphrase || cmd.Flags().Lookup("pem-path").Changed || keyProvider != "" || len(buildArgs.recipients) > 0 {
 		if os.Getuid() != 0 {
 			sylog.Fatalf("You must be root to build an encrypted container")
 		}
 
-		k, err := getEncryptionMaterial(cmd)
+		var k crypt.KeyInfo
+		var err error
+		if len(buildArgs.recipients) > 0 {
+			if promptForPassphrase || cmd.Flags().Lookup("pem-path").Changed || keyProvider != "" {
+				sylog.Fatalf("--recipient cannot be used with --passphrase, --pem-path or --key-provider")
+			}
+			sylog.Verbosef("Using recipient public keys for encrypted container")
+			k, err = getPGPEncryptionMaterial(buildArgs.recipients)
+		} else {
+			k, err = getEncryptionMaterial(cmd, "")
+		}
 		if err != nil {
 			sylog.Fatalf("While handling encryption material: %v", err)
 		}
 		keyInfo = &k
+
+		if buildArgs.verity {
+			sylog.Fatalf("--verity cannot be used with an encrypted container")
+		}
 	} else {
 		_, passphraseEnvOK := os.LookupEnv("SINGULARITY_ENCRYPTION_PASSPHRASE")
 		_, pemPathEnvOK := os.LookupEnv("SINGULARITY_ENCRYPTION_PEM_PATH")
@@ -242,6 +281,9 @@ func runBuildLocal(ctx context.Context, cmd *cobra.Command, dst, spec string) {
 	if buildArgs.sandbox {
 		buildFormat = "sandbox"
 	}
+	if buildArgs.verity && buildFormat != "sif" {
+		sylog.Fatalf("--verity can only be used when building a SIF image")
+	}
 
 	b, err := build.New(
 		defs,
@@ -262,6 +304,7 @@ func runBuildLocal(ctx context.Context, cmd *cobra.Command, dst, spec string) {
 				LibraryAuthToken:  authToken,
 				DockerAuthConfig:  &authConf,
 				EncryptionKeyInfo: keyInfo,
+				Verity:            buildArgs.verity,
 			},
 		})
 	if err != nil {
@@ -329,14 +372,28 @@ func handleBuildFlags(cmd *cobra.Command) {
 // passed to the crypt package for handling.
 // This handles the SINGULARITY_ENCRYPTION_PASSPHRASE/PEM_PATH envvars outside of cobra in order to
 // enforce the unique flag/env precidence for the encryption flow
-func getEncryptionMaterial(cmd *cobra.Command) (crypt.KeyInfo, error) {
+// The key providers receive the ID of image, empty for images being created.
+func getEncryptionMaterial(cmd *cobra.Command, image string) (crypt.KeyInfo, error) {
 	passphraseFlag := cmd.Flags().Lookup("passphrase")
 	PEMFlag := cmd.Flags().Lookup("pem-path")
 	passphraseEnv, passphraseEnvOK := os.LookupEnv("SINGULARITY_ENCRYPTION_PASSPHRASE")
 	pemPathEnv, pemPathEnvOK := os.LookupEnv("SINGULARITY_ENCRYPTION_PEM_PATH")
 
+	if keyProvider != "" {
+		if PEMFlag.Changed || passphraseFlag.Changed {
+			sylog.Fatalf("--key-provider cannot be used with --passphrase or --pem-path")
+		}
+		sylog.Verbosef("Using key provider %s for encrypted container", keyProvider)
+		return getKeyProviderMaterial(keyProvider, image)
+	}
+
 	// checks for no flags/envvars being set
 	if !(PEMFlag.Changed || pemPathEnvOK || passphraseFlag.Changed || passphraseEnvOK) {
+		// fallback on the key provider set by the administrator
+		if cfg, err := config.ParseFile(buildcfg.SINGULARITY_CONF_FILE); err == nil && cfg.KeyProvider != "" {
+			sylog.Verbosef("Using configured key provider %s for encrypted container", cfg.KeyProvider)
+			return getKeyProviderMaterial(cfg.KeyProvider, image)
+		}
 		sylog.Fatalf("Unable to use container encryption. Must supply encryption material through enironment variables or flags.")
 	}
 
@@ -393,3 +450,28 @@ func getEncryptionMaterial(cmd *cobra.Command) (crypt.KeyInfo, error) {
 
 	return crypt.KeyInfo{}, nil
 }
+
+// getKeyProviderMaterial returns the key information of image returned by
+// the key provider spec, the exec provider runs the helper configured in
+// singularity.conf when no path is given.
+func getKeyProviderMaterial(spec, image string) (crypt.KeyInfo, error) {
+	cfg, err := config.ParseFile(buildcfg.SINGULARITY_CONF_FILE)
+	if err != nil {
+		return crypt.KeyInfo{}, fmt.Errorf("unable to parse singularity configuration file: %s", err)
+	}
+
+	provider, err := crypt.NewKeyProvider(spec, cfg.KeyProviderHelper)
+	if err != nil {
+		return crypt.KeyInfo{}, err
+	}
+
+	imageID := ""
+	if image != "" {
+		imageID, err = crypt.ImageID(image)
+		if err != nil {
+			return crypt.KeyInfo{}, err
+		}
+	}
+
+	return provider.Key(imageID)
+}
@@ -0,0 +1,151 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/sylabs/singularity/docs"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+	"github.com/sylabs/singularity/pkg/sypgp"
+	"github.com/sylabs/singularity/pkg/util/crypt"
+	"golang.org/x/crypto/openpgp"
+)
+
+func init() {
+	cmdManager.RegisterCmd(CryptCmd)
+
+	cmdManager.RegisterSubCmd(CryptCmd, CryptAddRecipientCmd)
+	cmdManager.RegisterSubCmd(CryptCmd, CryptRemoveRecipientCmd)
+
+	cmdManager.RegisterFlagForCmd(&keyringFlag, CryptAddRecipientCmd, CryptRemoveRecipientCmd)
+}
+
+// CryptCmd is the 'crypt' command that allows management of the keys of
+// encrypted images
+var CryptCmd = &cobra.Command{
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return errors.New("Invalid command")
+	},
+	DisableFlagsInUseLine: true,
+
+	Use:           docs.CryptUse,
+	Short:         docs.CryptShort,
+	Long:          docs.CryptLong,
+	Example:       docs.CryptExample,
+	SilenceErrors: true,
+}
+
+// CryptAddRecipientCmd is `singularity crypt add-recipient <image> <fingerprint>...' command
+var CryptAddRecipientCmd = &cobra.Command{
+	Args:                  cobra.MinimumNArgs(2),
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := doCryptAddRecipientCmd(args[0], args[1:]); err != nil {
+			sylog.Fatalf("Unable to add recipients: %s", err)
+		}
+	},
+
+	Use:     docs.CryptAddRecipientUse,
+	Short:   docs.CryptAddRecipientShort,
+	Long:    docs.CryptAddRecipientLong,
+	Example: docs.CryptAddRecipientExample,
+}
+
+// CryptRemoveRecipientCmd is `singularity crypt remove-recipient <image> <fingerprint>...' command
+var CryptRemoveRecipientCmd = &cobra.Command{
+	Args:                  cobra.MinimumNArgs(2),
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := doCryptRemoveRecipientCmd(args[0], args[1:]); err != nil {
+			sylog.Fatalf("Unable to remove recipients: %s", err)
+		}
+	},
+
+	Use:     docs.CryptRemoveRecipientUse,
+	Short:   docs.CryptRemoveRecipientShort,
+	Long:    docs.CryptRemoveRecipientLong,
+	Example: docs.CryptRemoveRecipientExample,
+}
+
+func doCryptAddRecipientCmd(image string, fingerprints []string) error {
+	recipients, err := findRecipients(fingerprints)
+	if err != nil {
+		return err
+	}
+	keys, err := keyringHandle().LoadPrivKeyring()
+	if err != nil {
+		return fmt.Errorf("could not load private keyring: %s", err)
+	}
+
+	n, err := crypt.AddPGPRecipients(image, keys, pgpKeyPrompt, recipients)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Added %d recipient(s) to %s\n", n, image)
+	return nil
+}
+
+func doCryptRemoveRecipientCmd(image string, fingerprints []string) error {
+	recipients, err := findRecipients(fingerprints)
+	if err != nil {
+		return err
+	}
+
+	n, err := crypt.RemovePGPRecipients(image, recipients)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Removed %d encrypted key(s) from %s\n", n, image)
+	return nil
+}
+
+// findRecipients returns the public keys of the keyring matching the
+// fingerprints of the recipients of an image.
+func findRecipients(fingerprints []string) (openpgp.EntityList, error) {
+	for _, fp := range fingerprints {
+		if len(fp) != 40 {
+			return nil, fmt.Errorf("please provide full fingerprints(40 chars), got %q", fp)
+		}
+	}
+	return keyringHandle().FindPubKeys(fingerprints)
+}
+
+// getPGPEncryptionMaterial returns the key information to encrypt the
+// filesystem key of an image for the keys matching fingerprints.
+func getPGPEncryptionMaterial(fingerprints []string) (crypt.KeyInfo, error) {
+	recipients, err := findRecipients(fingerprints)
+	if err != nil {
+		return crypt.KeyInfo{}, err
+	}
+	return crypt.KeyInfo{Format: crypt.PGP, Keys: recipients}, nil
+}
+
+// getPGPDecryptionMaterial returns the key information to decrypt the
+// filesystem key of an image with the private keys of the keyring.
+func getPGPDecryptionMaterial() (crypt.KeyInfo, error) {
+	keys, err := keyringHandle().LoadPrivKeyring()
+	if err != nil {
+		return crypt.KeyInfo{}, fmt.Errorf("could not load private keyring: %s", err)
+	}
+	return crypt.KeyInfo{Format: crypt.PGP, Keys: keys, Prompt: pgpKeyPrompt}, nil
+}
+
+// pgpKeyPrompt asks for the passphrase of the first of the encrypted
+// private keys matching a recipient of an image.
+func pgpKeyPrompt(keys []openpgp.Key, symmetric bool) ([]byte, error) {
+	if symmetric || len(keys) == 0 {
+		return nil, fmt.Errorf("no private key to decrypt")
+	}
+	e := keys[0].Entity
+	msg := fmt.Sprintf("Enter passphrase of key %X: ", e.PrimaryKey.Fingerprint)
+	if err := sypgp.DecryptKey(e, msg); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
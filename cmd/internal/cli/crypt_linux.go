@@ -0,0 +1,102 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/sylabs/singularity/docs"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+	"github.com/sylabs/singularity/internal/pkg/util/fs"
+	"github.com/sylabs/singularity/internal/pkg/util/interactive"
+	"github.com/sylabs/singularity/pkg/cmdline"
+	"github.com/sylabs/singularity/pkg/util/crypt"
+)
+
+var (
+	cryptRekeyNewPassphrase bool
+	cryptRekeyNewPEMPath    string
+)
+
+// --new-passphrase
+var cryptRekeyNewPassphraseFlag = cmdline.Flag{
+	ID:           "cryptRekeyNewPassphraseFlag",
+	Value:        &cryptRekeyNewPassphrase,
+	DefaultValue: false,
+	Name:         "new-passphrase",
+	Usage:        "prompt for the new encryption passphrase",
+}
+
+// --new-pem-path
+var cryptRekeyNewPEMPathFlag = cmdline.Flag{
+	ID:           "cryptRekeyNewPEMPathFlag",
+	Value:        &cryptRekeyNewPEMPath,
+	DefaultValue: "",
+	Name:         "new-pem-path",
+	Usage:        "enter the path to the new PEM formated RSA key",
+	EnvKeys:      []string{"NEW_ENCRYPTION_PEM_PATH"},
+}
+
+func init() {
+	cmdManager.RegisterSubCmd(CryptCmd, CryptRekeyCmd)
+
+	cmdManager.RegisterFlagForCmd(&commonPromptForPassphraseFlag, CryptRekeyCmd)
+	cmdManager.RegisterFlagForCmd(&commonPEMFlag, CryptRekeyCmd)
+	cmdManager.RegisterFlagForCmd(&commonKeyProviderFlag, CryptRekeyCmd)
+	cmdManager.RegisterFlagForCmd(&cryptRekeyNewPassphraseFlag, CryptRekeyCmd)
+	cmdManager.RegisterFlagForCmd(&cryptRekeyNewPEMPathFlag, CryptRekeyCmd)
+}
+
+// CryptRekeyCmd is `singularity crypt rekey <image>' command
+var CryptRekeyCmd = &cobra.Command{
+	Args:                  cobra.ExactArgs(1),
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := doCryptRekeyCmd(cmd, args[0]); err != nil {
+			sylog.Fatalf("Unable to rekey image: %s", err)
+		}
+	},
+
+	Use:     docs.CryptRekeyUse,
+	Short:   docs.CryptRekeyShort,
+	Long:    docs.CryptRekeyLong,
+	Example: docs.CryptRekeyExample,
+}
+
+func doCryptRekeyCmd(cmd *cobra.Command, image string) error {
+	if cryptRekeyNewPassphrase == (cryptRekeyNewPEMPath != "") {
+		return fmt.Errorf("exactly one of --new-passphrase or --new-pem-path must be given")
+	}
+
+	oldKey, err := getEncryptionMaterial(cmd, image)
+	if err != nil {
+		return err
+	}
+
+	var newKey crypt.KeyInfo
+	if cryptRekeyNewPEMPath != "" {
+		if !fs.IsFile(cryptRekeyNewPEMPath) {
+			return fmt.Errorf("specified PEM file %s: does not exist", cryptRekeyNewPEMPath)
+		}
+		newKey = crypt.KeyInfo{Format: crypt.PEM, Path: cryptRekeyNewPEMPath}
+	} else {
+		passphrase, err := interactive.GetPassphrase("Enter new encryption passphrase: ", 3)
+		if err != nil {
+			return err
+		}
+		if passphrase == "" {
+			return fmt.Errorf("cannot encrypt container with empty passphrase")
+		}
+		newKey = crypt.KeyInfo{Format: crypt.Passphrase, Material: passphrase}
+	}
+
+	if err := crypt.Rekey(image, oldKey, newKey); err != nil {
+		return err
+	}
+	fmt.Printf("Encryption key of %s replaced\n", image)
+	return nil
+}
@@ -0,0 +1,244 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/sylabs/singularity/docs"
+	"github.com/sylabs/singularity/internal/app/singularity"
+	"github.com/sylabs/singularity/internal/pkg/buildcfg"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+	"github.com/sylabs/singularity/pkg/cmdline"
+)
+
+var (
+	eclConfigFile string
+	eclTestUser   string
+	eclGroup      singularity.EclGroup
+)
+
+// -c|--config
+var eclConfigFlag = cmdline.Flag{
+	ID:           "eclConfigFlag",
+	Value:        &eclConfigFile,
+	DefaultValue: buildcfg.ECL_FILE,
+	Name:         "config",
+	ShortHand:    "c",
+	Usage:        "path to the ECL configuration file",
+}
+
+// -u|--user
+var eclTestUserFlag = cmdline.Flag{
+	ID:           "eclTestUserFlag",
+	Value:        &eclTestUser,
+	DefaultValue: "",
+	Name:         "user",
+	ShortHand:    "u",
+	Usage:        "evaluate the decision as this user name or UID (root only for another user)",
+}
+
+// -m|--mode
+var eclGroupModeFlag = cmdline.Flag{
+	ID:           "eclGroupModeFlag",
+	Value:        &eclGroup.Mode,
+	DefaultValue: "whitelist",
+	Name:         "mode",
+	ShortHand:    "m",
+	Usage:        "execution group mode: whitelist, whitestrict, blacklist or sha256",
+}
+
+// -d|--dirpath
+var eclGroupDirPathFlag = cmdline.Flag{
+	ID:           "eclGroupDirPathFlag",
+	Value:        &eclGroup.DirPath,
+	DefaultValue: "",
+	Name:         "dirpath",
+	ShortHand:    "d",
+	Usage:        "directory, glob pattern or directory ending with /** of the group containers",
+}
+
+// -k|--keyfp
+var eclGroupKeyFPFlag = cmdline.Flag{
+	ID:           "eclGroupKeyFPFlag",
+	Value:        &eclGroup.KeyFPs,
+	DefaultValue: []string{},
+	Name:         "keyfp",
+	ShortHand:    "k",
+	Usage:        "fingerprint of a signing entity (can be specified multiple times)",
+}
+
+// --sha256
+var eclGroupSha256Flag = cmdline.Flag{
+	ID:           "eclGroupSha256Flag",
+	Value:        &eclGroup.Sha256,
+	DefaultValue: []string{},
+	Name:         "sha256",
+	Usage:        "allowed image sha256 digest for the sha256 mode (can be specified multiple times)",
+}
+
+func init() {
+	cmdManager.RegisterCmd(EclCmd)
+	cmdManager.RegisterSubCmd(EclCmd, EclValidateCmd)
+	cmdManager.RegisterSubCmd(EclCmd, EclTestCmd)
+	cmdManager.RegisterSubCmd(EclCmd, EclGroupCmd)
+	cmdManager.RegisterSubCmd(EclGroupCmd, EclGroupAddCmd)
+	cmdManager.RegisterSubCmd(EclGroupCmd, EclGroupRemoveCmd)
+
+	cmdManager.RegisterFlagForCmd(&eclConfigFlag, EclTestCmd, EclGroupAddCmd, EclGroupRemoveCmd)
+	cmdManager.RegisterFlagForCmd(&eclTestUserFlag, EclTestCmd)
+	cmdManager.RegisterFlagForCmd(&eclGroupModeFlag, EclGroupAddCmd)
+	cmdManager.RegisterFlagForCmd(&eclGroupDirPathFlag, EclGroupAddCmd)
+	cmdManager.RegisterFlagForCmd(&eclGroupKeyFPFlag, EclGroupAddCmd)
+	cmdManager.RegisterFlagForCmd(&eclGroupSha256Flag, EclGroupAddCmd)
+}
+
+// EclCmd is the 'ecl' command that groups the execution control list
+// management commands
+var EclCmd = &cobra.Command{
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return errors.New("Invalid command")
+	},
+	DisableFlagsInUseLine: true,
+
+	Use:           docs.EclUse,
+	Short:         docs.EclShort,
+	Long:          docs.EclLong,
+	Example:       docs.EclExample,
+	SilenceErrors: true,
+}
+
+// EclValidateCmd is 'singularity ecl validate' and validates an ECL
+// configuration file
+var EclValidateCmd = &cobra.Command{
+	Args:                  cobra.MaximumNArgs(1),
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		path := buildcfg.ECL_FILE
+		if len(args) > 0 {
+			path = args[0]
+		}
+
+		ecl, err := singularity.EclValidate(path)
+		if err != nil {
+			sylog.Fatalf("%s", err)
+		}
+
+		fmt.Printf("%s is valid, %d execution group(s)", path, len(ecl.ExecGroups))
+		if !ecl.Activated {
+			fmt.Printf(", ECL is not activated")
+		}
+		fmt.Println()
+	},
+
+	Use:     docs.EclValidateUse,
+	Short:   docs.EclValidateShort,
+	Long:    docs.EclValidateLong,
+	Example: docs.EclValidateExample,
+}
+
+// EclTestCmd is 'singularity ecl test' and shows how the ECL applies to
+// an image
+var EclTestCmd = &cobra.Command{
+	Args:                  cobra.ExactArgs(1),
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		d, activated, err := singularity.EclTest(eclConfigFile, args[0], eclTestUser)
+		if err != nil {
+			sylog.Fatalf("%s", err)
+		}
+
+		group := "none"
+		if d.Group != "" {
+			group = fmt.Sprintf("%s (%s)", d.Group, d.Mode)
+		}
+		signers := "none"
+		if len(d.Signers) > 0 {
+			signers = strings.Join(d.Signers, ", ")
+		}
+		decision := "BLOCKED"
+		if d.Allowed {
+			decision = "ALLOWED"
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "Image:\t%s\n", d.Image)
+		fmt.Fprintf(tw, "Digest:\tsha256:%s\n", d.Digest)
+		fmt.Fprintf(tw, "User:\t%s (%d)\n", d.User, d.UID)
+		fmt.Fprintf(tw, "Execgroup:\t%s\n", group)
+		fmt.Fprintf(tw, "Signers:\t%s\n", signers)
+		fmt.Fprintf(tw, "Decision:\t%s\n", decision)
+		if d.Reason != "" {
+			fmt.Fprintf(tw, "Reason:\t%s\n", d.Reason)
+		}
+		tw.Flush()
+
+		if !activated {
+			sylog.Warningf("The ECL is not activated in %s, all images are allowed to run", eclConfigFile)
+		}
+	},
+
+	Use:     docs.EclTestUse,
+	Short:   docs.EclTestShort,
+	Long:    docs.EclTestLong,
+	Example: docs.EclTestExample,
+}
+
+// EclGroupCmd is the 'ecl group' command that groups the execution group
+// management commands
+var EclGroupCmd = &cobra.Command{
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return errors.New("Invalid command")
+	},
+	DisableFlagsInUseLine: true,
+
+	Use:           docs.EclGroupUse,
+	Short:         docs.EclGroupShort,
+	Long:          docs.EclGroupLong,
+	Example:       docs.EclGroupExample,
+	SilenceErrors: true,
+}
+
+// EclGroupAddCmd is 'singularity ecl group add' and adds an execution group
+var EclGroupAddCmd = &cobra.Command{
+	Args:                  cobra.ExactArgs(1),
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		eclGroup.TagName = args[0]
+		if err := singularity.EclGroupAdd(eclConfigFile, eclGroup); err != nil {
+			sylog.Fatalf("%s", err)
+		}
+		fmt.Printf("Execution group %s added to %s\n", args[0], eclConfigFile)
+	},
+
+	Use:     docs.EclGroupAddUse,
+	Short:   docs.EclGroupAddShort,
+	Long:    docs.EclGroupAddLong,
+	Example: docs.EclGroupAddExample,
+}
+
+// EclGroupRemoveCmd is 'singularity ecl group remove' and removes an
+// execution group
+var EclGroupRemoveCmd = &cobra.Command{
+	Args:                  cobra.ExactArgs(1),
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := singularity.EclGroupRemove(eclConfigFile, args[0]); err != nil {
+			sylog.Fatalf("%s", err)
+		}
+		fmt.Printf("Execution group %s removed from %s\n", args[0], eclConfigFile)
+	},
+
+	Use:     docs.EclGroupRemoveUse,
+	Short:   docs.EclGroupRemoveShort,
+	Long:    docs.EclGroupRemoveLong,
+	Example: docs.EclGroupRemoveExample,
+}
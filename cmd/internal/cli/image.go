@@ -0,0 +1,32 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"errors"
+
+	"github.com/spf13/cobra"
+	"github.com/sylabs/singularity/docs"
+)
+
+func init() {
+	cmdManager.RegisterCmd(ImageCmd)
+	cmdManager.RegisterSubCmd(ImageCmd, ImageCheckCmd)
+}
+
+// ImageCmd is the 'image' command that groups image file management commands
+var ImageCmd = &cobra.Command{
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return errors.New("invalid command")
+	},
+	DisableFlagsInUseLine: true,
+
+	Use:           docs.ImageUse,
+	Short:         docs.ImageShort,
+	Long:          docs.ImageLong,
+	Example:       docs.ImageExample,
+	SilenceErrors: true,
+}
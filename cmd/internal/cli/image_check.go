@@ -0,0 +1,85 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/sylabs/singularity/docs"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+	"github.com/sylabs/singularity/pkg/cmdline"
+	"github.com/sylabs/singularity/pkg/image"
+)
+
+var imageCheckJSON bool
+
+// -j|--json
+var imageCheckJSONFlag = cmdline.Flag{
+	ID:           "imageCheckJSONFlag",
+	Value:        &imageCheckJSON,
+	DefaultValue: false,
+	Name:         "json",
+	ShortHand:    "j",
+	Usage:        "print the check report in json format",
+}
+
+func init() {
+	cmdManager.RegisterFlagForCmd(&imageCheckJSONFlag, ImageCheckCmd)
+}
+
+// ImageCheckCmd is 'singularity image check' and validates the structure of an image file
+var ImageCheckCmd = &cobra.Command{
+	DisableFlagsInUseLine: true,
+	Args:                  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		// args[0] contains image path
+		doImageCheckCmd(args[0])
+	},
+
+	Use:     docs.ImageCheckUse,
+	Short:   docs.ImageCheckShort,
+	Long:    docs.ImageCheckLong,
+	Example: docs.ImageCheckExample,
+}
+
+func doImageCheckCmd(path string) {
+	report, err := image.Check(path)
+	if err != nil {
+		sylog.Fatalf("Failed to check image %s: %s", path, err)
+	}
+
+	if imageCheckJSON {
+		b, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			sylog.Fatalf("Unable to format report as json: %s", err)
+		}
+		fmt.Printf("%s\n", b)
+		if !report.OK() {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if report.OK() {
+		sylog.Infof("%s image %s passed all checks", report.Format, report.Path)
+		return
+	}
+
+	for _, p := range report.Problems {
+		if p.Descriptor != 0 {
+			fmt.Printf("descriptor %d: %s\n", p.Descriptor, p.Message)
+		} else {
+			fmt.Printf("%s\n", p.Message)
+		}
+		if p.Hint != "" {
+			fmt.Printf("  hint: %s\n", p.Hint)
+		}
+	}
+	sylog.Fatalf("%s image %s failed %d check(s)", report.Format, report.Path, len(report.Problems))
+}
@@ -22,10 +22,12 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/sylabs/sif/pkg/sif"
 	"github.com/sylabs/singularity/docs"
+	"github.com/sylabs/singularity/internal/app/singularity"
 	"github.com/sylabs/singularity/internal/pkg/runtime/engine/config/oci"
 	"github.com/sylabs/singularity/internal/pkg/sylog"
 	"github.com/sylabs/singularity/internal/pkg/util/starter"
 	"github.com/sylabs/singularity/pkg/cmdline"
+	"github.com/sylabs/singularity/pkg/image"
 	"github.com/sylabs/singularity/pkg/runtime/engine/config"
 	singularityConfig "github.com/sylabs/singularity/pkg/runtime/engine/singularity/config"
 )
@@ -41,8 +43,18 @@ var (
 	environment bool
 	helpfile    bool
 	listApps    bool
+	inspectAll  bool
 )
 
+// --all
+var inspectAllFlag = cmdline.Flag{
+	ID:           "inspectAllFlag",
+	Value:        &inspectAll,
+	DefaultValue: false,
+	Name:         "all",
+	Usage:        "show all the image metadata in a single versioned json document",
+}
+
 // --list-apps
 var inspectAppsListFlag = cmdline.Flag{
 	ID:           "inspectAppsListFlag",
@@ -119,6 +131,7 @@ func init() {
 	cmdManager.RegisterFlagForCmd(&inspectRunscriptFlag, InspectCmd)
 	cmdManager.RegisterFlagForCmd(&inspectTestFlag, InspectCmd)
 	cmdManager.RegisterFlagForCmd(&inspectAppsListFlag, InspectCmd)
+	cmdManager.RegisterFlagForCmd(&inspectAllFlag, InspectCmd)
 }
 
 func getPathPrefix(appName string) string {
@@ -272,6 +285,11 @@ var InspectCmd = &cobra.Command{
 		}
 		sandboxImage := f.IsDir()
 
+		if inspectAll {
+			doInspectAll(args[0])
+			return
+		}
+
 		var fimg sif.FileImage
 		if !sandboxImage {
 			var err error
@@ -286,99 +304,51 @@ var InspectCmd = &cobra.Command{
 		inspectData.Type = containerType
 		inspectData.Data.Attributes.Labels = make(map[string]string, 1)
 
-		inspectShellCmd := []string{"/bin/sh", "-c", ""}
-
-		// Try to inspect the label partition, if not, then exec/shell
-		// the container to get the data.
+		// Try to inspect the label partition, if not, then read the
+		// container filesystem to get the data.
+		getLabels := false
 		if (labels || defaultToLabels()) && AppName == "" {
 			err := inspectLabelPartition(&inspectData, &fimg)
 			if err == errNoLabelPartition || err == errNoSIF {
 				sylog.Debugf("Cant get label partition, looking in container...")
-				inspectShellCmd[2] += getLabelsCommand(AppName)
+				getLabels = true
 			} else if err != nil {
 				sylog.Fatalf("Unable to inspect container: %s", err)
 			}
 		} else if (labels || defaultToLabels()) && AppName != "" {
-			// If '--app' is specified, then we need to shell/exec the
-			// container.
+			// If '--app' is specified, then we need to read the
+			// container filesystem.
 			sylog.Debugf("Inspection of labels selected.")
-			inspectShellCmd[2] += getLabelsCommand(AppName)
+			getLabels = true
 		}
 
 		// Inspect the deffile.
+		getDeffile := false
 		if deffile {
 			err := inspectDeffilePartition(&inspectData, &fimg)
 			if err == errNoLabelPartition || err == errNoSIF {
 				sylog.Debugf("Inspection of deffile selected.")
-				inspectShellCmd[2] += getDefinitionCommand()
+				getDeffile = true
 			} else if err != nil {
 				sylog.Fatalf("Unable to inspect deffile: %s", err)
 			}
 		}
 
-		if listApps {
-			sylog.Debugf("Listing all apps in container")
-			inspectShellCmd[2] += listAppsCommand
-		}
-
-		if helpfile {
-			sylog.Debugf("Inspection of helpfile selected.")
-			inspectShellCmd[2] += getHelpCommand(AppName)
-		}
-
-		if runscript {
-			sylog.Debugf("Inspection of runscript selected.")
-			inspectShellCmd[2] += getRunscriptCommand(AppName)
-		}
-
-		if testfile {
-			sylog.Debugf("Inspection of test selected.")
-			inspectShellCmd[2] += getTestCommand(AppName)
-		}
-
-		if environment {
-			sylog.Debugf("Inspection of environment selected.")
-			inspectShellCmd[2] += getEnvironmentCommand(AppName)
-		}
-
-		if inspectShellCmd[2] != "" {
+		if getLabels || getDeffile || listApps || helpfile || runscript || testfile || environment {
 			abspath, err := filepath.Abs(args[0])
 			if err != nil {
 				sylog.Fatalf("While determining absolute file path: %v", err)
 			}
-			name := filepath.Base(abspath)
 
-			// Execute the compound command string.
-			fileContents, err := getFileContent(abspath, name, inspectShellCmd)
-			if err != nil {
+			err = inspectRootFS(abspath, &inspectData, getLabels, getDeffile)
+			if err == image.ErrUnsupportedFS {
+				// Encrypted or ext3 root filesystems are only readable
+				// from a running container.
+				sylog.Debugf("Can't read image root filesystem, inspecting from a container")
+				inspectContainer(abspath, &inspectData, getLabels, getDeffile)
+			} else if err != nil {
 				sylog.Fatalf("Could not inspect container: %v", err)
 			}
-
-			// Parse the command output string into sections.
-			reader := bufio.NewReader(strings.NewReader(fileContents))
-			for {
-				section, err := reader.ReadBytes('\n')
-				if err != nil {
-					break
-				}
-				parts := strings.SplitN(strings.TrimSpace(string(section)), ":", 3)
-				if len(parts) == 2 {
-					label := parts[0]
-					sizeData, errConv := strconv.Atoi(parts[1])
-					if errConv != nil {
-						sylog.Fatalf("Badly formatted content, can't recover: %v", parts)
-					}
-					sylog.Debugf("Section %s found with %d bytes of data.", label, sizeData)
-					data := make([]byte, sizeData)
-					n, err := io.ReadFull(reader, data)
-					if n != len(data) && err != nil {
-						sylog.Fatalf("Unable to read %d bytes.", sizeData)
-					}
-					setAttribute(&inspectData, label, AppName, string(data))
-				} else {
-					sylog.Fatalf("Badly formatted content, can't recover: %v", parts)
-				}
-			}
 		}
 
 		// Output the inspection results (use JSON if requested).
@@ -424,6 +394,213 @@ var InspectCmd = &cobra.Command{
 	TraverseChildren: true,
 }
 
+// doInspectAll prints the metadata document of the image found at path,
+// the document is always in json format.
+func doInspectAll(path string) {
+	if AppName != "" {
+		sylog.Fatalf("--all can't be used with --app, metadata of all apps are reported")
+	}
+
+	md, err := singularity.InspectAll(path)
+	if err != nil {
+		sylog.Fatalf("Unable to inspect container: %s", err)
+	}
+
+	b, err := json.MarshalIndent(md, "", "\t")
+	if err != nil {
+		sylog.Fatalf("Could not format inspected data as JSON")
+	}
+	fmt.Printf("%s\n", b)
+}
+
+// inspectRootFS reads the container metadata selected by command flags
+// straight from the image root filesystem without starting a container.
+// It returns image.ErrUnsupportedFS if the root filesystem can't be read.
+func inspectRootFS(abspath string, inspectData *inspectFormat, getLabels, getDeffile bool) error {
+	img, err := image.Init(abspath, false)
+	if err != nil {
+		return err
+	}
+	defer img.File.Close()
+
+	rootfs, err := image.NewRootFS(img)
+	if err != nil {
+		return err
+	}
+
+	prefix := getPathPrefix(AppName)
+
+	if getLabels {
+		if err := inspectFile(rootfs, inspectData, prefix+"/labels.json", "labels"); err != nil {
+			return err
+		}
+	}
+	if getDeffile {
+		if err := inspectFile(rootfs, inspectData, getPathPrefix("")+"/Singularity", "deffile"); err != nil {
+			return err
+		}
+	}
+	if listApps {
+		sylog.Debugf("Listing all apps in container")
+		if err := inspectApps(rootfs, inspectData); err != nil {
+			return err
+		}
+	}
+	if helpfile {
+		sylog.Debugf("Inspection of helpfile selected.")
+		if err := inspectFile(rootfs, inspectData, prefix+"/runscript.help", "helpfile"); err != nil {
+			return err
+		}
+	}
+	if runscript {
+		sylog.Debugf("Inspection of runscript selected.")
+		if err := inspectFile(rootfs, inspectData, prefix+"/runscript", "runscript"); err != nil {
+			return err
+		}
+	}
+	if testfile {
+		sylog.Debugf("Inspection of test selected.")
+		if err := inspectFile(rootfs, inspectData, prefix+"/test", "test"); err != nil {
+			return err
+		}
+	}
+	if environment {
+		sylog.Debugf("Inspection of environment selected.")
+		if err := inspectEnvironment(rootfs, inspectData, prefix+"/env"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// inspectFile sets the attribute label with the content of the regular
+// file found at path in the container, missing files are ignored.
+func inspectFile(rootfs image.FS, inspectData *inspectFormat, path, label string) error {
+	fi, err := rootfs.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		return nil
+	}
+
+	data, err := rootfs.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	sylog.Debugf("Section %s found with %d bytes of data.", label, len(data))
+	setAttribute(inspectData, label, AppName, string(data))
+	return nil
+}
+
+// inspectEnvironment sets the environment attribute with the content of
+// the environment scripts found in the container directory dir.
+func inspectEnvironment(rootfs image.FS, inspectData *inspectFormat, dir string) error {
+	entries, err := rootfs.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if ok, _ := filepath.Match("9*-environment.sh", e.Name()); !ok {
+			continue
+		}
+		data, err := rootfs.ReadFile(dir + "/" + e.Name())
+		if err != nil {
+			return err
+		}
+		setAttribute(inspectData, e.Name(), AppName, string(data))
+	}
+	return nil
+}
+
+// inspectApps sets the apps attribute with the list of SCIF apps found
+// in the container.
+func inspectApps(rootfs image.FS, inspectData *inspectFormat) error {
+	entries, err := rootfs.ReadDir("/scif/apps")
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	var apps strings.Builder
+	for _, e := range entries {
+		fi, err := rootfs.Stat("/scif/apps/" + e.Name() + "/scif")
+		if err != nil || !fi.IsDir() {
+			continue
+		}
+		apps.WriteString(e.Name() + "\n")
+	}
+	setAttribute(inspectData, "apps", "", apps.String())
+	return nil
+}
+
+// inspectContainer gets the container metadata selected by command flags
+// by executing shell commands in the container.
+func inspectContainer(abspath string, inspectData *inspectFormat, getLabels, getDeffile bool) {
+	inspectShellCmd := []string{"/bin/sh", "-c", ""}
+
+	if getLabels {
+		inspectShellCmd[2] += getLabelsCommand(AppName)
+	}
+	if getDeffile {
+		inspectShellCmd[2] += getDefinitionCommand()
+	}
+	if listApps {
+		inspectShellCmd[2] += listAppsCommand
+	}
+	if helpfile {
+		inspectShellCmd[2] += getHelpCommand(AppName)
+	}
+	if runscript {
+		inspectShellCmd[2] += getRunscriptCommand(AppName)
+	}
+	if testfile {
+		inspectShellCmd[2] += getTestCommand(AppName)
+	}
+	if environment {
+		inspectShellCmd[2] += getEnvironmentCommand(AppName)
+	}
+
+	name := filepath.Base(abspath)
+
+	// Execute the compound command string.
+	fileContents, err := getFileContent(abspath, name, inspectShellCmd)
+	if err != nil {
+		sylog.Fatalf("Could not inspect container: %v", err)
+	}
+
+	// Parse the command output string into sections.
+	reader := bufio.NewReader(strings.NewReader(fileContents))
+	for {
+		section, err := reader.ReadBytes('\n')
+		if err != nil {
+			break
+		}
+		parts := strings.SplitN(strings.TrimSpace(string(section)), ":", 3)
+		if len(parts) == 2 {
+			label := parts[0]
+			sizeData, errConv := strconv.Atoi(parts[1])
+			if errConv != nil {
+				sylog.Fatalf("Badly formatted content, can't recover: %v", parts)
+			}
+			sylog.Debugf("Section %s found with %d bytes of data.", label, sizeData)
+			data := make([]byte, sizeData)
+			n, err := io.ReadFull(reader, data)
+			if n != len(data) && err != nil {
+				sylog.Fatalf("Unable to read %d bytes.", sizeData)
+			}
+			setAttribute(inspectData, label, AppName, string(data))
+		} else {
+			sylog.Fatalf("Badly formatted content, can't recover: %v", parts)
+		}
+	}
+}
+
 func getFileContent(abspath, name string, args []string) (string, error) {
 	engineConfig := singularityConfig.NewConfig()
 	ociConfig := &oci.Config{}
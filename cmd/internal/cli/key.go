@@ -10,7 +10,9 @@ import (
 
 	"github.com/spf13/cobra"
 	"github.com/sylabs/singularity/docs"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
 	"github.com/sylabs/singularity/pkg/cmdline"
+	"github.com/sylabs/singularity/pkg/sypgp"
 )
 
 const (
@@ -21,6 +23,7 @@ var (
 	keyServerURI        string // -u command line option
 	keySearchLongList   bool   // -l option for long-list
 	keyNewpairBitLength int    // -b option for bit length
+	keyringName         string // --keyring option
 )
 
 // -u|--url
@@ -54,6 +57,16 @@ var keyNewpairBitLengthFlag = cmdline.Flag{
 	Usage:        "specify key bit length",
 }
 
+// --keyring
+var keyringFlag = cmdline.Flag{
+	ID:           "keyringFlag",
+	Value:        &keyringName,
+	DefaultValue: "",
+	Name:         "keyring",
+	Usage:        "use the named keyring instead of the default keyring",
+	EnvKeys:      []string{"KEYRING"},
+}
+
 func init() {
 	cmdManager.RegisterCmd(KeyCmd)
 
@@ -63,6 +76,8 @@ func init() {
 	cmdManager.RegisterFlagForCmd(KeyNewPairCommentFlag, KeyNewPairCmd)
 	cmdManager.RegisterFlagForCmd(KeyNewPairPasswordFlag, KeyNewPairCmd)
 	cmdManager.RegisterFlagForCmd(KeyNewPairPushFlag, KeyNewPairCmd)
+	cmdManager.RegisterFlagForCmd(KeyNewPairTypeFlag, KeyNewPairCmd)
+	cmdManager.RegisterFlagForCmd(KeyNewPairExpireFlag, KeyNewPairCmd)
 
 	cmdManager.RegisterSubCmd(KeyCmd, KeyListCmd)
 	cmdManager.RegisterSubCmd(KeyCmd, KeySearchCmd)
@@ -71,11 +86,32 @@ func init() {
 	cmdManager.RegisterSubCmd(KeyCmd, KeyImportCmd)
 	cmdManager.RegisterSubCmd(KeyCmd, KeyRemoveCmd)
 	cmdManager.RegisterSubCmd(KeyCmd, KeyExportCmd)
+	cmdManager.RegisterSubCmd(KeyCmd, KeyRevokeCmd)
+	cmdManager.RegisterFlagForCmd(KeyRevokePushFlag, KeyRevokeCmd)
+	cmdManager.RegisterFlagForCmd(KeyRevokeOutputFlag, KeyRevokeCmd)
+	cmdManager.RegisterSubCmd(KeyCmd, KeyExtendCmd)
+	cmdManager.RegisterFlagForCmd(KeyExtendExpireFlag, KeyExtendCmd)
+	cmdManager.RegisterFlagForCmd(KeyExtendPushFlag, KeyExtendCmd)
+	cmdManager.RegisterSubCmd(KeyCmd, KeyTrustCmd)
+	cmdManager.RegisterSubCmd(KeyCmd, KeyBundleCmd)
+	cmdManager.RegisterSubCmd(KeyBundleCmd, KeyBundleCreateCmd)
+	cmdManager.RegisterFlagForCmd(KeyBundleFingerprintsFlag, KeyBundleCreateCmd)
+	cmdManager.RegisterFlagForCmd(KeyBundleOutputFlag, KeyBundleCreateCmd)
+	cmdManager.RegisterFlagForCmd(KeyBundleKeyIdxFlag, KeyBundleCreateCmd)
 
-	cmdManager.RegisterFlagForCmd(&keyServerURIFlag, KeySearchCmd, KeyPushCmd, KeyPullCmd)
+	cmdManager.RegisterFlagForCmd(&keyServerURIFlag, KeySearchCmd, KeyPushCmd, KeyPullCmd, KeyRevokeCmd, KeyExtendCmd)
 	cmdManager.RegisterFlagForCmd(&keySearchLongListFlag, KeySearchCmd)
 	cmdManager.RegisterFlagForCmd(&keyNewpairBitLengthFlag, KeyNewPairCmd)
 	cmdManager.RegisterFlagForCmd(&keyImportWithNewPasswordFlag, KeyImportCmd)
+	cmdManager.RegisterFlagForCmd(&keyringFlag, KeyNewPairCmd, KeyListCmd, KeyPullCmd, KeyPushCmd, KeyImportCmd, KeyRemoveCmd, KeyExportCmd, KeyRevokeCmd, KeyExtendCmd, KeyTrustCmd, KeyBundleCreateCmd)
+}
+
+// keyringHandle returns the handle of the keyring selected with --keyring.
+func keyringHandle() *sypgp.Handle {
+	if err := sypgp.CheckKeyringName(keyringName); err != nil {
+		sylog.Fatalf("%s", err)
+	}
+	return sypgp.NewHandle("", sypgp.KeyringHandleOpt(keyringName))
 }
 
 // KeyCmd is the 'key' command that allows management of key stores
@@ -0,0 +1,141 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/spf13/cobra"
+	"github.com/sylabs/singularity/docs"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+	"github.com/sylabs/singularity/pkg/cmdline"
+	"github.com/sylabs/singularity/pkg/sypgp"
+	"golang.org/x/crypto/openpgp"
+)
+
+var (
+	keyBundleFingerprints []string
+	// KeyBundleFingerprintsFlag is the flag to select the keys of the bundle
+	KeyBundleFingerprintsFlag = &cmdline.Flag{
+		ID:           "KeyBundleFingerprintsFlag",
+		Value:        &keyBundleFingerprints,
+		DefaultValue: []string{},
+		Name:         "fingerprints",
+		ShortHand:    "f",
+		Usage:        "comma separated fingerprints of the public keys to include in the bundle",
+	}
+
+	keyBundleOutput string
+	// KeyBundleOutputFlag is the flag to set the trust bundle file
+	KeyBundleOutputFlag = &cmdline.Flag{
+		ID:           "KeyBundleOutputFlag",
+		Value:        &keyBundleOutput,
+		DefaultValue: "",
+		Name:         "output",
+		ShortHand:    "o",
+		Usage:        "write the trust bundle to this file",
+	}
+
+	keyBundleKeyIdx int
+	// KeyBundleKeyIdxFlag is the flag to select the key signing the bundle
+	KeyBundleKeyIdxFlag = &cmdline.Flag{
+		ID:           "KeyBundleKeyIdxFlag",
+		Value:        &keyBundleKeyIdx,
+		DefaultValue: -1,
+		Name:         "keyidx",
+		ShortHand:    "k",
+		Usage:        "private key signing the bundle (index from 'keys list')",
+	}
+)
+
+// KeyBundleCmd is the 'key bundle' command that manages trust bundles
+var KeyBundleCmd = &cobra.Command{
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return errors.New("Invalid command")
+	},
+	DisableFlagsInUseLine: true,
+
+	Use:           docs.KeyBundleUse,
+	Short:         docs.KeyBundleShort,
+	Long:          docs.KeyBundleLong,
+	Example:       docs.KeyBundleExample,
+	SilenceErrors: true,
+}
+
+// KeyBundleCreateCmd is `singularity key bundle create' command
+var KeyBundleCreateCmd = &cobra.Command{
+	Args:                  cobra.ExactArgs(0),
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := doKeyBundleCreateCmd(); err != nil {
+			sylog.Fatalf("Unable to create trust bundle: %s", err)
+		}
+	},
+
+	Use:     docs.KeyBundleCreateUse,
+	Short:   docs.KeyBundleCreateShort,
+	Long:    docs.KeyBundleCreateLong,
+	Example: docs.KeyBundleCreateExample,
+}
+
+func doKeyBundleCreateCmd() error {
+	if len(keyBundleFingerprints) == 0 {
+		return fmt.Errorf("please provide the fingerprints of the keys with --fingerprints")
+	}
+	for _, fp := range keyBundleFingerprints {
+		if len(fp) != 40 {
+			return fmt.Errorf("please provide full fingerprints(40 chars): %s", fp)
+		}
+	}
+	if keyBundleOutput == "" {
+		return fmt.Errorf("please provide the trust bundle file with --output")
+	}
+
+	keyring := keyringHandle()
+
+	elist, err := keyring.LoadPrivKeyring()
+	if err != nil {
+		return fmt.Errorf("could not load private keyring: %s", err)
+	}
+	if elist == nil {
+		return fmt.Errorf("no private keys in keyring. use 'key newpair' to generate a key, or 'key import' to import a private key from gpg")
+	}
+
+	var signer *openpgp.Entity
+	if keyBundleKeyIdx != -1 {
+		if keyBundleKeyIdx < 0 || keyBundleKeyIdx >= len(elist) {
+			return fmt.Errorf("specified (-k, --keyidx) key index out of range")
+		}
+		signer = elist[keyBundleKeyIdx]
+	} else if len(elist) > 1 {
+		signer, err = sypgp.SelectPrivKey(elist)
+		if err != nil {
+			return fmt.Errorf("failed while reading selection: %s", err)
+		}
+	} else {
+		signer = elist[0]
+	}
+
+	if signer.PrivateKey.Encrypted {
+		if err := sypgp.DecryptKey(signer, ""); err != nil {
+			return fmt.Errorf("could not decrypt private key, wrong password?")
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := keyring.CreateTrustBundle(&buf, keyBundleFingerprints, signer); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(keyBundleOutput, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("could not write trust bundle: %s", err)
+	}
+
+	fmt.Printf("Trust bundle signed by %X written to: %s\n", signer.PrimaryKey.Fingerprint, keyBundleOutput)
+	return nil
+}
@@ -12,7 +12,6 @@ import (
 	"github.com/sylabs/singularity/docs"
 	"github.com/sylabs/singularity/internal/pkg/sylog"
 	"github.com/sylabs/singularity/pkg/cmdline"
-	"github.com/sylabs/singularity/pkg/sypgp"
 )
 
 var secretExport bool
@@ -58,7 +57,7 @@ var KeyExportCmd = &cobra.Command{
 }
 
 func exportRun(cmd *cobra.Command, args []string) {
-	keyring := sypgp.NewHandle("")
+	keyring := keyringHandle()
 	if secretExport {
 		err := keyring.ExportPrivateKey(args[0], armor)
 		if err != nil {
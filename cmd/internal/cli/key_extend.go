@@ -0,0 +1,96 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/spf13/cobra"
+	"github.com/sylabs/singularity/docs"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+	"github.com/sylabs/singularity/pkg/cmdline"
+	"github.com/sylabs/singularity/pkg/sypgp"
+)
+
+var (
+	keyExtendExpire string
+	// KeyExtendExpireFlag is the flag to set the new key validity period
+	KeyExtendExpireFlag = &cmdline.Flag{
+		ID:           "KeyExtendExpireFlag",
+		Value:        &keyExtendExpire,
+		DefaultValue: "",
+		Name:         "expire",
+		Usage:        "new key validity period from now, a number of days or a number followed by d, w, m or y, or never",
+	}
+
+	keyExtendPush bool
+	// KeyExtendPushFlag is the flag to push the extended key to the key server
+	KeyExtendPushFlag = &cmdline.Flag{
+		ID:           "KeyExtendPushFlag",
+		Value:        &keyExtendPush,
+		DefaultValue: false,
+		Name:         "push",
+		ShortHand:    "U",
+		Usage:        "push the extended public key to the key server",
+	}
+)
+
+// KeyExtendCmd is `singularity key extend <fingerprint>' command
+var KeyExtendCmd = &cobra.Command{
+	Args:                  cobra.ExactArgs(1),
+	DisableFlagsInUseLine: true,
+	PreRun:                sylabsToken,
+	Run: func(cmd *cobra.Command, args []string) {
+		if keyExtendPush {
+			handleKeyFlags(cmd)
+		}
+
+		if err := doKeyExtendCmd(context.TODO(), args[0], keyServerURI); err != nil {
+			sylog.Fatalf("Unable to extend key: %s", err)
+		}
+	},
+
+	Use:     docs.KeyExtendUse,
+	Short:   docs.KeyExtendShort,
+	Long:    docs.KeyExtendLong,
+	Example: docs.KeyExtendExample,
+}
+
+func doKeyExtendCmd(ctx context.Context, fingerprint string, url string) error {
+	if len(fingerprint) != 40 {
+		return fmt.Errorf("please provide a full fingerprint(40 chars)")
+	}
+	if keyExtendExpire == "" {
+		return fmt.Errorf("please provide the new validity period with --expire")
+	}
+	expire, err := sypgp.ParseExpiry(keyExtendExpire)
+	if err != nil {
+		return err
+	}
+
+	keyring := keyringHandle()
+	entity, err := keyring.ExtendKey(fingerprint, expire)
+	if err != nil {
+		return err
+	}
+
+	if expiry := sypgp.KeyExpiry(entity, entity.PrimaryKey.KeyId); expiry.IsZero() {
+		fmt.Printf("Key with fingerprint %X never expires\n", entity.PrimaryKey.Fingerprint)
+	} else {
+		fmt.Printf("Key with fingerprint %X expires on %s\n", entity.PrimaryKey.Fingerprint, expiry)
+	}
+
+	if keyExtendPush {
+		if err := sypgp.PushPubkey(ctx, http.DefaultClient, entity, url, authToken); err != nil {
+			return fmt.Errorf("could not push extended key: %s", err)
+		}
+		fmt.Printf("Extended key pushed to server successfully\n")
+	}
+
+	return nil
+}
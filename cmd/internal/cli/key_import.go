@@ -12,7 +12,6 @@ import (
 	"github.com/sylabs/singularity/docs"
 	"github.com/sylabs/singularity/internal/pkg/sylog"
 	"github.com/sylabs/singularity/pkg/cmdline"
-	"github.com/sylabs/singularity/pkg/sypgp"
 )
 
 // KeyImportCmd is `singularity key (or keys) import` and imports a local key into the singularity key store.
@@ -38,7 +37,7 @@ var keyImportWithNewPasswordFlag = cmdline.Flag{
 }
 
 func importRun(cmd *cobra.Command, args []string) {
-	keyring := sypgp.NewHandle("")
+	keyring := keyringHandle()
 	if err := keyring.ImportKey(args[0], keyImportWithNewPassword); err != nil {
 		sylog.Errorf("key import command failed: %s", err)
 		os.Exit(2)
@@ -13,10 +13,14 @@ import (
 
 	"github.com/spf13/cobra"
 	"github.com/sylabs/singularity/docs"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
 	"github.com/sylabs/singularity/pkg/sypgp"
 )
 
-var secret bool
+var (
+	secret       bool
+	listKeyrings bool
+)
 
 // -s|--secret
 var keyListSecretFlag = cmdline.Flag{
@@ -29,8 +33,18 @@ var keyListSecretFlag = cmdline.Flag{
 	EnvKeys:      []string{"SECRET"},
 }
 
+// --keyrings
+var keyListKeyringsFlag = cmdline.Flag{
+	ID:           "keyListKeyringsFlag",
+	Value:        &listKeyrings,
+	DefaultValue: false,
+	Name:         "keyrings",
+	Usage:        "list the available keyrings instead of keys",
+}
+
 func init() {
 	cmdManager.RegisterFlagForCmd(&keyListSecretFlag, KeyListCmd)
+	cmdManager.RegisterFlagForCmd(&keyListKeyringsFlag, KeyListCmd)
 }
 
 // KeyListCmd is `singularity key list' and lists local store OpenPGP keys
@@ -38,6 +52,13 @@ var KeyListCmd = &cobra.Command{
 	Args:                  cobra.ExactArgs(0),
 	DisableFlagsInUseLine: true,
 	Run: func(cmd *cobra.Command, args []string) {
+		if listKeyrings {
+			if err := doKeyListKeyringsCmd(); err != nil {
+				sylog.Errorf("%s", err)
+				os.Exit(2)
+			}
+			return
+		}
 		if err := doKeyListCmd(secret); err != nil {
 			os.Exit(2)
 		}
@@ -50,7 +71,7 @@ var KeyListCmd = &cobra.Command{
 }
 
 func doKeyListCmd(secret bool) error {
-	keyring := sypgp.NewHandle("")
+	keyring := keyringHandle()
 	if !secret {
 		fmt.Printf("Public key listing (%s):\n\n", keyring.PublicPath())
 		keyring.PrintPubKeyring()
@@ -61,3 +82,14 @@ func doKeyListCmd(secret bool) error {
 
 	return nil
 }
+
+func doKeyListKeyringsCmd() error {
+	names, err := sypgp.Keyrings("")
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		fmt.Println(name)
+	}
+	return nil
+}
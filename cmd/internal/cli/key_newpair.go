@@ -11,6 +11,7 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"strings"
 
 	"github.com/sylabs/singularity/internal/pkg/util/interactive"
 
@@ -74,6 +75,25 @@ var (
 		Usage:        "specify to push the public key to the remote keystore (default true)",
 	}
 
+	keyNewPairType     string
+	KeyNewPairTypeFlag = &cmdline.Flag{
+		ID:           "KeyNewPairTypeFlag",
+		Value:        &keyNewPairType,
+		DefaultValue: "",
+		Name:         "type",
+		ShortHand:    "t",
+		Usage:        "key type, one of: " + strings.Join(sypgp.KeyTypes, ", ") + " (default RSA key of --bit-length bits)",
+	}
+
+	keyNewPairExpire     string
+	KeyNewPairExpireFlag = &cmdline.Flag{
+		ID:           "KeyNewPairExpireFlag",
+		Value:        &keyNewPairExpire,
+		DefaultValue: "never",
+		Name:         "expire",
+		Usage:        "key validity period, a number of days or a number followed by d, w, m or y",
+	}
+
 	// KeyNewPairCmd is 'singularity key newpair' and generate a new OpenPGP key pair
 	KeyNewPairCmd = &cobra.Command{
 		Args:                  cobra.ExactArgs(0),
@@ -95,7 +115,7 @@ type keyNewPairOptions struct {
 func runNewPairCmd(cmd *cobra.Command, args []string) {
 	ctx := context.TODO()
 
-	keyring := sypgp.NewHandle("")
+	keyring := keyringHandle()
 
 	opts, err := collectInput(cmd)
 	if err != nil {
@@ -103,6 +123,14 @@ func runNewPairCmd(cmd *cobra.Command, args []string) {
 		os.Exit(2)
 	}
 	opts.KeyLength = keyNewpairBitLength
+	opts.KeyType = keyNewPairType
+	if opts.KeyType != "" && cmd.Flags().Changed(keyNewpairBitLengthFlag.Name) {
+		sylog.Fatalf("--bit-length is not compatible with --type")
+	}
+	opts.Expire, err = sypgp.ParseExpiry(keyNewPairExpire)
+	if err != nil {
+		sylog.Fatalf("%s", err)
+	}
 
 	fmt.Printf("Generating Entity and OpenPGP Key Pair... ")
 	key, err := keyring.GenKeyPair(opts.GenKeyPairOptions)
@@ -42,7 +42,7 @@ var KeyPullCmd = &cobra.Command{
 func doKeyPullCmd(ctx context.Context, fingerprint string, url string) error {
 	var count int
 
-	keyring := sypgp.NewHandle("")
+	keyring := keyringHandle()
 
 	// get matching keyring
 	el, err := sypgp.FetchPubkey(ctx, http.DefaultClient, fingerprint, url, authToken, false)
@@ -50,6 +50,9 @@ func doKeyPullCmd(ctx context.Context, fingerprint string, url string) error {
 		return fmt.Errorf("unable to pull key from server: %v", err)
 	}
 
+	if err := keyring.PathsCheck(); err != nil {
+		return err
+	}
 	elstore, err := keyring.LoadPubKeyring()
 	if err != nil {
 		return err
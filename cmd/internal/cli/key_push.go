@@ -42,7 +42,7 @@ var KeyPushCmd = &cobra.Command{
 }
 
 func doKeyPushCmd(ctx context.Context, fingerprint string, url string) error {
-	keyring := sypgp.NewHandle("")
+	keyring := keyringHandle()
 	el, err := keyring.LoadPubKeyring()
 	if err != nil {
 		return err
@@ -9,7 +9,6 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/sylabs/singularity/docs"
 	"github.com/sylabs/singularity/internal/pkg/sylog"
-	"github.com/sylabs/singularity/pkg/sypgp"
 )
 
 // KeyRemoveCmd is `singularity key remove <fingerprint>' command
@@ -17,7 +16,7 @@ var KeyRemoveCmd = &cobra.Command{
 	Args:                  cobra.ExactArgs(1),
 	DisableFlagsInUseLine: true,
 	Run: func(cmd *cobra.Command, args []string) {
-		keyring := sypgp.NewHandle("")
+		keyring := keyringHandle()
 		err := keyring.RemovePubKey(args[0])
 		if err != nil {
 			sylog.Fatalf("Unable to remove public key: %s", err)
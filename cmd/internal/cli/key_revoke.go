@@ -0,0 +1,97 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/spf13/cobra"
+	"github.com/sylabs/singularity/docs"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+	"github.com/sylabs/singularity/pkg/cmdline"
+	"github.com/sylabs/singularity/pkg/sypgp"
+)
+
+var (
+	keyRevokePush bool
+	// KeyRevokePushFlag is the flag to push the revoked key to the key server
+	KeyRevokePushFlag = &cmdline.Flag{
+		ID:           "KeyRevokePushFlag",
+		Value:        &keyRevokePush,
+		DefaultValue: false,
+		Name:         "push",
+		ShortHand:    "U",
+		Usage:        "push the revocation certificate to the key server",
+	}
+
+	keyRevokeOutput string
+	// KeyRevokeOutputFlag is the flag to save the revocation certificate
+	KeyRevokeOutputFlag = &cmdline.Flag{
+		ID:           "KeyRevokeOutputFlag",
+		Value:        &keyRevokeOutput,
+		DefaultValue: "",
+		Name:         "output",
+		ShortHand:    "o",
+		Usage:        "write the revocation certificate to this file",
+	}
+)
+
+// KeyRevokeCmd is `singularity key revoke <fingerprint>' command
+var KeyRevokeCmd = &cobra.Command{
+	Args:                  cobra.ExactArgs(1),
+	DisableFlagsInUseLine: true,
+	PreRun:                sylabsToken,
+	Run: func(cmd *cobra.Command, args []string) {
+		if keyRevokePush {
+			handleKeyFlags(cmd)
+		}
+
+		if err := doKeyRevokeCmd(context.TODO(), args[0], keyServerURI); err != nil {
+			sylog.Fatalf("Unable to revoke key: %s", err)
+		}
+	},
+
+	Use:     docs.KeyRevokeUse,
+	Short:   docs.KeyRevokeShort,
+	Long:    docs.KeyRevokeLong,
+	Example: docs.KeyRevokeExample,
+}
+
+func doKeyRevokeCmd(ctx context.Context, fingerprint string, url string) error {
+	if len(fingerprint) != 40 {
+		return fmt.Errorf("please provide a full fingerprint(40 chars)")
+	}
+
+	keyring := keyringHandle()
+	entity, err := keyring.RevokeKey(fingerprint)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Key with fingerprint %X revoked in the public keyring\n", entity.PrimaryKey.Fingerprint)
+
+	if keyRevokeOutput != "" {
+		cert, err := sypgp.SerializeRevokedKey(entity)
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(keyRevokeOutput, []byte(cert), 0644); err != nil {
+			return fmt.Errorf("could not write revocation certificate: %s", err)
+		}
+		fmt.Printf("Revocation certificate written to: %s\n", keyRevokeOutput)
+	}
+
+	if keyRevokePush {
+		if err := sypgp.PushPubkey(ctx, http.DefaultClient, entity, url, authToken); err != nil {
+			return fmt.Errorf("could not push revocation certificate: %s", err)
+		}
+		fmt.Printf("Revocation certificate pushed to server successfully\n")
+	}
+
+	return nil
+}
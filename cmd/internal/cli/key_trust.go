@@ -0,0 +1,47 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/sylabs/singularity/docs"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+	"github.com/sylabs/singularity/pkg/sypgp"
+)
+
+// KeyTrustCmd is `singularity key trust <fingerprint> full|marginal|none' command
+var KeyTrustCmd = &cobra.Command{
+	Args:                  cobra.ExactArgs(2),
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := doKeyTrustCmd(args[0], args[1]); err != nil {
+			sylog.Fatalf("Unable to set key trust level: %s", err)
+		}
+	},
+
+	Use:     docs.KeyTrustUse,
+	Short:   docs.KeyTrustShort,
+	Long:    docs.KeyTrustLong,
+	Example: docs.KeyTrustExample,
+}
+
+func doKeyTrustCmd(fingerprint, trust string) error {
+	if len(fingerprint) != 40 {
+		return fmt.Errorf("please provide a full fingerprint(40 chars)")
+	}
+	level, err := sypgp.ParseTrustLevel(trust)
+	if err != nil {
+		return err
+	}
+
+	if err := keyringHandle().SetKeyTrust(fingerprint, level); err != nil {
+		return err
+	}
+	fmt.Printf("Key with fingerprint %s now has %s trust\n", fingerprint, level)
+	return nil
+}
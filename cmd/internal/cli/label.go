@@ -0,0 +1,76 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/sylabs/singularity/docs"
+	"github.com/sylabs/singularity/internal/app/singularity"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+	"github.com/sylabs/singularity/internal/pkg/util/interactive"
+	"github.com/sylabs/singularity/pkg/signing"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+func init() {
+	cmdManager.RegisterCmd(LabelCmd)
+	cmdManager.RegisterSubCmd(LabelCmd, LabelListCmd)
+	cmdManager.RegisterSubCmd(LabelCmd, LabelSetCmd)
+	cmdManager.RegisterSubCmd(LabelCmd, LabelUnsetCmd)
+}
+
+// LabelCmd is the 'label' command that groups image labels management commands
+var LabelCmd = &cobra.Command{
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return errors.New("invalid command")
+	},
+	DisableFlagsInUseLine: true,
+
+	Use:           docs.LabelUse,
+	Short:         docs.LabelShort,
+	Long:          docs.LabelLong,
+	Example:       docs.LabelExample,
+	SilenceErrors: true,
+}
+
+// doLabelCmd applies set and unset to the labels of the image found at
+// path and offers to sign the image again if signatures don't match anymore.
+func doLabelCmd(path string, set map[string]string, unset []string) {
+	stale, err := singularity.LabelSet(path, set, unset)
+	if err != nil {
+		sylog.Fatalf("Failed to update labels of %s: %s", path, err)
+	}
+	if len(stale) == 0 {
+		return
+	}
+
+	sylog.Warningf("%d signature(s) of %s don't match the image labels anymore", len(stale), path)
+
+	if !terminal.IsTerminal(int(os.Stdin.Fd())) {
+		sylog.Warningf("Run 'singularity sign' to sign the image again")
+		return
+	}
+
+	ans, err := interactive.AskYNQuestion("n", "Do you want to sign %s again? [N/y] ", path)
+	if err != nil {
+		sylog.Fatalf("Failed to read answer: %s", err)
+	}
+	if ans != "y" {
+		return
+	}
+
+	err = singularity.LabelResign(path, stale, func(path string, id uint32, isGroup bool) error {
+		return signing.Sign(path, id, isGroup, false, false, "", privKey)
+	})
+	if err != nil {
+		sylog.Fatalf("Failed to sign container: %s", err)
+	}
+	fmt.Printf("Signature created and applied to %s\n", path)
+}
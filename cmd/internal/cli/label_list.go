@@ -0,0 +1,70 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/spf13/cobra"
+	"github.com/sylabs/singularity/docs"
+	"github.com/sylabs/singularity/internal/app/singularity"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+	"github.com/sylabs/singularity/pkg/cmdline"
+)
+
+var labelListJSON bool
+
+// -j|--json
+var labelListJSONFlag = cmdline.Flag{
+	ID:           "labelListJSONFlag",
+	Value:        &labelListJSON,
+	DefaultValue: false,
+	Name:         "json",
+	ShortHand:    "j",
+	Usage:        "print labels in json format",
+}
+
+func init() {
+	cmdManager.RegisterFlagForCmd(&labelListJSONFlag, LabelListCmd)
+}
+
+// LabelListCmd is 'singularity label list' and prints the labels of an image
+var LabelListCmd = &cobra.Command{
+	DisableFlagsInUseLine: true,
+	Args:                  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		labels, err := singularity.LabelList(args[0])
+		if err != nil {
+			sylog.Fatalf("Failed to list labels of %s: %s", args[0], err)
+		}
+
+		if labelListJSON {
+			b, err := json.MarshalIndent(labels, "", "\t")
+			if err != nil {
+				sylog.Fatalf("Unable to format labels as json: %s", err)
+			}
+			fmt.Printf("%s\n", b)
+			return
+		}
+
+		keys := make([]string, 0, len(labels))
+		for k := range labels {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			fmt.Printf("%s: %s\n", k, labels[k])
+		}
+	},
+
+	Use:     docs.LabelListUse,
+	Short:   docs.LabelListShort,
+	Long:    docs.LabelListLong,
+	Example: docs.LabelListExample,
+}
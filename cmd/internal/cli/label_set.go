@@ -0,0 +1,40 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/sylabs/singularity/docs"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+)
+
+func init() {
+	cmdManager.RegisterFlagForCmd(&signKeyIdxFlag, LabelSetCmd)
+}
+
+// LabelSetCmd is 'singularity label set' and adds or replaces labels of an image
+var LabelSetCmd = &cobra.Command{
+	DisableFlagsInUseLine: true,
+	Args:                  cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		set := make(map[string]string)
+		for _, arg := range args[1:] {
+			kv := strings.SplitN(arg, "=", 2)
+			if len(kv) != 2 || kv[0] == "" {
+				sylog.Fatalf("Invalid label %q, labels must be specified as <key>=<value>", arg)
+			}
+			set[kv[0]] = kv[1]
+		}
+		doLabelCmd(args[0], set, nil)
+	},
+
+	Use:     docs.LabelSetUse,
+	Short:   docs.LabelSetShort,
+	Long:    docs.LabelSetLong,
+	Example: docs.LabelSetExample,
+}
@@ -0,0 +1,29 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/sylabs/singularity/docs"
+)
+
+func init() {
+	cmdManager.RegisterFlagForCmd(&signKeyIdxFlag, LabelUnsetCmd)
+}
+
+// LabelUnsetCmd is 'singularity label unset' and removes labels from an image
+var LabelUnsetCmd = &cobra.Command{
+	DisableFlagsInUseLine: true,
+	Args:                  cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		doLabelCmd(args[0], nil, args[1:])
+	},
+
+	Use:     docs.LabelUnsetUse,
+	Short:   docs.LabelUnsetShort,
+	Long:    docs.LabelUnsetLong,
+	Example: docs.LabelUnsetExample,
+}
@@ -0,0 +1,105 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"errors"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/sylabs/singularity/docs"
+	"github.com/sylabs/singularity/internal/app/singularity"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+	"github.com/sylabs/singularity/internal/pkg/util/fs"
+	"github.com/sylabs/singularity/pkg/cmdline"
+	"github.com/sylabs/singularity/pkg/util/crypt"
+)
+
+var (
+	overlaySize    int
+	overlayEncrypt bool
+)
+
+// -s|--size
+var overlaySizeFlag = cmdline.Flag{
+	ID:           "overlaySizeFlag",
+	Value:        &overlaySize,
+	DefaultValue: 64,
+	Name:         "size",
+	ShortHand:    "s",
+	Usage:        "size of the overlay filesystem in MiB",
+}
+
+// -e|--encrypt
+var overlayEncryptFlag = cmdline.Flag{
+	ID:           "overlayEncryptFlag",
+	Value:        &overlayEncrypt,
+	DefaultValue: false,
+	Name:         "encrypt",
+	ShortHand:    "e",
+	Usage:        "create an overlay with an encrypted filesystem",
+}
+
+func init() {
+	cmdManager.RegisterCmd(OverlayCmd)
+	cmdManager.RegisterSubCmd(OverlayCmd, OverlayCreateCmd)
+
+	cmdManager.RegisterFlagForCmd(&overlaySizeFlag, OverlayCreateCmd)
+	cmdManager.RegisterFlagForCmd(&overlayEncryptFlag, OverlayCreateCmd)
+	cmdManager.RegisterFlagForCmd(&commonPromptForPassphraseFlag, OverlayCreateCmd)
+	cmdManager.RegisterFlagForCmd(&commonPEMFlag, OverlayCreateCmd)
+	cmdManager.RegisterFlagForCmd(&commonKeyProviderFlag, OverlayCreateCmd)
+}
+
+// OverlayCmd is the 'overlay' command that allows management of writable
+// overlay images
+var OverlayCmd = &cobra.Command{
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return errors.New("Invalid command")
+	},
+	DisableFlagsInUseLine: true,
+
+	Use:           docs.OverlayUse,
+	Short:         docs.OverlayShort,
+	Long:          docs.OverlayLong,
+	Example:       docs.OverlayExample,
+	SilenceErrors: true,
+}
+
+// OverlayCreateCmd is `singularity overlay create <image>' command
+var OverlayCreateCmd = &cobra.Command{
+	Args:                  cobra.ExactArgs(1),
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		var keyInfo *crypt.KeyInfo
+
+		if overlayEncrypt || promptForPassphrase || cmd.Flags().Lookup("pem-path").Changed || keyProvider != "" {
+			if os.Geteuid() != 0 {
+				sylog.Fatalf("You must be root to create an encrypted overlay")
+			}
+			// the key of an overlay embedded in an existing
+			// image is provided for this image
+			image := ""
+			if fs.IsFile(args[0]) {
+				image = args[0]
+			}
+			k, err := getEncryptionMaterial(cmd, image)
+			if err != nil {
+				sylog.Fatalf("While handling encryption material: %v", err)
+			}
+			keyInfo = &k
+		}
+
+		if err := singularity.OverlayCreate(args[0], overlaySize, keyInfo); err != nil {
+			sylog.Fatalf("Unable to create overlay: %s", err)
+		}
+	},
+
+	Use:     docs.OverlayCreateUse,
+	Short:   docs.OverlayCreateShort,
+	Long:    docs.OverlayCreateLong,
+	Example: docs.OverlayCreateExample,
+}
@@ -7,6 +7,7 @@ package cli
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"os/signal"
 	"path/filepath"
@@ -24,6 +25,8 @@ import (
 	"github.com/sylabs/singularity/internal/pkg/util/uri"
 	net "github.com/sylabs/singularity/pkg/client/net"
 	"github.com/sylabs/singularity/pkg/cmdline"
+	"github.com/sylabs/singularity/pkg/signing"
+	"github.com/sylabs/singularity/pkg/sypgp"
 )
 
 const (
@@ -55,6 +58,11 @@ var (
 	// pullArch is the architecture for which containers will be pulled from the
 	// SCS library.
 	pullArch string
+	// pullPolicy is the path of the verification policy the pulled image
+	// must satisfy, if set.
+	pullPolicy string
+	// pullRequireSignature when true; fails if an oras image has no detached signature.
+	pullRequireSignature bool
 )
 
 // --arch
@@ -132,6 +140,26 @@ var pullAllowUnauthenticatedFlag = cmdline.Flag{
 	Hidden:       true,
 }
 
+// --policy
+var pullPolicyFlag = cmdline.Flag{
+	ID:           "pullPolicyFlag",
+	Value:        &pullPolicy,
+	DefaultValue: "",
+	Name:         "policy",
+	Usage:        "remove the pulled image if it doesn't satisfy the verification policy of the YAML file",
+	EnvKeys:      []string{"PULL_POLICY"},
+}
+
+// --require-signature
+var pullRequireSignatureFlag = cmdline.Flag{
+	ID:           "pullRequireSignatureFlag",
+	Value:        &pullRequireSignature,
+	DefaultValue: false,
+	Name:         "require-signature",
+	Usage:        "remove the pulled image if it has no valid detached signature (oras only)",
+	EnvKeys:      []string{"REQUIRE_SIGNATURE"},
+}
+
 func init() {
 	cmdManager.RegisterCmd(PullCmd)
 
@@ -151,6 +179,9 @@ func init() {
 	cmdManager.RegisterFlagForCmd(&pullAllowUnsignedFlag, PullCmd)
 	cmdManager.RegisterFlagForCmd(&pullAllowUnauthenticatedFlag, PullCmd)
 	cmdManager.RegisterFlagForCmd(&pullArchFlag, PullCmd)
+	cmdManager.RegisterFlagForCmd(&pullPolicyFlag, PullCmd)
+	cmdManager.RegisterFlagForCmd(&pullRequireSignatureFlag, PullCmd)
+	cmdManager.RegisterFlagForCmd(&keyringFlag, PullCmd)
 }
 
 // PullCmd singularity pull
@@ -179,6 +210,28 @@ func pullRun(cmd *cobra.Command, args []string) {
 		sylog.Fatalf("Bad URI %s", pullFrom)
 	}
 
+	var policy *signing.Policy
+	if pullPolicy != "" {
+		p, err := signing.LoadPolicy(pullPolicy)
+		if err != nil {
+			sylog.Fatalf("%s", err)
+		}
+		policy = p
+	}
+
+	if pullRequireSignature && transport != OrasProtocol {
+		sylog.Fatalf("--require-signature is only supported for oras:// images")
+	}
+
+	if err := sypgp.CheckKeyringName(keyringName); err != nil {
+		sylog.Fatalf("%s", err)
+	}
+	// library pulls select the keyring of the remote endpoint with the
+	// other remote settings
+	if transport != LibraryProtocol && transport != "" && (policy != nil || transport == OrasProtocol) {
+		useDefaultRemoteKeyring()
+	}
+
 	pullTo := pullImageName
 	if pullTo == "" {
 		pullTo = args[0]
@@ -252,6 +305,24 @@ func pullRun(cmd *cobra.Command, args []string) {
 		if err != nil {
 			sylog.Fatalf("While pulling image from oci registry: %v", err)
 		}
+
+		author, err := singularity.OrasVerify(ctx, pullTo, ref, &ociAuth, keyServerURL, authToken, signing.VerifyOptions{TrustBundle: systemTrustBundle(), Keyring: keyringName})
+		if err == singularity.ErrOrasPullUnsigned && !pullRequireSignature {
+			sylog.Warningf("Skipping container verification: %v", err)
+		} else if err != nil && !pullRequireSignature {
+			// the image is only removed when a signature is required
+			fmt.Printf("%s", author)
+			sylog.Warningf("Image %s failed signature verification: %v", pullFrom, err)
+		} else if err != nil {
+			fmt.Printf("%s", author)
+			if err := os.Remove(pullTo); err != nil {
+				sylog.Errorf("Unable to remove %s: %s", pullTo, err)
+			}
+			sylog.Fatalf("Image %s failed signature verification: %v", pullFrom, err)
+		} else {
+			fmt.Printf("%s", author)
+			sylog.Infof("Detached signature of %s verified", pullTo)
+		}
 	case HTTPProtocol, HTTPSProtocol:
 		err := net.DownloadImage(pullTo, pullFrom)
 		if err != nil {
@@ -270,6 +341,18 @@ func pullRun(cmd *cobra.Command, args []string) {
 	default:
 		sylog.Fatalf("Unsupported transport type: %s", transport)
 	}
+
+	if policy != nil {
+		author, _, err := signing.Verify(ctx, pullTo, keyServerURL, 0, false, false, authToken, false, false, signing.VerifyOptions{Policy: policy, TrustBundle: systemTrustBundle(), Keyring: keyringName})
+		if err != nil {
+			fmt.Printf("%s", author)
+			if err := os.Remove(pullTo); err != nil {
+				sylog.Errorf("Unable to remove %s: %s", pullTo, err)
+			}
+			sylog.Fatalf("Image %s doesn't satisfy the verification policy %s: %s", pullFrom, pullPolicy, err)
+		}
+		sylog.Infof("Image %s satisfies the verification policy %s", pullTo, pullPolicy)
+	}
 }
 
 func handlePullFlags(cmd *cobra.Command) {
@@ -286,6 +369,7 @@ func handlePullFlags(cmd *cobra.Command) {
 	}
 
 	authToken = endpoint.Token
+	useRemoteKeyring(endpoint)
 	if !cmd.Flags().Lookup("library").Changed {
 		libraryURI, err := endpoint.GetServiceURI("library")
 		if err != nil {
@@ -18,6 +18,8 @@ import (
 	"github.com/sylabs/singularity/internal/pkg/sylog"
 	"github.com/sylabs/singularity/internal/pkg/util/uri"
 	"github.com/sylabs/singularity/pkg/cmdline"
+	"github.com/sylabs/singularity/pkg/signing"
+	"github.com/sylabs/singularity/pkg/sypgp"
 )
 
 var (
@@ -26,6 +28,9 @@ var (
 
 	// unauthenticatedPush when true; will never ask to push a unsigned container
 	unauthenticatedPush bool
+
+	// pushSign when true; pushes a detached signature along with an oras image
+	pushSign bool
 )
 
 // --library
@@ -49,11 +54,24 @@ var pushAllowUnsignedFlag = cmdline.Flag{
 	EnvKeys:      []string{"ALLOW_UNSIGNED"},
 }
 
+// --sign
+var pushSignFlag = cmdline.Flag{
+	ID:           "pushSignFlag",
+	Value:        &pushSign,
+	DefaultValue: false,
+	Name:         "sign",
+	Usage:        "push a detached signature of the image made with a private key (oras only)",
+	EnvKeys:      []string{"PUSH_SIGN"},
+}
+
 func init() {
 	cmdManager.RegisterCmd(PushCmd)
 
 	cmdManager.RegisterFlagForCmd(&pushLibraryURIFlag, PushCmd)
 	cmdManager.RegisterFlagForCmd(&pushAllowUnsignedFlag, PushCmd)
+	cmdManager.RegisterFlagForCmd(&pushSignFlag, PushCmd)
+	cmdManager.RegisterFlagForCmd(&signKeyIdxFlag, PushCmd)
+	cmdManager.RegisterFlagForCmd(&keyringFlag, PushCmd)
 
 	cmdManager.RegisterFlagForCmd(&dockerUsernameFlag, PushCmd)
 	cmdManager.RegisterFlagForCmd(&dockerPasswordFlag, PushCmd)
@@ -74,6 +92,13 @@ var PushCmd = &cobra.Command{
 			sylog.Fatalf("bad uri %s", dest)
 		}
 
+		if transport != OrasProtocol && (pushSign || cmd.Flag(signKeyIdxFlag.Name).Changed || keyringName != "") {
+			sylog.Fatalf("--sign, --keyidx and --keyring are only supported when pushing to an oras:// reference")
+		}
+		if !pushSign && (cmd.Flag(signKeyIdxFlag.Name).Changed || keyringName != "") {
+			sylog.Fatalf("--keyidx and --keyring require --sign")
+		}
+
 		switch transport {
 		case LibraryProtocol, "": // Handle pushing to a library
 			handlePushFlags(cmd)
@@ -93,7 +118,18 @@ var PushCmd = &cobra.Command{
 				sylog.Fatalf("Unable to make docker oci credentials: %s", err)
 			}
 
-			if err := oras.UploadImage(file, ref, &ociAuth); err != nil {
+			var sign oras.SignFunc
+			if pushSign {
+				if err := sypgp.CheckKeyringName(keyringName); err != nil {
+					sylog.Fatalf("%s", err)
+				}
+				sign, err = signing.ORASSigner(keyringName, privKey)
+				if err != nil {
+					sylog.Fatalf("Failed to load signing key: %s", err)
+				}
+			}
+
+			if err := oras.UploadImage(file, ref, &ociAuth, sign); err != nil {
 				sylog.Fatalf("Unable to push image to oci registry: %v", err)
 			}
 			sylog.Infof("Upload complete")
@@ -16,6 +16,7 @@ import (
 	"github.com/sylabs/singularity/internal/pkg/sylog"
 	"github.com/sylabs/singularity/pkg/cmdline"
 	"github.com/sylabs/singularity/pkg/syfs"
+	"github.com/sylabs/singularity/pkg/sypgp"
 )
 
 const (
@@ -28,6 +29,7 @@ var (
 	remoteConfig   string
 	remoteNoLogin  bool
 	global         bool
+	remoteKeyring  string
 )
 
 // assemble values of remoteConfig for user/sys locations
@@ -72,6 +74,15 @@ var remoteNoLoginFlag = cmdline.Flag{
 	Usage:        "skip automatic login step",
 }
 
+// --keyring
+var remoteKeyringFlag = cmdline.Flag{
+	ID:           "remoteKeyringFlag",
+	Value:        &remoteKeyring,
+	DefaultValue: "",
+	Name:         "keyring",
+	Usage:        "named keyring used by default to verify images of the remote",
+}
+
 func init() {
 	cmdManager.RegisterCmd(RemoteCmd)
 	cmdManager.RegisterSubCmd(RemoteCmd, RemoteAddCmd)
@@ -89,6 +100,8 @@ func init() {
 	cmdManager.RegisterFlagForCmd(&remoteGlobalFlag, RemoteAddCmd, RemoteRemoveCmd, RemoteUseCmd)
 	// add --no-login flag to add command
 	cmdManager.RegisterFlagForCmd(&remoteNoLoginFlag, RemoteAddCmd)
+	// add --keyring flag to add command
+	cmdManager.RegisterFlagForCmd(&remoteKeyringFlag, RemoteAddCmd)
 }
 
 // RemoteCmd singularity remote [...]
@@ -125,7 +138,10 @@ var RemoteAddCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		name := args[0]
 		uri := args[1]
-		if err := singularity.RemoteAdd(remoteConfig, name, uri, global); err != nil {
+		if err := sypgp.CheckKeyringName(remoteKeyring); err != nil {
+			sylog.Fatalf("%s", err)
+		}
+		if err := singularity.RemoteAdd(remoteConfig, name, uri, global, remoteKeyring); err != nil {
 			sylog.Fatalf("%s", err)
 		}
 		sylog.Infof("Remote %q added.", name)
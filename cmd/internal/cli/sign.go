@@ -7,17 +7,29 @@ package cli
 
 import (
 	"fmt"
+	"path/filepath"
 
 	"github.com/spf13/cobra"
 	"github.com/sylabs/singularity/docs"
 	"github.com/sylabs/singularity/internal/pkg/sylog"
+	"github.com/sylabs/singularity/internal/pkg/util/fs"
 	"github.com/sylabs/singularity/pkg/cmdline"
 	"github.com/sylabs/singularity/pkg/signing"
+	"github.com/sylabs/singularity/pkg/sypgp"
 )
 
 var (
 	privKey int // -k encryption key (index from 'keys list') specification
 	signAll bool
+
+	certificatePath string // --certificate
+	certKeyPath     string // --key
+
+	signRemove  string // --remove
+	signReplace bool   // --replace
+
+	signGPGAgent bool   // --gpg-agent
+	signGPGKeyID string // --keyid
 )
 
 // -u|--url
@@ -81,6 +93,60 @@ var signAllFlag = cmdline.Flag{
 	Usage:        "sign all non-signature partitions in a SIF",
 }
 
+// --certificate
+var signCertificateFlag = cmdline.Flag{
+	ID:           "signCertificateFlag",
+	Value:        &certificatePath,
+	DefaultValue: "",
+	Name:         "certificate",
+	Usage:        "sign with an X.509 certificate, PEM file with the signer certificate followed by its chain",
+}
+
+// --key
+var signCertKeyFlag = cmdline.Flag{
+	ID:           "signCertKeyFlag",
+	Value:        &certKeyPath,
+	DefaultValue: "",
+	Name:         "key",
+	Usage:        "PEM file with the private key of the certificate set with --certificate",
+}
+
+// --remove
+var signRemoveFlag = cmdline.Flag{
+	ID:           "signRemoveFlag",
+	Value:        &signRemove,
+	DefaultValue: "",
+	Name:         "remove",
+	Usage:        "remove the signatures made by a key or certificate fingerprint, or all signatures with 'all'",
+}
+
+// --replace
+var signReplaceFlag = cmdline.Flag{
+	ID:           "signReplaceFlag",
+	Value:        &signReplace,
+	DefaultValue: false,
+	Name:         "replace",
+	Usage:        "replace the existing signatures of the signed partitions",
+}
+
+// --gpg-agent
+var signGPGAgentFlag = cmdline.Flag{
+	ID:           "signGPGAgentFlag",
+	Value:        &signGPGAgent,
+	DefaultValue: false,
+	Name:         "gpg-agent",
+	Usage:        "sign with a GnuPG secret key through gpg-agent",
+}
+
+// --keyid
+var signGPGKeyIDFlag = cmdline.Flag{
+	ID:           "signGPGKeyIDFlag",
+	Value:        &signGPGKeyID,
+	DefaultValue: "",
+	Name:         "keyid",
+	Usage:        "GnuPG key ID, fingerprint or user ID used with --gpg-agent (default GnuPG secret key)",
+}
+
 func init() {
 	cmdManager.RegisterCmd(SignCmd)
 
@@ -90,6 +156,13 @@ func init() {
 	cmdManager.RegisterFlagForCmd(&signSifDescIDFlag, SignCmd)
 	cmdManager.RegisterFlagForCmd(&signKeyIdxFlag, SignCmd)
 	cmdManager.RegisterFlagForCmd(&signAllFlag, SignCmd)
+	cmdManager.RegisterFlagForCmd(&signCertificateFlag, SignCmd)
+	cmdManager.RegisterFlagForCmd(&signCertKeyFlag, SignCmd)
+	cmdManager.RegisterFlagForCmd(&signRemoveFlag, SignCmd)
+	cmdManager.RegisterFlagForCmd(&signReplaceFlag, SignCmd)
+	cmdManager.RegisterFlagForCmd(&signGPGAgentFlag, SignCmd)
+	cmdManager.RegisterFlagForCmd(&signGPGKeyIDFlag, SignCmd)
+	cmdManager.RegisterFlagForCmd(&keyringFlag, SignCmd)
 }
 
 // SignCmd singularity sign
@@ -99,6 +172,15 @@ var SignCmd = &cobra.Command{
 
 	Run: func(cmd *cobra.Command, args []string) {
 		// args[0] contains image path
+		if cmd.Flag(signRemoveFlag.Name).Changed {
+			doSignRemoveCmd(cmd, args[0])
+			return
+		}
+		if fs.IsDir(args[0]) {
+			fmt.Printf("Signing sandbox: %s\n", args[0])
+			doSignSandboxCmd(cmd, args[0])
+			return
+		}
 		fmt.Printf("Signing image: %s\n", args[0])
 		doSignCmd(cmd, args[0])
 	},
@@ -109,7 +191,9 @@ var SignCmd = &cobra.Command{
 	Example: docs.SignExample,
 }
 
-func doSignCmd(cmd *cobra.Command, cpath string) {
+// signSelection returns the descriptor or group ID selected with the
+// command flags.
+func signSelection(cmd *cobra.Command) (uint32, bool) {
 	// Group id should start at 1.
 	if cmd.Flag(verifySifGroupIDFlag.Name).Changed && sifGroupID == 0 {
 		sylog.Fatalf("invalid group id")
@@ -140,8 +224,95 @@ func doSignCmd(cmd *cobra.Command, cpath string) {
 		sylog.Fatalf("'--all' not compatible with '--sif-id' or '--groupid'")
 	}
 
-	if err := signing.Sign(cpath, id, isGroup, signAll, privKey); err != nil {
+	return id, isGroup
+}
+
+func doSignCmd(cmd *cobra.Command, cpath string) {
+	id, isGroup := signSelection(cmd)
+
+	if signGPGKeyID != "" && !signGPGAgent {
+		sylog.Fatalf("'--keyid' requires '--gpg-agent'")
+	}
+	if signGPGAgent {
+		if certificatePath != "" || certKeyPath != "" || cmd.Flag(signKeyIdxFlag.Name).Changed || keyringName != "" {
+			sylog.Fatalf("'--gpg-agent' not compatible with '--certificate', '--key', '--keyidx' or '--keyring'")
+		}
+		if err := signing.SignGPGAgent(cpath, id, isGroup, signAll, signReplace, signGPGKeyID); err != nil {
+			sylog.Fatalf("Failed to sign container: %s", err)
+		}
+		fmt.Printf("Signature created and applied to %s\n", cpath)
+		return
+	}
+
+	if certificatePath != "" || certKeyPath != "" {
+		if certificatePath == "" || certKeyPath == "" {
+			sylog.Fatalf("'--certificate' and '--key' must be used together")
+		}
+		if cmd.Flag(signKeyIdxFlag.Name).Changed || keyringName != "" {
+			sylog.Fatalf("'--keyidx' and '--keyring' not compatible with '--certificate'")
+		}
+		if err := signing.SignX509(cpath, id, isGroup, signAll, signReplace, certificatePath, certKeyPath); err != nil {
+			sylog.Fatalf("Failed to sign container: %s", err)
+		}
+		fmt.Printf("Signature created and applied to %s\n", cpath)
+		return
+	}
+
+	if err := sypgp.CheckKeyringName(keyringName); err != nil {
+		sylog.Fatalf("%s", err)
+	}
+	if err := signing.Sign(cpath, id, isGroup, signAll, signReplace, keyringName, privKey); err != nil {
 		sylog.Fatalf("Failed to sign container: %s", err)
 	}
 	fmt.Printf("Signature created and applied to %s\n", cpath)
 }
+
+func doSignSandboxCmd(cmd *cobra.Command, dir string) {
+	if sifGroupID != 0 || sifDescID != 0 || signAll || signReplace {
+		sylog.Fatalf("'--groupid', '--sif-id', '--all' and '--replace' not compatible with sandbox images")
+	}
+	if certificatePath != "" || certKeyPath != "" {
+		sylog.Fatalf("'--certificate' and '--key' not compatible with sandbox images")
+	}
+	if signGPGKeyID != "" && !signGPGAgent {
+		sylog.Fatalf("'--keyid' requires '--gpg-agent'")
+	}
+
+	if signGPGAgent {
+		if cmd.Flag(signKeyIdxFlag.Name).Changed || keyringName != "" {
+			sylog.Fatalf("'--gpg-agent' not compatible with '--keyidx' or '--keyring'")
+		}
+		if err := signing.SignSandboxGPGAgent(dir, signGPGKeyID); err != nil {
+			sylog.Fatalf("Failed to sign sandbox: %s", err)
+		}
+	} else {
+		if err := sypgp.CheckKeyringName(keyringName); err != nil {
+			sylog.Fatalf("%s", err)
+		}
+		if err := signing.SignSandbox(dir, keyringName, privKey); err != nil {
+			sylog.Fatalf("Failed to sign sandbox: %s", err)
+		}
+	}
+	fmt.Printf("Signed manifest written to %s\n", filepath.Join(dir, signing.SandboxManifestPath))
+}
+
+func doSignRemoveCmd(cmd *cobra.Command, cpath string) {
+	id, isGroup := signSelection(cmd)
+
+	if signReplace || signGPGAgent || certificatePath != "" || certKeyPath != "" || cmd.Flag(signKeyIdxFlag.Name).Changed || keyringName != "" {
+		sylog.Fatalf("'--remove' not compatible with '--replace', '--gpg-agent', '--certificate', '--key', '--keyidx' or '--keyring'")
+	}
+
+	fingerprint := signRemove
+	if fingerprint == "all" {
+		fingerprint = ""
+	} else if len(fingerprint) != 40 {
+		sylog.Fatalf("please provide a full fingerprint(40 chars) or 'all'")
+	}
+
+	n, err := signing.RemoveSignatures(cpath, id, isGroup, fingerprint)
+	if err != nil {
+		sylog.Fatalf("Failed to remove signatures: %s", err)
+	}
+	fmt.Printf("%d signature(s) removed from %s\n", n, cpath)
+}
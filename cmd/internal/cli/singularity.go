@@ -50,6 +50,7 @@ var (
 
 	encryptionPEMPath   string
 	promptForPassphrase bool
+	keyProvider         string
 	forceOverwrite      bool
 	noHTTPS             bool
 	tmpDir              string
@@ -177,6 +178,16 @@ var commonPEMFlag = cmdline.Flag{
 	Usage:        "enter an path to a PEM formated RSA key for an encrypted container",
 }
 
+// --key-provider
+var commonKeyProviderFlag = cmdline.Flag{
+	ID:           "commonKeyProviderFlag",
+	Value:        &keyProvider,
+	DefaultValue: "",
+	Name:         "key-provider",
+	Usage:        "get the key of an encrypted container from a key provider: exec[:path], env[:variable] or fd:number",
+	EnvKeys:      []string{"KEY_PROVIDER"},
+}
+
 // -F|--force
 var commonForceFlag = cmdline.Flag{
 	ID:           "commonForceFlag",
@@ -477,10 +488,9 @@ func defaultRemoteLogin(filepath string, c *scs.Config) error {
 	return nil
 }
 
-// sylabsRemote returns the remote in use or an error
-func sylabsRemote(filepath string) (*scs.EndPoint, error) {
-	var c *scs.Config
-
+// remoteConfigs returns the user remote configuration found at filepath
+// synced with the system remote configuration.
+func remoteConfigs(filepath string) (*scs.Config, error) {
 	// try to load both remotes, check for errors, sync if both exist,
 	// if neither exist return errNoDefault to return to old auth behavior
 	cSys, sysErr := loadRemoteConf(remoteConfigSys)
@@ -488,15 +498,23 @@ func sylabsRemote(filepath string) (*scs.EndPoint, error) {
 	if sysErr != nil && usrErr != nil {
 		return nil, scs.ErrNoDefault
 	} else if sysErr != nil {
-		c = cUsr
+		return cUsr, nil
 	} else if usrErr != nil {
-		c = cSys
-	} else {
-		// sync cUsr with system config cSys
-		if err := cUsr.SyncFrom(cSys); err != nil {
-			return nil, err
-		}
-		c = cUsr
+		return cSys, nil
+	}
+
+	// sync cUsr with system config cSys
+	if err := cUsr.SyncFrom(cSys); err != nil {
+		return nil, err
+	}
+	return cUsr, nil
+}
+
+// sylabsRemote returns the remote in use or an error
+func sylabsRemote(filepath string) (*scs.EndPoint, error) {
+	c, err := remoteConfigs(filepath)
+	if err != nil {
+		return nil, err
 	}
 
 	endpoint, err := c.GetDefault()
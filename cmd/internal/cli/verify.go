@@ -12,10 +12,14 @@ import (
 
 	"github.com/spf13/cobra"
 	"github.com/sylabs/singularity/docs"
+	"github.com/sylabs/singularity/internal/pkg/buildcfg"
 	scs "github.com/sylabs/singularity/internal/pkg/remote"
 	"github.com/sylabs/singularity/internal/pkg/sylog"
+	"github.com/sylabs/singularity/internal/pkg/util/fs"
 	"github.com/sylabs/singularity/pkg/cmdline"
+	"github.com/sylabs/singularity/pkg/runtime/engine/config"
 	"github.com/sylabs/singularity/pkg/signing"
+	"github.com/sylabs/singularity/pkg/sypgp"
 )
 
 var (
@@ -24,6 +28,13 @@ var (
 	localVerify bool   // -l flag
 	jsonVerify  bool   // -j flag
 	verifyAll   bool
+	caBundle    string // --ca-bundle
+	crlPath     string // --crl
+	policyPath  string // --policy
+	trustBundle string // --trust-bundle
+	failExpired bool   // --fail-expired
+
+	requireTrust string // --require-trust
 )
 
 // -u|--url
@@ -98,6 +109,65 @@ var verifyAllFlag = cmdline.Flag{
 	Usage:        "verify all non-signature partitions in a SIF",
 }
 
+// --ca-bundle
+var verifyCABundleFlag = cmdline.Flag{
+	ID:           "verifyCABundleFlag",
+	Value:        &caBundle,
+	DefaultValue: "",
+	Name:         "ca-bundle",
+	Usage:        "PEM file with the root certificates used to verify X.509 signatures",
+	EnvKeys:      []string{"CA_BUNDLE"},
+}
+
+// --crl
+var verifyCRLFlag = cmdline.Flag{
+	ID:           "verifyCRLFlag",
+	Value:        &crlPath,
+	DefaultValue: "",
+	Name:         "crl",
+	Usage:        "PEM or DER file with the certificate revocation lists checked while verifying X.509 signatures",
+}
+
+// --policy
+var verifyPolicyFlag = cmdline.Flag{
+	ID:           "verifyPolicyFlag",
+	Value:        &policyPath,
+	DefaultValue: "",
+	Name:         "policy",
+	Usage:        "verify the image against the policy of the YAML file (default system policy, if any)",
+	EnvKeys:      []string{"VERIFY_POLICY"},
+}
+
+// --trust-bundle
+var verifyTrustBundleFlag = cmdline.Flag{
+	ID:           "verifyTrustBundleFlag",
+	Value:        &trustBundle,
+	DefaultValue: "",
+	Name:         "trust-bundle",
+	Usage:        "verify with the keys of the trust bundle instead of the key server (default system trust bundle, if any)",
+	EnvKeys:      []string{"TRUST_BUNDLE"},
+}
+
+// --fail-expired
+var verifyFailExpiredFlag = cmdline.Flag{
+	ID:           "verifyFailExpiredFlag",
+	Value:        &failExpired,
+	DefaultValue: false,
+	Name:         "fail-expired",
+	Usage:        "fail the verification of signatures made after the expiration of their signing key, instead of warning",
+	EnvKeys:      []string{"FAIL_EXPIRED"},
+}
+
+// --require-trust
+var verifyRequireTrustFlag = cmdline.Flag{
+	ID:           "verifyRequireTrustFlag",
+	Value:        &requireTrust,
+	DefaultValue: "",
+	Name:         "require-trust",
+	Usage:        "minimum trust level (full or marginal) of the signing keys, see 'key trust'",
+	EnvKeys:      []string{"REQUIRE_TRUST"},
+}
+
 func init() {
 	cmdManager.RegisterCmd(VerifyCmd)
 
@@ -108,6 +178,13 @@ func init() {
 	cmdManager.RegisterFlagForCmd(&verifyLocalFlag, VerifyCmd)
 	cmdManager.RegisterFlagForCmd(&verifyJSONFlag, VerifyCmd)
 	cmdManager.RegisterFlagForCmd(&verifyAllFlag, VerifyCmd)
+	cmdManager.RegisterFlagForCmd(&verifyCABundleFlag, VerifyCmd)
+	cmdManager.RegisterFlagForCmd(&verifyCRLFlag, VerifyCmd)
+	cmdManager.RegisterFlagForCmd(&verifyPolicyFlag, VerifyCmd)
+	cmdManager.RegisterFlagForCmd(&verifyTrustBundleFlag, VerifyCmd)
+	cmdManager.RegisterFlagForCmd(&verifyFailExpiredFlag, VerifyCmd)
+	cmdManager.RegisterFlagForCmd(&keyringFlag, VerifyCmd)
+	cmdManager.RegisterFlagForCmd(&verifyRequireTrustFlag, VerifyCmd)
 }
 
 // VerifyCmd singularity verify
@@ -119,15 +196,25 @@ var VerifyCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		ctx := context.TODO()
 
-		if f, err := os.Stat(args[0]); os.IsNotExist(err) {
+		f, err := os.Stat(args[0])
+		if os.IsNotExist(err) {
 			sylog.Fatalf("No such file or directory: %s", args[0])
-		} else if f.IsDir() {
-			sylog.Fatalf("File is a directory: %s", args[0])
+		}
+
+		if err := sypgp.CheckKeyringName(keyringName); err != nil {
+			sylog.Fatalf("%s", err)
 		}
 
 		// dont need to resolve remote endpoint
-		if !localVerify {
+		if !localVerify && trustBundle == "" {
 			handleVerifyFlags(cmd)
+		} else {
+			useDefaultRemoteKeyring()
+		}
+
+		if err == nil && f.IsDir() {
+			doVerifySandboxCmd(ctx, cmd, args[0], keyServerURI)
+			return
 		}
 
 		// args[0] contains image path
@@ -171,7 +258,43 @@ func doVerifyCmd(ctx context.Context, cmd *cobra.Command, cpath, url string) {
 		sylog.Fatalf("'--all' not compatible with '--sif-id' or '--groupid'")
 	}
 
-	author, _, err := signing.Verify(ctx, cpath, url, id, isGroup, verifyAll, authToken, localVerify, jsonVerify)
+	opts := signing.VerifyOptions{
+		CABundle:       caBundle,
+		CRL:            crlPath,
+		FailExpiredKey: failExpired,
+		Keyring:        keyringName,
+	}
+
+	if requireTrust != "" {
+		level, err := sypgp.ParseTrustLevel(requireTrust)
+		if err != nil {
+			sylog.Fatalf("%s", err)
+		}
+		opts.RequireTrust = level
+	}
+
+	if policyPath != "" {
+		if id != 0 || isGroup || verifyAll {
+			sylog.Fatalf("'--policy' not compatible with '--sif-id', '--groupid' or '--all'")
+		}
+		policy, err := signing.LoadPolicy(policyPath)
+		if err != nil {
+			sylog.Fatalf("%s", err)
+		}
+		opts.Policy = policy
+	} else if id == 0 && !isGroup && !verifyAll && fs.IsFile(buildcfg.VERIFY_POLICY_FILE) {
+		// the system policy applies to the default verification only
+		sylog.Verbosef("Using system verification policy %s", buildcfg.VERIFY_POLICY_FILE)
+		policy, err := signing.LoadPolicy(buildcfg.VERIFY_POLICY_FILE)
+		if err != nil {
+			sylog.Fatalf("%s", err)
+		}
+		opts.Policy = policy
+	}
+
+	opts.TrustBundle = loadTrustBundle(trustBundle)
+
+	author, _, err := signing.Verify(ctx, cpath, url, id, isGroup, verifyAll, authToken, localVerify, jsonVerify, opts)
 	fmt.Printf("%s", author)
 	if err == signing.ErrVerificationFail {
 		sylog.Fatalf("Failed to verify: %s", cpath)
@@ -181,6 +304,86 @@ func doVerifyCmd(ctx context.Context, cmd *cobra.Command, cpath, url string) {
 	sylog.Infof("Container verified: %s", cpath)
 }
 
+func doVerifySandboxCmd(ctx context.Context, cmd *cobra.Command, dir, url string) {
+	if sifGroupID != 0 || sifDescID != 0 || verifyAll {
+		sylog.Fatalf("'--groupid', '--sif-id' and '--all' not compatible with sandbox images")
+	}
+	if policyPath != "" || caBundle != "" || crlPath != "" {
+		sylog.Fatalf("'--policy', '--ca-bundle' and '--crl' not compatible with sandbox images")
+	}
+
+	opts := signing.VerifyOptions{
+		FailExpiredKey: failExpired,
+		Keyring:        keyringName,
+		TrustBundle:    loadTrustBundle(trustBundle),
+	}
+	if requireTrust != "" {
+		level, err := sypgp.ParseTrustLevel(requireTrust)
+		if err != nil {
+			sylog.Fatalf("%s", err)
+		}
+		opts.RequireTrust = level
+	}
+
+	author, _, err := signing.VerifySandbox(ctx, dir, url, authToken, localVerify, jsonVerify, opts)
+	fmt.Printf("%s", author)
+	if err == signing.ErrVerificationFail {
+		sylog.Fatalf("Failed to verify: %s", dir)
+	} else if err != nil {
+		sylog.Fatalf("Failed to verify: %s: %s", dir, err)
+	}
+	sylog.Infof("Sandbox verified: %s", dir)
+}
+
+// systemTrustBundle returns the trust bundle set in singularity.conf, or nil
+// if there is none.
+func systemTrustBundle() *sypgp.TrustBundle {
+	if !fs.IsFile(buildcfg.SINGULARITY_CONF_FILE) {
+		return nil
+	}
+	c, err := config.ParseFile(buildcfg.SINGULARITY_CONF_FILE)
+	if err != nil {
+		sylog.Fatalf("Unable to parse singularity.conf file: %s", err)
+	}
+	if c.TrustBundle == "" {
+		return nil
+	}
+
+	sylog.Verbosef("Using system trust bundle %s", c.TrustBundle)
+	b, err := sypgp.LoadSystemTrustBundle(c.TrustBundle, c.TrustBundleSigners)
+	if err != nil {
+		sylog.Fatalf("Unable to load system trust bundle: %s", err)
+	}
+	return b
+}
+
+// loadTrustBundle returns the trust bundle at path, which must be signed by
+// a key of the local public keyring or of the system trust bundle. The system
+// trust bundle is returned if path is empty.
+func loadTrustBundle(path string) *sypgp.TrustBundle {
+	system := systemTrustBundle()
+	if path == "" {
+		return system
+	}
+
+	signers, err := keyringHandle().LoadPubKeyring()
+	if err != nil {
+		sylog.Fatalf("Could not load public keyring: %s", err)
+	}
+	if system != nil {
+		signers = append(signers, system.Keys...)
+	}
+	if len(signers) == 0 {
+		sylog.Fatalf("No public keys to check the trust bundle signature with")
+	}
+
+	b, err := sypgp.LoadTrustBundle(path, signers)
+	if err != nil {
+		sylog.Fatalf("Unable to load trust bundle: %s", err)
+	}
+	return b
+}
+
 func handleVerifyFlags(cmd *cobra.Command) {
 	// if we can load config and if default endpoint is set, use that
 	// otherwise fall back on regular authtoken and URI behavior
@@ -193,6 +396,7 @@ func handleVerifyFlags(cmd *cobra.Command) {
 	}
 
 	authToken = endpoint.Token
+	useRemoteKeyring(endpoint)
 	if !cmd.Flags().Lookup("url").Changed {
 		uri, err := endpoint.GetServiceURI("keystore")
 		if err != nil {
@@ -201,3 +405,33 @@ func handleVerifyFlags(cmd *cobra.Command) {
 		keyServerURI = uri
 	}
 }
+
+// useRemoteKeyring selects the keyring of the remote endpoint, unless a
+// keyring was set with --keyring.
+func useRemoteKeyring(endpoint *scs.EndPoint) {
+	if endpoint.Keyring == "" || keyringName != "" {
+		return
+	}
+	if err := sypgp.CheckKeyringName(endpoint.Keyring); err != nil {
+		sylog.Fatalf("Keyring of the remote endpoint: %s", err)
+	}
+	sylog.Verbosef("Using keyring %s of the remote endpoint", endpoint.Keyring)
+	keyringName = endpoint.Keyring
+}
+
+// useDefaultRemoteKeyring selects the keyring of the default remote
+// endpoint like useRemoteKeyring, without logging in to the endpoint, for
+// verifications which don't use its key server.
+func useDefaultRemoteKeyring() {
+	c, err := remoteConfigs(remoteConfig)
+	if err == nil {
+		var endpoint *scs.EndPoint
+		if endpoint, err = c.GetDefault(); err == nil {
+			useRemoteKeyring(endpoint)
+			return
+		}
+	}
+	if err != scs.ErrNoDefault {
+		sylog.Fatalf("Unable to load remote configuration: %v", err)
+	}
+}
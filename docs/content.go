@@ -61,7 +61,50 @@ Enterprise Performance Computing (EPC)`
       library://  an image library (default https://cloud.sylabs.io/library)
       docker://   a Docker registry (default Docker Hub)
       shub://     a Singularity registry (default Singularity Hub)
-      oras://     a supporting OCI registry`
+      oras://     a supporting OCI registry
+
+  MULTI-ARCHITECTURE IMAGES:
+
+  With the --arch-merge option, the build spec is replaced by a list of SIF
+  images built for different architectures which are merged into a single SIF
+  image. The image system partition matching the host architecture, or the one
+  requested with the --arch option of action commands, is selected at runtime.
+  Signatures are not carried over and the merged image must be signed again.
+
+  ENCRYPTED IMAGES:
+
+  With the --encrypt option, the file system of the image is encrypted with a
+  passphrase (--passphrase), an RSA key (--pem-path), or a random key wrapped
+  for the public keys of your keyring given with --recipient. Recipients need
+  an RSA encryption key, any of them can run the image with the matching
+  private key of their keyring, and they are managed afterward with the
+  'singularity crypt' commands.
+
+  The key can also be obtained without interaction from a key provider given
+  with --key-provider, or with the 'key provider' option of singularity.conf:
+
+      exec[:path]  runs a helper receiving the image ID on its standard input
+                   and writing the key on its standard output, the helper
+                   is set by 'key provider helper' if no path is given
+      env[:name]   reads the environment variable name, or
+                   SINGULARITY_ENCRYPTION_KEY
+      fd:n         reads the file descriptor n
+
+  A PEM encoded key is used as --pem-path would, anything else is a
+  passphrase.
+
+  VERITY IMAGES:
+
+  With the --verity option, a dm-verity hash tree of the file system is
+  stored in the SIF image along with its root hash. Signing the system
+  partition also signs the hash tree and the root hash data objects, each
+  with its own signature, the signature of the partition is unchanged.
+  When 'enable verity' is set in singularity.conf, the file system is
+  mounted through a verity device checking every block read against the
+  hash tree, so that a modification of the image made after its signature
+  was verified is detected. Images with a hash tree are then refused to run
+  unless a valid signature covers their root hash. Verity can't be used
+  with encryption.`
 
 	BuildExample string = `
 
@@ -155,7 +198,20 @@ Enterprise Performance Computing (EPC)`
       Build a base sandbox from DockerHub, make changes to it, then build sif
           $ singularity build --sandbox /tmp/debian docker://debian:latest
           $ singularity exec --writable /tmp/debian apt-get install python
-          $ singularity build /tmp/debian2.sif /tmp/debian`
+          $ singularity build /tmp/debian2.sif /tmp/debian
+
+      Build a multi-architecture sif from single architecture sif images:
+          $ singularity build --arch-merge /tmp/debian.sif /tmp/debian-amd64.sif /tmp/debian-arm64.sif
+
+      Build an encrypted sif for two recipients of your public keyring:
+          $ sudo singularity build --encrypt \
+              --recipient D87FE3AF5C1F063FCBCC9B02F812842B5EEE5934 \
+              --recipient 8883491F4268F173C6E5DC49EDECE4F3F38D871E \
+              /tmp/debian3.sif /path/to/debian.def
+
+      Build a sif protected by a dm-verity hash tree, then sign it:
+          $ sudo singularity build --verity /tmp/debian4.sif /path/to/debian.def
+          $ singularity sign /tmp/debian4.sif`
 
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
 	// Cache
@@ -211,7 +267,12 @@ Enterprise Performance Computing (EPC)`
 	KeyShort string = `Manage OpenPGP keys`
 	KeyLong  string = `
   Manage your trusted, public and private keys in your keyring
-  (default: '~/.singularity/sypgp' if 'SINGULARITY_SYPGPDIR' is not set.)`
+  (default: '~/.singularity/sypgp' if 'SINGULARITY_SYPGPDIR' is not set.)
+
+  Other named keyrings are stored in the 'keyrings' directory of the same
+  location and are selected with --keyring <name>, or with the
+  SINGULARITY_KEYRING environment variable, e.g. to keep personal, team and
+  release keys apart.`
 	KeyExample string = `
   All group commands have their own help output:
 
@@ -256,10 +317,18 @@ Enterprise Performance Computing (EPC)`
 	KeyNewPairLong  string = `
   The 'key newpair' command allows you to create a new key or public/private
   keys to be stored in the default user local key store location (e.g., 
-  $HOME/.singularity/sypgp).`
+  $HOME/.singularity/sypgp).
+
+  The key is made of a primary key, which certifies a separate signing subkey
+  used by 'singularity sign', and of an encryption subkey for RSA keys. The
+  key type is set with --type: rsa2048, rsa3072, rsa4096, ecdsa-p256 or
+  ecdsa-p384, an RSA key of --bit-length bits is created by default. The key
+  never expires unless a validity period is set with --expire, it can be
+  renewed later with 'singularity key extend'.`
 	KeyNewPairExample string = `
   $ singularity key newpair
-  $ singularity key newpair --password=psk --name=your-name --comment="key comment" --email=mail@email.com --push=false`
+  $ singularity key newpair --password=psk --name=your-name --comment="key comment" --email=mail@email.com --push=false
+  $ singularity key newpair --type ecdsa-p256 --expire 2y`
 
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
 	// key list
@@ -268,10 +337,12 @@ Enterprise Performance Computing (EPC)`
 	KeyListShort string = `List keys in your local keyring`
 	KeyListLong  string = `
   List your local keys in your keyring. Will list public (trusted) keys
-  by default.`
+  by default. --keyrings lists the names of the available keyrings instead.`
 	KeyListExample string = `
   $ singularity key list
-  $ singularity key list --secret`
+  $ singularity key list --secret
+  $ singularity key list --keyrings
+  $ singularity key list --keyring release`
 
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
 	// key search
@@ -326,6 +397,292 @@ Enterprise Performance Computing (EPC)`
 	KeyRemoveExample string = `
   $ singularity key remove D87FE3AF5C1F063FCBCC9B02F812842B5EEE5934`
 
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// key revoke
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	KeyRevokeUse   string = `revoke [revoke options...] <fingerprint>`
+	KeyRevokeShort string = `Revoke a key of your private keyring`
+	KeyRevokeLong  string = `
+  The 'key revoke' command generates a revocation certificate with a private
+  key of your keyring and adds it to the matching public key. Signatures made
+  with a revoked key are reported as revoked by 'singularity verify'. The
+  revocation can't be undone.
+
+  The revocation certificate can be saved to a file with --output, to be
+  imported by others with 'singularity key import', and published to a key
+  server with --push.`
+	KeyRevokeExample string = `
+  $ singularity key revoke D87FE3AF5C1F063FCBCC9B02F812842B5EEE5934
+
+  Revoke a key and publish the revocation:
+  $ singularity key revoke --push D87FE3AF5C1F063FCBCC9B02F812842B5EEE5934`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// key extend
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	KeyExtendUse   string = `extend --expire <period> [extend options...] <fingerprint>`
+	KeyExtendShort string = `Change the expiration of a key of your private keyring`
+	KeyExtendLong  string = `
+  The 'key extend' command changes the expiration of a key of your private
+  keyring, and of its subkeys, to the validity period given with --expire
+  counted from now: a number of days, or a number followed by d (days), w
+  (weeks), m (months) or y (years). With --expire never, the key never
+  expires. The public key is updated in your keyring and can be published to
+  a key server with --push.`
+	KeyExtendExample string = `
+  $ singularity key extend --expire 2y D87FE3AF5C1F063FCBCC9B02F812842B5EEE5934
+
+  Remove the expiration of a key and publish it:
+  $ singularity key extend --expire never --push D87FE3AF5C1F063FCBCC9B02F812842B5EEE5934`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// key trust
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	KeyTrustUse   string = `trust [trust options...] <fingerprint> full|marginal|none`
+	KeyTrustShort string = `Set the trust level of a key of your public keyring`
+	KeyTrustLong  string = `
+  The 'key trust' command sets the trust level of a key of your public
+  keyring to full, marginal or none. The trust levels are stored next to the
+  keyring and shown by 'singularity key list'. Keys created with 'singularity
+  key newpair' are fully trusted, any other key, e.g. imported or pulled from
+  a key server, isn't trusted until its trust level is set.
+
+  'singularity verify' reports the trust level of the signing keys, and with
+  --require-trust fails the verification of signatures made by keys of a
+  lower trust level.`
+	KeyTrustExample string = `
+  $ singularity key trust D87FE3AF5C1F063FCBCC9B02F812842B5EEE5934 full
+
+  $ singularity verify --local --require-trust full container.sif`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// key bundle
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	KeyBundleUse   string = `bundle`
+	KeyBundleShort string = `Manage trust bundles`
+	KeyBundleLong  string = `
+  A trust bundle is a signed file holding trusted public keys and their
+  revocations. It is used by 'singularity verify --trust-bundle' in place of
+  the key server, for verification on systems without network access.`
+	KeyBundleExample string = `
+  All group commands have their own help output:
+
+  $ singularity help key bundle create
+  $ singularity key bundle create --help`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// key bundle create
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	KeyBundleCreateUse   string = `create --fingerprints <fingerprint,...> --output <file>`
+	KeyBundleCreateShort string = `Create a trust bundle from keys of your public keyring`
+	KeyBundleCreateLong  string = `
+  The 'key bundle create' command writes the public keys of your keyring
+  matching the given fingerprints, with their revocations, to a trust bundle
+  signed with a private key of your keyring. The public key of the signer is
+  part of the bundle.
+
+  A bundle given to 'singularity verify --trust-bundle' must be signed by a
+  key of the local public keyring or of the system trust bundle, set by the
+  administrator in singularity.conf.`
+	KeyBundleCreateExample string = `
+  $ singularity key bundle create \
+      --fingerprints D87FE3AF5C1F063FCBCC9B02F812842B5EEE5934 \
+      --output trust.bundle`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// crypt
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	CryptUse   string = `crypt`
+	CryptShort string = `Manage the keys of encrypted images`
+	CryptLong  string = `
+  The file system key of an image built with 'singularity build --encrypt
+  --recipient' is wrapped for the public key of each recipient. The crypt
+  commands add and remove recipients, or replace the PEM key or passphrase of
+  an image, by rewrapping the key, without re-encrypting the file system.
+  Signatures of the image must be made again after a change of keys.`
+	CryptExample string = `
+  All group commands have their own help output:
+
+  $ singularity help crypt add-recipient
+  $ singularity crypt add-recipient --help`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// crypt add-recipient
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	CryptAddRecipientUse   string = `add-recipient [add-recipient options...] <image path> <fingerprint>...`
+	CryptAddRecipientShort string = `Wrap the file system key of an encrypted image for new recipients`
+	CryptAddRecipientLong  string = `
+  The 'crypt add-recipient' command wraps the file system key of an encrypted
+  image for the public keys of your keyring matching the given fingerprints.
+  The key is unwrapped with a private key of your keyring matching a current
+  recipient of the image. Recipients need an RSA encryption key.`
+	CryptAddRecipientExample string = `
+  $ singularity crypt add-recipient container.sif 8883491F4268F173C6E5DC49EDECE4F3F38D871E`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// crypt remove-recipient
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	CryptRemoveRecipientUse   string = `remove-recipient [remove-recipient options...] <image path> <fingerprint>...`
+	CryptRemoveRecipientShort string = `Remove recipients of an encrypted image`
+	CryptRemoveRecipientLong  string = `
+  The 'crypt remove-recipient' command removes the file system key of an
+  encrypted image wrapped for the public keys of your keyring matching the
+  given fingerprints. The last recipient of an image can't be removed.
+
+  A removed recipient who already ran the image may still know the file
+  system key, rebuild the image to make sure it can't be decrypted anymore.`
+	CryptRemoveRecipientExample string = `
+  $ singularity crypt remove-recipient container.sif 8883491F4268F173C6E5DC49EDECE4F3F38D871E`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// crypt rekey
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	CryptRekeyUse   string = `rekey [rekey options...] <image path>`
+	CryptRekeyShort string = `Replace the PEM key or passphrase of an encrypted image`
+	CryptRekeyLong  string = `
+  The 'crypt rekey' command unwraps the file system key of an encrypted image
+  with the old key, given with --passphrase or --pem-path, and stores it
+  wrapped with the new key, given with --new-passphrase or --new-pem-path.
+  The encrypted partition is left untouched.
+
+  Images built with --passphrase use the passphrase itself as file system
+  key, rekeying them wraps this passphrase with the new key and checks the
+  old passphrase with cryptsetup first. Images can be rekeyed any number of
+  times, the images built with --recipient are managed with 'crypt
+  add-recipient' and 'crypt remove-recipient'.
+
+  A leaked key may already have been used to obtain the file system key,
+  rebuild the image to make sure it can't be decrypted anymore.`
+	CryptRekeyExample string = `
+  $ singularity crypt rekey --pem-path old.pem --new-pem-path new.pem container.sif
+
+  $ singularity crypt rekey --pem-path old.pem --new-passphrase container.sif
+  $ singularity run --passphrase container.sif`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// overlay
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	OverlayUse   string = `overlay`
+	OverlayShort string = `Manage writable overlay images`
+	OverlayLong  string = `
+  The overlay commands create the writable overlay images given to the
+  --overlay option of the action commands, or embedded in SIF images.`
+	OverlayExample string = `
+  All group commands have their own help output:
+
+  $ singularity help overlay create
+  $ singularity overlay create --help`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// overlay create
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	OverlayCreateUse   string = `create [create options...] <image path>`
+	OverlayCreateShort string = `Create a writable overlay image`
+	OverlayCreateLong  string = `
+  The 'overlay create' command creates an ext3 overlay image of the given size.
+  If the image path is an existing SIF container image, the overlay partition
+  is embedded in it and used each time the container runs.
+
+  With --encrypt, the overlay file system is encrypted with cryptsetup, like
+  images built with 'singularity build --encrypt', and the key is given with
+  --passphrase or --pem-path. A new encrypted overlay is created as a SIF
+  overlay image. The same key source must be given to the action commands
+  using the overlay, a PEM key pair is used with its public key to create the
+  overlay and its private key to run with it. Creating an encrypted overlay
+  requires root privileges.
+
+  The encrypted overlays are closed when the container exits.`
+	OverlayCreateExample string = `
+  $ singularity overlay create --size 1024 overlay.img
+  $ singularity shell --overlay overlay.img container.sif
+
+  $ sudo singularity overlay create --encrypt --pem-path rsa_pub.pem overlay.sif
+  $ singularity shell --pem-path rsa_pri.pem --overlay overlay.sif container.sif
+
+  $ sudo singularity overlay create --encrypt --passphrase container.sif
+  $ singularity shell --passphrase --writable container.sif`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// ecl
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	EclUse   string = `ecl`
+	EclShort string = `Manage the execution control list`
+	EclLong  string = `
+  The ecl commands validate the execution control list configuration file
+  (ecl.toml), show how it applies to an image and add or remove execution
+  groups. An invalid configuration file prevents SIF containers from running,
+  validate it after each modification.`
+	EclExample string = `
+  All group commands have their own help output:
+
+  $ singularity help ecl test
+  $ singularity ecl group add --help`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// ecl validate
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	EclValidateUse   string = `validate [file]`
+	EclValidateShort string = `Validate an execution control list configuration file`
+	EclValidateLong  string = `
+  The 'ecl validate' command checks the syntax and the execution groups of an
+  ECL configuration file, the system ecl.toml if no file is given.`
+	EclValidateExample string = `
+  $ singularity ecl validate
+  $ singularity ecl validate /tmp/ecl.toml`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// ecl test
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	EclTestUse   string = `test [test options...] <image path>`
+	EclTestShort string = `Show whether the execution control list allows an image to run`
+	EclTestLong  string = `
+  The 'ecl test' command shows the execution group matching a SIF image, its
+  signers and digest, and whether the image would run or be blocked with the
+  reason. The decision is evaluated even if the ECL is not activated, and it
+  is not written to the audit log. The --user option evaluates the decision
+  as another user, the image is opened with the credentials and the groups
+  of this user so the test fails if the user can't read it. Only root can
+  test the decision for another user.`
+	EclTestExample string = `
+  $ singularity ecl test /var/cache/containers/container.sif
+  $ sudo singularity ecl test --user alice --config /tmp/ecl.toml container.sif`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// ecl group
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	EclGroupUse   string = `group`
+	EclGroupShort string = `Add or remove execution groups`
+	EclGroupLong  string = `
+  The ecl group commands add or remove execution groups of the ECL
+  configuration file. The file is validated and replaced atomically, the
+  comment block at the beginning of the file is preserved but the comments
+  between or after the settings are lost.`
+	EclGroupExample string = `
+  $ singularity ecl group add --mode whitelist --dirpath /data/containers \
+      --keyfp 5994BE54C31CF1B5E1994F987C52CF6D055F072B group1
+  $ singularity ecl group remove group1`
+
+	EclGroupAddUse   string = `add [add options...] <tagname>`
+	EclGroupAddShort string = `Add an execution group`
+	EclGroupAddLong  string = `
+  The 'ecl group add' command adds an execution group to the ECL configuration
+  file. The group is rejected if the resulting configuration is not valid, for
+  example when its dirpath overlaps with the dirpath of another group.`
+	EclGroupAddExample string = `
+  $ singularity ecl group add --mode whitestrict --dirpath /data/containers \
+      --keyfp 5994BE54C31CF1B5E1994F987C52CF6D055F072B \
+      --keyfp 7064B1D6EFF01B1262FED3F03581D99FE87EAFD1 group1
+  $ singularity ecl group add --mode sha256 --dirpath '/opt/containers/**' \
+      --sha256 9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08 pinned`
+
+	EclGroupRemoveUse   string = `remove <tagname>`
+	EclGroupRemoveShort string = `Remove an execution group`
+	EclGroupRemoveLong  string = `
+  The 'ecl group remove' command removes an execution group from the ECL
+  configuration file.`
+	EclGroupRemoveExample string = `
+  $ singularity ecl group remove group1`
+
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
 	// delete
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
@@ -587,7 +944,16 @@ Enterprise Performance Computing (EPC)`
       oras://registry/namespace/image:tag
 
   http, https: Pull an image using the http(s?) protocol
-      https://library.sylabs.io/v1/imagefile/library/default/alpine:latest`
+      https://library.sylabs.io/v1/imagefile/library/default/alpine:latest
+
+  With --policy, the pulled image is verified against the verification policy
+  of a YAML file and removed if it doesn't satisfy it, see 'singularity help
+  verify'.
+
+  Images pulled with oras are verified against the detached signature pushed
+  with 'singularity push --sign', if any. A failed verification is only
+  reported, with --require-signature the image is removed if there is no
+  detached signature or if it is invalid.`
 	PullExample string = `
   From Sylabs cloud library
   $ singularity pull alpine.sif library://alpine:latest
@@ -599,7 +965,13 @@ Enterprise Performance Computing (EPC)`
   $ singularity pull singularity-images.sif shub://vsoch/singularity-images
 
   From supporting OCI registry (e.g. Azure Container Registry)
-  $ singularity pull image.sif oras://<username>.azurecr.io/namespace/image:tag`
+  $ singularity pull image.sif oras://<username>.azurecr.io/namespace/image:tag
+
+  Verified against a verification policy
+  $ singularity pull --policy policy.yaml alpine.sif library://alpine:latest
+
+  With a mandatory detached signature from a supporting OCI registry
+  $ singularity pull --require-signature image.sif oras://registry/namespace/image:tag`
 
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
 	// push
@@ -616,6 +988,9 @@ Enterprise Performance Computing (EPC)`
   oras:
       oras://registry/namespace/repo:tag
 
+  With --sign, a detached signature of the image made with a private key of
+  the keyring is pushed to an oras repository along with the image, tagged
+  after the digest of the image manifest. 'singularity pull' verifies it.
 
   NOTE: It's always good practice to sign your containers before
   pushing them to the library. An auth token is required to push to the library,
@@ -625,7 +1000,10 @@ Enterprise Performance Computing (EPC)`
   $ singularity push /home/user/my.sif library://user/collection/my.sif:latest
 
   To supported OCI registry
-  $ singularity push /home/user/my.sif oras://registry/namespace/image:tag`
+  $ singularity push /home/user/my.sif oras://registry/namespace/image:tag
+
+  To supported OCI registry with a detached signature
+  $ singularity push --sign /home/user/my.sif oras://registry/namespace/image:tag`
 
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
 	// search
@@ -652,6 +1030,11 @@ Enterprise Performance Computing (EPC)`
   automatically. All arguments following the container name will be passed
   directly to the runscript.
 
+  Containers built from Docker or OCI images keep the original image config.
+  With --oci-config, the ENTRYPOINT of this config is executed instead of the
+  runscript, followed by the arguments given after the container name, or by
+  the CMD without arguments, in the WORKDIR of the image unless --pwd is set.
+
   singularity run accepts the following container formats:` + formats
 	RunExamples string = `
   # Here we see that the runscript prints "Hello world: "
@@ -664,7 +1047,11 @@ Enterprise Performance Computing (EPC)`
   Hello world: one two three
 
   # Note that this does the same thing
-  $ ./tmp/debian.sif one two three`
+  $ ./tmp/debian.sif one two three
+
+  # Run the ENTRYPOINT and CMD of the Docker image in its WORKDIR
+  $ singularity build nginx.sif docker://nginx
+  $ singularity run --oci-config nginx.sif`
 
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
 	// shell
@@ -710,9 +1097,54 @@ Enterprise Performance Computing (EPC)`
   default without parameters, the command searches for the primary partition and 
   creates a verification block that is then added to the SIF container file.
   
-  To generate a keypair, see 'singularity help key newpair'`
+  To generate a keypair, see 'singularity help key newpair'
+
+  With --certificate and --key, the signature is created with the private key
+  of an X.509 certificate instead of a PGP key. The certificate file must
+  start with the signer certificate, intermediate certificates following it
+  are stored in the signature so that the certificate chain can be validated
+  by 'singularity verify --ca-bundle'.
+
+  With --replace, the existing signatures of the signed data objects are
+  removed once the new signature is created, e.g. after a key rotation.
+  --remove deletes the signatures made by a key or certificate fingerprint,
+  or every signature with 'all', from the whole image or from the data object
+  or group selected with --sif-id or --groupid. The image is left unchanged
+  if an error occurs.
+
+  With --gpg-agent, the signature is created by gpg-agent with a secret key
+  of your GnuPG keyring, selected with --keyid or the default GnuPG key, so
+  that GnuPG keys don't need to be imported in the Singularity keyring. The
+  passphrase is asked by the pinentry program of gpg-agent. RSA and ECDSA
+  keys are supported. The public key must be imported, or pushed to the key
+  server, to verify the signature:
+    $ gpg --export --armor <keyid> > key.asc
+    $ singularity key import key.asc
+
+  The private key is taken from the keyring set with --keyring, the default
+  keyring otherwise.
+
+  SANDBOX IMAGES:
+
+  When the image path is a sandbox directory, a manifest listing the path,
+  mode and SHA-256 digest of every file of the sandbox is signed with the
+  selected PGP key, or with --gpg-agent, and stored in
+  .singularity.d/manifest.sig, replacing a previous one. The manifest is
+  generated in a deterministic order and doesn't depend on file times.`
 	SignExample string = `
-  $ singularity sign container.sif`
+  $ singularity sign container.sif
+
+  $ singularity sign sandbox/
+
+  $ singularity sign --keyring release container.sif
+
+  $ singularity sign --certificate signer.pem --key signer-key.pem container.sif
+
+  $ singularity sign --replace container.sif
+
+  $ singularity sign --gpg-agent --keyid alice@example.com container.sif
+
+  $ singularity sign --remove D87FE3AF5C1F063FCBCC9B02F812842B5EEE5934 container.sif`
 
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
 	// verify
@@ -725,9 +1157,156 @@ Enterprise Performance Computing (EPC)`
   multiple data objects signed. By default the command searches for the primary 
   partition signature. If found, a list of all verification blocks applied on 
   the primary partition is gathered so that data integrity (hashing) and 
-  signature verification is done for all those blocks.`
+  signature verification is done for all those blocks.
+
+  PGP and X.509 signatures can be attached to the same image. The certificate
+  chain of X.509 signatures is validated with the root certificates of the
+  file set with --ca-bundle: the signer certificate must be valid now, allow
+  digital signatures and code signing, and may be checked against the
+  certificate revocation lists of the file set with --crl.
+
+  With --policy, all the signatures of the image are verified and the result
+  is decided by the verification policy of a YAML file, which can require:
+  signed data objects (Partitions), a minimum number of signatures from a set
+  of fingerprints (Signers), signatures created after a date (NotBefore) and
+  signing keys found in the key server rather than in the local keyring
+  (AllowLocalKeys). The system policy, if installed in
+  ${prefix}/etc/singularity/verify-policy.yaml, is used by default unless a
+  data object or group is selected. An example is installed along with it.
+
+  With --trust-bundle, the keys and revocations of a trust bundle created by
+  'singularity key bundle create' are used instead of the key server. The
+  bundle must be signed by a key of the local public keyring or of the system
+  trust bundle, which is used by default when set in singularity.conf.
+
+  Signatures made after the expiration of their signing key are reported as
+  [EXPIRED], with --fail-expired they fail the verification.
+
+  The local public keys are taken from the keyring set with --keyring, or
+  from the keyring of the remote endpoint set with 'singularity remote add
+  --keyring' when verifying against its key server, the default keyring
+  otherwise.
+
+  The trust level of the signing keys is reported as [TRUST]: keys of the
+  local keyring have the level set with 'singularity key trust', keys of a
+  trust bundle are fully trusted and keys fetched from the key server aren't
+  trusted. With --require-trust full or marginal, signatures made by keys of
+  a lower trust level are reported as [UNTRUSTED] and fail the verification.
+
+  When the image path is a sandbox directory, the signed manifest created by
+  'singularity sign' is verified like a PGP signature, then the files of the
+  sandbox are compared with it: files added, removed or modified since the
+  manifest was signed are reported as [ADDED], [REMOVED] and [MODIFIED] and
+  fail the verification. --policy, --ca-bundle and --crl don't apply to
+  sandboxes.`
 	VerifyExample string = `
-  $ singularity verify container.sif`
+  $ singularity verify container.sif
+
+  $ singularity verify sandbox/
+
+  $ singularity verify --policy policy.yaml container.sif
+
+  $ singularity verify --ca-bundle roots.pem --crl revoked.crl container.sif
+
+  $ singularity verify --trust-bundle trust.bundle container.sif
+
+  $ singularity verify --local --require-trust full container.sif`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// image
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	ImageUse   string = `image`
+	ImageShort string = `Manage image files`
+	ImageLong  string = `
+  Manage and validate Singularity image files.`
+	ImageExample string = `
+  All group commands have their own help output:
+
+  $ singularity help image check
+  $ singularity image check --help`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// image check
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	ImageCheckUse   string = `check [check options...] <image path>`
+	ImageCheckShort string = `Check the structure of an image file`
+	ImageCheckLong  string = `
+  The image check command validates the structure of an image file without
+  running it. For SIF images it checks the global header and descriptors, that
+  every data object lies within the file (detecting truncated transfers), the
+  squashfs and ext3 superblocks of partitions, and that every signature is
+  linked to an existing data object or group. Squashfs and ext3 image files
+  have their superblock checked.
+
+  The command exits with a non-zero status if a problem is found.`
+	ImageCheckExample string = `
+  $ singularity image check container.sif
+
+  $ singularity image check --json container.sif`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// label
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	LabelUse   string = `label`
+	LabelShort string = `Manage image labels`
+	LabelLong  string = `
+  List and modify the labels of a SIF image or of a sandbox directory without
+  rebuilding it. SIF image labels are stored in the labels data object of the
+  image, sandbox labels are stored in .singularity.d/labels.json.`
+	LabelExample string = `
+  All group commands have their own help output:
+
+  $ singularity help label set
+  $ singularity label set --help`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// label list
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	LabelListUse   string = `list [list options...] <image path>`
+	LabelListShort string = `List the labels of an image`
+	LabelListLong  string = `
+  The label list command prints the labels of a SIF image or of a sandbox
+  directory.`
+	LabelListExample string = `
+  $ singularity label list container.sif
+
+  $ singularity label list --json container.sif`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// label set
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	LabelSetUse   string = `set [set options...] <image path> <key>=<value> [<key>=<value>...]`
+	LabelSetShort string = `Add or replace labels of an image`
+	LabelSetLong  string = `
+  The label set command adds labels to a SIF image or a sandbox directory,
+  existing labels with the same key are replaced.
+
+  For SIF images, the labels data object is rewritten in place, an image
+  without labels data object starts from the labels written in the container
+  filesystem at build time. If the labels were signed, their signatures don't
+  match anymore and the command offers to sign the image again with a key of
+  the local keyring.`
+	LabelSetExample string = `
+  $ singularity label set container.sif maintainer="Jane Doe <jane@example.com>"
+
+  $ singularity label set container.sif org.label-schema.doi=10.1000/182`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// label unset
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	LabelUnsetUse   string = `unset [unset options...] <image path> <key> [<key>...]`
+	LabelUnsetShort string = `Remove labels from an image`
+	LabelUnsetLong  string = `
+  The label unset command removes labels from a SIF image or a sandbox
+  directory.
+
+  For SIF images, the labels data object is rewritten in place, an image
+  without labels data object starts from the labels written in the container
+  filesystem at build time. If the labels were signed, their signatures don't
+  match anymore and the command offers to sign the image again with a key of
+  the local keyring.`
+	LabelUnsetExample string = `
+  $ singularity label unset container.sif maintainer`
 
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
 	// Run-help
@@ -770,9 +1349,17 @@ Enterprise Performance Computing (EPC)`
   Inspect will show you labels, environment variables, apps and scripts associated 
   with the image determined by the flags you pass. By default, they will be shown in 
   plain text. If you would like to list them in json format, you should use the --json flag.
+
+  The --all flag prints a single json document holding the labels, definition
+  file, scripts, environment and help of the container and of every app, along
+  with the SIF descriptors, signatures and partition sizes. The document carries
+  a schemaVersion field and its schema is defined by the Go types of the
+  github.com/sylabs/singularity/pkg/inspect package.
   `
 	InspectExample string = `
   $ singularity inspect ubuntu.sif
+
+  $ singularity inspect --all --json ubuntu.sif
   
   If you want to list the applications (apps) installed in a container (located at
   /scif/apps) you should run inspect command with --list-apps <container-image> flag.
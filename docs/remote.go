@@ -29,9 +29,13 @@ const (
 	RemoteAddLong  string = `
 	The 'remote add' command allows you to create a new remote endpoint to be
 	be used for singularity remote services. Authentication with a newly created
-	endpoint will occur automatically.`
+	endpoint will occur automatically.
+
+	With --keyring, the named keyring is used by default to verify the images
+	of the remote with 'singularity verify' and 'singularity pull'.`
 	RemoteAddExample string = `
-  $ singularity remote add SylabsCloud cloud.sylabs.io`
+  $ singularity remote add SylabsCloud cloud.sylabs.io
+  $ singularity remote add --keyring release Release cloud.example.com`
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
 	// remote remove command
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
@@ -81,6 +81,7 @@ require (
 	github.com/sylabs/scs-key-client v0.4.1
 	github.com/sylabs/scs-library-client v0.4.4
 	github.com/sylabs/sif v1.0.8
+	github.com/ulikunitz/xz v0.5.6
 	github.com/urfave/cli v1.21.0 // indirect
 	github.com/vbatts/go-mtree v0.4.4 // indirect
 	github.com/vbauerster/mpb v3.4.0+incompatible // indirect
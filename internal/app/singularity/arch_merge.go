@@ -0,0 +1,165 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/sylabs/sif/pkg/sif"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+	"github.com/sylabs/singularity/pkg/image"
+)
+
+// ArchMerge combines the SIF images srcs, built for different architectures,
+// into a single multi-architecture SIF image written to dst. The primary system
+// partition of the first image remains the primary one, system partitions of
+// other images are stored as system partitions, each of them with its own
+// group holding the data objects of the original image. Signatures are not
+// copied, the resulting image must be signed again.
+func ArchMerge(dst string, srcs []string) error {
+	if len(srcs) < 2 {
+		return fmt.Errorf("at least two images are required for an architecture merge")
+	}
+
+	cinfo := sif.CreateInfo{
+		Pathname:   dst,
+		Launchstr:  sif.HdrLaunch,
+		Sifversion: sif.HdrVersion,
+		ID:         uuid.NewV4(),
+	}
+
+	archs := make(map[string]string)
+	groupID := uint32(sif.DescrDefaultGroup)
+	signatures := 0
+
+	for i, src := range srcs {
+		fimg, err := sif.LoadContainer(src, true)
+		if err != nil {
+			return fmt.Errorf("failed to load SIF image %s: %s", src, err)
+		}
+		defer fimg.UnloadContainer()
+
+		for _, d := range image.GetSystemPartitions(&fimg) {
+			arch, err := d.GetArch()
+			if err != nil {
+				return fmt.Errorf("while reading architecture of %s: %s", src, err)
+			}
+			goArch := sif.GetGoArch(string(arch[:sif.HdrArchLen-1]))
+			if other, ok := archs[goArch]; ok {
+				return fmt.Errorf("both %s and %s contain a system partition for architecture %s", other, src, goArch)
+			}
+			archs[goArch] = src
+		}
+
+		input, n, next, err := archMergeInputs(&fimg, len(cinfo.InputDescr), groupID, i == 0)
+		if err != nil {
+			return fmt.Errorf("while merging %s: %s", src, err)
+		}
+		cinfo.InputDescr = append(cinfo.InputDescr, input...)
+		signatures += n
+		groupID = next
+	}
+
+	if signatures > 0 {
+		sylog.Warningf("%d signature(s) from source images were not copied, sign %s again", signatures, dst)
+	}
+
+	// remove anything that may exist at the destination at last moment
+	os.RemoveAll(dst)
+
+	if _, err := sif.CreateContainer(cinfo); err != nil {
+		return fmt.Errorf("while creating multi-architecture image: %s", err)
+	}
+	return nil
+}
+
+// archMergeInputs returns the descriptor inputs required to copy the data objects of
+// fimg, except signatures, into a new SIF image where offset descriptors were already
+// added and where groupID is the next free group. IDs, groups and links are renumbered
+// accordingly, the primary system partition is demoted to a simple system partition
+// when primary is false. It returns the number of skipped signatures and the next
+// free group.
+func archMergeInputs(fimg *sif.FileImage, offset int, groupID uint32, primary bool) ([]sif.DescriptorInput, int, uint32, error) {
+	var input []sif.DescriptorInput
+
+	r, ok := fimg.Fp.(io.ReaderAt)
+	if !ok {
+		return nil, 0, 0, fmt.Errorf("SIF image doesn't support random access")
+	}
+
+	ids := make(map[uint32]uint32)
+	groups := make(map[uint32]uint32)
+	signatures := 0
+
+	for _, d := range fimg.DescrArr {
+		if !d.Used {
+			continue
+		}
+		if d.Datatype == sif.DataSignature {
+			signatures++
+			continue
+		}
+		ids[d.ID] = uint32(offset + len(input) + 1)
+		if _, ok := groups[d.Groupid]; !ok && d.Groupid != sif.DescrUnusedGroup {
+			groups[d.Groupid] = groupID
+			groupID++
+		}
+		input = append(input, sif.DescriptorInput{})
+	}
+
+	i := 0
+	for _, d := range fimg.DescrArr {
+		if !d.Used || d.Datatype == sif.DataSignature {
+			continue
+		}
+
+		in := &input[i]
+		i++
+
+		in.Datatype = d.Datatype
+		in.Groupid = sif.DescrUnusedGroup
+		if g, ok := groups[d.Groupid]; ok {
+			in.Groupid = g
+		}
+		in.Link = sif.DescrUnusedLink
+		if d.Link&sif.DescrGroupMask == sif.DescrGroupMask {
+			in.Link = groups[d.Link]
+		} else if d.Link != sif.DescrUnusedLink {
+			in.Link = ids[d.Link]
+		}
+		in.Fname = d.GetName()
+		in.Fp = io.NewSectionReader(r, d.Fileoff, d.Filelen)
+		in.Size = d.Filelen
+
+		extra := d.Extra[:]
+		if d.Datatype == sif.DataPartition {
+			ptype, err := d.GetPartType()
+			if err != nil {
+				return nil, 0, 0, err
+			}
+			if ptype == sif.PartPrimSys && !primary {
+				fstype, err := d.GetFsType()
+				if err != nil {
+					return nil, 0, 0, err
+				}
+				arch, err := d.GetArch()
+				if err != nil {
+					return nil, 0, 0, err
+				}
+				if err := in.SetPartExtra(fstype, sif.PartSystem, string(arch[:sif.HdrArchLen-1])); err != nil {
+					return nil, 0, 0, err
+				}
+				continue
+			}
+		}
+		in.Extra.Write(extra)
+	}
+
+	return input, signatures, groupID, nil
+}
@@ -0,0 +1,129 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/sylabs/sif/pkg/sif"
+	"github.com/sylabs/singularity/pkg/image"
+)
+
+const testSquash = "../../../pkg/image/testdata/squashfs.v4"
+
+func createArchSIF(t *testing.T, dir, arch string) string {
+	return createRootFsSIF(t, filepath.Join(dir, arch+".sif"), testSquash, arch)
+}
+
+// createRootFsSIF creates at path a SIF image for arch with the squashfs
+// image rootfs as primary partition.
+func createRootFsSIF(t *testing.T, path, rootfs, arch string) string {
+	fp, err := os.Open(rootfs)
+	if err != nil {
+		t.Fatalf("failed to open %s: %s", rootfs, err)
+	}
+	defer fp.Close()
+
+	fi, err := fp.Stat()
+	if err != nil {
+		t.Fatalf("failed to stat %s: %s", rootfs, err)
+	}
+
+	definput := sif.DescriptorInput{
+		Datatype: sif.DataDeffile,
+		Groupid:  sif.DescrDefaultGroup,
+		Link:     sif.DescrUnusedLink,
+		Data:     []byte("bootstrap: scratch\n# " + arch),
+	}
+	definput.Size = int64(binary.Size(definput.Data))
+
+	parinput := sif.DescriptorInput{
+		Datatype: sif.DataPartition,
+		Groupid:  sif.DescrDefaultGroup,
+		Link:     sif.DescrUnusedLink,
+		Fname:    "rootfs",
+		Fp:       fp,
+		Size:     fi.Size(),
+	}
+	if err := parinput.SetPartExtra(sif.FsSquash, sif.PartPrimSys, sif.GetSIFArch(arch)); err != nil {
+		t.Fatalf("failed to set partition extra data: %s", err)
+	}
+
+	cinfo := sif.CreateInfo{
+		Pathname:   path,
+		Launchstr:  sif.HdrLaunch,
+		Sifversion: sif.HdrVersion,
+		ID:         uuid.NewV4(),
+		InputDescr: []sif.DescriptorInput{definput, parinput},
+	}
+	if _, err := sif.CreateContainer(cinfo); err != nil {
+		t.Fatalf("failed to create SIF %s: %s", path, err)
+	}
+
+	return path
+}
+
+func TestArchMerge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "arch-merge-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	amd64 := createArchSIF(t, dir, "amd64")
+	arm64 := createArchSIF(t, dir, "arm64")
+	merged := filepath.Join(dir, "merged.sif")
+
+	if err := ArchMerge(merged, []string{amd64}); err == nil {
+		t.Errorf("unexpected success with a single image")
+	}
+	if err := ArchMerge(merged, []string{amd64, amd64}); err == nil {
+		t.Errorf("unexpected success with two images of the same architecture")
+	}
+	if err := ArchMerge(merged, []string{amd64, arm64}); err != nil {
+		t.Fatalf("unexpected error while merging images: %s", err)
+	}
+
+	fimg, err := sif.LoadContainer(merged, true)
+	if err != nil {
+		t.Fatalf("failed to load %s: %s", merged, err)
+	}
+	archs := image.GetSIFArchs(&fimg)
+	fimg.UnloadContainer()
+
+	if len(archs) != 2 || archs[0] != "amd64" || archs[1] != "arm64" {
+		t.Fatalf("unexpected architectures in merged image: %v", archs)
+	}
+
+	var offsets []uint64
+	for _, arch := range archs {
+		img, err := image.InitArch(merged, false, arch)
+		if err != nil {
+			t.Fatalf("unexpected error while loading %s for %s: %s", merged, arch, err)
+		}
+		img.File.Close()
+
+		if !img.HasRootFs() {
+			t.Fatalf("no root filesystem found for %s", arch)
+		}
+		if len(img.Sections) != 1 {
+			t.Errorf("unexpected number of sections for %s: %d", arch, len(img.Sections))
+		}
+		offsets = append(offsets, img.Partitions[0].Offset)
+	}
+	if offsets[0] == offsets[1] {
+		t.Errorf("same system partition selected for both architectures")
+	}
+
+	if _, err := image.InitArch(merged, false, "ppc64le"); err == nil {
+		t.Errorf("unexpected success for an architecture not present in the image")
+	}
+}
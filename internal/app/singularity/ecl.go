@@ -0,0 +1,117 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/sylabs/singularity/internal/pkg/syecl"
+	"github.com/sylabs/singularity/internal/pkg/util/user"
+	imgutil "github.com/sylabs/singularity/pkg/image"
+)
+
+// EclGroup describes an execution group added with EclGroupAdd.
+type EclGroup struct {
+	TagName string
+	Mode    string
+	DirPath string
+	KeyFPs  []string
+	Sha256  []string
+}
+
+// EclValidate loads and validates the ECL configuration file path.
+func EclValidate(path string) (*syecl.EclConfig, error) {
+	ecl, err := syecl.LoadConfig(path)
+	if err != nil {
+		return nil, fmt.Errorf("while loading %s: %s", path, err)
+	}
+	if err := ecl.ValidateConfig(); err != nil {
+		return nil, fmt.Errorf("invalid configuration %s: %s", path, err)
+	}
+	return &ecl, nil
+}
+
+// EclTest returns the decision of the ECL configuration file path for the
+// image found at image, run by username (a name or a UID) if not empty,
+// and whether the ECL is activated. The image is opened with the
+// credentials of username, only root can test the decision for another user.
+func EclTest(path, image, username string) (*syecl.Decision, bool, error) {
+	ecl, err := EclValidate(path)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var u *user.User
+	if username != "" {
+		if uid, err := strconv.ParseUint(username, 10, 32); err == nil {
+			u, err = user.GetPwUID(uint32(uid))
+		} else {
+			u, err = user.GetPwNam(username)
+		}
+		if err != nil {
+			return nil, false, fmt.Errorf("while looking up user %s: %s", username, err)
+		}
+		if int(u.UID) == os.Getuid() {
+			u = nil
+		} else if os.Geteuid() != 0 {
+			return nil, false, fmt.Errorf("only root can test the decision for user %s", username)
+		}
+	}
+
+	// execgroups match the directory of the resolved container path
+	// like at runtime
+	resolved, err := imgutil.ResolvePath(image)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if u == nil {
+		d, err := ecl.Test(resolved)
+		if err != nil {
+			return nil, false, fmt.Errorf("while testing %s: %s", image, err)
+		}
+		return d, ecl.Activated, nil
+	}
+
+	fp, err := openAsUser(resolved, u)
+	if err != nil {
+		return nil, false, fmt.Errorf("user %s can't open %s: %s", u.Name, image, err)
+	}
+	defer fp.Close()
+
+	d := ecl.TestFp(fp)
+	d.UID = int(u.UID)
+	d.User = u.Name
+	return d, ecl.Activated, nil
+}
+
+// EclGroupAdd adds the execution group g to the ECL configuration file path.
+func EclGroupAdd(path string, g EclGroup) error {
+	ecl, err := EclValidate(path)
+	if err != nil {
+		return err
+	}
+	if err := ecl.AddGroup(g.TagName, g.Mode, g.DirPath, g.KeyFPs, g.Sha256); err != nil {
+		return fmt.Errorf("while adding execgroup %s: %s", g.TagName, err)
+	}
+	return syecl.PutConfig(*ecl, path)
+}
+
+// EclGroupRemove removes the execution group tagname from the ECL
+// configuration file path. The configuration is not validated, so that an
+// invalid execution group can be removed.
+func EclGroupRemove(path, tagname string) error {
+	ecl, err := syecl.LoadConfig(path)
+	if err != nil {
+		return fmt.Errorf("while loading %s: %s", path, err)
+	}
+	if err := ecl.RemoveGroup(tagname); err != nil {
+		return err
+	}
+	return syecl.PutConfig(ecl, path)
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"fmt"
+	"os"
+	osuser "os/user"
+	"runtime"
+	"strconv"
+	"syscall"
+	"unsafe"
+
+	"github.com/sylabs/singularity/internal/pkg/util/user"
+)
+
+// userGroups returns the primary and supplementary groups of u.
+func userGroups(u *user.User) ([]uint32, error) {
+	groups := []uint32{u.GID}
+
+	ou, err := osuser.LookupId(strconv.FormatUint(uint64(u.UID), 10))
+	if err != nil {
+		return nil, err
+	}
+	gids, err := ou.GroupIds()
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range gids {
+		gid, err := strconv.ParseUint(g, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("bad group ID %s", g)
+		}
+		if uint32(gid) != u.GID {
+			groups = append(groups, uint32(gid))
+		}
+	}
+	return groups, nil
+}
+
+// openAsUser opens path read-only with the filesystem credentials and the
+// groups of u, so that the file is only opened if u could open it. The
+// credentials are set on a locked thread which is never unlocked, the
+// thread exits with its goroutine and the other goroutines keep the
+// credentials of the process.
+func openAsUser(path string, u *user.User) (*os.File, error) {
+	groups, err := userGroups(u)
+	if err != nil {
+		return nil, fmt.Errorf("while getting groups of user %s: %s", u.Name, err)
+	}
+
+	type result struct {
+		fp  *os.File
+		err error
+	}
+	c := make(chan result, 1)
+
+	go func() {
+		runtime.LockOSThread()
+
+		// setgroups is applied to all threads by the syscall package,
+		// the raw system call only changes the groups of this thread
+		_, _, errno := syscall.RawSyscall(syscall.SYS_SETGROUPS, uintptr(len(groups)), uintptr(unsafe.Pointer(&groups[0])), 0)
+		if errno != 0 {
+			c <- result{err: fmt.Errorf("while setting groups: %s", errno)}
+			return
+		}
+		syscall.RawSyscall(syscall.SYS_SETFSGID, uintptr(u.GID), 0, 0)
+		syscall.RawSyscall(syscall.SYS_SETFSUID, uintptr(u.UID), 0, 0)
+
+		// setfsuid doesn't report errors, it returns the previous fsuid
+		if fsuid, _, _ := syscall.RawSyscall(syscall.SYS_SETFSUID, ^uintptr(0), 0, 0); uint32(fsuid) != u.UID {
+			c <- result{err: fmt.Errorf("could not switch to user %s", u.Name)}
+			return
+		}
+
+		fp, err := os.Open(path)
+		c <- result{fp, err}
+	}()
+
+	r := <-c
+	return r.fp, r.err
+}
@@ -0,0 +1,61 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sylabs/singularity/internal/pkg/test"
+	"github.com/sylabs/singularity/internal/pkg/util/user"
+)
+
+func TestOpenAsUser(t *testing.T) {
+	test.EnsurePrivilege(t)
+
+	nobody, err := user.GetPwNam("nobody")
+	if err != nil {
+		t.Skipf("user nobody not found: %s", err)
+	}
+
+	dir, err := ioutil.TempDir("", "ecl-user-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Chmod(dir, 0755); err != nil {
+		t.Fatalf("failed to change mode of %s: %s", dir, err)
+	}
+
+	private := filepath.Join(dir, "private.sif")
+	public := filepath.Join(dir, "public.sif")
+	if err := ioutil.WriteFile(private, nil, 0600); err != nil {
+		t.Fatalf("failed to create %s: %s", private, err)
+	}
+	if err := ioutil.WriteFile(public, nil, 0644); err != nil {
+		t.Fatalf("failed to create %s: %s", public, err)
+	}
+
+	if fp, err := openAsUser(private, nobody); err == nil {
+		fp.Close()
+		t.Errorf("user nobody should not be able to open %s", private)
+	}
+
+	fp, err := openAsUser(public, nobody)
+	if err != nil {
+		t.Fatalf("user nobody should be able to open %s: %s", public, err)
+	}
+	fp.Close()
+
+	// the credentials of the other threads are left unchanged
+	fp, err = os.Open(private)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	fp.Close()
+}
@@ -0,0 +1,303 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sylabs/sif/pkg/sif"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+	"github.com/sylabs/singularity/pkg/image"
+	"github.com/sylabs/singularity/pkg/inspect"
+)
+
+// InspectAll returns the metadata of the image found at path, reading
+// the image root filesystem without starting a container. Container
+// metadata are left empty for root filesystems which can't be read
+// without mounting them.
+func InspectAll(path string) (*inspect.Metadata, error) {
+	img, err := image.Init(path, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load image %s: %s", path, err)
+	}
+	defer img.File.Close()
+
+	md := &inspect.Metadata{
+		SchemaVersion: inspect.SchemaVersion,
+		Path:          img.Path,
+		Attributes:    newAttributes(),
+		Apps:          make([]inspect.App, 0),
+		Partitions:    make([]inspect.Partition, 0),
+		Descriptors:   make([]inspect.Descriptor, 0),
+		Signatures:    make([]inspect.Signature, 0),
+	}
+
+	if img.Type != image.SANDBOX {
+		fi, err := img.File.Stat()
+		if err != nil {
+			return nil, fmt.Errorf("could not stat %s: %s", img.Path, err)
+		}
+		md.Size = fi.Size()
+	}
+
+	switch img.Type {
+	case image.SANDBOX:
+		md.Format = inspect.FormatSandbox
+	case image.SIF:
+		md.Format = inspect.FormatSIF
+		if err := inspectSIF(img.Path, md); err != nil {
+			return nil, err
+		}
+	case image.SQUASHFS:
+		md.Format = inspect.FormatSquashfs
+		md.Partitions = append(md.Partitions, inspect.Partition{Filesystem: "squashfs", Type: "primary-system", Size: md.Size})
+	case image.EXT3:
+		md.Format = inspect.FormatExt3
+		md.Partitions = append(md.Partitions, inspect.Partition{Filesystem: "ext3", Type: "primary-system", Size: md.Size})
+	}
+
+	rootfs, err := image.NewRootFS(img)
+	if err == image.ErrUnsupportedFS {
+		sylog.Warningf("Container metadata of %s are not available: %s", img.Path, err)
+		return md, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := inspectAttributes(rootfs, "/.singularity.d", &md.Attributes); err != nil {
+		return nil, err
+	}
+
+	apps, err := rootfs.ReadDir("/scif/apps")
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, a := range apps {
+		prefix := "/scif/apps/" + a.Name() + "/scif"
+		if fi, err := rootfs.Stat(prefix); err != nil || !fi.IsDir() {
+			continue
+		}
+		app := inspect.App{
+			Name:       a.Name(),
+			Attributes: newAttributes(),
+		}
+		if err := inspectAttributes(rootfs, prefix, &app.Attributes); err != nil {
+			return nil, err
+		}
+		md.Apps = append(md.Apps, app)
+	}
+
+	return md, nil
+}
+
+func newAttributes() inspect.Attributes {
+	return inspect.Attributes{
+		Labels:      make(map[string]string),
+		Environment: make(map[string]string),
+	}
+}
+
+// inspectAttributes fills attrs with the metadata found in the container
+// directory dir, labels and definition file already set are kept.
+func inspectAttributes(rootfs image.FS, dir string, attrs *inspect.Attributes) error {
+	files := []struct {
+		name  string
+		value *string
+	}{
+		{"Singularity", &attrs.Deffile},
+		{"runscript", &attrs.Runscript},
+		{"startscript", &attrs.Startscript},
+		{"test", &attrs.Test},
+		{"runscript.help", &attrs.Helpfile},
+	}
+
+	for _, f := range files {
+		if *f.value != "" {
+			continue
+		}
+		data, err := readRegularFile(rootfs, dir+"/"+f.name)
+		if err != nil {
+			return err
+		}
+		*f.value = string(data)
+	}
+
+	if len(attrs.Labels) == 0 {
+		data, err := readRegularFile(rootfs, dir+"/labels.json")
+		if err != nil {
+			return err
+		}
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, &attrs.Labels); err != nil {
+				sylog.Warningf("Unable to parse labels: %s", err)
+			}
+		}
+	}
+
+	env, err := rootfs.ReadDir(dir + "/env")
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, e := range env {
+		if !e.Mode().IsRegular() || !strings.HasSuffix(e.Name(), ".sh") {
+			continue
+		}
+		data, err := rootfs.ReadFile(dir + "/env/" + e.Name())
+		if err != nil {
+			return err
+		}
+		attrs.Environment[e.Name()] = string(data)
+	}
+
+	return nil
+}
+
+// readRegularFile returns the content of the regular file found at path,
+// nil if there is no regular file at path.
+func readRegularFile(rootfs image.FS, path string) ([]byte, error) {
+	fi, err := rootfs.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if !fi.Mode().IsRegular() {
+		return nil, nil
+	}
+	return rootfs.ReadFile(path)
+}
+
+// inspectSIF fills md with the SIF descriptors, partitions, signatures,
+// labels and definition file of the SIF image found at path.
+func inspectSIF(path string, md *inspect.Metadata) error {
+	fimg, err := sif.LoadContainer(path, true)
+	if err != nil {
+		return fmt.Errorf("failed to load SIF image %s: %s", path, err)
+	}
+	defer fimg.UnloadContainer()
+
+	labels, _, err := readSIFLabels(&fimg)
+	if err != nil {
+		return err
+	}
+	md.Attributes.Labels = labels
+
+	if descrs, _, err := fimg.GetLinkedDescrsByType(sif.DescrUnusedLink, sif.DataDeffile); err == nil {
+		md.Attributes.Deffile = string(descrs[0].GetData(&fimg))
+	}
+
+	for _, d := range fimg.DescrArr {
+		if !d.Used {
+			continue
+		}
+
+		descr := inspect.Descriptor{
+			ID:     d.ID,
+			Type:   image.DatatypeName(d.Datatype),
+			Name:   d.GetName(),
+			Group:  d.Groupid &^ sif.DescrGroupMask,
+			Link:   d.Link &^ sif.DescrGroupMask,
+			Offset: d.Fileoff,
+			Size:   d.Filelen,
+		}
+		if d.Link&sif.DescrGroupMask == sif.DescrGroupMask {
+			descr.LinkGroup = true
+		}
+		md.Descriptors = append(md.Descriptors, descr)
+
+		switch d.Datatype {
+		case sif.DataPartition:
+			part := inspect.Partition{
+				ID:         d.ID,
+				Filesystem: "unknown",
+				Type:       "unknown",
+				Size:       d.Filelen,
+			}
+			if fstype, err := d.GetFsType(); err == nil {
+				part.Filesystem = fstypeString(fstype)
+			}
+			// encrypted partitions other than squashfs are raw partitions
+			// described by their encryption info
+			if info, _, err := image.GetEncryptionInfo(&fimg, &d); err == nil && part.Filesystem == "raw" {
+				part.Filesystem = "encrypted-" + info.Filesystem
+			}
+			if ptype, err := d.GetPartType(); err == nil {
+				part.Type = parttypeString(ptype)
+			}
+			if arch, err := d.GetArch(); err == nil {
+				if goArch := sif.GetGoArch(string(arch[:sif.HdrArchLen-1])); goArch != "unknown" {
+					part.Arch = goArch
+				}
+			}
+			md.Partitions = append(md.Partitions, part)
+		case sif.DataSignature:
+			sig := inspect.Signature{
+				ID:        d.ID,
+				Link:      descr.Link,
+				LinkGroup: descr.LinkGroup,
+				Hash:      "unknown",
+			}
+			if htype, err := d.GetHashType(); err == nil {
+				sig.Hash = hashtypeString(htype)
+			}
+			if entity, err := d.GetEntityString(); err == nil {
+				sig.Fingerprint = entity
+			}
+			md.Signatures = append(md.Signatures, sig)
+		}
+	}
+
+	return nil
+}
+
+func fstypeString(fstype sif.Fstype) string {
+	switch fstype {
+	case sif.FsSquash:
+		return "squashfs"
+	case sif.FsExt3:
+		return "ext3"
+	case sif.FsEncryptedSquashfs:
+		return "encrypted-squashfs"
+	case sif.FsRaw:
+		return "raw"
+	case sif.FsImmuObj:
+		return "archive"
+	}
+	return "unknown"
+}
+
+func parttypeString(ptype sif.Parttype) string {
+	switch ptype {
+	case sif.PartPrimSys:
+		return "primary-system"
+	case sif.PartSystem:
+		return "system"
+	case sif.PartData:
+		return "data"
+	case sif.PartOverlay:
+		return "overlay"
+	}
+	return "unknown"
+}
+
+func hashtypeString(htype sif.Hashtype) string {
+	switch htype {
+	case sif.HashSHA256:
+		return "sha256"
+	case sif.HashSHA384:
+		return "sha384"
+	case sif.HashSHA512:
+		return "sha512"
+	case sif.HashBLAKE2S:
+		return "blake2s"
+	case sif.HashBLAKE2B:
+		return "blake2b"
+	}
+	return "unknown"
+}
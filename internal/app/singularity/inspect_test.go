@@ -0,0 +1,148 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sylabs/sif/pkg/sif"
+	"github.com/sylabs/singularity/pkg/image"
+	"github.com/sylabs/singularity/pkg/inspect"
+)
+
+func TestInspectAllSIF(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inspect-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := createArchSIF(t, dir, "amd64")
+	addLabelsSIF(t, path, []byte(`{"maintainer": "nobody"}`))
+
+	md, err := InspectAll(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if md.SchemaVersion != inspect.SchemaVersion || md.Format != inspect.FormatSIF {
+		t.Errorf("unexpected schema version %q or format %q", md.SchemaVersion, md.Format)
+	}
+	if md.Attributes.Deffile != "bootstrap: scratch\n# amd64" {
+		t.Errorf("unexpected definition file: %q", md.Attributes.Deffile)
+	}
+	if md.Attributes.Labels["maintainer"] != "nobody" {
+		t.Errorf("unexpected labels: %v", md.Attributes.Labels)
+	}
+	if len(md.Descriptors) != 4 {
+		t.Errorf("unexpected number of descriptors: %d", len(md.Descriptors))
+	}
+	if len(md.Partitions) != 1 || md.Partitions[0].Type != "primary-system" || md.Partitions[0].Filesystem != "squashfs" || md.Partitions[0].Arch != "amd64" || md.Partitions[0].Size == 0 {
+		t.Errorf("unexpected partitions: %+v", md.Partitions)
+	}
+	if len(md.Signatures) != 1 || !md.Signatures[0].LinkGroup || md.Signatures[0].Link != 1 || md.Signatures[0].Hash != "sha384" {
+		t.Errorf("unexpected signatures: %+v", md.Signatures)
+	}
+}
+
+func TestInspectAllEncryptedOverlay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inspect-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := createArchSIF(t, dir, "amd64")
+
+	fimg, err := sif.LoadContainer(path, false)
+	if err != nil {
+		t.Fatalf("failed to load %s: %s", path, err)
+	}
+	part := sif.DescriptorInput{
+		Datatype: sif.DataPartition,
+		Groupid:  sif.DescrDefaultGroup,
+		Link:     sif.DescrUnusedLink,
+		Data:     []byte("encrypted overlay"),
+		Fname:    "overlay.img",
+	}
+	part.Size = int64(len(part.Data))
+	if err := part.SetPartExtra(sif.FsRaw, sif.PartOverlay, sif.GetSIFArch("amd64")); err != nil {
+		t.Fatalf("failed to set partition extra data: %s", err)
+	}
+	if err := fimg.AddObject(part); err != nil {
+		t.Fatalf("failed to add overlay partition: %s", err)
+	}
+	var partID uint32
+	for _, d := range fimg.DescrArr {
+		if ptype, err := d.GetPartType(); d.Used && err == nil && ptype == sif.PartOverlay {
+			partID = d.ID
+		}
+	}
+	info, err := image.EncryptionInfoInput(image.EncryptionInfo{Filesystem: image.EncryptedExt3}, sif.DescrDefaultGroup, partID)
+	if err != nil {
+		t.Fatalf("failed to create encryption info: %s", err)
+	}
+	if err := fimg.AddObject(info); err != nil {
+		t.Fatalf("failed to add encryption info: %s", err)
+	}
+	fimg.UnloadContainer()
+
+	md, err := InspectAll(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(md.Partitions) != 2 || md.Partitions[1].Type != "overlay" || md.Partitions[1].Filesystem != "encrypted-ext3" {
+		t.Errorf("unexpected partitions: %+v", md.Partitions)
+	}
+}
+
+func TestInspectAllSandbox(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inspect-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		".singularity.d/labels.json":            `{"maintainer": "nobody"}`,
+		".singularity.d/runscript":              "#!/bin/sh\n",
+		".singularity.d/startscript":            "#!/bin/sh\n",
+		".singularity.d/env/90-environment.sh":  "export A=B\n",
+		"scif/apps/foo/scif/runscript.help":     "help\n",
+		"scif/apps/foo/scif/env/94-appsbase.sh": "export C=D\n",
+		"scif/apps/notanapp/README":             "",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create %s: %s", filepath.Dir(path), err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %s", path, err)
+		}
+	}
+
+	md, err := InspectAll(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if md.Format != inspect.FormatSandbox || md.Size != 0 || len(md.Descriptors) != 0 {
+		t.Errorf("unexpected sandbox metadata: %+v", md)
+	}
+	if md.Attributes.Labels["maintainer"] != "nobody" || md.Attributes.Runscript == "" || md.Attributes.Startscript == "" {
+		t.Errorf("unexpected attributes: %+v", md.Attributes)
+	}
+	if md.Attributes.Environment["90-environment.sh"] != "export A=B\n" {
+		t.Errorf("unexpected environment: %v", md.Attributes.Environment)
+	}
+	if len(md.Apps) != 1 || md.Apps[0].Name != "foo" || md.Apps[0].Attributes.Helpfile != "help\n" || len(md.Apps[0].Attributes.Environment) != 1 {
+		t.Errorf("unexpected apps: %+v", md.Apps)
+	}
+}
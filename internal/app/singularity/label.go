@@ -0,0 +1,348 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/sylabs/sif/pkg/sif"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+	"github.com/sylabs/singularity/pkg/image"
+)
+
+// labelsPath is the location of the labels file in the container filesystem.
+const labelsPath = "/.singularity.d/labels.json"
+
+// StaleSignature describes a signature of a SIF image which doesn't match
+// anymore after a label modification.
+type StaleSignature struct {
+	// ID is the descriptor ID of the signature.
+	ID uint32
+	// Link is the descriptor ID or the group ID the signature applies to.
+	Link uint32
+	// Group is true when the signature applies to a group of descriptors.
+	Group bool
+}
+
+// LabelList returns the labels of the SIF image or sandbox found at path.
+func LabelList(path string) (map[string]string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not stat %s: %s", path, err)
+	}
+
+	if fi.IsDir() {
+		return readSandboxLabels(path)
+	}
+
+	fimg, err := sif.LoadContainer(path, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load SIF image %s: %s", path, err)
+	}
+	defer fimg.UnloadContainer()
+
+	labels, _, err := readImageLabels(path, &fimg)
+	return labels, err
+}
+
+// LabelSet adds or replaces labels of the SIF image or sandbox found at
+// path and removes labels listed in unset. For SIF images, it returns
+// the signatures which don't match the image anymore.
+func LabelSet(path string, set map[string]string, unset []string) ([]StaleSignature, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not stat %s: %s", path, err)
+	}
+
+	if fi.IsDir() {
+		labels, err := readSandboxLabels(path)
+		if err != nil {
+			return nil, err
+		}
+		if !updateLabels(labels, set, unset) {
+			return nil, nil
+		}
+		data, err := marshalLabels(labels)
+		if err != nil {
+			return nil, err
+		}
+		err = replaceFile(filepath.Join(path, labelsPath), 0644, func(tmp *os.File) error {
+			_, err := tmp.Write(data)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("while writing labels: %s", err)
+		}
+		return nil, nil
+	}
+
+	fimg, err := sif.LoadContainer(path, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load SIF image %s: %s", path, err)
+	}
+	defer fimg.UnloadContainer()
+
+	return setSIFLabels(&fimg, path, set, unset)
+}
+
+// setSIFLabels applies set and unset to the labels of the SIF image fimg
+// loaded from path, the labels data object is replaced in place. It returns
+// the signatures which don't match the image anymore.
+func setSIFLabels(fimg *sif.FileImage, path string, set map[string]string, unset []string) ([]StaleSignature, error) {
+	labels, descr, err := readImageLabels(path, fimg)
+	if err != nil {
+		return nil, err
+	}
+	if !updateLabels(labels, set, unset) {
+		return nil, nil
+	}
+	data, err := marshalLabels(labels)
+	if err != nil {
+		return nil, err
+	}
+
+	input := sif.DescriptorInput{
+		Datatype: sif.DataLabels,
+		Groupid:  sif.DescrDefaultGroup,
+		Link:     sif.DescrUnusedLink,
+		Data:     data,
+		Fname:    "labels.json",
+	}
+	input.Size = int64(binary.Size(input.Data))
+
+	var stale []StaleSignature
+	if descr != nil {
+		input.Groupid = descr.Groupid
+		stale = staleSignatures(fimg, descr)
+
+		_, index, err := fimg.GetFromDescrID(descr.ID)
+		if err != nil {
+			return nil, fmt.Errorf("while removing labels: %s", err)
+		}
+		if err := fimg.DeleteObject(descr.ID, 0); err != nil {
+			return nil, fmt.Errorf("while removing labels: %s", err)
+		}
+		// DeleteObject only clears the descriptor in the file, AddObject
+		// would write it back with the descriptor table
+		fimg.DescrArr[index] = sif.Descriptor{}
+	}
+
+	if err := fimg.AddObject(input); err != nil {
+		return nil, fmt.Errorf("while writing labels: %s", err)
+	}
+
+	// signatures of the labels descriptor must now link to the
+	// descriptor of the new labels
+	descrs, _, err := fimg.GetLinkedDescrsByType(sif.DescrUnusedLink, sif.DataLabels)
+	if err != nil {
+		return nil, fmt.Errorf("while looking for new labels: %s", err)
+	}
+	for i := range stale {
+		if !stale[i].Group {
+			stale[i].Link = descrs[0].ID
+		}
+	}
+
+	return stale, nil
+}
+
+// replaceFile atomically replaces the file at path with a temporary file
+// created in the same directory, with permissions mode, and filled by write.
+// The file at path is left untouched if write fails.
+func replaceFile(path string, mode os.FileMode, write func(tmp *os.File) error) (err error) {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+"-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if err = write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// LabelResign removes the stale signatures from the SIF image found at path
+// and calls sign to sign again the descriptor or the group each of them
+// applied to.
+func LabelResign(path string, stale []StaleSignature, sign func(path string, id uint32, isGroup bool) error) error {
+	fimg, err := sif.LoadContainer(path, false)
+	if err != nil {
+		return fmt.Errorf("failed to load SIF image %s: %s", path, err)
+	}
+	for _, s := range stale {
+		if err := fimg.DeleteObject(s.ID, sif.DelZero); err != nil {
+			fimg.UnloadContainer()
+			return fmt.Errorf("while removing signature %d: %s", s.ID, err)
+		}
+	}
+	fimg.UnloadContainer()
+
+	for _, s := range stale {
+		id := s.Link
+		if s.Group {
+			id &^= sif.DescrGroupMask
+		}
+		if err := sign(path, id, s.Group); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readSIFLabels returns the labels stored in the SIF labels data object
+// and its descriptor, nil if the image doesn't contain labels.
+func readSIFLabels(fimg *sif.FileImage) (map[string]string, *sif.Descriptor, error) {
+	labels := make(map[string]string)
+
+	descrs, _, err := fimg.GetLinkedDescrsByType(sif.DescrUnusedLink, sif.DataLabels)
+	if err != nil || len(descrs) == 0 {
+		return labels, nil, nil
+	}
+
+	descr := descrs[0]
+	if err := json.Unmarshal(descr.GetData(fimg), &labels); err != nil {
+		return nil, nil, fmt.Errorf("while parsing labels: %s", err)
+	}
+	return labels, descr, nil
+}
+
+// readImageLabels returns the labels of the SIF image fimg loaded from path
+// and the descriptor of its labels data object. Without a labels data object,
+// the labels written at build time in the root filesystem are returned,
+// like inspect does, along with a nil descriptor.
+func readImageLabels(path string, fimg *sif.FileImage) (map[string]string, *sif.Descriptor, error) {
+	labels, descr, err := readSIFLabels(fimg)
+	if err != nil || descr != nil {
+		return labels, descr, err
+	}
+	labels, err = readRootFsLabels(path)
+	return labels, nil, err
+}
+
+// readRootFsLabels returns the labels found in the root filesystem of the
+// image at path, read without mounting it.
+func readRootFsLabels(path string) (map[string]string, error) {
+	labels := make(map[string]string)
+
+	img, err := image.Init(path, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open image %s: %s", path, err)
+	}
+	defer img.File.Close()
+
+	if !img.HasRootFs() {
+		return labels, nil
+	}
+	rootfs, err := image.NewRootFS(img)
+	if err == image.ErrUnsupportedFS {
+		sylog.Warningf("Labels stored in the container filesystem of %s are not available: %s", path, err)
+		return labels, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	b, err := rootfs.ReadFile(labelsPath)
+	if os.IsNotExist(err) {
+		return labels, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("while reading labels: %s", err)
+	}
+
+	if err := json.Unmarshal(b, &labels); err != nil {
+		return nil, fmt.Errorf("while parsing labels: %s", err)
+	}
+	return labels, nil
+}
+
+func readSandboxLabels(path string) (map[string]string, error) {
+	labels := make(map[string]string)
+
+	b, err := ioutil.ReadFile(filepath.Join(path, labelsPath))
+	if os.IsNotExist(err) {
+		return labels, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("while reading labels: %s", err)
+	}
+
+	if err := json.Unmarshal(b, &labels); err != nil {
+		return nil, fmt.Errorf("while parsing labels: %s", err)
+	}
+	return labels, nil
+}
+
+// updateLabels applies set and unset to labels, it returns false
+// if labels are left unchanged.
+func updateLabels(labels map[string]string, set map[string]string, unset []string) bool {
+	changed := false
+
+	for k, v := range set {
+		if old, ok := labels[k]; !ok || old != v {
+			labels[k] = v
+			changed = true
+		}
+	}
+	for _, k := range unset {
+		if _, ok := labels[k]; ok {
+			delete(labels, k)
+			changed = true
+		} else {
+			sylog.Warningf("Label %s not found", k)
+		}
+	}
+	return changed
+}
+
+func marshalLabels(labels map[string]string) ([]byte, error) {
+	// same format as labels written at build time
+	data, err := json.MarshalIndent(labels, "", "\t")
+	if err != nil {
+		return nil, fmt.Errorf("while encoding labels: %s", err)
+	}
+	return data, nil
+}
+
+// staleSignatures returns the signatures applying to the labels
+// descriptor, directly or through its group.
+func staleSignatures(fimg *sif.FileImage, descr *sif.Descriptor) []StaleSignature {
+	var stale []StaleSignature
+
+	for _, d := range fimg.DescrArr {
+		if !d.Used || d.Datatype != sif.DataSignature {
+			continue
+		}
+		if d.Link&sif.DescrGroupMask == sif.DescrGroupMask {
+			if d.Link == descr.Groupid {
+				stale = append(stale, StaleSignature{ID: d.ID, Link: d.Link, Group: true})
+			}
+		} else if d.Link == descr.ID {
+			stale = append(stale, StaleSignature{ID: d.ID, Link: d.Link})
+		}
+	}
+	return stale
+}
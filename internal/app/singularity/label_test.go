@@ -0,0 +1,210 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sylabs/sif/pkg/sif"
+)
+
+// testLabelsSquash is a squashfs image holding /.singularity.d/labels.json.
+const testLabelsSquash = "../../../pkg/image/testdata/squashfs.labels"
+
+// addLabelsSIF adds a labels data object holding data to the SIF image
+// found at path, along with a signature applying to its group.
+func addLabelsSIF(t *testing.T, path string, data []byte) {
+	fimg, err := sif.LoadContainer(path, false)
+	if err != nil {
+		t.Fatalf("failed to load %s: %s", path, err)
+	}
+	defer fimg.UnloadContainer()
+
+	labels := sif.DescriptorInput{
+		Datatype: sif.DataLabels,
+		Groupid:  sif.DescrDefaultGroup,
+		Link:     sif.DescrUnusedLink,
+		Fname:    "labels.json",
+		Data:     data,
+		Size:     int64(len(data)),
+	}
+	if err := fimg.AddObject(labels); err != nil {
+		t.Fatalf("failed to add labels to %s: %s", path, err)
+	}
+
+	sig := sif.DescriptorInput{
+		Datatype: sif.DataSignature,
+		Groupid:  sif.DescrUnusedGroup,
+		Link:     sif.DescrDefaultGroup,
+		Fname:    "group-signature",
+		Data:     []byte("signature"),
+		Size:     9,
+	}
+	if err := sig.SetSignExtra(sif.HashSHA384, "0123456789abcdef0123456789abcdef01234567"); err != nil {
+		t.Fatalf("failed to set signature extra data: %s", err)
+	}
+	if err := fimg.AddObject(sig); err != nil {
+		t.Fatalf("failed to add signature to %s: %s", path, err)
+	}
+}
+
+func TestLabelSIF(t *testing.T) {
+	dir, err := ioutil.TempDir("", "label-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := createArchSIF(t, dir, "amd64")
+	addLabelsSIF(t, path, []byte(`{"maintainer": "nobody", "version": "1.0"}`))
+	if err := os.Chmod(path, 0640); err != nil {
+		t.Fatalf("failed to change %s permissions: %s", path, err)
+	}
+
+	stale, err := LabelSet(path, map[string]string{"version": "1.0"}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error while setting labels: %s", err)
+	}
+	if len(stale) != 0 {
+		t.Errorf("unexpected stale signatures while labels are unchanged: %v", stale)
+	}
+
+	stale, err = LabelSet(path, map[string]string{"doi": "10.1000/182"}, []string{"maintainer"})
+	if err != nil {
+		t.Fatalf("unexpected error while setting labels: %s", err)
+	}
+	if len(stale) != 1 || !stale[0].Group || stale[0].Link != sif.DescrDefaultGroup {
+		t.Errorf("unexpected stale signatures: %v", stale)
+	}
+
+	labels, err := LabelList(path)
+	if err != nil {
+		t.Fatalf("unexpected error while listing labels: %s", err)
+	}
+	if len(labels) != 2 || labels["doi"] != "10.1000/182" || labels["version"] != "1.0" {
+		t.Errorf("unexpected labels: %v", labels)
+	}
+
+	// the image is updated in place
+	if fi, err := os.Stat(path); err != nil {
+		t.Errorf("failed to stat %s: %s", path, err)
+	} else if fi.Mode().Perm() != 0640 {
+		t.Errorf("image permissions not preserved: %s", fi.Mode())
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("unexpected files left in %s: %d", dir, len(files))
+	}
+
+	var signed []uint32
+	err = LabelResign(path, stale, func(path string, id uint32, isGroup bool) error {
+		if !isGroup {
+			t.Errorf("unexpected signature of descriptor %d", id)
+		}
+		signed = append(signed, id)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error while signing labels again: %s", err)
+	}
+	if len(signed) != 1 || signed[0] != 1 {
+		t.Errorf("unexpected signed groups: %v", signed)
+	}
+
+	fimg, err := sif.LoadContainer(path, true)
+	if err != nil {
+		t.Fatalf("failed to load %s: %s", path, err)
+	}
+	defer fimg.UnloadContainer()
+
+	if _, _, err := fimg.GetFromDescrID(stale[0].ID); err == nil {
+		t.Errorf("stale signature still present in %s", path)
+	}
+	if _, _, err := fimg.GetPartPrimSys(); err != nil {
+		t.Errorf("system partition lost while updating labels: %s", err)
+	}
+}
+
+func TestLabelSIFRootFs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "label-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the labels written at build time are only found in the
+	// root filesystem
+	path := createRootFsSIF(t, filepath.Join(dir, "labels.sif"), testLabelsSquash, "amd64")
+
+	labels, err := LabelList(path)
+	if err != nil {
+		t.Fatalf("unexpected error while listing labels: %s", err)
+	}
+	if len(labels) != 2 || labels["maintainer"] != "builder" {
+		t.Errorf("unexpected labels: %v", labels)
+	}
+
+	if _, err := LabelSet(path, map[string]string{"doi": "10.1000/182"}, []string{"maintainer"}); err != nil {
+		t.Fatalf("unexpected error while setting labels: %s", err)
+	}
+
+	labels, err = LabelList(path)
+	if err != nil {
+		t.Fatalf("unexpected error while listing labels: %s", err)
+	}
+	if len(labels) != 2 || labels["doi"] != "10.1000/182" || labels["org.label-schema.schema-version"] != "1.0" {
+		t.Errorf("unexpected labels: %v", labels)
+	}
+
+	md, err := InspectAll(path)
+	if err != nil {
+		t.Fatalf("unexpected error while inspecting %s: %s", path, err)
+	}
+	if len(md.Attributes.Labels) != 2 || md.Attributes.Labels["doi"] != "10.1000/182" {
+		t.Errorf("unexpected inspected labels: %v", md.Attributes.Labels)
+	}
+}
+
+func TestLabelSandbox(t *testing.T) {
+	dir, err := ioutil.TempDir("", "label-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := os.MkdirAll(filepath.Join(dir, ".singularity.d"), 0755); err != nil {
+		t.Fatalf("failed to create sandbox: %s", err)
+	}
+
+	labels, err := LabelList(dir)
+	if err != nil {
+		t.Fatalf("unexpected error while listing labels: %s", err)
+	}
+	if len(labels) != 0 {
+		t.Errorf("unexpected labels: %v", labels)
+	}
+
+	stale, err := LabelSet(dir, map[string]string{"maintainer": "nobody"}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error while setting labels: %s", err)
+	}
+	if len(stale) != 0 {
+		t.Errorf("unexpected stale signatures for a sandbox: %v", stale)
+	}
+
+	if _, err := LabelSet(dir, nil, []string{"maintainer"}); err != nil {
+		t.Fatalf("unexpected error while unsetting labels: %s", err)
+	}
+	labels, err = LabelList(dir)
+	if err != nil {
+		t.Fatalf("unexpected error while listing labels: %s", err)
+	}
+	if len(labels) != 0 {
+		t.Errorf("unexpected labels: %v", labels)
+	}
+}
@@ -0,0 +1,291 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/sylabs/sif/pkg/sif"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+	"github.com/sylabs/singularity/internal/pkg/util/fs"
+	"github.com/sylabs/singularity/pkg/image"
+	"github.com/sylabs/singularity/pkg/util/crypt"
+)
+
+// OverlayCreate creates an overlay image of sizeMiB megabytes at path. If
+// path is an existing SIF image, the overlay partition is added to it. If
+// keyInfo is not nil, the overlay filesystem is encrypted with a key
+// wrapped with keyInfo and stored as SIF overlay image, otherwise a new
+// overlay is a plain ext3 image.
+func OverlayCreate(path string, sizeMiB int, keyInfo *crypt.KeyInfo) error {
+	if sizeMiB <= 0 {
+		return fmt.Errorf("overlay size must be greater than zero")
+	}
+
+	embed := false
+	if fs.IsFile(path) {
+		img, err := image.Init(path, false)
+		if err != nil {
+			return fmt.Errorf("while opening %s: %s", path, err)
+		}
+		img.File.Close()
+
+		if img.Type != image.SIF || !img.HasRootFs() {
+			return fmt.Errorf("%s already exists and is not a SIF container image", path)
+		}
+		embed = true
+	} else if fs.IsDir(path) {
+		return fmt.Errorf("%s is a directory", path)
+	}
+
+	uid, gid := overlayOwner()
+
+	var ext3File string
+	if embed || keyInfo != nil {
+		f, err := ioutil.TempFile("", "overlay-")
+		if err != nil {
+			return fmt.Errorf("while creating temporary overlay file: %s", err)
+		}
+		f.Close()
+		defer os.Remove(f.Name())
+		ext3File = f.Name()
+	} else {
+		ext3File = path
+	}
+
+	if err := createExt3Overlay(ext3File, sizeMiB, uid, gid); err != nil {
+		return err
+	}
+
+	if !embed && keyInfo == nil {
+		return os.Chown(path, uid, gid)
+	}
+
+	partFile := ext3File
+	fstype := sif.FsExt3
+
+	var encryptedKey []byte
+	if keyInfo != nil {
+		plaintext, err := crypt.NewPlaintextKey(*keyInfo)
+		if err != nil {
+			return fmt.Errorf("while generating overlay key: %s", err)
+		}
+		encryptedKey, err = crypt.EncryptKey(*keyInfo, plaintext)
+		if err != nil {
+			return fmt.Errorf("while encrypting overlay key: %s", err)
+		}
+
+		cryptDev := &crypt.Device{}
+		partFile, err = cryptDev.EncryptFilesystem(ext3File, plaintext)
+		if err != nil {
+			return fmt.Errorf("while encrypting overlay filesystem: %s", err)
+		}
+		defer os.Remove(partFile)
+
+		// the encryption info object tells the filesystem
+		fstype = sif.FsRaw
+	}
+
+	if embed {
+		return addOverlayPartition(path, partFile, fstype, keyInfo, encryptedKey)
+	}
+
+	if err := createOverlaySIF(path, partFile, fstype, keyInfo, encryptedKey); err != nil {
+		return err
+	}
+	return os.Chown(path, uid, gid)
+}
+
+// overlayOwner returns the user owning the created overlay, the user
+// calling sudo when run with sudo.
+func overlayOwner() (int, int) {
+	uid, gid := os.Getuid(), os.Getgid()
+	if uid != 0 {
+		return uid, gid
+	}
+
+	sudoUID, errUID := strconv.Atoi(os.Getenv("SUDO_UID"))
+	sudoGID, errGID := strconv.Atoi(os.Getenv("SUDO_GID"))
+	if errUID != nil || errGID != nil {
+		return uid, gid
+	}
+	return sudoUID, sudoGID
+}
+
+// createExt3Overlay creates an ext3 filesystem of sizeMiB megabytes in
+// path with the upper and work directories used by the overlay layer.
+func createExt3Overlay(path string, sizeMiB, uid, gid int) error {
+	mkfs, err := exec.LookPath("mkfs.ext3")
+	if err != nil {
+		return fmt.Errorf("mkfs.ext3 not found: %s", err)
+	}
+
+	dir, err := ioutil.TempDir("", "overlay-")
+	if err != nil {
+		return fmt.Errorf("while creating temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, d := range []string{"upper", "work"} {
+		p := filepath.Join(dir, d)
+		if err := os.Mkdir(p, 0755); err != nil {
+			return fmt.Errorf("while creating %s directory: %s", d, err)
+		}
+		if err := os.Chown(p, uid, gid); err != nil {
+			return fmt.Errorf("while changing %s directory ownership: %s", d, err)
+		}
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("while creating %s: %s", path, err)
+	}
+	f.Close()
+
+	if err := os.Truncate(path, int64(sizeMiB)*1024*1024); err != nil {
+		return fmt.Errorf("while resizing %s: %s", path, err)
+	}
+
+	cmd := exec.Command(mkfs, "-q", "-F", "-d", dir, path)
+	sylog.Debugf("Running %s", cmd.Args)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		os.Remove(path)
+		return fmt.Errorf("while creating ext3 filesystem: %s: %s", err, out)
+	}
+	return nil
+}
+
+// overlayPartitionInput returns the descriptor input of the overlay
+// partition stored in the file fp.
+func overlayPartitionInput(fp *os.File, fstype sif.Fstype, groupID uint32) (sif.DescriptorInput, error) {
+	fi, err := fp.Stat()
+	if err != nil {
+		return sif.DescriptorInput{}, fmt.Errorf("while calling stat on overlay file: %s", err)
+	}
+
+	input := sif.DescriptorInput{
+		Datatype: sif.DataPartition,
+		Groupid:  groupID,
+		Link:     sif.DescrUnusedLink,
+		Fname:    "overlay.img",
+		Fp:       fp,
+		Size:     fi.Size(),
+	}
+	if err := input.SetPartExtra(fstype, sif.PartOverlay, sif.GetSIFArch(runtime.GOARCH)); err != nil {
+		return sif.DescriptorInput{}, err
+	}
+	return input, nil
+}
+
+// createOverlaySIF creates a SIF overlay image at path holding the overlay
+// partition partFile and, if it is encrypted with keyInfo, its encrypted key
+// and encryption info.
+func createOverlaySIF(path, partFile string, fstype sif.Fstype, keyInfo *crypt.KeyInfo, encryptedKey []byte) error {
+	fp, err := os.Open(partFile)
+	if err != nil {
+		return fmt.Errorf("while opening overlay file: %s", err)
+	}
+	defer fp.Close()
+
+	cinfo := sif.CreateInfo{
+		Pathname:   path,
+		Launchstr:  sif.HdrLaunch,
+		Sifversion: sif.HdrVersion,
+		ID:         uuid.NewV4(),
+	}
+
+	part, err := overlayPartitionInput(fp, fstype, sif.DescrDefaultGroup)
+	if err != nil {
+		return err
+	}
+	cinfo.InputDescr = append(cinfo.InputDescr, part)
+
+	if keyInfo != nil {
+		partID := uint32(len(cinfo.InputDescr))
+		inputs, err := crypt.KeyInputs(*keyInfo, image.EncryptedExt3, encryptedKey, sif.DescrDefaultGroup, partID)
+		if err != nil {
+			return fmt.Errorf("while storing overlay key: %s", err)
+		}
+		cinfo.InputDescr = append(cinfo.InputDescr, inputs...)
+	}
+
+	if _, err := sif.CreateContainer(cinfo); err != nil {
+		return fmt.Errorf("while creating overlay image: %s", err)
+	}
+	return nil
+}
+
+// addOverlayPartition adds the overlay partition partFile and, if it is
+// encrypted with keyInfo, its encrypted key and encryption info to the group
+// of the primary system partition of the SIF image path.
+func addOverlayPartition(path, partFile string, fstype sif.Fstype, keyInfo *crypt.KeyInfo, encryptedKey []byte) error {
+	fimg, err := sif.LoadContainer(path, false)
+	if err != nil {
+		return fmt.Errorf("while loading SIF image %s: %s", path, err)
+	}
+	defer fimg.UnloadContainer()
+
+	primDescr, _, err := fimg.GetPartPrimSys()
+	if err != nil {
+		return fmt.Errorf("while retrieving primary system partition: %s", err)
+	}
+	groupID := primDescr.Groupid
+
+	findOverlay := func() *sif.Descriptor {
+		for i, d := range fimg.DescrArr {
+			if !d.Used || d.Datatype != sif.DataPartition || d.Groupid != groupID {
+				continue
+			}
+			if ptype, err := d.GetPartType(); err == nil && ptype == sif.PartOverlay {
+				return &fimg.DescrArr[i]
+			}
+		}
+		return nil
+	}
+	if findOverlay() != nil {
+		return fmt.Errorf("%s already contains an overlay partition", path)
+	}
+
+	fp, err := os.Open(partFile)
+	if err != nil {
+		return fmt.Errorf("while opening overlay file: %s", err)
+	}
+	defer fp.Close()
+
+	part, err := overlayPartitionInput(fp, fstype, groupID)
+	if err != nil {
+		return err
+	}
+	if err := fimg.AddObject(part); err != nil {
+		return fmt.Errorf("while adding overlay partition: %s", err)
+	}
+
+	if keyInfo == nil {
+		return nil
+	}
+
+	d := findOverlay()
+	if d == nil {
+		return fmt.Errorf("overlay partition not found after its addition to %s", path)
+	}
+	inputs, err := crypt.KeyInputs(*keyInfo, image.EncryptedExt3, encryptedKey, groupID, d.ID)
+	if err != nil {
+		return fmt.Errorf("while storing overlay key: %s", err)
+	}
+	for _, input := range inputs {
+		if err := fimg.AddObject(input); err != nil {
+			return fmt.Errorf("while adding overlay key: %s", err)
+		}
+	}
+	return nil
+}
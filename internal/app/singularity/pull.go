@@ -21,12 +21,15 @@ import (
 	"github.com/sylabs/singularity/internal/pkg/util/uri"
 	"github.com/sylabs/singularity/pkg/build/types"
 	shub "github.com/sylabs/singularity/pkg/client/shub"
+	"github.com/sylabs/singularity/pkg/signing"
 	"gopkg.in/cheggaaa/pb.v1"
 )
 
 var (
 	// ErrLibraryPullUnsigned indicates that the interactive portion of the pull was aborted.
 	ErrLibraryPullUnsigned = errors.New("failed to verify container")
+	// ErrOrasPullUnsigned indicates that the image pulled with ORAS has no detached signature.
+	ErrOrasPullUnsigned = errors.New("no detached signature found for container")
 )
 
 // PullShub will download a image from shub, and cache it. Next time
@@ -174,6 +177,30 @@ func OrasPull(ctx context.Context, imgCache *cache.Handle, name, ref string, for
 	return nil
 }
 
+// OrasVerify verifies the detached signature of the SIF image pulled to name
+// from the provided oci reference and returns the formatted verification
+// output. ErrOrasPullUnsigned is returned if the image has no detached
+// signature.
+func OrasVerify(ctx context.Context, name, ref string, ociAuth *ocitypes.DockerAuthConfig, keyServerURL, authToken string, opts signing.VerifyOptions) (string, error) {
+	sig, err := oras.ImageSignature(ctx, ref, ociAuth)
+	if err == oras.ErrNoSignature {
+		return "", ErrOrasPullUnsigned
+	} else if err != nil {
+		return "", fmt.Errorf("failed to get detached signature for %s: %v", ref, err)
+	}
+
+	// the reference may have been updated since the image was pulled
+	sum, err := oras.ImageHash(name)
+	if err != nil {
+		return "", fmt.Errorf("error getting ImageHash: %v", err)
+	} else if sum != sig.Image.String() {
+		return "", fmt.Errorf("image hash(%s) and signed image hash(%s) does not match", sum, sig.Image)
+	}
+
+	author, _, err := signing.VerifyORAS(ctx, sig, keyServerURL, authToken, false, opts)
+	return author, err
+}
+
 // OciPull will build a SIF image from the specified oci URI
 func OciPull(ctx context.Context, imgCache *cache.Handle, name, imageURI, tmpDir string, ociAuth *ocitypes.DockerAuthConfig, noHTTPS, noCleanUp bool) error {
 	sysCtx := &ocitypes.SystemContext{
@@ -15,8 +15,9 @@ import (
 	"github.com/sylabs/singularity/internal/pkg/remote"
 )
 
-// RemoteAdd adds remote to configuration
-func RemoteAdd(configFile, name, uri string, global bool) (err error) {
+// RemoteAdd adds remote to configuration, keyring is the name of the keyring
+// used to verify the images of the remote, the default keyring if empty.
+func RemoteAdd(configFile, name, uri string, global bool, keyring string) (err error) {
 	// Explicit handling of corner cases: name and uri must be valid strings
 	if strings.TrimSpace(name) == "" {
 		return fmt.Errorf("invalid name: cannot have empty name")
@@ -50,7 +51,7 @@ func RemoteAdd(configFile, name, uri string, global bool) (err error) {
 	if err != nil {
 		return err
 	}
-	e := remote.EndPoint{URI: path.Join(u.Host + u.Path), System: global}
+	e := remote.EndPoint{URI: path.Join(u.Host + u.Path), System: global, Keyring: keyring}
 
 	if err := c.Add(name, &e); err != nil {
 		return err
@@ -344,7 +344,7 @@ func TestRemoteAdd(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := RemoteAdd(tt.cfgfile, tt.remoteName, tt.uri, tt.global)
+			err := RemoteAdd(tt.cfgfile, tt.remoteName, tt.uri, tt.global, "")
 			if tt.shallPass == true && err != nil {
 				t.Fatalf("valid case failed: %s\n", err)
 			}
@@ -6,10 +6,13 @@
 package assemblers
 
 import (
+	"bufio"
 	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"regexp"
 	"runtime"
 	"strconv"
@@ -19,8 +22,10 @@ import (
 	"github.com/sylabs/sif/pkg/sif"
 	"github.com/sylabs/singularity/internal/pkg/sylog"
 	"github.com/sylabs/singularity/pkg/build/types"
+	"github.com/sylabs/singularity/pkg/image"
 	"github.com/sylabs/singularity/pkg/image/packer"
 	"github.com/sylabs/singularity/pkg/util/crypt"
+	"github.com/sylabs/singularity/pkg/util/verity"
 )
 
 // SIFAssembler doesn't store anything.
@@ -34,7 +39,12 @@ type encryptionOptions struct {
 	plaintext []byte
 }
 
-func createSIF(path string, definition, ociConf []byte, squashfile string, encOpts *encryptionOptions) (err error) {
+type verityOptions struct {
+	params []byte
+	tree   []byte
+}
+
+func createSIF(path string, definition, labels, ociConf, ociImageConf []byte, squashfile string, encOpts *encryptionOptions, verOpts *verityOptions) (err error) {
 	// general info for the new SIF file creation
 	cinfo := sif.CreateInfo{
 		Pathname:   path,
@@ -55,6 +65,22 @@ func createSIF(path string, definition, ociConf []byte, squashfile string, encOp
 	// add this descriptor input element to creation descriptor slice
 	cinfo.InputDescr = append(cinfo.InputDescr, definput)
 
+	if len(labels) > 0 {
+		// data we need to create a labels descriptor, labels can then
+		// be modified without rebuilding the image
+		labelsInput := sif.DescriptorInput{
+			Datatype: sif.DataLabels,
+			Groupid:  sif.DescrDefaultGroup,
+			Link:     sif.DescrUnusedLink,
+			Data:     labels,
+			Fname:    "labels.json",
+		}
+		labelsInput.Size = int64(binary.Size(labelsInput.Data))
+
+		// add this descriptor input element to creation descriptor slice
+		cinfo.InputDescr = append(cinfo.InputDescr, labelsInput)
+	}
+
 	if len(ociConf) > 0 {
 		// data we need to create a definition file descriptor
 		ociInput := sif.DescriptorInput{
@@ -62,7 +88,7 @@ func createSIF(path string, definition, ociConf []byte, squashfile string, encOp
 			Groupid:  sif.DescrDefaultGroup,
 			Link:     sif.DescrUnusedLink,
 			Data:     ociConf,
-			Fname:    "oci-config.json",
+			Fname:    image.OCIConfigSection,
 		}
 		ociInput.Size = int64(binary.Size(ociInput.Data))
 
@@ -70,6 +96,21 @@ func createSIF(path string, definition, ociConf []byte, squashfile string, encOp
 		cinfo.InputDescr = append(cinfo.InputDescr, ociInput)
 	}
 
+	if len(ociImageConf) > 0 {
+		// data we need to create the complete OCI image config descriptor
+		ociImageInput := sif.DescriptorInput{
+			Datatype: sif.DataGenericJSON,
+			Groupid:  sif.DescrDefaultGroup,
+			Link:     sif.DescrUnusedLink,
+			Data:     ociImageConf,
+			Fname:    image.OCIImageConfigSection,
+		}
+		ociImageInput.Size = int64(binary.Size(ociImageInput.Data))
+
+		// add this descriptor input element to creation descriptor slice
+		cinfo.InputDescr = append(cinfo.InputDescr, ociImageInput)
+	}
+
 	// data we need to create a system partition descriptor
 	parinput := sif.DescriptorInput{
 		Datatype: sif.DataPartition,
@@ -106,6 +147,7 @@ func createSIF(path string, definition, ociConf []byte, squashfile string, encOp
 
 	// add this descriptor input element to the list
 	cinfo.InputDescr = append(cinfo.InputDescr, parinput)
+	syspartID := uint32(len(cinfo.InputDescr))
 
 	if encOpts != nil {
 		data, err := crypt.EncryptKey(encOpts.keyInfo, encOpts.plaintext)
@@ -113,24 +155,35 @@ func createSIF(path string, definition, ociConf []byte, squashfile string, encOp
 			return fmt.Errorf("while encrypting filesystem key: %s", err)
 		}
 
-		if data != nil {
-			syspartID := uint32(len(cinfo.InputDescr))
-			part := sif.DescriptorInput{
-				Datatype: sif.DataCryptoMessage,
-				Groupid:  sif.DescrDefaultGroup,
-				Link:     syspartID,
-				Data:     data,
-				Size:     int64(len(data)),
-			}
-
-			// extra data needed for the creation of a signature descriptor
-			err := part.SetCryptoMsgExtra(sif.FormatPEM, sif.MessageRSAOAEP)
-			if err != nil {
-				return err
-			}
-
-			cinfo.InputDescr = append(cinfo.InputDescr, part)
+		inputs, err := crypt.KeyInputs(encOpts.keyInfo, image.EncryptedSquashfs, data, sif.DescrDefaultGroup, syspartID)
+		if err != nil {
+			return fmt.Errorf("while storing filesystem key: %s", err)
+		}
+		cinfo.InputDescr = append(cinfo.InputDescr, inputs...)
+	}
+
+	if verOpts != nil {
+		// the hash tree and its parameters are linked to the system
+		// partition and grouped with it to be signed along with it
+		treeInput := sif.DescriptorInput{
+			Datatype: sif.DataGeneric,
+			Groupid:  sif.DescrDefaultGroup,
+			Link:     syspartID,
+			Data:     verOpts.tree,
+			Fname:    verity.HashTreeName,
+		}
+		treeInput.Size = int64(binary.Size(treeInput.Data))
+
+		paramsInput := sif.DescriptorInput{
+			Datatype: sif.DataGenericJSON,
+			Groupid:  sif.DescrDefaultGroup,
+			Link:     syspartID,
+			Data:     verOpts.params,
+			Fname:    verity.ParamsName,
 		}
+		paramsInput.Size = int64(binary.Size(paramsInput.Data))
+
+		cinfo.InputDescr = append(cinfo.InputDescr, treeInput, paramsInput)
 	}
 
 	// remove anything that may exist at the build destination at last moment
@@ -155,6 +208,10 @@ func createSIF(path string, definition, ociConf []byte, squashfile string, encOp
 func (a *SIFAssembler) Assemble(b *types.Bundle, path string) error {
 	sylog.Infof("Creating SIF file...")
 
+	if b.Opts.Verity && b.Opts.EncryptionKeyInfo != nil {
+		return fmt.Errorf("verity hash tree can't be created for an encrypted filesystem")
+	}
+
 	s := packer.NewSquashfs()
 	s.MksquashfsPath = a.MksquashfsPath
 
@@ -211,7 +268,21 @@ func (a *SIFAssembler) Assemble(b *types.Bundle, path string) error {
 
 	}
 
-	err = createSIF(path, b.Recipe.Raw, b.JSONObjects[types.OCIConfigJSON], fsPath, encOpts)
+	var verOpts *verityOptions
+
+	if b.Opts.Verity {
+		verOpts, err = createHashTree(fsPath)
+		if err != nil {
+			return fmt.Errorf("while creating verity hash tree: %v", err)
+		}
+	}
+
+	labels, err := ioutil.ReadFile(filepath.Join(b.RootfsPath, "/.singularity.d/labels.json"))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("while reading labels: %v", err)
+	}
+
+	err = createSIF(path, b.Recipe.Raw, labels, b.JSONObjects[types.OCIConfigJSON], b.JSONObjects[types.OCIImageConfigJSON], fsPath, encOpts, verOpts)
 	if err != nil {
 		return fmt.Errorf("while creating SIF: %v", err)
 	}
@@ -219,6 +290,39 @@ func (a *SIFAssembler) Assemble(b *types.Bundle, path string) error {
 	return nil
 }
 
+// createHashTree pads the squashfs image at path to the size required by
+// dm-verity and returns its hash tree and the parameters describing it.
+func createHashTree(path string) (*verityOptions, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	// squashfs ignores data past the end of the filesystem
+	size := verity.DataSize(fi.Size())
+	if err := f.Truncate(size); err != nil {
+		return nil, fmt.Errorf("while padding squashfs: %v", err)
+	}
+
+	tree, params, err := verity.HashTree(bufio.NewReader(f), size)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+
+	return &verityOptions{params: data, tree: tree}, nil
+}
+
 // changeOwner check the command being called with sudo with the environment
 // variable SUDO_COMMAND. Pattern match that for the singularity bin.
 func changeOwner() (int, int, bool) {
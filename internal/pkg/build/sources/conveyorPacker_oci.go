@@ -41,6 +41,7 @@ type OCIConveyorPacker struct {
 	b         *sytypes.Bundle
 	tmpfsRef  types.ImageReference
 	policyCtx *signature.PolicyContext
+	imgSpec   *imgspecv1.Image
 	imgConfig imgspecv1.ImageConfig
 	sysCtx    *types.SystemContext
 }
@@ -137,10 +138,11 @@ func (cp *OCIConveyorPacker) Get(ctx context.Context, b *sytypes.Bundle) (err er
 		return err
 	}
 
-	cp.imgConfig, err = cp.getConfig(ctx)
+	cp.imgSpec, err = cp.getConfig(ctx)
 	if err != nil {
 		return err
 	}
+	cp.imgConfig = cp.imgSpec.Config
 
 	return nil
 }
@@ -184,19 +186,14 @@ func (cp *OCIConveyorPacker) fetch(ctx context.Context) error {
 	return err
 }
 
-func (cp *OCIConveyorPacker) getConfig(ctx context.Context) (imgspecv1.ImageConfig, error) {
+func (cp *OCIConveyorPacker) getConfig(ctx context.Context) (*imgspecv1.Image, error) {
 	img, err := cp.srcRef.NewImage(ctx, cp.sysCtx)
 	if err != nil {
-		return imgspecv1.ImageConfig{}, err
+		return nil, err
 	}
 	defer img.Close()
 
-	imgSpec, err := img.OCIConfig(ctx)
-	if err != nil {
-		return imgspecv1.ImageConfig{}, err
-	}
-
-	return imgSpec.Config, nil
+	return img.OCIConfig(ctx)
 }
 
 func (cp *OCIConveyorPacker) insertOCIConfig() error {
@@ -206,6 +203,15 @@ func (cp *OCIConveyorPacker) insertOCIConfig() error {
 	}
 
 	cp.b.JSONObjects[buildTypes.OCIConfigJSON] = conf
+
+	// the complete image config keeps what isn't translated
+	// by the runscript and the environment (USER, STOPSIGNAL ...)
+	imgConf, err := json.Marshal(cp.imgSpec)
+	if err != nil {
+		return err
+	}
+
+	cp.b.JSONObjects[buildTypes.OCIImageConfigJSON] = imgConf
 	return nil
 }
 
@@ -60,17 +60,22 @@ func unpackSIF(b *types.Bundle, img *image.Image) (err error) {
 		return fmt.Errorf("unrecognized partition format")
 	}
 
-	ociReader, err := image.NewSectionReader(img, "oci-config.json", -1)
-	if err == image.ErrNoSection {
-		sylog.Debugf("No oci-config.json section found")
-	} else if err != nil {
-		return fmt.Errorf("could not get OCI config section reader: %v", err)
-	} else {
+	for name, key := range map[string]string{
+		image.OCIConfigSection:      types.OCIConfigJSON,
+		image.OCIImageConfigSection: types.OCIImageConfigJSON,
+	} {
+		ociReader, err := image.NewSectionReader(img, name, -1)
+		if err == image.ErrNoSection {
+			sylog.Debugf("No %s section found", name)
+			continue
+		} else if err != nil {
+			return fmt.Errorf("could not get OCI config section reader: %v", err)
+		}
 		ociConfig, err := ioutil.ReadAll(ociReader)
 		if err != nil {
 			return fmt.Errorf("could not read OCI config: %v", err)
 		}
-		b.JSONObjects[types.OCIConfigJSON] = ociConfig
+		b.JSONObjects[key] = ociConfig
 	}
 	return nil
 }
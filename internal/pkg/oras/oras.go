@@ -10,6 +10,7 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -17,8 +18,10 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/containerd/containerd/errdefs"
 	"github.com/containerd/containerd/images"
 	"github.com/containerd/containerd/reference"
+	"github.com/containerd/containerd/remotes"
 	"github.com/containerd/containerd/remotes/docker"
 	ocitypes "github.com/containers/image/types"
 	"github.com/deislabs/oras/pkg/content"
@@ -26,6 +29,7 @@ import (
 	"github.com/deislabs/oras/pkg/oras"
 	"github.com/opencontainers/go-digest"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	pkgerrors "github.com/pkg/errors"
 	"github.com/sylabs/singularity/internal/pkg/sylog"
 	"github.com/sylabs/singularity/pkg/image"
 )
@@ -39,8 +43,64 @@ const (
 
 	// SifLayerMediaType is the mediaType for the "layer" which contains the actual SIF file
 	SifLayerMediaType = "appliciation/vnd.sylabs.sif.layer.tar"
+
+	// SifSignatureConfigMediaType is the config descriptor mediaType of detached signatures
+	SifSignatureConfigMediaType = "application/vnd.sylabs.sif.signature.config.v1+json"
+
+	// SifSignatureLayerMediaType is the mediaType for the "layer" which contains the detached signature
+	SifSignatureLayerMediaType = "application/vnd.sylabs.sif.signature.v1+pgp"
+
+	// SifSignedManifestAnnotation is the annotation of a detached signature
+	// manifest holding the digest of the signed SIF manifest
+	SifSignedManifestAnnotation = "org.sylabs.sif.signed-manifest"
+
+	// maxSignatureSize is the maximum size of a detached signature
+	maxSignatureSize = 1024 * 1024
 )
 
+// ErrNoSignature corresponds to an image without detached signature.
+var ErrNoSignature = errors.New("no detached signature found")
+
+// SignFunc returns the detached signature of the payload describing a
+// pushed SIF image.
+type SignFunc func(payload []byte) ([]byte, error)
+
+// SignaturePayload describes a SIF image pushed to a registry, it is
+// the data signed by a detached signature.
+type SignaturePayload struct {
+	// Reference is the repository of the image
+	Reference string `json:"reference"`
+	// Manifest is the digest of the image manifest
+	Manifest digest.Digest `json:"manifest"`
+	// Image is the digest of the SIF layer
+	Image digest.Digest `json:"image"`
+}
+
+// Signature is the detached signature of a SIF image stored in a registry
+// along with the description of the image it was found for.
+type Signature struct {
+	SignaturePayload
+	// Data is the detached signature
+	Data []byte
+}
+
+// newResolver returns the docker resolver used to reach registries.
+var newResolver = func(ociAuth *ocitypes.DockerAuthConfig) remotes.Resolver {
+	return docker.NewResolver(docker.ResolverOptions{Credentials: genCredfn(ociAuth)})
+}
+
+// getResolver returns the resolver used to reach registries with the
+// credentials ociAuth.
+func getResolver(ociAuth *ocitypes.DockerAuthConfig) remotes.Resolver {
+	return notFoundResolver{newResolver(ociAuth)}
+}
+
+// SignatureTag returns the tag of the detached signature of the image
+// manifest with digest manifest.
+func SignatureTag(manifest digest.Digest) string {
+	return manifest.Algorithm().String() + "-" + manifest.Hex() + ".sig"
+}
+
 // DownloadImage downloads a SIF image specified by an oci reference to a file using the included credentials
 func DownloadImage(imagePath, ref string, ociAuth *ocitypes.DockerAuthConfig) error {
 	ref = strings.TrimPrefix(ref, "//")
@@ -56,7 +116,7 @@ func DownloadImage(imagePath, ref string, ociAuth *ocitypes.DockerAuthConfig) er
 		sylog.Infof("No tag or digest found, using default: %s", SifDefaultTag)
 	}
 
-	resolver := docker.NewResolver(docker.ResolverOptions{Credentials: genCredfn(ociAuth)})
+	resolver := getResolver(ociAuth)
 
 	wd, err := os.Getwd()
 	if err != nil {
@@ -105,8 +165,9 @@ func DownloadImage(imagePath, ref string, ociAuth *ocitypes.DockerAuthConfig) er
 }
 
 // UploadImage uploads the image specified by path and pushes it to the provided oci reference,
-// it will use credentials if supplied
-func UploadImage(path, ref string, ociAuth *ocitypes.DockerAuthConfig) error {
+// it will use credentials if supplied. If sign is not nil, a detached signature of the pushed
+// image returned by sign is pushed to the same repository.
+func UploadImage(path, ref string, ociAuth *ocitypes.DockerAuthConfig, sign SignFunc) error {
 	// ensure that are uploading a SIF
 	if err := ensureSIF(path); err != nil {
 		return err
@@ -132,7 +193,7 @@ func UploadImage(path, ref string, ociAuth *ocitypes.DockerAuthConfig) error {
 		sylog.Infof("No tag or digest found, using default: %s", SifDefaultTag)
 	}
 
-	resolver := docker.NewResolver(docker.ResolverOptions{Credentials: genCredfn(ociAuth)})
+	resolver := getResolver(ociAuth)
 
 	store := content.NewFileStore("")
 	defer store.Close()
@@ -153,13 +214,118 @@ func UploadImage(path, ref string, ociAuth *ocitypes.DockerAuthConfig) error {
 
 	descriptors := []ocispec.Descriptor{desc}
 
-	if _, err := oras.Push(orasctx.Background(), resolver, spec.String(), store, descriptors, oras.WithConfig(conf)); err != nil {
+	manifest, err := oras.Push(orasctx.Background(), resolver, spec.String(), store, descriptors, oras.WithConfig(conf))
+	if err != nil {
 		return fmt.Errorf("unable to push: %s", err)
 	}
 
+	if sign == nil {
+		return nil
+	}
+
+	payload := SignaturePayload{
+		Reference: spec.Locator,
+		Manifest:  manifest.Digest,
+		Image:     desc.Digest,
+	}
+	if err := pushSignature(resolver, payload, sign); err != nil {
+		return fmt.Errorf("unable to push signature: %s", err)
+	}
+
 	return nil
 }
 
+// pushSignature signs payload with sign and pushes the detached signature to
+// the repository of the signed image, tagged after the signed manifest digest.
+func pushSignature(resolver remotes.Resolver, payload SignaturePayload, sign SignFunc) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("while encoding signature payload: %s", err)
+	}
+
+	signature, err := sign(data)
+	if err != nil {
+		return err
+	}
+
+	store := content.NewMemoryStore()
+
+	conf := store.Add("", SifSignatureConfigMediaType, []byte("{}"))
+	desc := store.Add("signature.asc", SifSignatureLayerMediaType, signature)
+
+	ref := payload.Reference + ":" + SignatureTag(payload.Manifest)
+	annotations := map[string]string{SifSignedManifestAnnotation: payload.Manifest.String()}
+
+	_, err = oras.Push(orasctx.Background(), resolver, ref, store, []ocispec.Descriptor{desc}, oras.WithConfig(conf), oras.WithManifestAnnotations(annotations))
+	return err
+}
+
+// ImageSignature returns the detached signature of the SIF image specified by
+// an oci reference, with the image it was found for. ErrNoSignature is returned
+// if there's no detached signature for the image.
+func ImageSignature(ctx context.Context, uri string, ociAuth *ocitypes.DockerAuthConfig) (*Signature, error) {
+	ref := strings.TrimPrefix(uri, "//")
+
+	spec, err := reference.Parse(ref)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse oci reference: %s", err)
+	}
+	if spec.Object == "" {
+		spec.Object = SifDefaultTag
+	}
+
+	resolver := getResolver(ociAuth)
+
+	desc, man, err := fetchManifest(ctx, resolver, spec.String())
+	if err != nil {
+		return nil, err
+	}
+	layer, err := sifLayer(man)
+	if err != nil {
+		return nil, err
+	}
+
+	sigRef := spec.Locator + ":" + SignatureTag(desc.Digest)
+
+	_, sigDesc, err := resolver.Resolve(ctx, sigRef)
+	if errdefs.IsNotFound(err) {
+		return nil, ErrNoSignature
+	} else if err != nil {
+		return nil, fmt.Errorf("while resolving signature reference: %v", err)
+	}
+	sigMan, err := readManifest(ctx, resolver, sigRef, sigDesc)
+	if err != nil {
+		return nil, fmt.Errorf("while fetching signature manifest: %v", err)
+	}
+	if sigMan.Annotations[SifSignedManifestAnnotation] != desc.Digest.String() {
+		return nil, fmt.Errorf("signature manifest doesn't reference manifest %s", desc.Digest)
+	}
+
+	for _, l := range sigMan.Layers {
+		if l.MediaType != SifSignatureLayerMediaType {
+			continue
+		}
+		if l.Size > maxSignatureSize {
+			return nil, fmt.Errorf("signature of %d bytes exceeds %d bytes", l.Size, maxSignatureSize)
+		}
+		data, err := fetchBlob(ctx, resolver, sigRef, l)
+		if err != nil {
+			return nil, fmt.Errorf("while fetching signature: %v", err)
+		}
+		sig := &Signature{
+			SignaturePayload: SignaturePayload{
+				Reference: spec.Locator,
+				Manifest:  desc.Digest,
+				Image:     layer.Digest,
+			},
+			Data: data,
+		}
+		return sig, nil
+	}
+
+	return nil, fmt.Errorf("no layer found corresponding to a signature")
+}
+
 // ensureSIF checks for a SIF image at filepath and returns an error if it is not, or an error is encountered
 func ensureSIF(filepath string) error {
 	img, err := image.Init(filepath, false)
@@ -183,51 +349,107 @@ func ensureSIF(filepath string) error {
 func ImageSHA(ctx context.Context, uri string, ociAuth *ocitypes.DockerAuthConfig) (string, error) {
 	ref := strings.TrimPrefix(uri, "//")
 
-	resolver := docker.NewResolver(docker.ResolverOptions{Credentials: genCredfn(ociAuth)})
+	resolver := getResolver(ociAuth)
 
+	_, man, err := fetchManifest(ctx, resolver, ref)
+	if err != nil {
+		return "", err
+	}
+
+	l, err := sifLayer(man)
+	if err != nil {
+		return "", err
+	}
+	return l.Digest.String(), nil
+}
+
+// notFoundResolver wraps a resolver so that Resolve returns an error
+// wrapping errdefs.ErrNotFound when the registry doesn't know a reference.
+// The docker resolver only reports it with the untyped error "<ref> not
+// found" once the registry answered 404 to all its requests.
+type notFoundResolver struct {
+	remotes.Resolver
+}
+
+func (r notFoundResolver) Resolve(ctx context.Context, ref string) (string, ocispec.Descriptor, error) {
+	name, desc, err := r.Resolver.Resolve(ctx, ref)
+	if err != nil && !errdefs.IsNotFound(err) && err.Error() == ref+" not found" {
+		return name, desc, pkgerrors.Wrapf(errdefs.ErrNotFound, "%s", ref)
+	}
+	return name, desc, err
+}
+
+// fetchManifest returns the descriptor and the content of the image manifest
+// of the reference ref.
+func fetchManifest(ctx context.Context, resolver remotes.Resolver, ref string) (ocispec.Descriptor, *ocispec.Manifest, error) {
 	_, desc, err := resolver.Resolve(ctx, ref)
 	if err != nil {
-		return "", fmt.Errorf("while resolving reference: %v", err)
+		return desc, nil, fmt.Errorf("while resolving reference: %v", err)
 	}
 
+	man, err := readManifest(ctx, resolver, ref, desc)
+	return desc, man, err
+}
+
+// readManifest returns the content of the image manifest described by desc.
+func readManifest(ctx context.Context, resolver remotes.Resolver, ref string, desc ocispec.Descriptor) (*ocispec.Manifest, error) {
 	// ensure that we received an image manifest descriptor
 	if desc.MediaType != ocispec.MediaTypeImageManifest {
-		return "", fmt.Errorf("could not get image manifest, received mediaType: %s", desc.MediaType)
+		return nil, fmt.Errorf("could not get image manifest, received mediaType: %s", desc.MediaType)
+	}
+
+	b, err := fetchBlob(ctx, resolver, ref, desc)
+	if err != nil {
+		return nil, fmt.Errorf("while fetching manifest: %v", err)
 	}
 
+	var man ocispec.Manifest
+	if err := json.Unmarshal(b, &man); err != nil {
+		return nil, fmt.Errorf("while unmarshalling manifest: %v", err)
+	}
+
+	return &man, nil
+}
+
+// fetchBlob returns the content described by desc fetched from the
+// repository of the reference ref, after checking its digest.
+func fetchBlob(ctx context.Context, resolver remotes.Resolver, ref string, desc ocispec.Descriptor) ([]byte, error) {
 	fetcher, err := resolver.Fetcher(ctx, ref)
 	if err != nil {
-		return "", fmt.Errorf("while creating fetcher for reference: %v", err)
+		return nil, fmt.Errorf("while creating fetcher for reference: %v", err)
 	}
 
 	rc, err := fetcher.Fetch(ctx, desc)
 	if err != nil {
-		return "", fmt.Errorf("while fetching manifest: %v", err)
+		return nil, err
 	}
 	defer rc.Close()
 
-	b, err := ioutil.ReadAll(rc)
+	b, err := ioutil.ReadAll(io.LimitReader(rc, desc.Size+1))
 	if err != nil {
-		return "", fmt.Errorf("while reading manifest: %v", err)
+		return nil, err
 	}
-
-	var man ocispec.Manifest
-	if err := json.Unmarshal(b, &man); err != nil {
-		return "", fmt.Errorf("while unmarshalling manifest: %v", err)
+	if int64(len(b)) != desc.Size || desc.Digest.Validate() != nil || desc.Digest.Algorithm().FromBytes(b) != desc.Digest {
+		return nil, fmt.Errorf("content doesn't match digest %s", desc.Digest)
 	}
 
+	return b, nil
+}
+
+// sifLayer returns the descriptor of the SIF layer of the manifest man.
+func sifLayer(man *ocispec.Manifest) (ocispec.Descriptor, error) {
 	// search image layers for sif image and return sha
 	for _, l := range man.Layers {
 		if l.MediaType == SifLayerMediaType {
 			// only allow sha256 digests
 			if l.Digest.Algorithm() != digest.SHA256 {
-				return "", fmt.Errorf("SIF layer found with incorrect digest algorithm: %s", l.Digest.Algorithm())
+				return l, fmt.Errorf("SIF layer found with incorrect digest algorithm: %s", l.Digest.Algorithm())
 			}
-			return l.Digest.String(), nil
+			return l, nil
 		}
 	}
 
-	return "", fmt.Errorf("no layer found corresponding to SIF image")
+	return ocispec.Descriptor{}, fmt.Errorf("no layer found corresponding to SIF image")
 }
 
 // ImageHash returns the appropriate hash for a provided image file
@@ -0,0 +1,291 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package oras
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/remotes"
+	"github.com/containerd/containerd/remotes/docker"
+	ocitypes "github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	uuid "github.com/satori/go.uuid"
+	"github.com/sylabs/sif/pkg/sif"
+)
+
+const testSquash = "../../../pkg/image/testdata/squashfs.v4"
+
+var (
+	uploadPath = regexp.MustCompile(`^/v2/(.+)/blobs/uploads/(.*)$`)
+	objectPath = regexp.MustCompile(`^/v2/(.+)/(manifests|blobs)/([^/]+)$`)
+)
+
+type manifest struct {
+	mediaType string
+	data      []byte
+}
+
+// registry is a minimal in memory implementation of the registry API
+// serving the requests of the ORAS push and pull operations.
+type registry struct {
+	sync.Mutex
+	blobs     map[digest.Digest][]byte
+	manifests map[string]manifest
+	uploads   int
+	// status holds the status codes answered to the requests of
+	// the manifests repo:ref instead of the manifests.
+	status map[string]int
+}
+
+func newRegistry() *registry {
+	return &registry{
+		blobs:     make(map[digest.Digest][]byte),
+		manifests: make(map[string]manifest),
+		status:    make(map[string]int),
+	}
+}
+
+func (r *registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.Lock()
+	defer r.Unlock()
+
+	if m := uploadPath.FindStringSubmatch(req.URL.Path); m != nil {
+		switch req.Method {
+		case http.MethodPost:
+			r.uploads++
+			w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%d", m[1], r.uploads))
+			w.WriteHeader(http.StatusAccepted)
+		case http.MethodPut:
+			data, _ := ioutil.ReadAll(req.Body)
+			dgst := digest.Digest(req.URL.Query().Get("digest"))
+			if dgst.Validate() != nil || digest.FromBytes(data) != dgst {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			r.blobs[dgst] = data
+			w.Header().Set("Docker-Content-Digest", dgst.String())
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	m := objectPath.FindStringSubmatch(req.URL.Path)
+	if m == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	repo, kind, ref := m[1], m[2], m[3]
+
+	var data []byte
+	mediaType := "application/octet-stream"
+
+	switch {
+	case kind == "manifests" && req.Method == http.MethodPut:
+		data, _ := ioutil.ReadAll(req.Body)
+		mf := manifest{mediaType: req.Header.Get("Content-Type"), data: data}
+		dgst := digest.FromBytes(data)
+		r.manifests[repo+"@"+dgst.String()] = mf
+		r.manifests[repo+":"+ref] = mf
+		w.Header().Set("Docker-Content-Digest", dgst.String())
+		w.WriteHeader(http.StatusCreated)
+		return
+	case kind == "manifests" && r.status[repo+":"+ref] != 0:
+		w.WriteHeader(r.status[repo+":"+ref])
+		return
+	case kind == "manifests":
+		mf, ok := r.manifests[repo+"@"+ref]
+		if !ok {
+			mf, ok = r.manifests[repo+":"+ref]
+		}
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		data, mediaType = mf.data, mf.mediaType
+	default:
+		blob, ok := r.blobs[digest.Digest(ref)]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		data = blob
+	}
+
+	w.Header().Set("Content-Type", mediaType)
+	w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+	w.Header().Set("Docker-Content-Digest", digest.FromBytes(data).String())
+	w.WriteHeader(http.StatusOK)
+	if req.Method == http.MethodGet {
+		w.Write(data)
+	}
+}
+
+// createSIF creates a minimal SIF image in dir.
+func createSIF(t *testing.T, dir string) string {
+	path := filepath.Join(dir, "image.sif")
+
+	fp, err := os.Open(testSquash)
+	if err != nil {
+		t.Fatalf("failed to open %s: %s", testSquash, err)
+	}
+	defer fp.Close()
+
+	fi, err := fp.Stat()
+	if err != nil {
+		t.Fatalf("failed to stat %s: %s", testSquash, err)
+	}
+
+	definput := sif.DescriptorInput{
+		Datatype: sif.DataDeffile,
+		Groupid:  sif.DescrDefaultGroup,
+		Link:     sif.DescrUnusedLink,
+		Data:     []byte("bootstrap: scratch\n"),
+	}
+	definput.Size = int64(binary.Size(definput.Data))
+
+	parinput := sif.DescriptorInput{
+		Datatype: sif.DataPartition,
+		Groupid:  sif.DescrDefaultGroup,
+		Link:     sif.DescrUnusedLink,
+		Fname:    "rootfs",
+		Fp:       fp,
+		Size:     fi.Size(),
+	}
+	if err := parinput.SetPartExtra(sif.FsSquash, sif.PartPrimSys, sif.GetSIFArch("amd64")); err != nil {
+		t.Fatalf("failed to set partition extra data: %s", err)
+	}
+
+	cinfo := sif.CreateInfo{
+		Pathname:   path,
+		Launchstr:  sif.HdrLaunch,
+		Sifversion: sif.HdrVersion,
+		ID:         uuid.NewV4(),
+		InputDescr: []sif.DescriptorInput{definput, parinput},
+	}
+	if _, err := sif.CreateContainer(cinfo); err != nil {
+		t.Fatalf("failed to create SIF: %s", err)
+	}
+
+	return path
+}
+
+func TestImageSignature(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oras-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	reg := newRegistry()
+	srv := httptest.NewServer(reg)
+	defer srv.Close()
+
+	defer func(f func(*ocitypes.DockerAuthConfig) remotes.Resolver) {
+		newResolver = f
+	}(newResolver)
+	newResolver = func(*ocitypes.DockerAuthConfig) remotes.Resolver {
+		return docker.NewResolver(docker.ResolverOptions{PlainHTTP: true})
+	}
+
+	host := strings.TrimPrefix(srv.URL, "http://")
+	path := createSIF(t, dir)
+	ctx := context.Background()
+
+	sum, err := ImageHash(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// an image pushed without signature
+	unsigned := "//" + host + "/test/image:unsigned"
+	if err := UploadImage(path, unsigned, nil, nil); err != nil {
+		t.Fatalf("unexpected error while pushing: %s", err)
+	}
+	if _, err := ImageSignature(ctx, unsigned, nil); err != ErrNoSignature {
+		t.Errorf("unexpected signature result for an unsigned image: %v", err)
+	}
+
+	// an image pushed with a detached signature
+	var payload []byte
+	sign := func(p []byte) ([]byte, error) {
+		payload = p
+		return append([]byte("signature of "), p...), nil
+	}
+	signed := "//" + host + "/test/image:signed"
+	if err := UploadImage(path, signed, nil, sign); err != nil {
+		t.Fatalf("unexpected error while pushing: %s", err)
+	}
+
+	sig, err := ImageSignature(ctx, signed, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !bytes.Equal(sig.Data, append([]byte("signature of "), payload...)) {
+		t.Errorf("unexpected signature %q", sig.Data)
+	}
+	if sig.Reference != host+"/test/image" || sig.Image.String() != sum {
+		t.Errorf("unexpected signed image %+v", sig.SignaturePayload)
+	}
+
+	var p SignaturePayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		t.Fatalf("failed to decode payload: %s", err)
+	}
+	if p != sig.SignaturePayload {
+		t.Errorf("signed payload %+v doesn't describe the image %+v", p, sig.SignaturePayload)
+	}
+	if _, ok := reg.manifests["test/image:"+SignatureTag(p.Manifest)]; !ok {
+		t.Errorf("signature isn't tagged after the manifest digest %s", p.Manifest)
+	}
+
+	// the signed image is pulled as usual
+	pulled := filepath.Join(dir, "pulled.sif")
+	if err := DownloadImage(pulled, signed, nil); err != nil {
+		t.Fatalf("unexpected error while pulling: %s", err)
+	}
+	if pulledSum, err := ImageHash(pulled); err != nil || pulledSum != sum {
+		t.Errorf("unexpected pulled image hash %s: %v", pulledSum, err)
+	}
+
+	// the signature of the image previously pushed with
+	// the tag doesn't apply to another image
+	if err := UploadImage(pulled, signed, nil, nil); err != nil {
+		t.Fatalf("unexpected error while pushing: %s", err)
+	}
+	if _, err := ImageSignature(ctx, signed, nil); err != ErrNoSignature {
+		t.Errorf("unexpected signature result for an unsigned image: %v", err)
+	}
+
+	// unknown references are reported with a typed error
+	if _, _, err := getResolver(nil).Resolve(ctx, host+"/test/image:missing"); !errdefs.IsNotFound(err) {
+		t.Errorf("unexpected error for an unknown reference: %v", err)
+	}
+
+	// a registry failure isn't reported as a missing signature
+	reg.Lock()
+	dgst := digest.FromBytes(reg.manifests["test/image:unsigned"].data)
+	reg.status["test/image:"+SignatureTag(dgst)] = http.StatusInternalServerError
+	reg.Unlock()
+	if _, err := ImageSignature(ctx, unsigned, nil); err == nil || err == ErrNoSignature {
+		t.Errorf("unexpected signature result for a registry failure: %v", err)
+	}
+}
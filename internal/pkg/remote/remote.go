@@ -37,9 +37,10 @@ type Config struct {
 
 // EndPoint descriptes a single remote service
 type EndPoint struct {
-	URI    string `yaml:"URI,omitempty"`
-	Token  string `yaml:"Token,omitempty"`
-	System bool   `yaml:"System"` // Was this EndPoint set from system config file
+	URI     string `yaml:"URI,omitempty"`
+	Token   string `yaml:"Token,omitempty"`
+	System  bool   `yaml:"System"`            // Was this EndPoint set from system config file
+	Keyring string `yaml:"Keyring,omitempty"` // Named keyring used to verify images of this EndPoint
 }
 
 // ReadFrom reads remote configuration from io.Reader
@@ -93,12 +94,14 @@ func (c *Config) SyncFrom(sys *Config) error {
 			return fmt.Errorf("name collision while syncing: %s", name)
 		} else if err == nil {
 			eUsr.URI = eSys.URI // update URI just in case
+			eUsr.Keyring = eSys.Keyring
 			continue
 		}
 
 		e := &EndPoint{
-			URI:    eSys.URI,
-			System: true,
+			URI:     eSys.URI,
+			System:  true,
+			Keyring: eSys.Keyring,
 		}
 
 		if err := c.Add(name, e); err != nil {
@@ -253,6 +253,42 @@ func TestSyncFrom(t *testing.T) {
 					},
 				},
 			},
+		}, {
+			name: "sys config endpoint keyring",
+			sys: Config{
+				Remotes: map[string]*EndPoint{
+					"sylabs-global": {
+						URI:     "cloud.sylabs.io",
+						Keyring: "release",
+					},
+					"sylabs-new": {
+						URI:     "cloud.sylabs.io",
+						Keyring: "team",
+					},
+				},
+			},
+			usr: Config{
+				Remotes: map[string]*EndPoint{
+					"sylabs-global": {
+						URI:    "cloud.sylabs.io",
+						System: true,
+					},
+				},
+			},
+			res: Config{
+				Remotes: map[string]*EndPoint{
+					"sylabs-global": {
+						URI:     "cloud.sylabs.io",
+						System:  true,
+						Keyring: "release",
+					},
+					"sylabs-new": {
+						URI:     "cloud.sylabs.io",
+						System:  true,
+						Keyring: "team",
+					},
+				},
+			},
 		}, {
 			name: "sys config update default endpoint",
 			sys: Config{
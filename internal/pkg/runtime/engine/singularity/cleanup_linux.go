@@ -21,6 +21,7 @@ import (
 	"github.com/sylabs/singularity/internal/pkg/util/starter"
 	"github.com/sylabs/singularity/pkg/runtime/engine/config"
 	"github.com/sylabs/singularity/pkg/util/crypt"
+	"github.com/sylabs/singularity/pkg/util/verity"
 )
 
 // CleanupContainer is called from master after the MonitorContainer returns.
@@ -75,9 +76,9 @@ func (e *EngineOperations) CleanupContainer(ctx context.Context, fatal error, st
 		}
 	}
 
-	if e.EngineConfig.CryptDev != "" {
-		if err := cleanupCrypt(e.EngineConfig.CryptDev); err != nil {
-			sylog.Errorf("could not cleanup crypt: %v", err)
+	if len(e.EngineConfig.CryptDevs) > 0 || len(e.EngineConfig.VerityDevs) > 0 {
+		if err := cleanupDevices(e.EngineConfig.CryptDevs, e.EngineConfig.VerityDevs); err != nil {
+			sylog.Errorf("could not cleanup crypt and verity devices: %v", err)
 		}
 	}
 
@@ -92,8 +93,8 @@ func (e *EngineOperations) CleanupContainer(ctx context.Context, fatal error, st
 	return nil
 }
 
-func cleanupCrypt(path string) error {
-	// elevate the privilege to unmount and delete the crypt device
+func cleanupDevices(cryptPaths, verityPaths []string) error {
+	// elevate the privilege to unmount and delete the crypt and verity devices
 	priv.Escalate()
 	defer priv.Drop()
 
@@ -107,12 +108,39 @@ func cleanupCrypt(path string) error {
 		return fmt.Errorf("error while unmounting rootfs session directory: %s", err)
 	}
 
-	devName := filepath.Base(path)
+	// encrypted overlay images are mounted in the overlay-images
+	// session directory, they must be unmounted to release the
+	// crypt devices
+	overlays, _ := filepath.Glob(filepath.Join(buildcfg.SESSIONDIR, "overlay-images", "*"))
+	for _, dir := range overlays {
+		if err := syscall.Unmount(dir, syscall.MNT_DETACH); err != nil && err != syscall.EINVAL {
+			sylog.Debugf("Could not unmount overlay session directory %s: %s", dir, err)
+		}
+	}
 
-	cryptDev := &crypt.Device{}
-	err = cryptDev.CloseCryptDevice(devName)
-	if err != nil {
-		return fmt.Errorf("unable to delete crypt device: %s", devName)
+	// close all crypt and verity devices even if one of them fails
+	var failed []string
+
+	for _, path := range cryptPaths {
+		devName := filepath.Base(path)
+
+		cryptDev := &crypt.Device{}
+		if err := cryptDev.CloseCryptDevice(devName); err != nil {
+			failed = append(failed, devName)
+		}
+	}
+
+	for _, path := range verityPaths {
+		devName := filepath.Base(path)
+
+		verityDev := &verity.Device{}
+		if err := verityDev.Close(devName); err != nil {
+			failed = append(failed, devName)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("unable to delete crypt or verity device(s): %s", strings.Join(failed, ", "))
 	}
 
 	return nil
@@ -7,6 +7,8 @@ package singularity
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -19,6 +21,7 @@ import (
 	"github.com/sylabs/singularity/internal/pkg/buildcfg"
 	"github.com/sylabs/singularity/internal/pkg/cgroups"
 	"github.com/sylabs/singularity/internal/pkg/runtime/engine/singularity/rpc/client"
+	"github.com/sylabs/singularity/internal/pkg/syecl"
 	"github.com/sylabs/singularity/internal/pkg/sylog"
 	"github.com/sylabs/singularity/internal/pkg/util/fs"
 	"github.com/sylabs/singularity/internal/pkg/util/fs/files"
@@ -37,6 +40,7 @@ import (
 	"github.com/sylabs/singularity/pkg/util/loop"
 	"github.com/sylabs/singularity/pkg/util/namespaces"
 	"github.com/sylabs/singularity/pkg/util/nvidia"
+	"github.com/sylabs/singularity/pkg/util/verity"
 	"golang.org/x/crypto/ssh/terminal"
 )
 
@@ -81,6 +85,12 @@ func create(ctx context.Context, engine *EngineOperations, rpcOps *client.RPC, p
 		suidFlag:      syscall.MS_NOSUID,
 	}
 
+	if d := engine.EngineConfig.GetEclDecision(); d != nil {
+		if err := c.auditEclDecision(d); err != nil {
+			return err
+		}
+	}
+
 	cwd := engine.EngineConfig.GetCwd()
 	if err := os.Chdir(cwd); err != nil {
 		return fmt.Errorf("can't change directory to %s: %s", cwd, err)
@@ -232,6 +242,25 @@ func create(ctx context.Context, engine *EngineOperations, rpcOps *client.RPC, p
 	return nil
 }
 
+// auditEclDecision writes the ECL decision made while loading the image to
+// the audit log through the RPC server, which has the privileges to write
+// to a file protected from users, and refuses the image if it was denied.
+func (c *container) auditEclDecision(d *syecl.Decision) error {
+	img := c.engine.EngineConfig.GetImageList()[0]
+
+	c.rpcOps.SetFsID(0, 0)
+	_, err := c.rpcOps.EclAudit(d, int(img.Fd))
+	c.rpcOps.SetFsID(os.Getuid(), os.Getgid())
+	if err != nil {
+		sylog.Warningf("While writing ECL audit record: %s", err)
+	}
+
+	if !d.Allowed {
+		return errors.New(d.Reason)
+	}
+	return nil
+}
+
 // setupSessionLayout will create the session layout according to the capabilities of Singularity
 // on the system. It will first attempt to use "overlay", followed by "underlay", and if neither
 // are available it will not use either. If neither are used, we will not be able to bind mount
@@ -521,6 +550,14 @@ mount:
 	return nil
 }
 
+// verityMount holds the verity parameters of an image mount point
+// and the location of the hash tree in the image.
+type verityMount struct {
+	Params     verity.Params `json:"params"`
+	HashOffset uint64        `json:"hashOffset"`
+	HashSize   uint64        `json:"hashSize"`
+}
+
 // mount image via loop
 func (c *container) mountImage(mnt *mount.Point) error {
 	maxDevices := int(c.engine.EngineConfig.File.MaxLoopDevices)
@@ -563,7 +600,7 @@ func (c *container) mountImage(mnt *mount.Point) error {
 
 	mountType := mnt.Type
 
-	if mountType == "encryptfs" {
+	if mountType == "encryptfs" || mountType == "encryptext3" {
 		key, err := mount.GetKey(mnt.InternalOptions)
 		if err != nil {
 			return err
@@ -586,11 +623,66 @@ func (c *container) mountImage(mnt *mount.Point) error {
 		path = cryptDev
 
 		// Save this device to cleanup later
-		c.engine.EngineConfig.CryptDev = cryptDev
+		c.engine.EngineConfig.CryptDevs = append(c.engine.EngineConfig.CryptDevs, cryptDev)
+
+		// root filesystems are encrypted squashfs, overlays
+		// are encrypted ext3
+		if mountType == "encryptext3" {
+			mountType = "ext3"
+		} else {
+			mountType = "squashfs"
+		}
+	}
+
+	if mountType == "verityfs" {
+		data, err := mount.GetVerity(mnt.InternalOptions)
+		if err != nil {
+			return err
+		}
+
+		vm := new(verityMount)
+		if err := json.Unmarshal(data, vm); err != nil {
+			return fmt.Errorf("while decoding verity parameters: %s", err)
+		}
+
+		// the root hash must be the one verified with the image signatures
+		rootHash := c.engine.EngineConfig.GetVerityRootHash()
+		if rootHash == "" || vm.Params.RootHash != rootHash {
+			return fmt.Errorf("verity root hash of %s doesn't match the signed one", mnt.Source)
+		}
+
+		// the hash tree is read through its own loop device
+		hashInfo := loop.Info64{
+			Offset:    vm.HashOffset,
+			SizeLimit: vm.HashSize,
+			Flags:     loop.FlagsAutoClear | loop.FlagsReadOnly,
+		}
+		hashNumber, err := c.rpcOps.LoopDevice(mnt.Source, os.O_RDONLY, hashInfo, maxDevices, shared)
+		if err != nil {
+			return fmt.Errorf("failed to find loop device for verity hash tree: %s", err)
+		}
+		hashPath := fmt.Sprintf("/dev/loop%d", hashNumber)
+
+		// veritysetup requires to run in the host IPC namespace
+		// like cryptsetup
+		masterPid := 0
+		if c.ipcNS {
+			masterPid = os.Getpid()
+		}
+
+		verityDev, err := c.rpcOps.Verity(path, hashPath, &vm.Params, masterPid)
+		if err != nil {
+			return fmt.Errorf("unable to open verity device: %s", err)
+		}
+
+		path = verityDev
+
+		// Save this device to cleanup later
+		c.engine.EngineConfig.VerityDevs = append(c.engine.EngineConfig.VerityDevs, verityDev)
 
-		// Currently we only support encrypted squashfs file system
 		mountType = "squashfs"
 	}
+
 	err = c.rpcOps.Mount(path, mnt.Destination, mountType, flags, optsString)
 	switch err {
 	case syscall.EINVAL:
@@ -677,6 +769,39 @@ func (c *container) addRootfsMount(system *mount.System) error {
 	switch imageObject.Partitions[0].Type {
 	case image.SQUASHFS:
 		mountType = "squashfs"
+
+		if c.engine.EngineConfig.File.EnableVerity {
+			params, tree, err := verity.FromImage(imageObject)
+			if err != nil {
+				return err
+			} else if params == nil && c.engine.EngineConfig.GetVerityRootHash() != "" {
+				return fmt.Errorf("verity hash tree of %s was removed after its verification", imageObject.Path)
+			} else if params != nil {
+				data, err := json.Marshal(verityMount{
+					Params:     *params,
+					HashOffset: tree.Offset,
+					HashSize:   tree.Size,
+				})
+				if err != nil {
+					return err
+				}
+
+				sylog.Debugf("Mounting squashfs image through a verity device: %v\n", rootfs)
+				if err := system.Points.AddVerityImage(
+					mount.RootfsTag,
+					imageObject.Source,
+					c.session.RootFsPath(),
+					flags,
+					offset,
+					size,
+					data,
+				); err != nil {
+					return err
+				}
+
+				return nil
+			}
+		}
 	case image.EXT3:
 		mountType = "ext3"
 	case image.ENCRYPTSQUASHFS:
@@ -802,7 +927,7 @@ func (c *container) addOverlayMount(system *mount.System) error {
 		size := imageObject.Partitions[0].Size
 
 		switch imageObject.Type {
-		case image.EXT3:
+		case image.EXT3, image.ENCRYPTEXT3:
 			flags := uintptr(c.suidFlag | syscall.MS_NODEV)
 
 			if !imageObject.Writable {
@@ -810,7 +935,18 @@ func (c *container) addOverlayMount(system *mount.System) error {
 				ov.AddLowerDir(filepath.Join(dst, "upper"))
 			}
 
-			err = system.Points.AddImage(mount.PreLayerTag, src, dst, "ext3", flags, offset, size, nil)
+			mountType := "ext3"
+			var key []byte
+
+			if imageObject.Type == image.ENCRYPTEXT3 {
+				mountType = "encryptext3"
+				key = c.engine.EngineConfig.GetOverlayEncryptionKey(imageObject.Path)
+				if key == nil {
+					return fmt.Errorf("no key found for encrypted overlay image %s", imageObject.Path)
+				}
+			}
+
+			err = system.Points.AddImage(mount.PreLayerTag, src, dst, mountType, flags, offset, size, key)
 			if err != nil {
 				return fmt.Errorf("while adding ext3 image: %s", err)
 			}
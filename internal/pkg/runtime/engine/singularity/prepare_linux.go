@@ -7,7 +7,9 @@ package singularity
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -32,8 +34,11 @@ import (
 	"github.com/sylabs/singularity/pkg/image"
 	"github.com/sylabs/singularity/pkg/runtime/engine/config"
 	singularityConfig "github.com/sylabs/singularity/pkg/runtime/engine/singularity/config"
+	"github.com/sylabs/singularity/pkg/signing"
+	"github.com/sylabs/singularity/pkg/sypgp"
 	"github.com/sylabs/singularity/pkg/util/capabilities"
 	"github.com/sylabs/singularity/pkg/util/fs/proc"
+	"github.com/sylabs/singularity/pkg/util/verity"
 	"golang.org/x/sys/unix"
 )
 
@@ -955,7 +960,7 @@ func (e *EngineOperations) setSessionLayer(img *image.Image) error {
 
 			if img.Type == image.SIF {
 				for _, p := range img.Partitions[1:] {
-					if p.Type == image.EXT3 {
+					if p.Type == image.EXT3 || p.Type == image.ENCRYPTEXT3 {
 						hasSIFOverlay = true
 						break
 					}
@@ -1002,6 +1007,70 @@ func (e *EngineOperations) setSessionLayer(img *image.Image) error {
 	return nil
 }
 
+// verifyImage verifies the signatures of img required by the configuration:
+// img must satisfy the system verification policy if enforced, and the
+// dm-verity root hash of its system partition, if any, must be covered by a
+// valid signature when verity is enabled. Signatures are only verified with
+// keys of the local keyring and of the system trust bundle, if set. The image
+// is verified once through its file descriptor, so that the verified file is
+// the one mounted. It returns the verified root hash, or an empty string if
+// img has no verity hash tree.
+func verifyImage(img *image.Image, file *config.FileConfig) (string, error) {
+	if file.EnforceVerifyPolicy && img.Type != image.SIF {
+		return "", fmt.Errorf("only SIF images satisfying the verification policy are allowed to run")
+	}
+
+	hasVerity := false
+	if file.EnableVerity {
+		params, _, err := verity.FromImage(img)
+		if err != nil {
+			return "", err
+		}
+		hasVerity = params != nil
+	}
+	if !file.EnforceVerifyPolicy && !hasVerity {
+		return "", nil
+	}
+
+	var err error
+	var data []byte
+	opts := signing.VerifyOptions{}
+	if hasVerity {
+		opts.VerityParams = &data
+	}
+	if file.EnforceVerifyPolicy {
+		opts.Policy, err = signing.LoadPolicy(buildcfg.VERIFY_POLICY_FILE)
+		if err != nil {
+			return "", fmt.Errorf("while loading verification policy: %s", err)
+		}
+	}
+	if file.TrustBundle != "" {
+		opts.TrustBundle, err = sypgp.LoadSystemTrustBundle(file.TrustBundle, file.TrustBundleSigners)
+		if err != nil {
+			return "", fmt.Errorf("while loading system trust bundle: %s", err)
+		}
+	}
+
+	author, _, err := signing.Verify(context.TODO(), img.Source, "", 0, false, false, "", true, false, opts)
+	if err != nil && file.EnforceVerifyPolicy {
+		sylog.Verbosef("%s", author)
+		return "", fmt.Errorf("%s doesn't satisfy the verification policy %s, run 'singularity verify --policy %s --local %s' for details", img.Path, buildcfg.VERIFY_POLICY_FILE, buildcfg.VERIFY_POLICY_FILE, img.Path)
+	}
+	if !hasVerity {
+		return "", nil
+	}
+	if err != nil || data == nil {
+		sylog.Verbosef("%s", author)
+		return "", fmt.Errorf("%s is protected by a dm-verity hash tree but no valid signature covers it, run 'singularity verify --local %s' for details", img.Path, img.Path)
+	}
+
+	verified, err := verity.ParseParams(data)
+	if err != nil {
+		return "", err
+	}
+	return verified.RootHash, nil
+}
+
 func (e *EngineOperations) loadImages(starterConfig *starter.Config) error {
 	images := make([]image.Image, 0)
 
@@ -1026,6 +1095,15 @@ func (e *EngineOperations) loadImages(starterConfig *starter.Config) error {
 
 	sessionLayer := e.EngineConfig.GetSessionLayer()
 
+	// the root hash and the ECL decision are only trusted when set
+	// here, never when set by the user configuration
+	rootHash, err := verifyImage(img, e.EngineConfig.File)
+	if err != nil {
+		return err
+	}
+	e.EngineConfig.SetVerityRootHash(rootHash)
+	e.EngineConfig.SetEclDecision(nil)
+
 	// first image is always the root filesystem
 	images = append(images, *img)
 	writableOverlayPath := ""
@@ -1056,9 +1134,16 @@ func (e *EngineOperations) loadImages(starterConfig *starter.Config) error {
 			if err = ecl.ValidateConfig(); err != nil {
 				return err
 			}
-			_, err := ecl.ShouldRunFp(img.File)
-			if err != nil {
-				return err
+			if ecl.Activated {
+				d := ecl.DecideFp(img.File, e.EngineConfig.GetImageArch())
+				// the audit log is written by the RPC server which
+				// has the privileges to write to a protected file,
+				// a denied image is then refused by CreateContainer
+				if ecl.AuditEnabled() {
+					e.EngineConfig.SetEclDecision(d)
+				} else if !d.Allowed {
+					return errors.New(d.Reason)
+				}
 			}
 		}
 		// load overlay partition if we use overlay layer
@@ -1066,13 +1151,13 @@ func (e *EngineOperations) loadImages(starterConfig *starter.Config) error {
 			// look for potential overlay partition in SIF image
 			// and inject them into the overlay list
 			for _, p := range img.Partitions[1:] {
-				if p.Type == image.EXT3 || p.Type == image.SQUASHFS {
+				if p.Type == image.EXT3 || p.Type == image.ENCRYPTEXT3 || p.Type == image.SQUASHFS {
 					imgCopy := *img
 					imgCopy.Type = int(p.Type)
 					imgCopy.Partitions = []image.Section{p}
 					images = append(images, imgCopy)
 					overlayPartitions = append(overlayPartitions, imgCopy.Path)
-					if img.Writable && p.Type == image.EXT3 {
+					if img.Writable && p.Type != image.SQUASHFS {
 						writableOverlayPath = img.Path
 					}
 				}
@@ -1092,7 +1177,7 @@ func (e *EngineOperations) loadImages(starterConfig *starter.Config) error {
 
 	// lock all ext3 partitions if any to prevent concurrent writes
 	for _, part := range img.Partitions {
-		if part.Type == image.EXT3 {
+		if part.Type == image.EXT3 || part.Type == image.ENCRYPTEXT3 {
 			if err := img.LockSection(part); err != nil {
 				return fmt.Errorf("error while locking ext3 partition from %s: %s", img.Path, err)
 			}
@@ -1115,9 +1200,18 @@ func (e *EngineOperations) loadImages(starterConfig *starter.Config) error {
 			return fmt.Errorf("failed to open overlay image %s: %s", splitted[0], err)
 		}
 
+		// SIF overlay images hold a single overlay partition
+		// without root filesystem
+		if img.Type == image.SIF && !img.HasRootFs() {
+			if len(img.Partitions) != 1 {
+				return fmt.Errorf("SIF overlay image %s must contain exactly one overlay partition", img.Path)
+			}
+			img.Type = int(img.Partitions[0].Type)
+		}
+
 		for _, part := range img.Partitions {
 			// lock all ext3 partitions if any to prevent concurrent writes
-			if part.Type == image.EXT3 {
+			if part.Type == image.EXT3 || part.Type == image.ENCRYPTEXT3 {
 				if err := img.LockSection(part); err != nil {
 					return fmt.Errorf("error while locking ext3 overlay partition from %s: %s", img.Path, err)
 				}
@@ -1152,7 +1246,7 @@ func (e *EngineOperations) loadImages(starterConfig *starter.Config) error {
 }
 
 func (e *EngineOperations) loadImage(path string, writable bool) (*image.Image, error) {
-	imgObject, err := image.Init(path, writable)
+	imgObject, err := image.InitArch(path, writable, e.EngineConfig.GetImageArch())
 	if err != nil {
 		return nil, err
 	}
@@ -9,7 +9,9 @@ import (
 	"os"
 	"syscall"
 
+	"github.com/sylabs/singularity/internal/pkg/syecl"
 	"github.com/sylabs/singularity/pkg/util/loop"
+	"github.com/sylabs/singularity/pkg/util/verity"
 )
 
 // MkdirArgs defines the arguments to mkdir.
@@ -44,6 +46,14 @@ type CryptArgs struct {
 	MasterPid int
 }
 
+// VerityArgs defines the arguments to open a verity device.
+type VerityArgs struct {
+	DataDev   string
+	HashDev   string
+	Params    verity.Params
+	MasterPid int
+}
+
 // ChrootArgs defines the arguments to chroot.
 type ChrootArgs struct {
 	Root   string
@@ -76,3 +86,10 @@ type StatReply struct {
 type StatArgs struct {
 	Path string
 }
+
+// EclAuditArgs defines the arguments to write an ECL decision to the
+// audit log.
+type EclAuditArgs struct {
+	Decision syecl.Decision
+	ImageFd  int
+}
@@ -12,7 +12,9 @@ import (
 	"syscall"
 
 	args "github.com/sylabs/singularity/internal/pkg/runtime/engine/singularity/rpc"
+	"github.com/sylabs/singularity/internal/pkg/syecl"
 	"github.com/sylabs/singularity/pkg/util/loop"
+	"github.com/sylabs/singularity/pkg/util/verity"
 )
 
 // RPC holds the state necessary for remote procedure calls.
@@ -57,6 +59,21 @@ func (t *RPC) Decrypt(offset uint64, path string, key []byte, masterPid int) (st
 	return reply, err
 }
 
+// Verity calls the Verity RPC using the supplied arguments.
+func (t *RPC) Verity(dataDev, hashDev string, params *verity.Params, masterPid int) (string, error) {
+	arguments := &args.VerityArgs{
+		DataDev:   dataDev,
+		HashDev:   hashDev,
+		Params:    *params,
+		MasterPid: masterPid,
+	}
+
+	var reply string
+	err := t.Client.Call(t.Name+".Verity", arguments, &reply)
+
+	return reply, err
+}
+
 // Mkdir calls the mkdir RPC using the supplied arguments.
 func (t *RPC) Mkdir(path string, perm os.FileMode) (int, error) {
 	arguments := &args.MkdirArgs{
@@ -137,6 +154,17 @@ func (t *RPC) Stat(path string) (*syscall.Stat_t, error) {
 	return &reply.St, err
 }
 
+// EclAudit calls the EclAudit RPC using the supplied arguments.
+func (t *RPC) EclAudit(d *syecl.Decision, imageFd int) (int, error) {
+	arguments := &args.EclAuditArgs{
+		Decision: *d,
+		ImageFd:  imageFd,
+	}
+	var reply int
+	err := t.Client.Call(t.Name+".EclAudit", arguments, &reply)
+	return reply, err
+}
+
 func init() {
 	var sysErrnoType syscall.Errno
 	// register syscall.Errno as a type we need to get back
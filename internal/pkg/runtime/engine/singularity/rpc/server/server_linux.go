@@ -13,13 +13,16 @@ import (
 	"strings"
 	"syscall"
 
+	"github.com/sylabs/singularity/internal/pkg/buildcfg"
 	args "github.com/sylabs/singularity/internal/pkg/runtime/engine/singularity/rpc"
+	"github.com/sylabs/singularity/internal/pkg/syecl"
 	"github.com/sylabs/singularity/internal/pkg/sylog"
 	"github.com/sylabs/singularity/internal/pkg/util/mainthread"
 	"github.com/sylabs/singularity/internal/pkg/util/user"
 	"github.com/sylabs/singularity/pkg/util/crypt"
 	"github.com/sylabs/singularity/pkg/util/loop"
 	"github.com/sylabs/singularity/pkg/util/namespaces"
+	"github.com/sylabs/singularity/pkg/util/verity"
 )
 
 var diskGID = -1
@@ -66,6 +69,35 @@ func (t *Methods) Decrypt(arguments *args.CryptArgs, reply *string) (err error)
 	return err
 }
 
+// Verity opens a verity device checking the data loop device.
+func (t *Methods) Verity(arguments *args.VerityArgs, reply *string) (err error) {
+	verityDev := &verity.Device{}
+
+	// veritysetup requires to run in the host IPC namespace
+	// like cryptsetup, see Decrypt
+	if arguments.MasterPid > 0 {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+
+		if err := namespaces.Enter(arguments.MasterPid, "ipc"); err != nil {
+			return fmt.Errorf("while joining host IPC namespace: %s", err)
+		}
+	}
+
+	verityName, err := verityDev.Open(arguments.DataDev, arguments.HashDev, &arguments.Params)
+
+	// return to the container IPC namespace if required
+	if arguments.MasterPid > 0 {
+		if err := namespaces.Enter(os.Getpid(), "ipc"); err != nil {
+			return fmt.Errorf("while joining container IPC namespace: %s", err)
+		}
+	}
+
+	*reply = "/dev/mapper/" + verityName
+
+	return err
+}
+
 // Mkdir performs a mkdir with the specified arguments.
 func (t *Methods) Mkdir(arguments *args.MkdirArgs, reply *int) (err error) {
 	mainthread.Execute(func() {
@@ -219,3 +251,27 @@ func (t *Methods) Stat(arguments *args.StatArgs, reply *args.StatReply) error {
 	reply.Err = syscall.Stat(arguments.Path, &reply.St)
 	return err
 }
+
+// EclAudit writes the ECL decision about the image opened with the file
+// descriptor ImageFd to the audit log set by the ECL configuration file,
+// the image digest is computed from this file descriptor.
+func (t *Methods) EclAudit(arguments *args.EclAuditArgs, reply *int) (err error) {
+	ecl, err := syecl.LoadConfig(buildcfg.ECL_FILE)
+	if err != nil {
+		return fmt.Errorf("while loading ECL configuration: %s", err)
+	}
+
+	mainthread.Execute(func() {
+		var fp *os.File
+
+		fp, err = os.Open(fmt.Sprintf("/proc/self/fd/%d", arguments.ImageFd))
+		if err != nil {
+			return
+		}
+		defer fp.Close()
+
+		d := arguments.Decision
+		err = ecl.Audit(&d, fp)
+	})
+	return err
+}
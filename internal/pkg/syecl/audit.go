@@ -0,0 +1,158 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package syecl
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/syslog"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/sylabs/singularity/internal/pkg/util/user"
+)
+
+// syslogTag is the tag of the audit messages sent to the local syslog
+const syslogTag = "singularity-ecl"
+
+// Decision describes an allow or deny decision of the ECL, it is written
+// as a JSON line to the audit log
+type Decision struct {
+	Time    time.Time `json:"time"`
+	UID     int       `json:"uid"`
+	User    string    `json:"user,omitempty"`
+	Image   string    `json:"image"`
+	Digest  string    `json:"digest,omitempty"`
+	Group   string    `json:"execgroup,omitempty"`
+	Mode    string    `json:"mode,omitempty"`
+	Signers []string  `json:"signers,omitempty"`
+	Allowed bool      `json:"allowed"`
+	Reason  string    `json:"reason,omitempty"`
+}
+
+// newDecision returns a decision about image for the user running it
+func newDecision(image string) *Decision {
+	d := &Decision{
+		Time:  time.Now().UTC(),
+		UID:   os.Getuid(),
+		Image: image,
+	}
+	if u, err := user.CurrentOriginal(); err == nil {
+		d.UID = int(u.UID)
+		d.User = u.Name
+	}
+	return d
+}
+
+// setSigners records the unique signing entities fingerprints
+func (d *Decision) setSigners(keyfps []string) {
+	seen := make(map[string]bool)
+	for _, fp := range keyfps {
+		if !seen[fp] {
+			seen[fp] = true
+			d.Signers = append(d.Signers, fp)
+		}
+	}
+}
+
+func (d *Decision) allow() *Decision {
+	d.Allowed = true
+	return d
+}
+
+func (d *Decision) deny(err error) *Decision {
+	d.Allowed = false
+	d.Reason = err.Error()
+	return d
+}
+
+// sendAudit sends an encoded decision to the local syslog, tests replace it
+var sendAudit = sendAuditSyslog
+
+// AuditEnabled returns whether decisions are written to the audit log
+func (ecl *EclConfig) AuditEnabled() bool {
+	return ecl.AuditLog != "" || ecl.AuditSyslog
+}
+
+// Audit writes the decision d about the opened container fp to the audit
+// log file and to the local syslog. The image digest is computed from fp if
+// not already set. The audit log file is protected from the users, at
+// runtime decisions are written by the privileged part of the runtime
+func (ecl *EclConfig) Audit(d *Decision, fp *os.File) error {
+	if !ecl.AuditEnabled() {
+		return nil
+	}
+
+	if d.Digest == "" {
+		digest, err := imageDigest(fp)
+		if err != nil {
+			return fmt.Errorf("while computing %s digest: %s", fp.Name(), err)
+		}
+		d.Digest = digest
+	}
+
+	b, err := json.Marshal(d)
+	if err != nil {
+		return fmt.Errorf("while encoding ECL audit record: %s", err)
+	}
+
+	if ecl.AuditLog != "" {
+		if err := appendAuditLog(ecl.AuditLog, b); err != nil {
+			return fmt.Errorf("while writing ECL audit log %s: %s", ecl.AuditLog, err)
+		}
+	}
+	if ecl.AuditSyslog {
+		if err := sendAudit(d.Allowed, b); err != nil {
+			return fmt.Errorf("while sending ECL audit record to syslog: %s", err)
+		}
+	}
+	return nil
+}
+
+// appendAuditLog appends the JSON line record to the file path, which
+// is created if missing. A symlink or a file which isn't a regular file
+// owned by the current user is refused
+func appendAuditLog(path string, record []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE|syscall.O_NOFOLLOW, 0600)
+	if err != nil {
+		return err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); !fi.Mode().IsRegular() || !ok || int(st.Uid) != os.Geteuid() {
+		f.Close()
+		return fmt.Errorf("not a regular file owned by uid %d", os.Geteuid())
+	}
+
+	if _, err := f.Write(append(record, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// sendAuditSyslog sends the JSON record to the local syslog socket, denied
+// containers are logged with the warning priority
+func sendAuditSyslog(allowed bool, record []byte) error {
+	priority := syslog.LOG_AUTH | syslog.LOG_INFO
+	if !allowed {
+		priority = syslog.LOG_AUTH | syslog.LOG_WARNING
+	}
+
+	w, err := syslog.New(priority, syslogTag)
+	if err != nil {
+		return fmt.Errorf("while connecting to syslog: %s", err)
+	}
+	defer w.Close()
+
+	_, err = w.Write(record)
+	return err
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package syecl
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAudit(t *testing.T) {
+	var decisions []Decision
+	var priorities []bool
+
+	sendAudit = func(allowed bool, record []byte) error {
+		var d Decision
+		if err := json.Unmarshal(record, &d); err != nil {
+			t.Fatalf("failed to decode audit record %q: %s", record, err)
+		}
+		decisions = append(decisions, d)
+		priorities = append(priorities, allowed)
+		return nil
+	}
+	defer func() {
+		sendAudit = sendAuditSyslog
+	}()
+
+	auditLog := filepath.Join(testEclDirPath3, "audit.log")
+	defer os.Remove(auditLog)
+
+	ecl := EclConfig{
+		Activated:   true,
+		AuditLog:    auditLog,
+		AuditSyslog: true,
+		ExecGroups: []execgroup{
+			{"group1", "whitelist", testEclDirPath1, []string{KeyFP1, KeyFP2}, nil},
+			{"group2", "whitestrict", testEclDirPath2, []string{KeyFP1, KeyFP2}, nil},
+		},
+	}
+	if err := ecl.ValidateConfig(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if run, err := ecl.ShouldRun(testContainer1); err != nil || !run {
+		t.Errorf("%s should be allowed to run: %v", testContainer1, err)
+	}
+	run, err := ecl.ShouldRun(testContainer3)
+	if err == nil || run {
+		t.Fatalf("%s should NOT be allowed to run", testContainer3)
+	}
+	// the user must know which group and signatures denied the container
+	if !strings.Contains(err.Error(), "group2") || !strings.Contains(err.Error(), KeyFP2) {
+		t.Errorf("unexpected reason: %s", err)
+	}
+
+	if len(decisions) != 2 {
+		t.Fatalf("got %d syslog audit records, expected 2", len(decisions))
+	}
+
+	f, err := os.Open(auditLog)
+	if err != nil {
+		t.Fatalf("failed to open audit log: %s", err)
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if len(lines) != 2 {
+		t.Fatalf("got %d audit log lines, expected 2", len(lines))
+	}
+	for i, l := range lines {
+		var d Decision
+		if err := json.Unmarshal([]byte(l), &d); err != nil {
+			t.Fatalf("failed to decode audit record %q: %s", l, err)
+		}
+		if d.Image != decisions[i].Image || d.Digest != decisions[i].Digest {
+			t.Errorf("audit log record %+v differs from syslog record %+v", d, decisions[i])
+		}
+	}
+
+	tests := []struct {
+		d       Decision
+		image   string
+		group   string
+		allowed bool
+	}{
+		{decisions[0], testContainer1, "group1", true},
+		{decisions[1], testContainer3, "group2", false},
+	}
+	for i, tt := range tests {
+		if priorities[i] != tt.allowed {
+			t.Errorf("record %d sent with the priority of allowed=%v", i, priorities[i])
+		}
+		if tt.d.Image != tt.image || tt.d.Group != tt.group || tt.d.Allowed != tt.allowed {
+			t.Errorf("unexpected audit record %+v", tt.d)
+		}
+		if tt.d.UID != os.Getuid() {
+			t.Errorf("got uid %d, expected %d", tt.d.UID, os.Getuid())
+		}
+		if len(tt.d.Digest) != 64 {
+			t.Errorf("unexpected digest %q", tt.d.Digest)
+		}
+		if len(tt.d.Signers) == 0 || tt.d.Signers[0] == "" {
+			t.Errorf("missing signers in %+v", tt.d)
+		}
+		if !tt.allowed && tt.d.Reason == "" {
+			t.Errorf("missing reason in %+v", tt.d)
+		}
+	}
+}
+
+func TestAuditLogSymlink(t *testing.T) {
+	target := filepath.Join(testEclDirPath3, "target.log")
+	link := filepath.Join(testEclDirPath3, "link.log")
+	defer os.Remove(target)
+	defer os.Remove(link)
+
+	if err := os.Symlink(target, link); err != nil {
+		t.Fatalf("failed to create symlink %s: %s", link, err)
+	}
+
+	ecl := EclConfig{Activated: true, AuditLog: link}
+	d := &Decision{Image: testContainer1, Digest: strings.Repeat("0", 64)}
+	if err := ecl.Audit(d, nil); err == nil {
+		t.Errorf("audit log written through symlink %s", link)
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Errorf("symlink target %s created", target)
+	}
+}
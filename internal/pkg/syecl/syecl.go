@@ -10,35 +10,126 @@
 package syecl
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
 
 	toml "github.com/pelletier/go-toml"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+	"github.com/sylabs/singularity/pkg/image"
 	"github.com/sylabs/singularity/pkg/signing"
 )
 
+const (
+	// DefaultDeny denies containers outside of any execgroup
+	DefaultDeny = "deny"
+	// DefaultDenyUnsigned allows signed containers outside of any execgroup
+	// and denies unsigned containers everywhere
+	DefaultDenyUnsigned = "deny-unsigned-everywhere"
+)
+
+// recursiveSuffix marks a dirpath matching a directory and all of its
+// subdirectories
+const recursiveSuffix = "/**"
+
 // EclConfig describes the structure of an execution control list configuration file
 type EclConfig struct {
-	Activated  bool        `toml:"activated"` // toggle the activation of the ECL rules
-	ExecGroups []execgroup `toml:"execgroup"` // Slice of all execution groups
+	Activated   bool        `toml:"activated"`   // toggle the activation of the ECL rules
+	Default     string      `toml:"default"`     // rule for containers outside of any execgroup
+	AuditLog    string      `toml:"auditlog"`    // file receiving the JSON lines audit log
+	AuditSyslog bool        `toml:"auditsyslog"` // send the audit log to the local syslog
+	ExecGroups  []execgroup `toml:"execgroup"`   // Slice of all execution groups
 }
 
 // execgroup describes an execution group, the main unit of configuration:
 //	TagName: a descriptive identifier
-//	ListMode: whether the execgroup follows a whitelist, whitestrict or blacklist model
+//	ListMode: whether the execgroup follows a whitelist, whitestrict, blacklist or sha256 model
 //		whitelist: one or more KeyFP's present and verified,
 //		whitestrict: all KeyFP's present and verified,
 //		blacklist: none of the KeyFP should be present
-//	DirPath: containers must be stored in this directory path
+//		sha256: the image digest is one of Sha256, signatures are not checked
+//	DirPath: containers must be stored in this directory path, a glob pattern
+//		or a path ending with /** for the directory and all its subdirectories
 //	KeyFPs: list of Key Fingerprints of entities to verify
+//	Sha256: list of allowed image sha256 digests
 type execgroup struct {
 	TagName  string   `toml:"tagname"`
 	ListMode string   `toml:"mode"`
 	DirPath  string   `toml:"dirpath"`
 	KeyFPs   []string `toml:"keyfp"`
+	Sha256   []string `toml:"sha256"`
+}
+
+// dirPattern returns the directory pattern of the execgroup and whether it
+// also matches subdirectories
+func (e *execgroup) dirPattern() (string, bool) {
+	if e.DirPath == recursiveSuffix {
+		return "/", true
+	}
+	if strings.HasSuffix(e.DirPath, recursiveSuffix) {
+		return strings.TrimSuffix(e.DirPath, recursiveSuffix), true
+	}
+	return e.DirPath, false
+}
+
+// matchDir returns whether the directory dir is part of the execgroup
+func (e *execgroup) matchDir(dir string) bool {
+	pattern, recursive := e.dirPattern()
+	for {
+		if ok, _ := filepath.Match(pattern, dir); ok {
+			return true
+		}
+		if !recursive || dir == "/" || dir == "." {
+			return false
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// globChars are the metacharacters of the filepath.Match patterns
+const globChars = `*?[\`
+
+// literalPrefix returns the part of pattern before its first metacharacter
+func literalPrefix(pattern string) string {
+	if i := strings.IndexAny(pattern, globChars); i >= 0 {
+		return pattern[:i]
+	}
+	return pattern
+}
+
+// overlap returns whether the two execgroups may match the same directory,
+// the pattern of each execgroup is matched against the other one. A glob
+// pattern only matches a literal directory, globs are assumed to overlap
+// with other globs and with the recursive dirpaths when the literal prefix
+// of one pattern is a prefix of the other one
+func (e *execgroup) overlap(o *execgroup) bool {
+	p, pRecursive := e.dirPattern()
+	q, qRecursive := o.dirPattern()
+	if p == q || e.matchDir(q) || o.matchDir(p) {
+		return true
+	}
+
+	pGlob := strings.ContainsAny(p, globChars)
+	qGlob := strings.ContainsAny(q, globChars)
+	if (pGlob && (qGlob || qRecursive)) || (qGlob && pRecursive) {
+		p, q = literalPrefix(p), literalPrefix(q)
+		return strings.HasPrefix(p, q) || strings.HasPrefix(q, p)
+	}
+	return false
+}
+
+// normalizeDigest returns the lower case hex digest of a sha256 entry
+// optionally prefixed by sha256:
+func normalizeDigest(d string) string {
+	return strings.ToLower(strings.TrimPrefix(d, "sha256:"))
 }
 
 // LoadConfig opens an ECL config file and unmarshals it into structures
@@ -54,14 +145,111 @@ func LoadConfig(confPath string) (ecl EclConfig, err error) {
 	return
 }
 
-// PutConfig takes the content of an EclConfig struct and Marshals it to file
+// headerComments returns the comment block at the beginning of a config
+// file, up to its first setting
+func headerComments(data []byte) []byte {
+	end := 0
+	for end < len(data) {
+		n := bytes.IndexByte(data[end:], '\n') + 1
+		if n == 0 {
+			n = len(data) - end
+		}
+		line := bytes.TrimSpace(data[end : end+n])
+		if len(line) > 0 && line[0] != '#' {
+			break
+		}
+		end += n
+	}
+	if end > 0 && data[end-1] != '\n' {
+		return append(data[:end:end], '\n')
+	}
+	return data[:end]
+}
+
+// PutConfig takes the content of an EclConfig struct and Marshals it to file,
+// the file is replaced atomically and keeps its permissions if it exists.
+// The comment block at the beginning of an existing file is preserved, the
+// comments between or after the settings are lost
 func PutConfig(ecl EclConfig, confPath string) (err error) {
 	data, err := toml.Marshal(ecl)
 	if err != nil {
 		return
 	}
 
-	return ioutil.WriteFile(confPath, data, 0600)
+	mode := os.FileMode(0600)
+	if fi, err := os.Stat(confPath); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	if old, err := ioutil.ReadFile(confPath); err == nil {
+		data = append(headerComments(old), data...)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(confPath), "."+filepath.Base(confPath)+"-")
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return
+	}
+	if err = tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return
+	}
+	if err = tmp.Close(); err != nil {
+		return
+	}
+
+	return os.Rename(tmp.Name(), confPath)
+}
+
+// AddGroup adds an execution group to the configuration, the configuration
+// is left unchanged if the resulting configuration is not valid
+func (ecl *EclConfig) AddGroup(tagname, mode, dirpath string, keyfps, digests []string) error {
+	if tagname == "" {
+		return fmt.Errorf("an execgroup requires a tagname")
+	}
+	for _, v := range ecl.ExecGroups {
+		if v.TagName == tagname {
+			return fmt.Errorf("execgroup %s already exists", tagname)
+		}
+	}
+
+	ecl.ExecGroups = append(ecl.ExecGroups, execgroup{
+		TagName:  tagname,
+		ListMode: mode,
+		DirPath:  dirpath,
+		KeyFPs:   keyfps,
+		Sha256:   digests,
+	})
+	if err := ecl.ValidateConfig(); err != nil {
+		ecl.ExecGroups = ecl.ExecGroups[:len(ecl.ExecGroups)-1]
+		return err
+	}
+
+	return nil
+}
+
+// RemoveGroup removes the execution group tagname from the configuration
+func (ecl *EclConfig) RemoveGroup(tagname string) error {
+	for i, v := range ecl.ExecGroups {
+		if v.TagName == tagname {
+			ecl.ExecGroups = append(ecl.ExecGroups[:i], ecl.ExecGroups[i+1:]...)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("no execgroup %s found", tagname)
 }
 
 // ValidateConfig makes sure paths from configs are fully resolved and that
@@ -69,7 +257,14 @@ func PutConfig(ecl EclConfig, confPath string) (err error) {
 func (ecl *EclConfig) ValidateConfig() error {
 	m := map[string]bool{}
 
-	for _, v := range ecl.ExecGroups {
+	if ecl.Default != "" && ecl.Default != DefaultDeny && ecl.Default != DefaultDenyUnsigned {
+		return fmt.Errorf("the default field can only be either: %s, %s", DefaultDeny, DefaultDenyUnsigned)
+	}
+	if ecl.AuditLog != "" && !filepath.IsAbs(ecl.AuditLog) {
+		return fmt.Errorf("the auditlog field must be an absolute path")
+	}
+
+	for i, v := range ecl.ExecGroups {
 		if m[v.DirPath] {
 			return fmt.Errorf("a specific dirpath can only appear in one execgroup: %s", v.DirPath)
 		}
@@ -77,20 +272,26 @@ func (ecl *EclConfig) ValidateConfig() error {
 
 		// if we allow containers everywhere, don't test dirpath constraint
 		if v.DirPath != "" {
-			path, err := filepath.EvalSymlinks(v.DirPath)
-			if err != nil {
-				return err
-			}
-			abs, err := filepath.Abs(path)
-			if err != nil {
-				return err
+			if err := validateDirPath(v.DirPath); err != nil {
+				return fmt.Errorf("execgroup %s: %s", v.TagName, err)
 			}
-			if v.DirPath != abs {
-				return fmt.Errorf("all execgroup dirpath`s should be fully cleaned with symlinks resolved")
+			for _, o := range ecl.ExecGroups[:i] {
+				if o.DirPath != "" && v.overlap(&o) {
+					return fmt.Errorf("execgroups %s and %s are ambiguous: dirpath %s overlaps with %s", o.TagName, v.TagName, o.DirPath, v.DirPath)
+				}
 			}
 		}
-		if v.ListMode != "whitelist" && v.ListMode != "whitestrict" && v.ListMode != "blacklist" {
-			return fmt.Errorf("the mode field can only be either: whitelist, whitestrict, blacklist")
+		switch v.ListMode {
+		case "whitelist", "whitestrict", "blacklist":
+			if len(v.Sha256) > 0 {
+				return fmt.Errorf("execgroup %s is ambiguous: sha256 digests are only allowed with the sha256 mode", v.TagName)
+			}
+		case "sha256":
+			if len(v.KeyFPs) > 0 {
+				return fmt.Errorf("execgroup %s is ambiguous: key fingerprints are not allowed with the sha256 mode", v.TagName)
+			}
+		default:
+			return fmt.Errorf("the mode field can only be either: whitelist, whitestrict, blacklist, sha256")
 		}
 		for _, k := range v.KeyFPs {
 			decoded, err := hex.DecodeString(k)
@@ -98,113 +299,220 @@ func (ecl *EclConfig) ValidateConfig() error {
 				return fmt.Errorf("expecting a 40 chars hex fingerprint string")
 			}
 		}
+		for _, d := range v.Sha256 {
+			decoded, err := hex.DecodeString(normalizeDigest(d))
+			if err != nil || len(decoded) != sha256.Size {
+				return fmt.Errorf("expecting a 64 chars hex sha256 digest string")
+			}
+		}
 	}
 
 	return nil
 }
 
-// checkWhiteList evaluates authorization by requiring at least 1 entity
-func checkWhiteList(fp *os.File, egroup *execgroup) (ok bool, err error) {
-	// get all signing entities fingerprints on the primary partition
-	keyfps, err := signing.GetSignEntitiesFp(fp)
+// validateDirPath checks that a dirpath is fully resolved, symlinks are
+// only resolved for paths without glob pattern
+func validateDirPath(dirPath string) error {
+	path := strings.TrimSuffix(dirPath, recursiveSuffix)
+	if path == "" {
+		path = "/"
+	}
+
+	if strings.ContainsAny(path, "*?[") {
+		if _, err := filepath.Match(path, ""); err != nil {
+			return fmt.Errorf("invalid dirpath pattern %s: %s", dirPath, err)
+		}
+		if !filepath.IsAbs(path) || filepath.Clean(path) != path {
+			return fmt.Errorf("all execgroup dirpath`s should be absolute and fully cleaned")
+		}
+		return nil
+	}
+
+	resolved, err := filepath.EvalSymlinks(path)
 	if err != nil {
-		return
+		return err
+	}
+	abs, err := filepath.Abs(resolved)
+	if err != nil {
+		return err
 	}
+	if path != abs {
+		return fmt.Errorf("all execgroup dirpath`s should be fully cleaned with symlinks resolved")
+	}
+	return nil
+}
+
+// checkWhiteList evaluates authorization by requiring at least 1 entity
+func checkWhiteList(d *Decision, egroup *execgroup, keyfps []string) error {
 	// was the primary partition signed by an authorized entity?
 	for _, v := range egroup.KeyFPs {
 		for _, u := range keyfps {
 			if v == u {
-				ok = true
+				return nil
 			}
 		}
 	}
-	if !ok {
-		return false, fmt.Errorf("%s is not signed by required entities", fp.Name())
-	}
 
-	return true, nil
+	return fmt.Errorf("%s is not signed by any of the entities required by execgroup %s: %s", d.Image, egroup.TagName, strings.Join(egroup.KeyFPs, ", "))
 }
 
 // checkWhiteStrict evaluates authorization by requiring all entities
-func checkWhiteStrict(fp *os.File, egroup *execgroup) (ok bool, err error) {
-	// get all signing entities fingerprints on the primary partition
-	keyfps, err := signing.GetSignEntitiesFp(fp)
-	if err != nil {
-		return
-	}
-
+func checkWhiteStrict(d *Decision, egroup *execgroup, keyfps []string) error {
 	// was the primary partition signed by all authorized entity?
-	m := map[string]bool{}
+	var missing []string
 	for _, v := range egroup.KeyFPs {
-		m[v] = false
+		found := false
 		for _, u := range keyfps {
 			if v == u {
-				m[v] = true
+				found = true
 			}
 		}
-	}
-	for _, v := range m {
-		if !v {
-			return false, fmt.Errorf("%s is not signed by required entities", fp.Name())
+		if !found {
+			missing = append(missing, v)
 		}
 	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%s is missing signatures required by execgroup %s: %s", d.Image, egroup.TagName, strings.Join(missing, ", "))
+	}
 
-	return true, nil
+	return nil
 }
 
 // checkBlackList evaluates authorization by requiring all entities to be absent
-func checkBlackList(fp *os.File, egroup *execgroup) (ok bool, err error) {
-	// get all signing entities fingerprints on the primary partition
-	keyfps, err := signing.GetSignEntitiesFp(fp)
-	if err != nil {
-		return
-	}
+func checkBlackList(d *Decision, egroup *execgroup, keyfps []string) error {
 	// was the primary partition signed by an authorized entity?
 	for _, v := range egroup.KeyFPs {
 		for _, u := range keyfps {
 			if v == u {
-				return false, fmt.Errorf("%s is signed by a forbidden entity", fp.Name())
+				return fmt.Errorf("%s is signed by %s, forbidden by execgroup %s", d.Image, v, egroup.TagName)
 			}
 		}
 	}
 
-	return true, nil
+	return nil
 }
 
-func shouldRun(ecl *EclConfig, fp *os.File) (ok bool, err error) {
+// checkSha256 evaluates authorization by requiring the image digest to be
+// one of the allowed digests
+func checkSha256(d *Decision, egroup *execgroup) error {
+	for _, v := range egroup.Sha256 {
+		if normalizeDigest(v) == d.Digest {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%s sha256 digest %s is not allowed by execgroup %s", d.Image, d.Digest, egroup.TagName)
+}
+
+// imageDigest returns the hex sha256 digest of an opened container
+func imageDigest(fp *os.File) (string, error) {
+	fi, err := fp.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	// read from a section to not change the file offset
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(fp, 0, fi.Size())); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// decide evaluates the execgroup rules for an opened container and returns
+// the decision, the Reason field tells why a container is denied. The image
+// digest is computed if withDigest is true or if it is required. The
+// signatures checked are those of the system partition selected by arch,
+// or by the host architecture if arch is empty.
+func (ecl *EclConfig) decide(fp *os.File, arch string, withDigest bool) *Decision {
 	var egroup *execgroup
 
+	d := newDecision(fp.Name())
+
 	// look what execgroup a container is part of
-	for _, v := range ecl.ExecGroups {
-		if filepath.Dir(fp.Name()) == v.DirPath {
-			egroup = &v
+	dir := filepath.Dir(fp.Name())
+	for i, v := range ecl.ExecGroups {
+		if v.DirPath != "" && ecl.ExecGroups[i].matchDir(dir) {
+			egroup = &ecl.ExecGroups[i]
 			break
 		}
 	}
 	// go back at it and this time look for an empty dirpath execgroup to fallback into
 	if egroup == nil {
-		for _, v := range ecl.ExecGroups {
+		for i, v := range ecl.ExecGroups {
 			if v.DirPath == "" {
-				egroup = &v
+				egroup = &ecl.ExecGroups[i]
 				break
 			}
 		}
 	}
 
+	// get all signing entities fingerprints on the system partition
+	// which would be run, multi-architecture images carry one system
+	// partition per architecture
+	if arch == "" {
+		arch = runtime.GOARCH
+	}
+	keyfps, sigErr := signing.GetSignEntitiesArchFp(fp, arch)
+	d.setSigners(keyfps)
+
+	if withDigest || (egroup != nil && egroup.ListMode == "sha256") {
+		digest, err := imageDigest(fp)
+		if err != nil {
+			return d.deny(fmt.Errorf("while computing %s digest: %s", fp.Name(), err))
+		}
+		d.Digest = digest
+	}
+
 	if egroup == nil {
-		return false, fmt.Errorf("%s not part of any execgroup", fp.Name())
+		if ecl.Default == DefaultDenyUnsigned {
+			if sigErr != nil || len(keyfps) == 0 {
+				return d.deny(fmt.Errorf("%s is not part of any execgroup and is not signed", fp.Name()))
+			}
+			return d.allow()
+		}
+		return d.deny(fmt.Errorf("%s not part of any execgroup", fp.Name()))
 	}
 
+	d.Group = egroup.TagName
+	d.Mode = egroup.ListMode
+
+	var err error
 	switch egroup.ListMode {
-	case "whitelist":
-		return checkWhiteList(fp, egroup)
-	case "whitestrict":
-		return checkWhiteStrict(fp, egroup)
-	case "blacklist":
-		return checkBlackList(fp, egroup)
+	case "whitelist", "whitestrict", "blacklist":
+		if sigErr != nil {
+			return d.deny(fmt.Errorf("%s: execgroup %s requires signatures: %s", fp.Name(), egroup.TagName, sigErr))
+		}
+		switch egroup.ListMode {
+		case "whitelist":
+			err = checkWhiteList(d, egroup, keyfps)
+		case "whitestrict":
+			err = checkWhiteStrict(d, egroup, keyfps)
+		case "blacklist":
+			err = checkBlackList(d, egroup, keyfps)
+		}
+	case "sha256":
+		err = checkSha256(d, egroup)
+	default:
+		err = fmt.Errorf("ecl config file invalid")
+	}
+	if err != nil {
+		return d.deny(err)
 	}
 
-	return false, fmt.Errorf("ecl config file invalid")
+	return d.allow()
+}
+
+func shouldRun(ecl *EclConfig, fp *os.File) (ok bool, err error) {
+	d := ecl.decide(fp, "", false)
+	if err := ecl.Audit(d, fp); err != nil {
+		sylog.Warningf("%s", err)
+	}
+
+	if !d.Allowed {
+		return false, errors.New(d.Reason)
+	}
+	return true, nil
 }
 
 // ShouldRun determines if a container should run according to its execgroup rules
@@ -218,10 +526,45 @@ func (ecl *EclConfig) ShouldRun(cpath string) (ok bool, err error) {
 	if err != nil {
 		return false, err
 	}
+	defer fp.Close()
 
 	return shouldRun(ecl, fp)
 }
 
+// Test returns the decision of the execgroup rules for a container, even if
+// the ECL is not activated, without writing it to the audit log
+func (ecl *EclConfig) Test(cpath string) (*Decision, error) {
+	// execgroups match the directory of the resolved container path
+	// like at runtime
+	resolved, err := image.ResolvePath(cpath)
+	if err != nil {
+		return nil, err
+	}
+	fp, err := os.Open(resolved)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+
+	return ecl.TestFp(fp), nil
+}
+
+// TestFp returns the decision of the execgroup rules for an already opened
+// container, even if the ECL is not activated, without writing it to the
+// audit log
+func (ecl *EclConfig) TestFp(fp *os.File) *Decision {
+	return ecl.decide(fp, "", true)
+}
+
+// DecideFp returns the decision of the execgroup rules for an already opened
+// container without writing it to the audit log, the runtime writes it with
+// Audit from its privileged part. The signatures of the system partition
+// selected by arch, like the --arch option, are checked. The image digest
+// is only computed for the sha256 mode
+func (ecl *EclConfig) DecideFp(fp *os.File, arch string) *Decision {
+	return ecl.decide(fp, arch, false)
+}
+
 // ShouldRunFp determines if an already opened container should run according to its execgroup rules
 func (ecl *EclConfig) ShouldRunFp(fp *os.File) (ok bool, err error) {
 	// look if ECL rules are activated
@@ -6,11 +6,17 @@
 package syecl
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/sylabs/sif/pkg/sif"
 )
 
 const (
@@ -39,17 +45,17 @@ var (
 var testEclConfig = EclConfig{
 	Activated: true,
 	ExecGroups: []execgroup{
-		{"group1", "whitelist", "", []string{KeyFP1, KeyFP2}},
-		{"group2", "whitestrict", "", []string{KeyFP1, KeyFP2}},
-		{"group3", "blacklist", "", []string{KeyFP1}},
+		{"group1", "whitelist", "", []string{KeyFP1, KeyFP2}, nil},
+		{"group2", "whitestrict", "", []string{KeyFP1, KeyFP2}, nil},
+		{"group3", "blacklist", "", []string{KeyFP1}, nil},
 	},
 }
 
 var testEclConfig2 = EclConfig{
 	Activated: true,
 	ExecGroups: []execgroup{
-		{"pathdup", "whitelist", "/tmp", nil},
-		{"pathdup", "whitelist", "/tmp", nil},
+		{"pathdup", "whitelist", "/tmp", nil, nil},
+		{"pathdup", "whitelist", "/tmp", nil, nil},
 	},
 }
 
@@ -156,6 +162,409 @@ func TestShouldRun(t *testing.T) {
 	}
 }
 
+func TestValidateConfigGroups(t *testing.T) {
+	digest := strings.Repeat("ab", 32)
+
+	tests := []struct {
+		name    string
+		ecl     EclConfig
+		wantErr bool
+	}{
+		{
+			name: "glob and recursive dirpaths",
+			ecl: EclConfig{ExecGroups: []execgroup{
+				{"glob", "whitelist", testEclDirPath1 + "/*", []string{KeyFP1}, nil},
+				{"recursive", "whitelist", testEclDirPath2 + "/**", []string{KeyFP1}, nil},
+			}},
+		},
+		{
+			name: "sha256 mode",
+			ecl: EclConfig{Default: DefaultDenyUnsigned, ExecGroups: []execgroup{
+				{"pinned", "sha256", testEclDirPath1, nil, []string{digest, "sha256:" + strings.ToUpper(digest)}},
+			}},
+		},
+		{
+			name: "recursive dirpath overlapping dirpath",
+			ecl: EclConfig{ExecGroups: []execgroup{
+				{"recursive", "whitelist", filepath.Dir(testEclDirPath1) + "/**", []string{KeyFP1}, nil},
+				{"group", "whitelist", testEclDirPath1, []string{KeyFP1}, nil},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "glob overlapping dirpath",
+			ecl: EclConfig{ExecGroups: []execgroup{
+				{"group", "whitelist", testEclDirPath1, []string{KeyFP1}, nil},
+				{"glob", "whitelist", filepath.Dir(testEclDirPath1) + "/ecldir*", []string{KeyFP1}, nil},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "glob overlapping glob",
+			ecl: EclConfig{ExecGroups: []execgroup{
+				{"prefix", "whitelist", testEclDirPath1 + "/a*", []string{KeyFP1}, nil},
+				{"suffix", "whitelist", testEclDirPath1 + "/*b", []string{KeyFP1}, nil},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "relative glob",
+			ecl: EclConfig{ExecGroups: []execgroup{
+				{"glob", "whitelist", "containers/*", []string{KeyFP1}, nil},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "sha256 mode with fingerprints",
+			ecl: EclConfig{ExecGroups: []execgroup{
+				{"pinned", "sha256", testEclDirPath1, []string{KeyFP1}, []string{digest}},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "digests without sha256 mode",
+			ecl: EclConfig{ExecGroups: []execgroup{
+				{"group", "whitelist", testEclDirPath1, []string{KeyFP1}, []string{digest}},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "invalid digest",
+			ecl: EclConfig{ExecGroups: []execgroup{
+				{"pinned", "sha256", testEclDirPath1, nil, []string{"abcd"}},
+			}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid default",
+			ecl:     EclConfig{Default: "allow"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		err := tt.ecl.ValidateConfig()
+		if tt.wantErr && err == nil {
+			t.Errorf("%s: unexpected success", tt.name)
+		} else if !tt.wantErr && err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.name, err)
+		}
+	}
+}
+
+func TestExecgroupOverlap(t *testing.T) {
+	tests := []struct {
+		name    string
+		p, q    string
+		overlap bool
+	}{
+		{"same dirpath", "/data", "/data", true},
+		{"distinct dirpaths", "/data/a", "/data/b", false},
+		{"recursive parent", "/data/**", "/data/a", true},
+		{"recursive sibling", "/data/a/**", "/data/b", false},
+		{"glob matching dirpath", "/data/*", "/data/a", true},
+		{"glob not matching dirpath", "/data/a*", "/data/b", false},
+		{"recursive glob matching parent", "/data/*/**", "/data/a/b", true},
+		{"glob under recursive dirpath", "/data/**", "/data/*/a", true},
+		{"glob above recursive dirpath", "/data/*/a", "/data/b/**", true},
+		{"glob beside recursive dirpath", "/other/*", "/data/**", false},
+		{"prefix and suffix globs", "/data/a*", "/data/*b", true},
+		{"same prefix globs", "/data/a*", "/data/a?", true},
+		{"nested prefix globs", "/data/*", "/data/a/*", true},
+		{"character class globs", "/data/[ab]", "/data/[bc]", true},
+		{"distinct prefix globs", "/data/a/*", "/data/b/*", false},
+		{"recursive globs", "/data/a*/**", "/other/*/**", false},
+	}
+
+	for _, tt := range tests {
+		e := &execgroup{DirPath: tt.p}
+		o := &execgroup{DirPath: tt.q}
+		if got := e.overlap(o); got != tt.overlap {
+			t.Errorf("%s: %s and %s overlap returned %v", tt.name, tt.p, tt.q, got)
+		}
+		if got := o.overlap(e); got != tt.overlap {
+			t.Errorf("%s: %s and %s overlap returned %v", tt.name, tt.q, tt.p, got)
+		}
+	}
+}
+
+func TestShouldRunGroups(t *testing.T) {
+	b, err := ioutil.ReadFile(testContainer1)
+	if err != nil {
+		t.Fatalf("failed to read %s: %s", testContainer1, err)
+	}
+	digest := sha256.Sum256(b)
+
+	subdir := filepath.Join(testEclDirPath1, "sub", "dir")
+	if err := os.MkdirAll(subdir, 0755); err != nil {
+		t.Fatalf("failed to create %s: %s", subdir, err)
+	}
+	defer os.RemoveAll(filepath.Join(testEclDirPath1, "sub"))
+
+	nested := filepath.Join(subdir, filepath.Base(srcContainer1))
+	if err := copyFile(nested, srcContainer1); err != nil {
+		t.Fatalf("failed to copy container: %s", err)
+	}
+	unsigned := filepath.Join(testEclDirPath1, "unsigned.sif")
+	if err := ioutil.WriteFile(unsigned, []byte("not a SIF image"), 0644); err != nil {
+		t.Fatalf("failed to write %s: %s", unsigned, err)
+	}
+	defer os.Remove(unsigned)
+
+	tests := []struct {
+		name  string
+		ecl   EclConfig
+		image string
+		run   bool
+	}{
+		{
+			name: "recursive dirpath",
+			ecl: EclConfig{Activated: true, ExecGroups: []execgroup{
+				{"recursive", "whitelist", testEclDirPath1 + "/**", []string{KeyFP1}, nil},
+			}},
+			image: nested,
+			run:   true,
+		},
+		{
+			name: "recursive dirpath blacklist",
+			ecl: EclConfig{Activated: true, ExecGroups: []execgroup{
+				{"recursive", "blacklist", testEclDirPath1 + "/**", []string{KeyFP1}, nil},
+			}},
+			image: nested,
+		},
+		{
+			name: "glob dirpath",
+			ecl: EclConfig{Activated: true, ExecGroups: []execgroup{
+				{"glob", "whitelist", testEclDirPath1 + "/*/dir", []string{KeyFP1}, nil},
+			}},
+			image: nested,
+			run:   true,
+		},
+		{
+			name: "glob dirpath not matching",
+			ecl: EclConfig{Activated: true, ExecGroups: []execgroup{
+				{"glob", "whitelist", testEclDirPath1 + "/*", []string{KeyFP1}, nil},
+			}},
+			image: nested,
+		},
+		{
+			name: "sha256 allowed",
+			ecl: EclConfig{Activated: true, ExecGroups: []execgroup{
+				{"pinned", "sha256", testEclDirPath1, nil, []string{"sha256:" + hex.EncodeToString(digest[:])}},
+			}},
+			image: testContainer1,
+			run:   true,
+		},
+		{
+			name: "sha256 not allowed",
+			ecl: EclConfig{Activated: true, ExecGroups: []execgroup{
+				{"pinned", "sha256", testEclDirPath1, nil, []string{strings.Repeat("0", 64)}},
+			}},
+			image: testContainer1,
+		},
+		{
+			name:  "deny unsigned outside groups signed",
+			ecl:   EclConfig{Activated: true, Default: DefaultDenyUnsigned},
+			image: testContainer1,
+			run:   true,
+		},
+		{
+			name:  "deny unsigned outside groups unsigned",
+			ecl:   EclConfig{Activated: true, Default: DefaultDenyUnsigned},
+			image: unsigned,
+		},
+		{
+			name:  "deny outside groups",
+			ecl:   EclConfig{Activated: true, Default: DefaultDeny},
+			image: testContainer1,
+		},
+	}
+
+	for _, tt := range tests {
+		run, err := tt.ecl.ShouldRun(tt.image)
+		if tt.run && (err != nil || !run) {
+			t.Errorf("%s: %s should be allowed to run: %v", tt.name, tt.image, err)
+		} else if !tt.run && (err == nil || run) {
+			t.Errorf("%s: %s should NOT be allowed to run", tt.name, tt.image)
+		}
+	}
+}
+
+func TestGroups(t *testing.T) {
+	confPath := filepath.Join(testEclDirPath3, "groups.toml")
+	defer os.Remove(confPath)
+
+	ecl := EclConfig{Activated: true}
+	if err := ecl.AddGroup("group1", "whitelist", testEclDirPath1, []string{KeyFP1}, nil); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := ecl.AddGroup("group1", "whitelist", testEclDirPath2, []string{KeyFP1}, nil); err == nil {
+		t.Errorf("duplicated tagname should be rejected")
+	}
+	if err := ecl.AddGroup("group2", "whitelist", testEclDirPath1+"/**", []string{KeyFP1}, nil); err == nil {
+		t.Errorf("overlapping dirpath should be rejected")
+	}
+	if len(ecl.ExecGroups) != 1 {
+		t.Fatalf("rejected groups should not be added: %+v", ecl.ExecGroups)
+	}
+
+	header := "# ECL config file\n#\n#activated = true\n\n"
+	if err := ioutil.WriteFile(confPath, []byte(header+"activated = false\n# trailing comment\n"), 0644); err != nil {
+		t.Fatalf("failed to create %s: %s", confPath, err)
+	}
+	if err := PutConfig(ecl, confPath); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	data, err := ioutil.ReadFile(confPath)
+	if err != nil {
+		t.Fatalf("failed to read %s: %s", confPath, err)
+	}
+	if !strings.HasPrefix(string(data), header) {
+		t.Errorf("header comments not preserved:\n%s", data)
+	}
+	fi, err := os.Stat(confPath)
+	if err != nil {
+		t.Fatalf("failed to stat %s: %s", confPath, err)
+	}
+	if fi.Mode().Perm() != 0644 {
+		t.Errorf("got mode %o, expected 0644", fi.Mode().Perm())
+	}
+
+	loaded, err := LoadConfig(confPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	d, err := loaded.Test(testContainer1)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !d.Allowed || d.Group != "group1" || len(d.Digest) != 64 {
+		t.Errorf("unexpected decision %+v", d)
+	}
+
+	if err := loaded.RemoveGroup("group2"); err == nil {
+		t.Errorf("removing an unknown group should fail")
+	}
+	if err := loaded.RemoveGroup("group1"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	d, err = loaded.Test(testContainer1)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if d.Allowed || d.Group != "" {
+		t.Errorf("unexpected decision %+v", d)
+	}
+}
+
+func TestTestPath(t *testing.T) {
+	ecl := EclConfig{Activated: true}
+	if err := ecl.AddGroup("group1", "whitelist", testEclDirPath1, []string{KeyFP1}, nil); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// a symlink outside of the execgroup directory to a container inside
+	link := filepath.Join(testEclDirPath3, "link.sif")
+	if err := os.Symlink(testContainer1, link); err != nil {
+		t.Fatalf("failed to create symlink %s: %s", link, err)
+	}
+	defer os.Remove(link)
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("failed to get current directory: %s", err)
+	}
+	defer os.Chdir(cwd)
+
+	tests := []struct {
+		name string
+		dir  string
+		path string
+	}{
+		{"relative path", testEclDirPath1, filepath.Base(testContainer1)},
+		{"absolute symlink", cwd, link},
+		{"relative symlink", testEclDirPath3, filepath.Base(link)},
+	}
+	for _, tt := range tests {
+		if err := os.Chdir(tt.dir); err != nil {
+			t.Fatalf("failed to change directory to %s: %s", tt.dir, err)
+		}
+		d, err := ecl.Test(tt.path)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.name, err)
+		} else if !d.Allowed || d.Group != "group1" {
+			t.Errorf("%s: unexpected decision %+v", tt.name, d)
+		}
+	}
+}
+
+func TestDecideArch(t *testing.T) {
+	ecl := EclConfig{Activated: true}
+	if err := ecl.AddGroup("group1", "whitelist", testEclDirPath1, []string{KeyFP1}, nil); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// a multi-architecture image carrying an unsigned system
+	// partition for another architecture than the signed one
+	multiarch := filepath.Join(testEclDirPath1, "multiarch.sif")
+	if err := copyFile(multiarch, srcContainer1); err != nil {
+		t.Fatalf("failed to copy container: %s", err)
+	}
+	defer os.Remove(multiarch)
+
+	fimg, err := sif.LoadContainer(multiarch, false)
+	if err != nil {
+		t.Fatalf("failed to load %s: %s", multiarch, err)
+	}
+	primary, _, err := fimg.GetPartPrimSys()
+	if err != nil {
+		fimg.UnloadContainer()
+		t.Fatalf("failed to get primary partition: %s", err)
+	}
+	sifArch, err := primary.GetArch()
+	if err != nil {
+		fimg.UnloadContainer()
+		t.Fatalf("failed to get primary partition architecture: %s", err)
+	}
+	arch := sif.GetGoArch(string(sifArch[:sif.HdrArchLen-1]))
+	otherArch := "arm64"
+	if arch == otherArch {
+		otherArch = "amd64"
+	}
+
+	data := []byte("unsigned system partition")
+	input := sif.DescriptorInput{
+		Datatype: sif.DataPartition,
+		Groupid:  primary.Groupid + 1,
+		Link:     sif.DescrUnusedLink,
+		Fname:    "rootfs",
+		Fp:       bytes.NewReader(data),
+		Size:     int64(len(data)),
+	}
+	if err := input.SetPartExtra(sif.FsSquash, sif.PartSystem, sif.GetSIFArch(otherArch)); err != nil {
+		fimg.UnloadContainer()
+		t.Fatalf("failed to set partition extra data: %s", err)
+	}
+	if err := fimg.AddObject(input); err != nil {
+		fimg.UnloadContainer()
+		t.Fatalf("failed to add system partition: %s", err)
+	}
+	fimg.UnloadContainer()
+
+	fp, err := os.Open(multiarch)
+	if err != nil {
+		t.Fatalf("failed to open %s: %s", multiarch, err)
+	}
+	defer fp.Close()
+
+	if d := ecl.DecideFp(fp, arch); !d.Allowed {
+		t.Errorf("%s partition should be allowed to run: %s", arch, d.Reason)
+	}
+	if d := ecl.DecideFp(fp, otherArch); d.Allowed {
+		t.Errorf("unsigned %s partition should NOT be allowed to run", otherArch)
+	}
+}
+
 func copyFile(dst, src string) error {
 	s, err := os.Open(src)
 	if err != nil {
@@ -83,3 +83,15 @@ func cryptsetup(cfgpath string) (string, error) {
 	// use exec.LookPath to verify it's an executable.
 	return exec.LookPath(path)
 }
+
+// Veritysetup looks for the "veritysetup" program returning the absolute
+// path to it. The veritysetup program is shipped with cryptsetup, it's
+// looked for in the directory of the cryptsetup program.
+func Veritysetup() (string, error) {
+	cryptsetup, err := Cryptsetup()
+	if err != nil {
+		return "", err
+	}
+
+	return exec.LookPath(filepath.Join(filepath.Dir(cryptsetup), "veritysetup"))
+}
@@ -142,9 +142,11 @@ var authorizedTags = map[AuthorizedTag]struct {
 }
 
 var authorizedImage = map[string]fsContext{
-	"encryptfs": {true},
-	"ext3":      {true},
-	"squashfs":  {true},
+	"encryptfs":   {true},
+	"encryptext3": {true},
+	"ext3":        {true},
+	"squashfs":    {true},
+	"verityfs":    {true},
 }
 
 var authorizedFS = map[string]fsContext{
@@ -159,7 +161,7 @@ var authorizedFS = map[string]fsContext{
 	"fuse":    {false},
 }
 
-var internalOptions = []string{"loop", "offset", "sizelimit", "key"}
+var internalOptions = []string{"loop", "offset", "sizelimit", "key", "verity"}
 
 // Point describes a mount point
 type Point struct {
@@ -307,6 +309,17 @@ func GetSizeLimit(options []string) (uint64, error) {
 	return 0, fmt.Errorf("sizelimit option not found")
 }
 
+// GetVerity returns the verity parameters for image options
+func GetVerity(options []string) ([]byte, error) {
+	for _, opt := range options {
+		if strings.HasPrefix(opt, "verity=") {
+			paramsB64 := strings.TrimPrefix(opt, "verity=")
+			return base64.StdEncoding.DecodeString(paramsB64)
+		}
+	}
+	return nil, fmt.Errorf("verity option not found")
+}
+
 // GetKey returns key value for image options
 func GetKey(options []string) ([]byte, error) {
 	for _, opt := range options {
@@ -593,6 +606,19 @@ func (p *Points) ImportFromSpec(mounts []specs.Mount) error {
 
 // AddImage adds an image mount point
 func (p *Points) AddImage(tag AuthorizedTag, source string, dest string, fstype string, flags uintptr, offset uint64, sizelimit uint64, key []byte) error {
+	keyB64 := base64.StdEncoding.EncodeToString(key)
+	return p.addImage(tag, source, dest, fstype, flags, offset, sizelimit, "key="+keyB64)
+}
+
+// AddVerityImage adds a squashfs image mount point checked by a verity
+// device, params holds the verity parameters and the location of the hash
+// tree in the image.
+func (p *Points) AddVerityImage(tag AuthorizedTag, source string, dest string, flags uintptr, offset uint64, sizelimit uint64, params []byte) error {
+	paramsB64 := base64.StdEncoding.EncodeToString(params)
+	return p.addImage(tag, source, dest, "verityfs", flags, offset, sizelimit, "verity="+paramsB64)
+}
+
+func (p *Points) addImage(tag AuthorizedTag, source string, dest string, fstype string, flags uintptr, offset uint64, sizelimit uint64, extra string) error {
 	options := ""
 	if source == "" {
 		return fmt.Errorf("an image mount point must contain a source")
@@ -609,8 +635,7 @@ func (p *Points) AddImage(tag AuthorizedTag, source string, dest string, fstype
 	if sizelimit == 0 {
 		return fmt.Errorf("invalid image size, zero length")
 	}
-	keyB64 := base64.StdEncoding.EncodeToString(key)
-	options = fmt.Sprintf("loop,offset=%d,sizelimit=%d,key=%s,errors=remount-ro", offset, sizelimit, keyB64)
+	options = fmt.Sprintf("loop,offset=%d,sizelimit=%d,%s,errors=remount-ro", offset, sizelimit, extra)
 	return p.add(tag, source, dest, fstype, flags, options)
 }
 
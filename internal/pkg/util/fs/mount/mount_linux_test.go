@@ -98,6 +98,21 @@ func TestImage(t *testing.T) {
 	if len(points.GetAllImages()) != 0 {
 		t.Errorf("failed to remove image from mount point")
 	}
+
+	params := []byte(`{"rootHash":"00"}`)
+	if err := points.AddVerityImage(RootfsTag, "/fake", "/", syscall.MS_RDONLY, 31, 10, params); err != nil {
+		t.Fatalf("should have passed with verity image")
+	}
+	images = points.GetAllImages()
+	if len(images) != 1 || images[0].Type != "verityfs" {
+		t.Fatalf("should get only one registered verity image")
+	}
+	if p, err := GetVerity(images[0].InternalOptions); err != nil || string(p) != string(params) {
+		t.Errorf("verity option wasn't found or is invalid")
+	}
+	if _, err := GetVerity([]string{}); err == nil {
+		t.Errorf("should have failed, verity not provided")
+	}
 }
 
 func TestOverlay(t *testing.T) {
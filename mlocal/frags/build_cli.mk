@@ -78,3 +78,14 @@ $(remote_config_INSTALL): $(remote_config)
 
 INSTALLFILES += $(remote_config_INSTALL)
 
+# verification policy example
+verify_policy_example := $(SOURCEDIR)/etc/verify-policy.yaml.example
+
+verify_policy_example_INSTALL := $(DESTDIR)$(SYSCONFDIR)/singularity/verify-policy.yaml.example
+$(verify_policy_example_INSTALL): $(verify_policy_example)
+	@echo " INSTALL" $@
+	$(V)install -d $(@D)
+	$(V)install -m 0644 $< $@
+
+INSTALLFILES += $(verify_policy_example_INSTALL)
+
@@ -20,6 +20,10 @@ import (
 
 const OCIConfigJSON = "oci-config"
 
+// OCIImageConfigJSON is the key of the complete OCI image config
+// in the bundle JSON objects.
+const OCIImageConfigJSON = "oci-image-config"
+
 // Bundle is the temporary environment used during the image building process.
 type Bundle struct {
 	JSONObjects map[string][]byte `json:"jsonObjects"`
@@ -46,6 +50,9 @@ type Options struct {
 	// encryption if applicable.
 	// A nil value indicates encryption should not occur.
 	EncryptionKeyInfo *crypt.KeyInfo
+	// Verity indicates if a dm-verity hash tree of the filesystem
+	// is stored in the image.
+	Verity bool `json:"verity"`
 	// NoTest indicates if build should skip running the test script.
 	NoTest bool `json:"noTest"`
 	// Force automatically deletes an existing container at build destination while performing build.
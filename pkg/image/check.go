@@ -0,0 +1,345 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package image
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/sylabs/sif/pkg/sif"
+)
+
+// Problem describes a structural issue found while checking an image file.
+type Problem struct {
+	// Descriptor is the ID of the SIF descriptor the problem relates
+	// to, 0 if the problem relates to the whole image.
+	Descriptor uint32 `json:"descriptor,omitempty"`
+	Message    string `json:"message"`
+	Hint       string `json:"hint,omitempty"`
+}
+
+// CheckReport holds the result of an image structural check.
+type CheckReport struct {
+	Path     string    `json:"path"`
+	Format   string    `json:"format"`
+	Size     int64     `json:"size"`
+	Problems []Problem `json:"problems"`
+}
+
+// OK returns true if no problem was found during the check.
+func (r *CheckReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+func (r *CheckReport) add(id uint32, hint, format string, a ...interface{}) {
+	r.Problems = append(r.Problems, Problem{
+		Descriptor: id,
+		Message:    fmt.Sprintf(format, a...),
+		Hint:       hint,
+	})
+}
+
+const (
+	hintTruncated = "the image file is probably truncated, download or copy it again"
+	hintCorrupted = "the image file is corrupted, rebuild it or download it again"
+	hintResign    = "remove the image signatures and sign the image again"
+)
+
+// Check performs a structural validation of the image file found at
+// path without mounting or executing anything. Problems found in the
+// image are reported in the returned CheckReport, an error is returned
+// only if the image can't be checked at all.
+func Check(path string) (*CheckReport, error) {
+	resolvedPath, err := ResolvePath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(resolvedPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not open image %s: %s", resolvedPath, err)
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("could not stat image %s: %s", resolvedPath, err)
+	}
+	if fi.IsDir() {
+		return nil, fmt.Errorf("%s is a sandbox directory, only image files can be checked", resolvedPath)
+	}
+
+	report := &CheckReport{
+		Path:     resolvedPath,
+		Size:     fi.Size(),
+		Problems: make([]Problem, 0),
+	}
+
+	b := make([]byte, bufferSize)
+	if _, err := f.ReadAt(b, 0); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("could not read image header: %s", err)
+	}
+
+	switch {
+	case bytes.Contains(b, []byte(sif.HdrMagic)):
+		report.Format = "sif"
+		checkSIF(f, report)
+	default:
+		if offset, err := CheckSquashfsHeader(b); err == nil {
+			report.Format = "squashfs"
+			checkFilesystemSize(0, "squashfs", squashfsSize(b, offset), report.Size-int64(offset), report)
+			return report, nil
+		}
+		if offset, err := CheckExt3Header(b); err == nil {
+			report.Format = "ext3"
+			checkFilesystemSize(0, "ext3", ext3Size(b, offset), report.Size-int64(offset), report)
+			return report, nil
+		}
+		return nil, ErrUnknownFormat
+	}
+
+	return report, nil
+}
+
+// checkSIF checks SIF global header, descriptors, partitions and signature
+// links. Data objects are read with ReadAt rather than through the SIF
+// mapping to not fault on truncated files.
+func checkSIF(f *os.File, report *CheckReport) {
+	var header sif.Header
+
+	if report.Size < sif.DataStartOffset {
+		report.add(0, hintTruncated, "file size (%d bytes) is smaller than the SIF metadata area (%d bytes)", report.Size, sif.DataStartOffset)
+		return
+	}
+
+	if err := binary.Read(io.NewSectionReader(f, 0, report.Size), binary.LittleEndian, &header); err != nil {
+		report.add(0, hintTruncated, "could not read SIF global header: %s", err)
+		return
+	}
+	if version := cstring(header.Version[:]); version > sif.HdrVersion {
+		report.add(0, "", "unsupported SIF version %s, this version of Singularity supports up to %s", version, sif.HdrVersion)
+		return
+	}
+	if header.Dtotal <= 0 || header.Descroff < sif.DescrStartOffset || header.Dataoff < header.Descroff {
+		report.add(0, hintCorrupted, "invalid descriptor table (offset: %d, entries: %d)", header.Descroff, header.Dtotal)
+		return
+	}
+	// the descriptors are read only if they fit in the descriptor
+	// table and in the file
+	descrSize := int64(binary.Size(sif.Descriptor{}))
+	if max := (header.Dataoff - header.Descroff) / descrSize; header.Dtotal > max {
+		report.add(0, hintCorrupted, "descriptor table of %d entries overlaps with the data section (%d entries max)", header.Dtotal, max)
+		return
+	}
+	if max := (report.Size - header.Descroff) / descrSize; header.Dtotal > max {
+		report.add(0, hintTruncated, "descriptor table of %d entries exceeds the file size (%d entries max)", header.Dtotal, max)
+		return
+	}
+	if end := header.Dataoff + header.Datalen; end > report.Size {
+		report.add(0, hintTruncated, "data section ends at offset %d but file size is %d bytes", end, report.Size)
+	}
+
+	descrs := make([]sif.Descriptor, header.Dtotal)
+	descrReader := io.NewSectionReader(f, header.Descroff, report.Size-header.Descroff)
+	if err := binary.Read(descrReader, binary.LittleEndian, descrs); err != nil {
+		report.add(0, hintTruncated, "could not read SIF descriptors: %s", err)
+		return
+	}
+
+	ids := make(map[uint32]*sif.Descriptor)
+	groups := make(map[uint32]bool)
+	free := int64(0)
+	primary := 0
+
+	for i := range descrs {
+		d := &descrs[i]
+		if !d.Used {
+			free++
+			continue
+		}
+		if _, ok := ids[d.ID]; ok {
+			report.add(d.ID, hintCorrupted, "descriptor ID %d is used more than once", d.ID)
+		}
+		ids[d.ID] = d
+		if d.Groupid != sif.DescrUnusedGroup {
+			groups[d.Groupid] = true
+		}
+	}
+
+	if free != header.Dfree {
+		report.add(0, hintCorrupted, "global header reports %d free descriptors but %d were found", header.Dfree, free)
+	}
+
+	for i := range descrs {
+		d := &descrs[i]
+		if !d.Used {
+			continue
+		}
+
+		if d.Fileoff < header.Dataoff || d.Filelen < 0 {
+			report.add(d.ID, hintCorrupted, "data object is located outside of the data section (offset: %d, size: %d)", d.Fileoff, d.Filelen)
+			continue
+		}
+		if end := d.Fileoff + d.Filelen; end > report.Size {
+			report.add(d.ID, hintTruncated, "%s data object ends at offset %d but file size is %d bytes", DatatypeName(d.Datatype), end, report.Size)
+			continue
+		}
+
+		switch d.Datatype {
+		case sif.DataPartition:
+			if ptype, err := d.GetPartType(); err == nil && ptype == sif.PartPrimSys {
+				primary++
+			}
+			checkSIFPartition(f, d, report)
+		case sif.DataSignature:
+			checkSIFSignature(d, ids, groups, report)
+		}
+	}
+
+	if primary == 0 {
+		report.add(0, "", "no primary system partition found")
+	} else if primary > 1 {
+		report.add(0, hintCorrupted, "%d primary system partitions found", primary)
+	}
+}
+
+// checkSIFPartition checks a partition descriptor and the filesystem
+// superblock of the partition it refers to.
+func checkSIFPartition(f *os.File, d *sif.Descriptor, report *CheckReport) {
+	fstype, err := d.GetFsType()
+	if err != nil {
+		report.add(d.ID, hintCorrupted, "could not read partition filesystem type: %s", err)
+		return
+	}
+
+	size := d.Filelen
+	if size > bufferSize {
+		size = bufferSize
+	}
+	b := make([]byte, bufferSize)
+	if _, err := f.ReadAt(b[:size], d.Fileoff); err != nil {
+		report.add(d.ID, hintTruncated, "could not read partition header: %s", err)
+		return
+	}
+
+	switch fstype {
+	case sif.FsSquash:
+		if offset, err := CheckSquashfsHeader(b); err != nil {
+			report.add(d.ID, hintCorrupted, "invalid squashfs superblock: %s", err)
+		} else {
+			checkFilesystemSize(d.ID, "squashfs", squashfsSize(b, offset), d.Filelen-int64(offset), report)
+		}
+	case sif.FsExt3:
+		if offset, err := CheckExt3Header(b); err != nil {
+			report.add(d.ID, hintCorrupted, "invalid ext3 superblock: %s", err)
+		} else {
+			checkFilesystemSize(d.ID, "ext3", ext3Size(b, offset), d.Filelen-int64(offset), report)
+		}
+	case sif.FsEncryptedSquashfs, sif.FsRaw, sif.FsImmuObj:
+		// no superblock to check
+	default:
+		report.add(d.ID, hintCorrupted, "unknown filesystem type %d", fstype)
+	}
+}
+
+// squashfsSize returns the size of the squashfs filesystem whose superblock
+// starts at offset in b, the bytes_used field of the superblock, or -1 if
+// it's not found in b.
+func squashfsSize(b []byte, offset uint64) int64 {
+	const bytesUsedOffset = 40
+
+	if offset+bytesUsedOffset+8 > uint64(len(b)) {
+		return -1
+	}
+	size := binary.LittleEndian.Uint64(b[offset+bytesUsedOffset:])
+	if size > math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return int64(size)
+}
+
+// ext3Size returns the size of the ext3 filesystem starting at offset in b,
+// its block count times its block size, or -1 if the superblock fields are
+// not found in b.
+func ext3Size(b []byte, offset uint64) int64 {
+	const (
+		superblockOffset = 1024
+		blocksCount      = superblockOffset + 4
+		logBlockSize     = superblockOffset + 24
+	)
+
+	if offset+logBlockSize+4 > uint64(len(b)) {
+		return -1
+	}
+	blocks := int64(binary.LittleEndian.Uint32(b[offset+blocksCount:]))
+	log := binary.LittleEndian.Uint32(b[offset+logBlockSize:])
+	if log > 6 {
+		// block sizes are 64 KiB max
+		return math.MaxInt64
+	}
+	return blocks * (1024 << log)
+}
+
+// checkFilesystemSize reports a filesystem of fsSize bytes which doesn't
+// fit in the size bytes of its image or partition.
+func checkFilesystemSize(id uint32, fstype string, fsSize, size int64, report *CheckReport) {
+	if fsSize > size {
+		report.add(id, hintTruncated, "%s filesystem size is %d bytes but only %d bytes are available", fstype, fsSize, size)
+	}
+}
+
+// checkSIFSignature checks that a signature descriptor links to an existing
+// descriptor or group and carries a signing entity.
+func checkSIFSignature(d *sif.Descriptor, ids map[uint32]*sif.Descriptor, groups map[uint32]bool, report *CheckReport) {
+	if d.Link&sif.DescrGroupMask == sif.DescrGroupMask {
+		if !groups[d.Link] {
+			report.add(d.ID, hintResign, "signature is linked to group %d which doesn't exist", d.Link&^sif.DescrGroupMask)
+		}
+	} else if linked, ok := ids[d.Link]; !ok {
+		report.add(d.ID, hintResign, "signature is linked to descriptor %d which doesn't exist", d.Link)
+	} else if linked.Datatype == sif.DataSignature {
+		report.add(d.ID, hintResign, "signature is linked to another signature (descriptor %d)", d.Link)
+	}
+
+	if entity, err := d.GetEntity(); err != nil || len(bytes.Trim(entity, "\x00")) == 0 {
+		report.add(d.ID, hintResign, "signature doesn't carry a signing entity fingerprint")
+	}
+}
+
+// DatatypeName returns the name of a SIF data object type as used in
+// reports and inspect output.
+func DatatypeName(dtype sif.Datatype) string {
+	switch dtype {
+	case sif.DataDeffile:
+		return "deffile"
+	case sif.DataEnvVar:
+		return "envvar"
+	case sif.DataLabels:
+		return "labels"
+	case sif.DataPartition:
+		return "partition"
+	case sif.DataSignature:
+		return "signature"
+	case sif.DataGenericJSON:
+		return "generic-json"
+	case sif.DataGeneric:
+		return "generic"
+	case sif.DataCryptoMessage:
+		return "crypto-message"
+	}
+	return "unknown"
+}
+
+func cstring(b []byte) string {
+	if n := bytes.IndexByte(b, 0); n >= 0 {
+		return string(b[:n])
+	}
+	return string(b)
+}
@@ -0,0 +1,182 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package image
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/sylabs/sif/pkg/sif"
+)
+
+func TestCheck(t *testing.T) {
+	fp, err := os.Open(testSquash)
+	if err != nil {
+		t.Fatalf("failed to open %s: %s", testSquash, err)
+	}
+	defer fp.Close()
+
+	primPart := sif.DescriptorInput{
+		Datatype: sif.DataPartition,
+		Groupid:  sif.DescrDefaultGroup,
+		Link:     sif.DescrUnusedLink,
+		Fname:    "primPart",
+		Fp:       fp,
+		Extra: *bytes.NewBuffer([]byte{
+			0x01, 0x00, 0x00, 0x00, // fstype
+			0x02, 0x00, 0x00, 0x00, // part type
+		}),
+	}
+	primPart.Extra.WriteString(sif.GetSIFArch(runtime.GOARCH))
+
+	badPart := sif.DescriptorInput{
+		Datatype: sif.DataPartition,
+		Groupid:  sif.DescrDefaultGroup,
+		Link:     sif.DescrUnusedLink,
+		Fname:    "badPart",
+		Data:     make([]byte, 4096),
+		Size:     4096,
+		Extra: *bytes.NewBuffer([]byte{
+			0x02, 0x00, 0x00, 0x00, // fstype
+			0x03, 0x00, 0x00, 0x00, // part type
+		}),
+	}
+	badPart.Extra.WriteString(sif.GetSIFArch(runtime.GOARCH))
+
+	orphanSig := sif.DescriptorInput{
+		Datatype: sif.DataSignature,
+		Groupid:  sif.DescrUnusedGroup,
+		Link:     42,
+		Fname:    "part-signature",
+		Data:     []byte("signature"),
+		Size:     9,
+	}
+	if err := orphanSig.SetSignExtra(sif.HashSHA384, "0123456789abcdef0123456789abcdef01234567"); err != nil {
+		t.Fatalf("failed to set signature extra data: %s", err)
+	}
+
+	tests := []struct {
+		name             string
+		path             string
+		expectedProblems int
+	}{
+		{
+			name:             "Valid",
+			path:             createSIF(t, []sif.DescriptorInput{primPart}, false),
+			expectedProblems: 0,
+		},
+		{
+			name:             "NoPrimaryPartition",
+			path:             createSIF(t, nil, false),
+			expectedProblems: 1,
+		},
+		{
+			name:             "Truncated",
+			path:             createSIF(t, []sif.DescriptorInput{primPart}, true),
+			expectedProblems: 2,
+		},
+		{
+			name:             "BadExt3Superblock",
+			path:             createSIF(t, []sif.DescriptorInput{primPart, badPart}, false),
+			expectedProblems: 1,
+		},
+		{
+			name:             "TooManyDescriptors",
+			path:             setSIFDescriptorCount(t, createSIF(t, []sif.DescriptorInput{primPart}, false), 1<<40),
+			expectedProblems: 1,
+		},
+		{
+			name:             "OrphanSignature",
+			path:             createSIF(t, []sif.DescriptorInput{primPart, orphanSig}, false),
+			expectedProblems: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer os.Remove(tt.path)
+
+			report, err := Check(tt.path)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if report.Format != "sif" {
+				t.Errorf("unexpected format %q", report.Format)
+			}
+			if len(report.Problems) != tt.expectedProblems {
+				t.Errorf("unexpected number of problems: %d instead of %d: %+v", len(report.Problems), tt.expectedProblems, report.Problems)
+			}
+		})
+	}
+
+	report, err := Check(testSquash)
+	if err != nil {
+		t.Fatalf("unexpected error while checking %s: %s", testSquash, err)
+	}
+	if report.Format != "squashfs" || !report.OK() {
+		t.Errorf("unexpected report for %s: %+v", testSquash, report)
+	}
+
+	truncated := truncatedCopy(t, testSquash, 128)
+	defer os.Remove(truncated)
+
+	report, err = Check(truncated)
+	if err != nil {
+		t.Fatalf("unexpected error while checking %s: %s", truncated, err)
+	}
+	if report.Format != "squashfs" || len(report.Problems) != 1 {
+		t.Errorf("unexpected report for truncated squashfs image: %+v", report)
+	}
+
+	if _, err := Check(os.TempDir()); err == nil {
+		t.Errorf("unexpected success while checking a directory")
+	}
+}
+
+// setSIFDescriptorCount overwrites the number of descriptors recorded in the
+// header of the SIF image at path.
+func setSIFDescriptorCount(t *testing.T, path string, count int64) string {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("failed to open %s: %s", path, err)
+	}
+	defer f.Close()
+
+	var header sif.Header
+	if err := binary.Read(f, binary.LittleEndian, &header); err != nil {
+		t.Fatalf("failed to read SIF header: %s", err)
+	}
+	header.Dtotal = count
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatalf("failed to seek: %s", err)
+	}
+	if err := binary.Write(f, binary.LittleEndian, &header); err != nil {
+		t.Fatalf("failed to write SIF header: %s", err)
+	}
+	return path
+}
+
+// truncatedCopy returns a copy of the first size bytes of the file at path.
+func truncatedCopy(t *testing.T, path string, size int) string {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read %s: %s", path, err)
+	}
+	f, err := ioutil.TempFile("", "truncated-")
+	if err != nil {
+		t.Fatalf("failed to create temporary file: %s", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(b[:size]); err != nil {
+		t.Fatalf("failed to write %s: %s", f.Name(), err)
+	}
+	return f.Name()
+}
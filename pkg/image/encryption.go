@@ -0,0 +1,91 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package image
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/sylabs/sif/pkg/sif"
+)
+
+// EncryptionInfoSection is the name of the generic JSON data object
+// describing an encrypted SIF partition.
+const EncryptionInfoSection = "encryption.json"
+
+// Filesystems of the encrypted partitions described by EncryptionInfo.
+const (
+	EncryptedSquashfs = "squashfs"
+	EncryptedExt3     = "ext3"
+)
+
+// ErrNoEncryptionInfo is returned when a SIF partition isn't described by
+// an encryption info object.
+var ErrNoEncryptionInfo = errors.New("no encryption info found")
+
+// EncryptionInfo describes an encrypted SIF partition. SIF only defines the
+// encrypted squashfs filesystem type and the RSA-OAEP key message, so the
+// partitions are described by a generic JSON data object linked to them
+// rather than by filesystem and message types of our own. Encrypted
+// partitions other than squashfs have the raw filesystem type.
+type EncryptionInfo struct {
+	// Filesystem is the filesystem of the decrypted partition.
+	Filesystem string `json:"filesystem"`
+	// Key is the format of the key wrapping the filesystem key in the
+	// cryptographic message linked to the partition, it is empty when
+	// the passphrase is the filesystem key.
+	Key string `json:"key,omitempty"`
+}
+
+// GetEncryptionInfo returns the encryption info of the partition part of
+// the SIF image fimg and its descriptor, ErrNoEncryptionInfo is returned if
+// the partition isn't described.
+func GetEncryptionInfo(fimg *sif.FileImage, part *sif.Descriptor) (*EncryptionInfo, *sif.Descriptor, error) {
+	descrs, _, err := fimg.GetLinkedDescrsByType(part.ID, sif.DataGenericJSON)
+	if err == sif.ErrNotFound {
+		return nil, nil, ErrNoEncryptionInfo
+	} else if err != nil {
+		return nil, nil, fmt.Errorf("while searching encryption info: %s", err)
+	}
+
+	for _, d := range descrs {
+		if d.GetName() != EncryptionInfoSection {
+			continue
+		}
+
+		data := d.GetData(fimg)
+		if data == nil {
+			return nil, nil, fmt.Errorf("could not read encryption info of partition %d", part.ID)
+		}
+
+		info := new(EncryptionInfo)
+		if err := json.Unmarshal(data, info); err != nil {
+			return nil, nil, fmt.Errorf("while decoding encryption info of partition %d: %s", part.ID, err)
+		}
+		return info, d, nil
+	}
+
+	return nil, nil, ErrNoEncryptionInfo
+}
+
+// EncryptionInfoInput returns the descriptor input of the encryption info
+// of the partition partID, grouped with it so that it is signed along.
+func EncryptionInfoInput(info EncryptionInfo, groupID, partID uint32) (sif.DescriptorInput, error) {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return sif.DescriptorInput{}, fmt.Errorf("while encoding encryption info: %s", err)
+	}
+
+	return sif.DescriptorInput{
+		Datatype: sif.DataGenericJSON,
+		Groupid:  groupID,
+		Link:     partID,
+		Data:     data,
+		Size:     int64(len(data)),
+		Fname:    EncryptionInfoSection,
+	}, nil
+}
@@ -0,0 +1,136 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package image
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/sylabs/singularity/pkg/image/squashfs"
+)
+
+// ErrUnsupportedFS is returned by NewRootFS when the root filesystem of
+// an image can't be read without mounting it.
+var ErrUnsupportedFS = errors.New("root filesystem can't be read without mounting the image")
+
+const maxSymlinkFollowed = 40
+
+// FS gives read access to the root filesystem of an image without
+// mounting it. Paths are relative to the image root filesystem and
+// symbolic links are resolved within the image.
+type FS interface {
+	ReadFile(path string) ([]byte, error)
+	ReadDir(path string) ([]os.FileInfo, error)
+	Stat(path string) (os.FileInfo, error)
+}
+
+// NewRootFS returns an FS reading the root filesystem of img, which
+// must be a sandbox, a squashfs image or a SIF image with a squashfs
+// root filesystem. ErrUnsupportedFS is returned for other images.
+func NewRootFS(img *Image) (FS, error) {
+	if err := checkImage(img); err != nil {
+		return nil, err
+	}
+
+	if img.Type == SANDBOX {
+		return &sandboxFS{root: img.Path}, nil
+	}
+
+	if !img.HasRootFs() {
+		return nil, fmt.Errorf("no root filesystem found in %s", img.Path)
+	}
+
+	part := img.Partitions[0]
+	if part.Type != SQUASHFS {
+		return nil, ErrUnsupportedFS
+	}
+
+	r, err := squashfs.NewReader(io.NewSectionReader(img.File, int64(part.Offset), int64(part.Size)))
+	if err == squashfs.ErrUnsupportedCompression {
+		return nil, ErrUnsupportedFS
+	} else if err != nil {
+		return nil, fmt.Errorf("while reading root filesystem of %s: %s", img.Path, err)
+	}
+	return r, nil
+}
+
+// sandboxFS reads the root filesystem of a sandbox image.
+type sandboxFS struct {
+	root string
+}
+
+// path returns the host path of path, symbolic links are resolved
+// relative to the sandbox root.
+func (s *sandboxFS) path(path string) (string, error) {
+	links := 0
+	resolved := "/"
+	components := strings.Split(filepath.Clean("/"+path), "/")[1:]
+
+	for len(components) > 0 {
+		name := components[0]
+		components = components[1:]
+
+		if name == "" || name == "." {
+			continue
+		}
+		next := filepath.Join(resolved, name)
+
+		fi, err := os.Lstat(filepath.Join(s.root, next))
+		if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			// missing files are reported by the caller
+			resolved = next
+			continue
+		}
+
+		links++
+		if links > maxSymlinkFollowed {
+			return "", &os.PathError{Op: "open", Path: path, Err: syscall.ELOOP}
+		}
+		target, err := os.Readlink(filepath.Join(s.root, next))
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(target) {
+			resolved = "/"
+		}
+		components = append(strings.Split(target, "/"), components...)
+	}
+
+	return filepath.Join(s.root, resolved), nil
+}
+
+// ReadFile implements FS.
+func (s *sandboxFS) ReadFile(path string) ([]byte, error) {
+	p, err := s.path(path)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(p)
+}
+
+// ReadDir implements FS.
+func (s *sandboxFS) ReadDir(path string) ([]os.FileInfo, error) {
+	p, err := s.path(path)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadDir(p)
+}
+
+// Stat implements FS.
+func (s *sandboxFS) Stat(path string) (os.FileInfo, error) {
+	p, err := s.path(path)
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(p)
+}
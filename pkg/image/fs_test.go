@@ -0,0 +1,80 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package image
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewRootFS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rootfs-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := os.MkdirAll(filepath.Join(dir, ".singularity.d", "env"), 0755); err != nil {
+		t.Fatalf("failed to create sandbox: %s", err)
+	}
+	env := filepath.Join(dir, ".singularity.d", "env", "90-environment.sh")
+	if err := ioutil.WriteFile(env, []byte("export A=B\n"), 0644); err != nil {
+		t.Fatalf("failed to write %s: %s", env, err)
+	}
+	// absolute link targets must be resolved in the sandbox
+	if err := os.Symlink("/.singularity.d/env/90-environment.sh", filepath.Join(dir, "environment")); err != nil {
+		t.Fatalf("failed to create symlink: %s", err)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		file    string
+		content string
+	}{
+		{
+			name:    "Sandbox",
+			path:    dir,
+			file:    "/environment",
+			content: "export A=B\n",
+		},
+		{
+			name:    "Squashfs",
+			path:    testSquash,
+			file:    "/examplefile",
+			content: "Example File Contents\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := Init(tt.path, false)
+			if err != nil {
+				t.Fatalf("failed to load image %s: %s", tt.path, err)
+			}
+			defer img.File.Close()
+
+			rootfs, err := NewRootFS(img)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			data, err := rootfs.ReadFile(tt.file)
+			if err != nil {
+				t.Fatalf("unexpected error while reading %s: %s", tt.file, err)
+			}
+			if string(data) != tt.content {
+				t.Errorf("unexpected content for %s: %q", tt.file, data)
+			}
+
+			if _, err := rootfs.Stat("/missing"); !os.IsNotExist(err) {
+				t.Errorf("unexpected error for a missing file: %v", err)
+			}
+		})
+	}
+}
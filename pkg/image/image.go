@@ -30,6 +30,8 @@ const (
 	SIF
 	// ENCRYPTSQUASHFS constant for encrypted squashfs format
 	ENCRYPTSQUASHFS
+	// ENCRYPTEXT3 constant for encrypted ext3 format
+	ENCRYPTEXT3
 )
 
 const (
@@ -93,6 +95,10 @@ type Image struct {
 	Writable   bool      `json:"writable"`
 	Partitions []Section `json:"partitions"`
 	Sections   []Section `json:"sections"`
+
+	// arch is the architecture requested for image formats
+	// carrying multiple architectures, host's one if empty.
+	arch string
 }
 
 // AuthorizedPath checks if image is in a path supplied in paths
@@ -218,6 +224,13 @@ func ResolvePath(path string) (string, error) {
 
 // Init initializes an image object based on given path.
 func Init(path string, writable bool) (*Image, error) {
+	return InitArch(path, writable, "")
+}
+
+// InitArch initializes an image object based on given path, for
+// image formats carrying multiple architectures the partition built
+// for arch is selected, if arch is empty the host architecture is used.
+func InitArch(path string, writable bool, arch string) (*Image, error) {
 	sylog.Debugf("Image format detection")
 
 	resolvedPath, err := ResolvePath(path)
@@ -228,6 +241,7 @@ func Init(path string, writable bool) (*Image, error) {
 	img := &Image{
 		Path: resolvedPath,
 		Name: filepath.Base(resolvedPath),
+		arch: arch,
 	}
 
 	for _, rf := range registeredFormats {
@@ -0,0 +1,52 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	// OCIConfigSection is the name of the SIF data object holding the
+	// execution parameters of the OCI image config an image was built from.
+	OCIConfigSection = "oci-config.json"
+	// OCIImageConfigSection is the name of the SIF data object holding the
+	// complete OCI image config an image was built from.
+	OCIImageConfigSection = "oci-image-config.json"
+)
+
+// GetOCIImageConfig returns the OCI image config stored in a SIF image.
+// Images built before the complete config was stored only hold its
+// execution parameters, they are returned alone for those. Nil is returned
+// if the image doesn't contain any OCI image config.
+func GetOCIImageConfig(img *Image) (*imgspecv1.Image, error) {
+	reader, err := NewSectionReader(img, OCIImageConfigSection, -1)
+	if err == nil {
+		config := new(imgspecv1.Image)
+		if err := json.NewDecoder(reader).Decode(config); err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %s", OCIImageConfigSection, err)
+		}
+		return config, nil
+	} else if err != ErrNoSection {
+		return nil, fmt.Errorf("failed to read %s section: %s", OCIImageConfigSection, err)
+	}
+
+	reader, err = NewSectionReader(img, OCIConfigSection, -1)
+	if err == ErrNoSection {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read %s section: %s", OCIConfigSection, err)
+	}
+
+	config := new(imgspecv1.Image)
+	if err := json.NewDecoder(reader).Decode(&config.Config); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %s", OCIConfigSection, err)
+	}
+	return config, nil
+}
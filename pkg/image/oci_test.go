@@ -0,0 +1,78 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package image
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestGetOCIImageConfig(t *testing.T) {
+	f, err := ioutil.TempFile("", "oci-config-")
+	if err != nil {
+		t.Fatalf("failed to create temporary file: %s", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	config := `{"Entrypoint":["/bin/sh"],"WorkingDir":"/data"}`
+	imageConfig := `{"architecture":"amd64","os":"linux","config":{"User":"nginx","Cmd":["nginx"]}}`
+	if _, err := f.WriteString(config + imageConfig + "{"); err != nil {
+		t.Fatalf("failed to write file: %s", err)
+	}
+
+	configSection := Section{Name: OCIConfigSection, Offset: 0, Size: uint64(len(config))}
+	imageSection := Section{Name: OCIImageConfigSection, Offset: uint64(len(config)), Size: uint64(len(imageConfig))}
+	badSection := Section{Name: OCIImageConfigSection, Offset: uint64(len(config) + len(imageConfig)), Size: 1}
+
+	tests := []struct {
+		name     string
+		sections []Section
+		expected *imgspecv1.Image
+		fail     bool
+	}{
+		{
+			name: "no config",
+		},
+		{
+			name:     "execution parameters only",
+			sections: []Section{configSection},
+			expected: &imgspecv1.Image{
+				Config: imgspecv1.ImageConfig{Entrypoint: []string{"/bin/sh"}, WorkingDir: "/data"},
+			},
+		},
+		{
+			name:     "complete config",
+			sections: []Section{configSection, imageSection},
+			expected: &imgspecv1.Image{
+				Architecture: "amd64",
+				OS:           "linux",
+				Config:       imgspecv1.ImageConfig{User: "nginx", Cmd: []string{"nginx"}},
+			},
+		},
+		{
+			name:     "bad config",
+			sections: []Section{configSection, badSection},
+			fail:     true,
+		},
+	}
+	for _, tt := range tests {
+		config, err := GetOCIImageConfig(&Image{Type: SIF, File: f, Sections: tt.sections})
+		if tt.fail {
+			if err == nil {
+				t.Errorf("%s: unexpected success", tt.name)
+			}
+		} else if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.name, err)
+		} else if !reflect.DeepEqual(config, tt.expected) {
+			t.Errorf("%s: got config %+v instead of %+v", tt.name, config, tt.expected)
+		}
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"os"
 	"runtime"
+	"strings"
 	"syscall"
 
 	"github.com/sylabs/sif/pkg/sif"
@@ -17,8 +18,9 @@ import (
 
 type sifFormat struct{}
 
-func checkPartitionType(img *Image, fstype sif.Fstype, offset int64) (uint32, error) {
+func checkPartitionType(img *Image, fimg *sif.FileImage, desc *sif.Descriptor, fstype sif.Fstype) (uint32, error) {
 	header := make([]byte, bufferSize)
+	offset := desc.Fileoff
 
 	if _, err := img.File.ReadAt(header, offset); err != nil {
 		return 0, fmt.Errorf("failed to read SIF partition at offset %d: %s", offset, err)
@@ -37,11 +39,116 @@ func checkPartitionType(img *Image, fstype sif.Fstype, offset int64) (uint32, er
 		return EXT3, nil
 	case sif.FsEncryptedSquashfs:
 		return ENCRYPTSQUASHFS, nil
+	case sif.FsRaw:
+		info, _, err := GetEncryptionInfo(fimg, desc)
+		if err != nil && err != ErrNoEncryptionInfo {
+			return 0, err
+		} else if info != nil && info.Filesystem == EncryptedExt3 {
+			return ENCRYPTEXT3, nil
+		}
 	}
 
 	return 0, fmt.Errorf("unknown filesystem type %v", fstype)
 }
 
+// getArchSystemPartition returns the system partition built for sifArch,
+// the primary system partition is preferred if several partitions match.
+func getArchSystemPartition(fimg *sif.FileImage, sifArch string) *sif.Descriptor {
+	var found *sif.Descriptor
+
+	for i, desc := range fimg.DescrArr {
+		if !desc.Used || desc.Datatype != sif.DataPartition {
+			continue
+		}
+		ptype, err := desc.GetPartType()
+		if err != nil || (ptype != sif.PartPrimSys && ptype != sif.PartSystem) {
+			continue
+		}
+		arch, err := desc.GetArch()
+		if err != nil || string(arch[:sif.HdrArchLen-1]) != sifArch {
+			continue
+		}
+		if ptype == sif.PartPrimSys {
+			return &fimg.DescrArr[i]
+		}
+		if found == nil {
+			found = &fimg.DescrArr[i]
+		}
+	}
+
+	return found
+}
+
+// GetArchSystemPartition returns the system partition of a SIF image used to
+// run it on arch, or on the host architecture if arch is empty. The primary
+// system partition, if any, is returned for images with an unknown architecture.
+func GetArchSystemPartition(fimg *sif.FileImage, arch string) (*sif.Descriptor, error) {
+	if arch == "" {
+		arch = runtime.GOARCH
+	}
+	sifArch := string(fimg.Header.Arch[:sif.HdrArchLen-1])
+
+	// Get the system partition matching the requested architecture, multi-architecture
+	// images carry one system partition per architecture
+	desc := getArchSystemPartition(fimg, sif.GetSIFArch(arch))
+	if desc == nil && sifArch != sif.HdrArchUnknown {
+		if archs := GetSIFArchs(fimg); len(archs) > 1 {
+			return nil, fmt.Errorf("the image's architectures (%s) are incompatible with the requested one (%s)", strings.Join(archs, ", "), arch)
+		}
+		return nil, fmt.Errorf("the image's architecture (%s) is incompatible with the host's (%s)", sif.GetGoArch(sifArch), arch)
+	} else if desc == nil {
+		desc, _, _ = fimg.GetPartPrimSys()
+	}
+
+	return desc, nil
+}
+
+// GetSIFArchs returns the list of architectures, in Go notation, of the
+// system partitions carried by a SIF image, starting with the architecture
+// of the primary system partition.
+func GetSIFArchs(fimg *sif.FileImage) []string {
+	var archs []string
+
+	seen := make(map[string]bool)
+	for _, d := range GetSystemPartitions(fimg) {
+		arch, err := d.GetArch()
+		if err != nil {
+			continue
+		}
+		goArch := sif.GetGoArch(string(arch[:sif.HdrArchLen-1]))
+		if goArch == "unknown" || seen[goArch] {
+			continue
+		}
+		seen[goArch] = true
+		archs = append(archs, goArch)
+	}
+
+	return archs
+}
+
+// GetSystemPartitions returns the primary system partition followed by the
+// system partitions of other architectures found in a SIF image.
+func GetSystemPartitions(fimg *sif.FileImage) []*sif.Descriptor {
+	var descrs []*sif.Descriptor
+
+	for i, desc := range fimg.DescrArr {
+		if !desc.Used || desc.Datatype != sif.DataPartition {
+			continue
+		}
+		ptype, err := desc.GetPartType()
+		if err != nil {
+			continue
+		}
+		if ptype == sif.PartPrimSys {
+			descrs = append([]*sif.Descriptor{&fimg.DescrArr[i]}, descrs...)
+		} else if ptype == sif.PartSystem {
+			descrs = append(descrs, &fimg.DescrArr[i])
+		}
+	}
+
+	return descrs
+}
+
 func (f *sifFormat) initializer(img *Image, fi os.FileInfo) error {
 	if fi.IsDir() {
 		return debugError("not a sif file image")
@@ -73,28 +180,26 @@ func (f *sifFormat) initializer(img *Image, fi os.FileInfo) error {
 	// workflow described above. However, SIF is currently build upon the assumption
 	// that the architecture is assigned based on the architecture defined by a Go
 	// runtime, which is not 100% compliant with the intended workflow.
-	sifArch := string(fimg.Header.Arch[:sif.HdrArchLen-1])
-	if sifArch != sif.HdrArchUnknown && sifArch != sif.GetSIFArch(runtime.GOARCH) {
-		return fmt.Errorf("the image's architecture (%s) is incompatible with the host's (%s)", sif.GetGoArch(sifArch), runtime.GOARCH)
+	desc, err := GetArchSystemPartition(&fimg, img.arch)
+	if err != nil {
+		return err
 	}
 
 	groupID := -1
 
-	// Get the default system partition image
-	for _, desc := range fimg.DescrArr {
-		if !desc.Used {
-			continue
-		}
-		ptype, err := desc.GetPartType()
-		if err != nil {
-			continue
-		}
-		if ptype != sif.PartPrimSys {
-			continue
+	// data objects grouped with the system partitions of
+	// other architectures are ignored
+	otherGroups := make(map[uint32]bool)
+	for _, d := range GetSystemPartitions(&fimg) {
+		if d != desc {
+			otherGroups[d.Groupid] = true
 		}
+	}
+
+	if desc != nil {
 		fstype, err := desc.GetFsType()
 		if err != nil {
-			continue
+			return fmt.Errorf("while getting system partition filesystem type: %s", err)
 		}
 
 		// checks if the partition length is greater that the file
@@ -103,7 +208,7 @@ func (f *sifFormat) initializer(img *Image, fi os.FileInfo) error {
 			return fmt.Errorf("SIF image %s is corrupted: wrong partition size", img.File.Name())
 		}
 
-		htype, err := checkPartitionType(img, fstype, desc.Fileoff)
+		htype, err := checkPartitionType(img, &fimg, desc, fstype)
 		if err != nil {
 			return fmt.Errorf("while checking system partition header: %s", err)
 		}
@@ -118,11 +223,11 @@ func (f *sifFormat) initializer(img *Image, fi os.FileInfo) error {
 		}
 
 		groupID = int(desc.Groupid)
-		break
+		delete(otherGroups, desc.Groupid)
 	}
 
 	for _, desc := range fimg.DescrArr {
-		if !desc.Used {
+		if !desc.Used || otherGroups[desc.Groupid] {
 			continue
 		}
 		if ptype, err := desc.GetPartType(); err == nil {
@@ -131,8 +236,9 @@ func (f *sifFormat) initializer(img *Image, fi os.FileInfo) error {
 				continue
 			}
 			// ignore overlay partitions not associated to root
-			// filesystem group ID
-			if ptype == sif.PartOverlay && groupID != int(desc.Groupid) {
+			// filesystem group ID, overlay images without system
+			// partition keep all their overlay partitions
+			if ptype == sif.PartOverlay && groupID != -1 && groupID != int(desc.Groupid) {
 				continue
 			}
 			fstype, err := desc.GetFsType()
@@ -144,7 +250,7 @@ func (f *sifFormat) initializer(img *Image, fi os.FileInfo) error {
 				return fmt.Errorf("SIF image %s is corrupted: wrong partition size", img.File.Name())
 			}
 
-			htype, err := checkPartitionType(img, fstype, desc.Fileoff)
+			htype, err := checkPartitionType(img, &fimg, &desc, fstype)
 			if err != nil {
 				return fmt.Errorf("while checking data partition header: %s", err)
 			}
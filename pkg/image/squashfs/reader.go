@@ -0,0 +1,668 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package squashfs
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ulikunitz/xz"
+)
+
+// ErrUnsupportedCompression is returned when the squashfs filesystem
+// uses a compression algorithm not supported by the reader.
+var ErrUnsupportedCompression = errors.New("unsupported squashfs compression")
+
+const (
+	magic = 0x73717368
+
+	compZlib = 1
+	compXz   = 4
+
+	metadataSize       = 8192
+	minBlockSize       = 4096
+	maxBlockSize       = 1 << 20
+	metadataUncomp     = 0x8000
+	dataUncomp         = 1 << 24
+	noFragment         = 0xffffffff
+	fragmentsPerBlock  = metadataSize / 16
+	maxSymlinkFollowed = 40
+)
+
+// inode types
+const (
+	typeDir        = 1
+	typeFile       = 2
+	typeSymlink    = 3
+	typeExtDir     = 8
+	typeExtFile    = 9
+	typeExtSymlink = 10
+)
+
+type superblock struct {
+	Magic              uint32
+	Inodes             uint32
+	MkfsTime           uint32
+	BlockSize          uint32
+	Fragments          uint32
+	Compression        uint16
+	BlockLog           uint16
+	Flags              uint16
+	NoIds              uint16
+	Major              uint16
+	Minor              uint16
+	RootInode          uint64
+	BytesUsed          uint64
+	IDTableStart       uint64
+	XattrIDTableStart  uint64
+	InodeTableStart    uint64
+	DirTableStart      uint64
+	FragmentTableStart uint64
+	LookupTableStart   uint64
+}
+
+type inodeHeader struct {
+	Type   uint16
+	Mode   uint16
+	UID    uint16
+	GID    uint16
+	Mtime  uint32
+	Number uint32
+}
+
+type dirInode struct {
+	StartBlock uint32
+	Nlink      uint32
+	FileSize   uint16
+	Offset     uint16
+	Parent     uint32
+}
+
+type extDirInode struct {
+	Nlink      uint32
+	FileSize   uint32
+	StartBlock uint32
+	Parent     uint32
+	IndexCount uint16
+	Offset     uint16
+	Xattr      uint32
+}
+
+type fileInode struct {
+	StartBlock uint32
+	Fragment   uint32
+	Offset     uint32
+	FileSize   uint32
+}
+
+type extFileInode struct {
+	StartBlock uint64
+	FileSize   uint64
+	Sparse     uint64
+	Nlink      uint32
+	Fragment   uint32
+	Offset     uint32
+	Xattr      uint32
+}
+
+type symlinkInode struct {
+	Nlink      uint32
+	TargetSize uint32
+}
+
+type dirHeader struct {
+	Count      uint32
+	StartBlock uint32
+	Inode      uint32
+}
+
+type dirEntry struct {
+	Offset    uint16
+	InodeDiff int16
+	Type      uint16
+	NameSize  uint16
+}
+
+type fragmentEntry struct {
+	Start  uint64
+	Size   uint32
+	Unused uint32
+}
+
+// inode holds the information of a decoded inode required to
+// access its content.
+type inode struct {
+	name       string
+	mode       os.FileMode
+	mtime      time.Time
+	size       int64
+	startBlock uint64
+	offset     uint32
+	fragment   uint32
+	blocks     []uint32
+	target     string
+}
+
+// Name implements os.FileInfo.
+func (i *inode) Name() string { return i.name }
+
+// Size implements os.FileInfo.
+func (i *inode) Size() int64 { return i.size }
+
+// Mode implements os.FileInfo.
+func (i *inode) Mode() os.FileMode { return i.mode }
+
+// ModTime implements os.FileInfo.
+func (i *inode) ModTime() time.Time { return i.mtime }
+
+// IsDir implements os.FileInfo.
+func (i *inode) IsDir() bool { return i.mode.IsDir() }
+
+// Sys implements os.FileInfo.
+func (i *inode) Sys() interface{} { return nil }
+
+type metadataBlock struct {
+	data []byte
+	next int64
+}
+
+// Reader gives read access to files stored in a squashfs filesystem
+// without mounting it.
+type Reader struct {
+	r        io.ReaderAt
+	sb       superblock
+	metadata map[int64]*metadataBlock
+}
+
+// NewReader returns a reader for the squashfs filesystem provided by r,
+// the squashfs superblock must be located at offset 0.
+func NewReader(r io.ReaderAt) (*Reader, error) {
+	reader := &Reader{
+		r:        r,
+		metadata: make(map[int64]*metadataBlock),
+	}
+
+	sr := io.NewSectionReader(r, 0, int64(binary.Size(reader.sb)))
+	if err := binary.Read(sr, binary.LittleEndian, &reader.sb); err != nil {
+		return nil, fmt.Errorf("while reading squashfs superblock: %s", err)
+	}
+	if reader.sb.Magic != magic {
+		return nil, fmt.Errorf("not a valid squashfs filesystem")
+	}
+	if reader.sb.Major != 4 {
+		return nil, fmt.Errorf("unsupported squashfs version %d.%d", reader.sb.Major, reader.sb.Minor)
+	}
+	if reader.sb.Compression != compZlib && reader.sb.Compression != compXz {
+		return nil, ErrUnsupportedCompression
+	}
+
+	bs := reader.sb.BlockSize
+	if bs < minBlockSize || bs > maxBlockSize || bs&(bs-1) != 0 || reader.sb.BlockLog >= 32 || bs != 1<<reader.sb.BlockLog {
+		return nil, fmt.Errorf("invalid squashfs block size %d", bs)
+	}
+
+	// the filesystem size bounds the sizes read from the image, it
+	// must not be larger than the image
+	if reader.sb.BytesUsed < uint64(binary.Size(reader.sb)) || reader.sb.BytesUsed > math.MaxInt64 {
+		return nil, fmt.Errorf("invalid squashfs filesystem size %d", reader.sb.BytesUsed)
+	}
+	if _, err := r.ReadAt(make([]byte, 1), int64(reader.sb.BytesUsed)-1); err != nil {
+		return nil, fmt.Errorf("squashfs filesystem of %d bytes truncated: %s", reader.sb.BytesUsed, err)
+	}
+
+	return reader, nil
+}
+
+// ReadFile returns the content of the file found at path, symbolic
+// links are followed.
+func (r *Reader) ReadFile(path string) ([]byte, error) {
+	i, err := r.lookup(path, true)
+	if err != nil {
+		return nil, &os.PathError{Op: "read", Path: path, Err: err}
+	}
+	if !i.mode.IsRegular() {
+		return nil, &os.PathError{Op: "read", Path: path, Err: fmt.Errorf("not a regular file")}
+	}
+	return r.readData(i)
+}
+
+// Stat returns information about the file found at path, symbolic
+// links are followed.
+func (r *Reader) Stat(path string) (os.FileInfo, error) {
+	i, err := r.lookup(path, true)
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: path, Err: err}
+	}
+	return i, nil
+}
+
+// Lstat returns information about the file found at path, a symbolic
+// link found at path is not followed.
+func (r *Reader) Lstat(path string) (os.FileInfo, error) {
+	i, err := r.lookup(path, false)
+	if err != nil {
+		return nil, &os.PathError{Op: "lstat", Path: path, Err: err}
+	}
+	return i, nil
+}
+
+// ReadDir returns the entries of the directory found at path sorted
+// by name, like ioutil.ReadDir.
+func (r *Reader) ReadDir(path string) ([]os.FileInfo, error) {
+	i, err := r.lookup(path, true)
+	if err != nil {
+		return nil, &os.PathError{Op: "readdir", Path: path, Err: err}
+	}
+	if !i.IsDir() {
+		return nil, &os.PathError{Op: "readdir", Path: path, Err: fmt.Errorf("not a directory")}
+	}
+
+	entries, err := r.readDir(i)
+	if err != nil {
+		return nil, &os.PathError{Op: "readdir", Path: path, Err: err}
+	}
+
+	list := make([]os.FileInfo, 0, len(entries))
+	for _, e := range entries {
+		list = append(list, e)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name() < list[j].Name() })
+
+	return list, nil
+}
+
+// lookup returns the inode found at path p, the last path component
+// is resolved if it's a symbolic link and follow is true.
+func (r *Reader) lookup(p string, follow bool) (*inode, error) {
+	root, err := r.readInode(r.sb.RootInode, "/")
+	if err != nil {
+		return nil, err
+	}
+
+	links := 0
+	components := strings.Split(path.Clean("/"+p), "/")[1:]
+	dirs := []*inode{root}
+
+	for len(components) > 0 {
+		name := components[0]
+		components = components[1:]
+
+		cur := dirs[len(dirs)-1]
+		switch name {
+		case "":
+			continue
+		case ".":
+			continue
+		case "..":
+			if len(dirs) > 1 {
+				dirs = dirs[:len(dirs)-1]
+			}
+			continue
+		}
+
+		if !cur.IsDir() {
+			return nil, fmt.Errorf("not a directory")
+		}
+
+		entries, err := r.readDir(cur)
+		if err != nil {
+			return nil, err
+		}
+		next, ok := entries[name]
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+
+		if next.mode&os.ModeSymlink != 0 && (follow || len(components) > 0) {
+			links++
+			if links > maxSymlinkFollowed {
+				return nil, fmt.Errorf("too many levels of symbolic links")
+			}
+			target := strings.Split(next.target, "/")
+			if strings.HasPrefix(next.target, "/") {
+				dirs = dirs[:1]
+			}
+			components = append(target, components...)
+			continue
+		}
+
+		dirs = append(dirs, next)
+	}
+
+	return dirs[len(dirs)-1], nil
+}
+
+// readDir returns the entries of the directory inode dir.
+func (r *Reader) readDir(dir *inode) (map[string]*inode, error) {
+	entries := make(map[string]*inode)
+
+	// directory size includes the implicit . and .. entries
+	remaining := dir.size - 3
+	if remaining <= 0 {
+		return entries, nil
+	}
+
+	c := r.newCursor(r.sb.DirTableStart, dir.startBlock, int(dir.offset))
+	for remaining > 0 {
+		var hdr dirHeader
+		if err := binary.Read(c, binary.LittleEndian, &hdr); err != nil {
+			return nil, fmt.Errorf("while reading directory header: %s", err)
+		}
+		remaining -= int64(binary.Size(hdr))
+
+		for n := uint32(0); n <= hdr.Count; n++ {
+			var e dirEntry
+			if err := binary.Read(c, binary.LittleEndian, &e); err != nil {
+				return nil, fmt.Errorf("while reading directory entry: %s", err)
+			}
+			name := make([]byte, int(e.NameSize)+1)
+			if _, err := io.ReadFull(c, name); err != nil {
+				return nil, fmt.Errorf("while reading directory entry name: %s", err)
+			}
+			remaining -= int64(binary.Size(e) + len(name))
+
+			ref := uint64(hdr.StartBlock)<<16 | uint64(e.Offset)
+			i, err := r.readInode(ref, string(name))
+			if err != nil {
+				return nil, err
+			}
+			entries[i.name] = i
+		}
+	}
+
+	return entries, nil
+}
+
+// readInode decodes the inode referenced by ref.
+func (r *Reader) readInode(ref uint64, name string) (*inode, error) {
+	c := r.newCursor(r.sb.InodeTableStart, uint64(ref>>16), int(ref&0xffff))
+
+	var hdr inodeHeader
+	if err := binary.Read(c, binary.LittleEndian, &hdr); err != nil {
+		return nil, fmt.Errorf("while reading inode: %s", err)
+	}
+
+	i := &inode{
+		name:     name,
+		mode:     os.FileMode(hdr.Mode & 0777),
+		mtime:    time.Unix(int64(hdr.Mtime), 0),
+		fragment: noFragment,
+	}
+
+	switch hdr.Type {
+	case typeDir:
+		var d dirInode
+		if err := binary.Read(c, binary.LittleEndian, &d); err != nil {
+			return nil, fmt.Errorf("while reading directory inode: %s", err)
+		}
+		i.mode |= os.ModeDir
+		i.size = int64(d.FileSize)
+		i.startBlock = uint64(d.StartBlock)
+		i.offset = uint32(d.Offset)
+	case typeExtDir:
+		var d extDirInode
+		if err := binary.Read(c, binary.LittleEndian, &d); err != nil {
+			return nil, fmt.Errorf("while reading directory inode: %s", err)
+		}
+		i.mode |= os.ModeDir
+		i.size = int64(d.FileSize)
+		i.startBlock = uint64(d.StartBlock)
+		i.offset = uint32(d.Offset)
+	case typeFile:
+		var f fileInode
+		if err := binary.Read(c, binary.LittleEndian, &f); err != nil {
+			return nil, fmt.Errorf("while reading file inode: %s", err)
+		}
+		i.size = int64(f.FileSize)
+		i.startBlock = uint64(f.StartBlock)
+		i.offset = f.Offset
+		i.fragment = f.Fragment
+	case typeExtFile:
+		var f extFileInode
+		if err := binary.Read(c, binary.LittleEndian, &f); err != nil {
+			return nil, fmt.Errorf("while reading file inode: %s", err)
+		}
+		i.size = int64(f.FileSize)
+		i.startBlock = f.StartBlock
+		i.offset = f.Offset
+		i.fragment = f.Fragment
+	case typeSymlink, typeExtSymlink:
+		var s symlinkInode
+		if err := binary.Read(c, binary.LittleEndian, &s); err != nil {
+			return nil, fmt.Errorf("while reading symlink inode: %s", err)
+		}
+		if s.TargetSize > metadataSize {
+			return nil, fmt.Errorf("symlink target too long")
+		}
+		target := make([]byte, s.TargetSize)
+		if _, err := io.ReadFull(c, target); err != nil {
+			return nil, fmt.Errorf("while reading symlink target: %s", err)
+		}
+		i.mode |= os.ModeSymlink
+		i.size = int64(s.TargetSize)
+		i.target = string(target)
+		return i, nil
+	default:
+		// devices, fifos and sockets have no content to read
+		i.mode |= os.ModeIrregular
+		return i, nil
+	}
+
+	if i.IsDir() {
+		return i, nil
+	}
+
+	blockSize := int64(r.sb.BlockSize)
+	count := i.size / blockSize
+	if i.fragment == noFragment && i.size%blockSize != 0 {
+		count++
+	}
+	// each block of the list is stored in 4 bytes of the inode table
+	if count > int64(r.sb.BytesUsed)/4 {
+		return nil, fmt.Errorf("file size %d out of bounds", i.size)
+	}
+	i.blocks = make([]uint32, count)
+	if err := binary.Read(c, binary.LittleEndian, i.blocks); err != nil {
+		return nil, fmt.Errorf("while reading file block list: %s", err)
+	}
+
+	return i, nil
+}
+
+// readData returns the content of the regular file inode i.
+func (r *Reader) readData(i *inode) ([]byte, error) {
+	// the buffer grows with the data read beyond the image size
+	capacity := i.size
+	if capacity > int64(r.sb.BytesUsed) {
+		capacity = int64(r.sb.BytesUsed)
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, capacity))
+	blockSize := int64(r.sb.BlockSize)
+
+	pos := int64(i.startBlock)
+	for _, b := range i.blocks {
+		if b == 0 {
+			// sparse block
+			n := blockSize
+			if remaining := i.size - int64(buf.Len()); remaining < n {
+				n = remaining
+			}
+			buf.Write(make([]byte, n))
+			continue
+		}
+		data, err := r.readBlock(pos, b)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(data)
+		pos += int64(b &^ dataUncomp)
+	}
+
+	if i.fragment != noFragment {
+		data, err := r.readFragment(i.fragment)
+		if err != nil {
+			return nil, err
+		}
+		start := int64(i.offset)
+		end := start + i.size%blockSize
+		if end > int64(len(data)) {
+			return nil, fmt.Errorf("file fragment out of bounds")
+		}
+		buf.Write(data[start:end])
+	}
+
+	if int64(buf.Len()) < i.size {
+		return nil, fmt.Errorf("short read: %d bytes read instead of %d", buf.Len(), i.size)
+	}
+	return buf.Bytes()[:i.size], nil
+}
+
+// readFragment returns the decompressed fragment block at index idx.
+func (r *Reader) readFragment(idx uint32) ([]byte, error) {
+	var ptr uint64
+
+	off := int64(r.sb.FragmentTableStart) + int64(idx/fragmentsPerBlock)*8
+	if err := binary.Read(io.NewSectionReader(r.r, off, 8), binary.LittleEndian, &ptr); err != nil {
+		return nil, fmt.Errorf("while reading fragment table: %s", err)
+	}
+
+	var e fragmentEntry
+	c := r.newCursor(ptr, 0, int(idx%fragmentsPerBlock)*binary.Size(e))
+	if err := binary.Read(c, binary.LittleEndian, &e); err != nil {
+		return nil, fmt.Errorf("while reading fragment entry: %s", err)
+	}
+
+	return r.readBlock(int64(e.Start), e.Size)
+}
+
+// readBlock reads and decompresses the data block located at pos,
+// size is the block size as stored in block lists.
+func (r *Reader) readBlock(pos int64, size uint32) ([]byte, error) {
+	n := int64(size &^ dataUncomp)
+	if n > int64(r.sb.BlockSize)*2 {
+		return nil, fmt.Errorf("invalid data block size %d", n)
+	}
+
+	data := make([]byte, n)
+	if _, err := r.r.ReadAt(data, pos); err != nil {
+		return nil, fmt.Errorf("while reading data block: %s", err)
+	}
+	if size&dataUncomp != 0 {
+		return data, nil
+	}
+	return r.decompress(data, int64(r.sb.BlockSize))
+}
+
+// readMetadata returns the metadata block located at pos.
+func (r *Reader) readMetadata(pos int64) (*metadataBlock, error) {
+	if b, ok := r.metadata[pos]; ok {
+		return b, nil
+	}
+
+	var hdr uint16
+	if err := binary.Read(io.NewSectionReader(r.r, pos, 2), binary.LittleEndian, &hdr); err != nil {
+		return nil, fmt.Errorf("while reading metadata block header: %s", err)
+	}
+
+	n := int64(hdr &^ metadataUncomp)
+	data := make([]byte, n)
+	if _, err := r.r.ReadAt(data, pos+2); err != nil {
+		return nil, fmt.Errorf("while reading metadata block: %s", err)
+	}
+	if hdr&metadataUncomp == 0 {
+		var err error
+		if data, err = r.decompress(data, metadataSize); err != nil {
+			return nil, err
+		}
+	}
+
+	b := &metadataBlock{data: data, next: pos + 2 + n}
+	r.metadata[pos] = b
+	return b, nil
+}
+
+// decompress returns the decompressed content of the block data, which
+// must not exceed limit bytes.
+func (r *Reader) decompress(data []byte, limit int64) ([]byte, error) {
+	var dr io.Reader
+	var err error
+
+	switch r.sb.Compression {
+	case compZlib:
+		dr, err = zlib.NewReader(bytes.NewReader(data))
+	case compXz:
+		dr, err = xz.NewReader(bytes.NewReader(data))
+	default:
+		return nil, ErrUnsupportedCompression
+	}
+	if err != nil {
+		return nil, fmt.Errorf("while decompressing block: %s", err)
+	}
+
+	out, err := ioutil.ReadAll(io.LimitReader(dr, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("while decompressing block: %s", err)
+	} else if int64(len(out)) > limit {
+		return nil, fmt.Errorf("decompressed block larger than %d bytes", limit)
+	}
+	return out, nil
+}
+
+// cursor reads a metadata stream spanning several metadata blocks.
+type cursor struct {
+	r    *Reader
+	pos  int64
+	off  int
+	data []byte
+	next int64
+}
+
+func (r *Reader) newCursor(table, block uint64, offset int) *cursor {
+	return &cursor{
+		r:    r,
+		pos:  int64(table + block),
+		off:  offset,
+		next: -1,
+	}
+}
+
+// Read implements io.Reader.
+func (c *cursor) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if c.data == nil || c.off >= len(c.data) {
+			if c.data != nil {
+				c.off -= len(c.data)
+				c.pos = c.next
+			}
+			b, err := c.r.readMetadata(c.pos)
+			if err != nil {
+				return n, err
+			}
+			if len(b.data) == 0 {
+				return n, io.ErrUnexpectedEOF
+			}
+			c.data = b.data
+			c.next = b.next
+			continue
+		}
+		copied := copy(p[n:], c.data[c.off:])
+		c.off += copied
+		n += copied
+	}
+	return n, nil
+}
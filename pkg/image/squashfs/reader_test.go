@@ -0,0 +1,444 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package squashfs
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"os"
+	"strings"
+	"testing"
+)
+
+func openReader(t *testing.T, path string) (*Reader, *os.File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open %s: %s", path, err)
+	}
+	r, err := NewReader(f)
+	return r, f, err
+}
+
+func TestReader(t *testing.T) {
+	r, f, err := openReader(t, "../testdata/squashfs.v4")
+	if err != nil {
+		t.Fatalf("unexpected error while creating reader: %s", err)
+	}
+	defer f.Close()
+
+	entries, err := r.ReadDir("/")
+	if err != nil {
+		t.Fatalf("unexpected error while reading root directory: %s", err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "examplefile" || !entries[0].Mode().IsRegular() {
+		t.Fatalf("unexpected root directory entries: %v", entries)
+	}
+
+	for _, path := range []string{"examplefile", "/examplefile", "/./examplefile", "/../examplefile"} {
+		data, err := r.ReadFile(path)
+		if err != nil {
+			t.Errorf("unexpected error while reading %s: %s", path, err)
+		} else if string(data) != "Example File Contents\n" {
+			t.Errorf("unexpected content for %s: %q", path, data)
+		}
+	}
+
+	fi, err := r.Stat("/")
+	if err != nil {
+		t.Errorf("unexpected error while getting root directory information: %s", err)
+	} else if !fi.IsDir() {
+		t.Errorf("root directory is not a directory")
+	}
+
+	if _, err := r.ReadFile("/"); err == nil {
+		t.Errorf("unexpected success while reading a directory")
+	}
+	if _, err := r.ReadDir("/examplefile"); err == nil {
+		t.Errorf("unexpected success while listing a file")
+	}
+	if _, err := r.Stat("/missing"); !os.IsNotExist(err) {
+		t.Errorf("unexpected error for a missing file: %v", err)
+	}
+	if _, err := r.Stat("/examplefile/missing"); err == nil || os.IsNotExist(err) {
+		t.Errorf("unexpected error for a path through a file: %v", err)
+	}
+}
+
+func TestReaderUnsupported(t *testing.T) {
+	_, f, err := openReader(t, "../testdata/squashfs.lzo")
+	f.Close()
+	if err != ErrUnsupportedCompression {
+		t.Errorf("unexpected error for a lzo compressed image: %v", err)
+	}
+
+	_, f, err = openReader(t, "../testdata/squashfs.v3")
+	f.Close()
+	if err == nil {
+		t.Errorf("unexpected success for a squashfs v3 image")
+	}
+}
+
+// testImage describes a squashfs image built by build with uncompressed
+// data and metadata blocks, fields can be changed to corrupt it.
+type testImage struct {
+	sb        superblock
+	bigBlock  uint32
+	fragment  fragmentEntry
+	extOffset uint32
+	// inodeHeader is the header of the inode table metadata block
+	inodeHeader uint16
+	truncate    int
+	// bytesUsed, if set, replaces the filesystem size of the superblock
+	bytesUsed uint64
+	// bigSize, if set, replaces the size of the big file inode
+	bigSize uint32
+}
+
+const testBlockSize = 4096
+
+// newTestImage returns an image holding in its root directory, stored
+// with an extended inode:
+//   - big: a file of one data block and a tail stored in a fragment
+//   - ext: a file stored in the same fragment with an extended inode
+//   - sparse: a file of one sparse block with an extended inode
+//   - link: a symbolic link to big
+//   - sub: an empty directory
+func newTestImage() *testImage {
+	return &testImage{
+		sb: superblock{
+			Magic:             magic,
+			Inodes:            6,
+			BlockSize:         testBlockSize,
+			Fragments:         1,
+			Compression:       compZlib,
+			BlockLog:          12,
+			NoIds:             1,
+			Major:             4,
+			XattrIDTableStart: 0xffffffffffffffff,
+			LookupTableStart:  0xffffffffffffffff,
+		},
+		bigBlock:  testBlockSize | dataUncomp,
+		fragment:  fragmentEntry{Size: 150 | dataUncomp},
+		extOffset: 100,
+	}
+}
+
+func (img *testImage) bigContent() []byte {
+	return bytes.Repeat([]byte("0123456789abcdef"), (testBlockSize+100)/16+1)[:testBlockSize+100]
+}
+
+func (img *testImage) extContent() []byte {
+	return []byte(strings.Repeat("e", 50))
+}
+
+func (img *testImage) build(t *testing.T) []byte {
+	write := func(b *bytes.Buffer, v ...interface{}) {
+		for _, d := range v {
+			if err := binary.Write(b, binary.LittleEndian, d); err != nil {
+				t.Fatalf("failed to encode %T: %s", d, err)
+			}
+		}
+	}
+	metadata := func(b *bytes.Buffer, hdr uint16, data []byte) {
+		if hdr == 0 {
+			hdr = uint16(len(data)) | metadataUncomp
+		}
+		write(b, hdr)
+		b.Write(data)
+	}
+
+	sbSize := uint64(binary.Size(img.sb))
+	big := img.bigContent()
+	bigSize := uint32(len(big))
+	if img.bigSize != 0 {
+		bigSize = img.bigSize
+	}
+
+	// data blocks: the first block of big, then the fragment
+	// holding the tail of big followed by ext
+	var data bytes.Buffer
+	data.Write(big[:testBlockSize])
+	img.fragment.Start = sbSize + uint64(data.Len())
+	data.Write(big[testBlockSize:])
+	data.Write(img.extContent())
+
+	// inode table
+	var inodes bytes.Buffer
+	offsets := make(map[string]uint16)
+
+	offsets["big"] = uint16(inodes.Len())
+	write(&inodes,
+		inodeHeader{Type: typeFile, Mode: 0644, Number: 2},
+		fileInode{StartBlock: uint32(sbSize), Fragment: 0, FileSize: bigSize},
+		[]uint32{img.bigBlock},
+	)
+	offsets["ext"] = uint16(inodes.Len())
+	write(&inodes,
+		inodeHeader{Type: typeExtFile, Mode: 0600, Number: 3},
+		extFileInode{FileSize: 50, Nlink: 1, Fragment: 0, Offset: img.extOffset, Xattr: 0xffffffff},
+	)
+	offsets["sparse"] = uint16(inodes.Len())
+	write(&inodes,
+		inodeHeader{Type: typeExtFile, Mode: 0644, Number: 4},
+		extFileInode{FileSize: testBlockSize, Sparse: testBlockSize, Nlink: 1, Fragment: noFragment, Xattr: 0xffffffff},
+		[]uint32{0},
+	)
+	offsets["link"] = uint16(inodes.Len())
+	write(&inodes,
+		inodeHeader{Type: typeSymlink, Mode: 0777, Number: 5},
+		symlinkInode{Nlink: 1, TargetSize: 3},
+		[]byte("big"),
+	)
+	offsets["sub"] = uint16(inodes.Len())
+	write(&inodes,
+		inodeHeader{Type: typeDir, Mode: 0755, Number: 6},
+		dirInode{Nlink: 2, FileSize: 3, Parent: 1},
+	)
+
+	// directory table holding the root directory listing
+	var dirs bytes.Buffer
+	names := []string{"big", "ext", "link", "sparse", "sub"}
+	write(&dirs, dirHeader{Count: uint32(len(names) - 1), Inode: 2})
+	for _, name := range names {
+		write(&dirs, dirEntry{Offset: offsets[name], NameSize: uint16(len(name) - 1)}, []byte(name))
+	}
+
+	root := uint16(inodes.Len())
+	write(&inodes,
+		inodeHeader{Type: typeExtDir, Mode: 0755, Number: 1},
+		extDirInode{Nlink: 3, FileSize: uint32(dirs.Len() + 3), Parent: 7, Xattr: 0xffffffff},
+	)
+
+	var frags bytes.Buffer
+	write(&frags, img.fragment)
+
+	var out bytes.Buffer
+	sb := img.sb
+	sb.RootInode = uint64(root)
+	sb.InodeTableStart = sbSize + uint64(data.Len())
+	sb.DirTableStart = sb.InodeTableStart + 2 + uint64(inodes.Len())
+	fragBlock := sb.DirTableStart + 2 + uint64(dirs.Len())
+	sb.FragmentTableStart = fragBlock + 2 + uint64(frags.Len())
+	sb.IDTableStart = sb.FragmentTableStart + 8
+	sb.BytesUsed = sb.IDTableStart
+	if img.bytesUsed != 0 {
+		sb.BytesUsed = img.bytesUsed
+	}
+
+	write(&out, sb)
+	out.Write(data.Bytes())
+	metadata(&out, img.inodeHeader, inodes.Bytes())
+	metadata(&out, 0, dirs.Bytes())
+	metadata(&out, 0, frags.Bytes())
+	write(&out, fragBlock)
+
+	if img.truncate > 0 {
+		return out.Bytes()[:img.truncate]
+	}
+	return out.Bytes()
+}
+
+func TestReaderFragments(t *testing.T) {
+	img := newTestImage()
+	r, err := NewReader(bytes.NewReader(img.build(t)))
+	if err != nil {
+		t.Fatalf("unexpected error while creating reader: %s", err)
+	}
+
+	entries, err := r.ReadDir("/")
+	if err != nil {
+		t.Fatalf("unexpected error while reading root directory: %s", err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if strings.Join(names, " ") != "big ext link sparse sub" {
+		t.Errorf("unexpected root directory entries: %v", names)
+	}
+
+	tests := []struct {
+		name string
+		path string
+		want []byte
+	}{
+		{"block and fragment", "/big", img.bigContent()},
+		{"extended inode in fragment", "/ext", img.extContent()},
+		{"sparse block", "/sparse", make([]byte, testBlockSize)},
+		{"symbolic link", "/link", img.bigContent()},
+		{"parent directory", "/sub/../ext", img.extContent()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := r.ReadFile(tt.path)
+			if err != nil {
+				t.Fatalf("unexpected error while reading %s: %s", tt.path, err)
+			}
+			if !bytes.Equal(data, tt.want) {
+				t.Errorf("unexpected content for %s: %d bytes read instead of %d", tt.path, len(data), len(tt.want))
+			}
+		})
+	}
+
+	fi, err := r.Stat("/ext")
+	if err != nil {
+		t.Fatalf("unexpected error while getting file information: %s", err)
+	}
+	if fi.Size() != 50 || fi.Mode() != 0600 {
+		t.Errorf("unexpected extended inode information: size %d mode %s", fi.Size(), fi.Mode())
+	}
+	if fi, err := r.Lstat("/link"); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("unexpected symbolic link information: %v", err)
+	}
+	if entries, err := r.ReadDir("/sub"); err != nil || len(entries) != 0 {
+		t.Errorf("unexpected empty directory entries %v: %v", entries, err)
+	}
+}
+
+func TestReaderCorrupt(t *testing.T) {
+	sbSize := binary.Size(superblock{})
+
+	tests := []struct {
+		name    string
+		corrupt func(img *testImage)
+		// newErr is true if the reader creation must fail
+		newErr bool
+		path   string
+	}{
+		{
+			name:    "truncated superblock",
+			corrupt: func(img *testImage) { img.truncate = sbSize / 2 },
+			newErr:  true,
+		},
+		{
+			name:    "bad magic",
+			corrupt: func(img *testImage) { img.sb.Magic = 0x12345678 },
+			newErr:  true,
+		},
+		{
+			name:    "unsupported version",
+			corrupt: func(img *testImage) { img.sb.Major = 5 },
+			newErr:  true,
+		},
+		{
+			name:    "unsupported compression",
+			corrupt: func(img *testImage) { img.sb.Compression = 3 },
+			newErr:  true,
+		},
+		{
+			name:    "null block size",
+			corrupt: func(img *testImage) { img.sb.BlockSize = 0 },
+			newErr:  true,
+		},
+		{
+			name:    "block size not a power of two",
+			corrupt: func(img *testImage) { img.sb.BlockSize = 3 * testBlockSize },
+			newErr:  true,
+		},
+		{
+			name:    "block size too large",
+			corrupt: func(img *testImage) { img.sb.BlockSize, img.sb.BlockLog = 1<<21, 21 },
+			newErr:  true,
+		},
+		{
+			name:    "block size and log mismatch",
+			corrupt: func(img *testImage) { img.sb.BlockLog = 13 },
+			newErr:  true,
+		},
+		{
+			name:    "truncated image",
+			corrupt: func(img *testImage) { img.truncate = sbSize + testBlockSize + 200 },
+			newErr:  true,
+		},
+		{
+			name:    "filesystem size too small",
+			corrupt: func(img *testImage) { img.bytesUsed = uint64(sbSize / 2) },
+			newErr:  true,
+		},
+		{
+			name: "truncated inode table",
+			corrupt: func(img *testImage) {
+				img.truncate = sbSize + testBlockSize + 200
+				img.bytesUsed = uint64(img.truncate)
+			},
+			path: "/big",
+		},
+		{
+			name:    "corrupt compressed metadata",
+			corrupt: func(img *testImage) { img.inodeHeader = 64 },
+			path:    "/big",
+		},
+		{
+			name:    "invalid data block size",
+			corrupt: func(img *testImage) { img.bigBlock = 3 * testBlockSize },
+			path:    "/big",
+		},
+		{
+			name:    "file size out of bounds",
+			corrupt: func(img *testImage) { img.bigSize = 0xffffffff },
+			path:    "/big",
+		},
+		{
+			name:    "truncated fragment",
+			corrupt: func(img *testImage) { img.fragment.Size = 20 | dataUncomp },
+			path:    "/ext",
+		},
+		{
+			name:    "fragment offset out of bounds",
+			corrupt: func(img *testImage) { img.extOffset = 120 },
+			path:    "/ext",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := newTestImage()
+			tt.corrupt(img)
+
+			r, err := NewReader(bytes.NewReader(img.build(t)))
+			if tt.newErr {
+				if err == nil {
+					t.Errorf("unexpected success while creating reader")
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error while creating reader: %s", err)
+			}
+
+			if _, err := r.ReadFile(tt.path); err == nil {
+				t.Errorf("unexpected success while reading %s", tt.path)
+			}
+		})
+	}
+}
+
+func TestReaderMetadataBlock(t *testing.T) {
+	// a full metadata block is larger than the smallest data block
+	content := bytes.Repeat([]byte("metadata"), metadataSize/8)
+
+	var compressed bytes.Buffer
+	w := zlib.NewWriter(&compressed)
+	w.Write(content)
+	w.Close()
+
+	var block bytes.Buffer
+	binary.Write(&block, binary.LittleEndian, uint16(compressed.Len()))
+	block.Write(compressed.Bytes())
+
+	r := &Reader{
+		r:        bytes.NewReader(block.Bytes()),
+		sb:       superblock{BlockSize: testBlockSize, Compression: compZlib},
+		metadata: make(map[int64]*metadataBlock),
+	}
+	b, err := r.readMetadata(0)
+	if err != nil {
+		t.Fatalf("unexpected error while reading metadata block: %s", err)
+	}
+	if !bytes.Equal(b.data, content) {
+		t.Errorf("unexpected metadata block of %d bytes instead of %d", len(b.data), len(content))
+	}
+}
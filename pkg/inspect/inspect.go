@@ -0,0 +1,112 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// Package inspect defines the document printed by 'singularity inspect --all',
+// it allows other tools to decode image metadata without depending on the
+// command output format.
+package inspect
+
+// SchemaVersion is the version of the Metadata schema. The major number
+// is incremented on incompatible changes, the minor number when fields
+// are added.
+const SchemaVersion = "1.0"
+
+// Image formats reported in Metadata.Format.
+const (
+	FormatSIF      = "sif"
+	FormatSquashfs = "squashfs"
+	FormatExt3     = "ext3"
+	FormatSandbox  = "sandbox"
+)
+
+// Metadata describes an image, its content and its structure.
+type Metadata struct {
+	// SchemaVersion is the schema version of the document.
+	SchemaVersion string `json:"schemaVersion"`
+	// Path is the absolute path of the image.
+	Path string `json:"path"`
+	// Format is one of FormatSIF, FormatSquashfs, FormatExt3 or FormatSandbox.
+	Format string `json:"format"`
+	// Size is the size in bytes of the image file, 0 for sandboxes.
+	Size int64 `json:"size"`
+	// Attributes holds the container metadata.
+	Attributes Attributes `json:"attributes"`
+	// Apps lists the SCIF apps installed in the container.
+	Apps []App `json:"apps"`
+	// Partitions lists the image partitions.
+	Partitions []Partition `json:"partitions"`
+	// Descriptors lists the SIF descriptors, empty for other formats.
+	Descriptors []Descriptor `json:"descriptors"`
+	// Signatures lists the SIF signatures, empty for other formats.
+	Signatures []Signature `json:"signatures"`
+}
+
+// Attributes holds the metadata stored in /.singularity.d for the
+// container or in /scif/apps/<app>/scif for an app.
+type Attributes struct {
+	Labels      map[string]string `json:"labels"`
+	Deffile     string            `json:"deffile,omitempty"`
+	Runscript   string            `json:"runscript,omitempty"`
+	Startscript string            `json:"startscript,omitempty"`
+	Test        string            `json:"test,omitempty"`
+	Helpfile    string            `json:"helpfile,omitempty"`
+	// Environment maps environment script names to their content.
+	Environment map[string]string `json:"environment"`
+}
+
+// App describes a SCIF app.
+type App struct {
+	Name       string     `json:"name"`
+	Attributes Attributes `json:"attributes"`
+}
+
+// Partition describes an image partition.
+type Partition struct {
+	// ID is the SIF descriptor ID of the partition, 0 for other formats.
+	ID uint32 `json:"id,omitempty"`
+	// Filesystem is one of "squashfs", "ext3", "encrypted-squashfs",
+	// "encrypted-ext3", "raw", "archive" or "unknown".
+	Filesystem string `json:"filesystem"`
+	// Type is one of "primary-system", "system", "data", "overlay"
+	// or "unknown".
+	Type string `json:"type"`
+	// Arch is the GOARCH the partition was built for, empty if unknown.
+	Arch string `json:"arch,omitempty"`
+	// Size is the partition size in bytes.
+	Size int64 `json:"size"`
+}
+
+// Descriptor summarizes a SIF data object descriptor.
+type Descriptor struct {
+	ID uint32 `json:"id"`
+	// Type is one of "deffile", "envvar", "labels", "partition",
+	// "signature", "generic-json", "generic", "crypto-message" or
+	// "unknown".
+	Type string `json:"type"`
+	Name string `json:"name,omitempty"`
+	// Group is the group ID of the data object, 0 if none.
+	Group uint32 `json:"group,omitempty"`
+	// Link is the descriptor ID, or the group ID if LinkGroup is
+	// true, the data object is linked to, 0 if none.
+	Link      uint32 `json:"link,omitempty"`
+	LinkGroup bool   `json:"linkGroup,omitempty"`
+	Offset    int64  `json:"offset"`
+	Size      int64  `json:"size"`
+}
+
+// Signature describes a SIF signature, signatures are listed but not
+// verified.
+type Signature struct {
+	// ID is the descriptor ID of the signature.
+	ID uint32 `json:"id"`
+	// Link is the descriptor ID, or the group ID if LinkGroup is
+	// true, covered by the signature.
+	Link      uint32 `json:"link"`
+	LinkGroup bool   `json:"linkGroup,omitempty"`
+	// Hash is the hash algorithm used, like "sha384".
+	Hash string `json:"hash"`
+	// Fingerprint is the fingerprint of the signing key.
+	Fingerprint string `json:"fingerprint"`
+}
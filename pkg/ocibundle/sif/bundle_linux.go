@@ -6,16 +6,18 @@
 package sifbundle
 
 import (
-	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
 
-	imageSpecs "github.com/opencontainers/image-spec/specs-go/v1"
 	specs "github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/opencontainers/runtime-tools/generate"
+	"golang.org/x/sys/unix"
 
 	"github.com/sylabs/singularity/pkg/image"
 	"github.com/sylabs/singularity/pkg/ocibundle"
@@ -29,20 +31,121 @@ type sifBundle struct {
 	ocibundle.Bundle
 }
 
-func (s *sifBundle) writeConfig(img *image.Image, g *generate.Generator) error {
-	// check if SIF file contain an OCI image configuration
-	reader, err := image.NewSectionReader(img, "oci-config.json", -1)
-	if err != nil && err != image.ErrNoSection {
-		return fmt.Errorf("failed to read oci-config.json section: %s", err)
-	} else if err == image.ErrNoSection {
-		return tools.SaveBundleConfig(s.bundlePath, g)
+// maxIDFileSize is the maximum size of the passwd and group files read
+// from the root filesystem.
+const maxIDFileSize = 4 << 20
+
+// readRootFsFile reads the regular file name, relative to the root
+// filesystem rootFs, without following any symbolic link so that the file
+// read is always inside the root filesystem.
+func readRootFsFile(rootFs, name string) ([]byte, error) {
+	fd, err := unix.Open(rootFs, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: rootFs, Err: err}
+	}
+
+	elems := strings.Split(name, "/")
+	for i, elem := range elems {
+		flags := unix.O_RDONLY | unix.O_NOFOLLOW | unix.O_CLOEXEC
+		if i < len(elems)-1 {
+			flags |= unix.O_DIRECTORY
+		} else {
+			// don't block on FIFOs
+			flags |= unix.O_NONBLOCK
+		}
+		next, err := unix.Openat(fd, elem, flags, 0)
+		unix.Close(fd)
+		if err == unix.ELOOP {
+			return nil, fmt.Errorf("/%s: symbolic links are not allowed", strings.Join(elems[:i+1], "/"))
+		} else if err != nil {
+			return nil, &os.PathError{Op: "open", Path: "/" + strings.Join(elems[:i+1], "/"), Err: err}
+		}
+		fd = next
+	}
+
+	f := os.NewFile(uintptr(fd), "/"+name)
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	} else if !fi.Mode().IsRegular() {
+		return nil, fmt.Errorf("/%s is not a regular file", name)
+	} else if fi.Size() > maxIDFileSize {
+		return nil, fmt.Errorf("/%s is too large", name)
+	}
+	return ioutil.ReadAll(io.LimitReader(f, maxIDFileSize))
+}
+
+// lookupID returns the numerical ID of an entry of the passwd or group
+// file name of the root filesystem rootFs identified by name or by ID, the
+// third field of the entry. The fourth field, the primary group of a
+// passwd entry, is returned too.
+func lookupID(rootFs, name, id string) (uint32, string, error) {
+	if n, err := strconv.ParseUint(id, 10, 32); err == nil {
+		id = strconv.FormatUint(n, 10)
+	}
+
+	data, err := readRootFsFile(rootFs, name)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, "", err
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) < 4 || (fields[0] != id && fields[2] != id) {
+			continue
+		}
+		n, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil {
+			return 0, "", fmt.Errorf("bad ID for entry %q in /%s", id, name)
+		}
+		return uint32(n), fields[3], nil
+	}
+
+	// numerical IDs don't need an entry
+	if n, err := strconv.ParseUint(id, 10, 32); err == nil {
+		return uint32(n), "", nil
+	}
+	return 0, "", fmt.Errorf("no entry %q found in /%s", id, name)
+}
+
+// resolveUser returns the user ID and the group ID of the user of an
+// OCI image config, either user, uid, user:group or uid:gid, looked up in
+// the passwd and group files of the root filesystem.
+func resolveUser(rootFs, user string) (uint32, uint32, error) {
+	ids := strings.SplitN(user, ":", 2)
+
+	uid, gid, err := lookupID(rootFs, "etc/passwd", ids[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to resolve user %s: %s", ids[0], err)
+	}
+
+	group := gid
+	if len(ids) == 2 {
+		group = ids[1]
+	} else if group == "" {
+		// numerical user without passwd entry
+		group = "0"
+	}
+
+	g, _, err := lookupID(rootFs, "etc/group", group)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to resolve group %s: %s", group, err)
 	}
 
-	var imgConfig imageSpecs.ImageConfig
+	return uid, g, nil
+}
 
-	if err := json.NewDecoder(reader).Decode(&imgConfig); err != nil {
-		return fmt.Errorf("failed to decode oci-config.json: %s", err)
+func (s *sifBundle) writeConfig(img *image.Image, g *generate.Generator) error {
+	// check if SIF file contain an OCI image configuration
+	imgSpec, err := image.GetOCIImageConfig(img)
+	if err != nil {
+		return err
+	} else if imgSpec == nil {
+		return tools.SaveBundleConfig(s.bundlePath, g)
 	}
+	imgConfig := imgSpec.Config
 
 	if len(g.Config.Process.Args) == 1 && g.Config.Process.Args[0] == tools.RunScript {
 		args := imgConfig.Entrypoint
@@ -69,6 +172,15 @@ func (s *sifBundle) writeConfig(img *image.Image, g *generate.Generator) error {
 		}
 	}
 
+	if imgConfig.User != "" && g.Config.Process.User.UID == 0 && g.Config.Process.User.GID == 0 {
+		uid, gid, err := resolveUser(tools.RootFs(s.bundlePath).Path(), imgConfig.User)
+		if err != nil {
+			return err
+		}
+		g.SetProcessUID(uid)
+		g.SetProcessGID(gid)
+	}
+
 	volumes := tools.Volumes(s.bundlePath).Path()
 	for dst := range imgConfig.Volumes {
 		replacer := strings.NewReplacer(string(os.PathSeparator), "_")
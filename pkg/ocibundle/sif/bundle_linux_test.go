@@ -11,6 +11,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"testing"
 
 	"github.com/sylabs/singularity/pkg/ocibundle/tools"
@@ -102,3 +103,93 @@ func TestFromSif(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestResolveUser(t *testing.T) {
+	rootFs, err := ioutil.TempDir("", "rootfs-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(rootFs)
+
+	if err := os.Mkdir(filepath.Join(rootFs, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	passwd := "root:x:0:0:root:/root:/bin/sh\nnginx:x:101:102:nginx:/var/cache/nginx:/sbin/nologin\n"
+	group := "root:x:0:\nnginx:x:102:\nwww:x:33:nginx\n"
+	if err := ioutil.WriteFile(filepath.Join(rootFs, "etc/passwd"), []byte(passwd), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(rootFs, "etc/group"), []byte(group), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		user string
+		uid  uint32
+		gid  uint32
+		fail bool
+	}{
+		{user: "nginx", uid: 101, gid: 102},
+		{user: "101", uid: 101, gid: 102},
+		{user: "nginx:www", uid: 101, gid: 33},
+		{user: "nginx:0", uid: 101, gid: 0},
+		{user: "1000", uid: 1000, gid: 0},
+		{user: "1000:1000", uid: 1000, gid: 1000},
+		{user: "unknown", fail: true},
+		{user: "nginx:unknown", fail: true},
+	}
+	for _, tt := range tests {
+		uid, gid, err := resolveUser(rootFs, tt.user)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("unexpected success for user %q", tt.user)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error for user %q: %s", tt.user, err)
+		} else if uid != tt.uid || gid != tt.gid {
+			t.Errorf("unexpected IDs %d:%d for user %q", uid, gid, tt.user)
+		}
+	}
+
+	// the file content isn't reported in errors
+	passwd = "secret:x:bad:0::/:/bin/sh\n"
+	if err := ioutil.WriteFile(filepath.Join(rootFs, "etc/passwd"), []byte(passwd), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := resolveUser(rootFs, "secret"); err == nil {
+		t.Errorf("unexpected success with a bad ID")
+	} else if strings.Contains(err.Error(), "bad:") || strings.Contains(err.Error(), rootFs) {
+		t.Errorf("unexpected error content: %s", err)
+	}
+
+	// symbolic links are refused, they could point outside of the root
+	// filesystem
+	hostEtc := filepath.Join(rootFs, "host")
+	if err := os.Mkdir(hostEtc, 0755); err != nil {
+		t.Fatal(err)
+	}
+	hostPasswd := filepath.Join(hostEtc, "passwd")
+	if err := ioutil.WriteFile(hostPasswd, []byte("nginx:x:0:0::/:/bin/sh\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	links := []struct {
+		path   string
+		target string
+	}{
+		{"etc/passwd", hostPasswd},
+		{"etc", hostEtc},
+	}
+	for _, l := range links {
+		if err := os.RemoveAll(filepath.Join(rootFs, l.path)); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(l.target, filepath.Join(rootFs, l.path)); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := resolveUser(rootFs, "nginx"); err == nil {
+			t.Errorf("unexpected success with symbolic link %s", l.path)
+		}
+	}
+}
@@ -39,6 +39,8 @@ type FileConfig struct {
 	AllowContainerDir       bool     `default:"yes" authorized:"yes,no" directive:"allow container dir"`
 	AlwaysUseNv             bool     `default:"no" authorized:"yes,no" directive:"always use nv"`
 	SharedLoopDevices       bool     `default:"no" authorized:"yes,no" directive:"shared loop devices"`
+	EnforceVerifyPolicy     bool     `default:"no" authorized:"yes,no" directive:"enforce verify policy"`
+	EnableVerity            bool     `default:"no" authorized:"yes,no" directive:"enable verity"`
 	MaxLoopDevices          uint     `default:"256" directive:"max loop devices"`
 	SessiondirMaxSize       uint     `default:"16" directive:"sessiondir max size"`
 	MountDev                string   `default:"yes" authorized:"yes,no,minimal" directive:"mount dev"`
@@ -53,4 +55,8 @@ type FileConfig struct {
 	CniPluginPath           string   `directive:"cni plugin path"`
 	MksquashfsPath          string   `directive:"mksquashfs path"`
 	CryptsetupPath          string   `directive:"cryptsetup path"`
+	TrustBundle             string   `directive:"trust bundle"`
+	TrustBundleSigners      string   `directive:"trust bundle signers"`
+	KeyProvider             string   `directive:"key provider"`
+	KeyProviderHelper       string   `directive:"key provider helper"`
 }
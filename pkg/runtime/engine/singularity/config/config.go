@@ -6,6 +6,7 @@
 package singularity
 
 import (
+	"github.com/sylabs/singularity/internal/pkg/syecl"
 	"github.com/sylabs/singularity/pkg/image"
 )
 
@@ -34,6 +35,7 @@ type JSONConfig struct {
 	OpenFd            []int         `json:"openFd,omitempty"`
 	TargetGID         []int         `json:"targetGID,omitempty"`
 	Image             string        `json:"image"`
+	ImageArch         string        `json:"imageArch,omitempty"`
 	Workdir           string        `json:"workdir,omitempty"`
 	CgroupsPath       string        `json:"cgroupsPath,omitempty"`
 	HomeSource        string        `json:"homedir,omitempty"`
@@ -48,6 +50,7 @@ type JSONConfig struct {
 	DNS               string        `json:"dns,omitempty"`
 	Cwd               string        `json:"cwd,omitempty"`
 	SessionLayer      string        `json:"sessionLayer,omitempty"`
+	VerityRootHash    string        `json:"verityRootHash,omitempty"`
 	EncryptionKey     []byte        `json:"encryptionKey,omitempty"`
 	TargetUID         int           `json:"targetUID,omitempty"`
 	WritableImage     bool          `json:"writableImage,omitempty"`
@@ -67,6 +70,14 @@ type JSONConfig struct {
 	DeleteImage       bool          `json:"deleteImage,omitempty"`
 	Fakeroot          bool          `json:"fakeroot,omitempty"`
 	SignalPropagation bool          `json:"signalPropagation,omitempty"`
+
+	// OverlayKeys holds the keys of the encrypted overlay partitions
+	// indexed by the path of their image.
+	OverlayKeys map[string][]byte `json:"overlayKeys,omitempty"`
+
+	// EclDecision holds the ECL decision about the image waiting to be
+	// written to the audit log.
+	EclDecision *syecl.Decision `json:"eclDecision,omitempty"`
 }
 
 // SetImage sets the container image path to be used by EngineConfig.JSON.
@@ -79,6 +90,18 @@ func (e *EngineConfig) GetImage() string {
 	return e.JSON.Image
 }
 
+// SetImageArch sets the architecture of the system partition to use
+// with multi-architecture images.
+func (e *EngineConfig) SetImageArch(arch string) {
+	e.JSON.ImageArch = arch
+}
+
+// GetImageArch retrieves the architecture of the system partition to
+// use with multi-architecture images.
+func (e *EngineConfig) GetImageArch() string {
+	return e.JSON.ImageArch
+}
+
 // SetKey sets the key for the image's system partition.
 func (e *EngineConfig) SetEncryptionKey(key []byte) {
 	e.JSON.EncryptionKey = key
@@ -89,6 +112,45 @@ func (e *EngineConfig) GetEncryptionKey() []byte {
 	return e.JSON.EncryptionKey
 }
 
+// SetVerityRootHash sets the dm-verity root hash of the image's system
+// partition, as read while its signatures were verified.
+func (e *EngineConfig) SetVerityRootHash(hash string) {
+	e.JSON.VerityRootHash = hash
+}
+
+// GetVerityRootHash retrieves the verified dm-verity root hash of the
+// image's system partition.
+func (e *EngineConfig) GetVerityRootHash() string {
+	return e.JSON.VerityRootHash
+}
+
+// SetEclDecision sets the ECL decision about the image, to be written
+// to the audit log before the container is created.
+func (e *EngineConfig) SetEclDecision(d *syecl.Decision) {
+	e.JSON.EclDecision = d
+}
+
+// GetEclDecision retrieves the ECL decision about the image waiting to
+// be written to the audit log.
+func (e *EngineConfig) GetEclDecision() *syecl.Decision {
+	return e.JSON.EclDecision
+}
+
+// SetOverlayEncryptionKey sets the key for the encrypted overlay
+// partition of the image located at path.
+func (e *EngineConfig) SetOverlayEncryptionKey(path string, key []byte) {
+	if e.JSON.OverlayKeys == nil {
+		e.JSON.OverlayKeys = make(map[string][]byte)
+	}
+	e.JSON.OverlayKeys[path] = key
+}
+
+// GetOverlayEncryptionKey retrieves the key for the encrypted overlay
+// partition of the image located at path.
+func (e *EngineConfig) GetOverlayEncryptionKey(path string) []byte {
+	return e.JSON.OverlayKeys[path]
+}
+
 // SetWritableImage defines the container image as writable or not.
 func (e *EngineConfig) SetWritableImage(writable bool) {
 	e.JSON.WritableImage = writable
@@ -22,13 +22,14 @@ import (
 
 // EngineConfig stores both the JSONConfig and the FileConfig
 type EngineConfig struct {
-	JSON      *JSONConfig                `json:"jsonConfig"`
-	OciConfig *oci.Config                `json:"ociConfig"`
-	File      *config.FileConfig         `json:"-"`
-	Network   *network.Setup             `json:"-"`
-	Cgroups   *cgroups.Manager           `json:"-"`
-	CryptDev  string                     `json:"-"`
-	Plugin    map[string]json.RawMessage `json:"plugin"` // Plugin is the raw JSON representation of the plugin configurations
+	JSON       *JSONConfig                `json:"jsonConfig"`
+	OciConfig  *oci.Config                `json:"ociConfig"`
+	File       *config.FileConfig         `json:"-"`
+	Network    *network.Setup             `json:"-"`
+	Cgroups    *cgroups.Manager           `json:"-"`
+	CryptDevs  []string                   `json:"-"`
+	VerityDevs []string                   `json:"-"`
+	Plugin     map[string]json.RawMessage `json:"plugin"` // Plugin is the raw JSON representation of the plugin configurations
 }
 
 // FuseInfo stores the FUSE-related information required or provided by
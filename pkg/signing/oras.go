@@ -0,0 +1,124 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package signing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/sylabs/singularity/internal/pkg/oras"
+	"github.com/sylabs/singularity/pkg/sypgp"
+	"golang.org/x/crypto/openpgp/clearsign"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+const orasSignatureHeader = "# Singularity ORAS signature v1"
+
+// ORASSigner returns a function signing the payload describing a SIF image
+// pushed to a registry with the private key of the named keyring selected
+// like Sign does.
+func ORASSigner(keyring string, keyIdx int) (oras.SignFunc, error) {
+	entity, key, err := loadSigningKey(keyring, keyIdx)
+	if err != nil {
+		return nil, err
+	}
+	return orasSignature(entity.PrimaryKey.Fingerprint, key), nil
+}
+
+func orasSignature(fingerprint [20]byte, key *packet.PrivateKey) oras.SignFunc {
+	return func(payload []byte) ([]byte, error) {
+		// the signing entity is part of the signed data, like the
+		// entity of sandbox manifests
+		var doc bytes.Buffer
+		fmt.Fprintln(&doc, orasSignatureHeader)
+		fmt.Fprintf(&doc, "%s%X\n", manifestFingerprint, fingerprint)
+		doc.Write(payload)
+
+		return pgpSignature(key)(doc.String())
+	}
+}
+
+// parseORASSignature returns the signing entity fingerprint and the
+// payload of a detached signature plaintext.
+func parseORASSignature(plaintext []byte) (string, *oras.SignaturePayload, error) {
+	lines := strings.SplitN(string(plaintext), "\n", 3)
+	if len(lines) != 3 || lines[0] != orasSignatureHeader {
+		return "", nil, fmt.Errorf("unsupported signature format")
+	}
+	if !strings.HasPrefix(lines[1], manifestFingerprint) {
+		return "", nil, fmt.Errorf("missing signing entity fingerprint in signature")
+	}
+	fingerprint := strings.TrimPrefix(lines[1], manifestFingerprint)
+	if len(fingerprint) != 40 {
+		return "", nil, fmt.Errorf("missing signing entity fingerprint in signature")
+	}
+
+	payload := new(oras.SignaturePayload)
+	dec := json.NewDecoder(strings.NewReader(lines[2]))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(payload); err != nil {
+		return "", nil, fmt.Errorf("while decoding signature payload: %s", err)
+	}
+	return fingerprint, payload, nil
+}
+
+// VerifyORAS verifies the detached signature sig of a SIF image stored in a
+// registry, the signing key is looked up like Verify does for PGP signatures,
+// and checks that it was made for the image sig was found for. Returns a
+// string of formatted output and true, if theres no local key matching the
+// signer entity. Policies and X.509 signatures are not supported for
+// detached signatures.
+func VerifyORAS(ctx context.Context, sig *oras.Signature, keyServiceURI, authToken string, localVerify bool, opts VerifyOptions) (string, bool, error) {
+	if opts.Policy != nil {
+		return "", false, fmt.Errorf("verification policies are not supported for detached signatures")
+	}
+
+	block, _ := clearsign.Decode(sig.Data)
+	if block == nil {
+		return "", false, fmt.Errorf("detached signature corrupted, unable to read data")
+	}
+	fingerprint, payload, err := parseORASSignature(block.Plaintext)
+	if err != nil {
+		return "", false, fmt.Errorf("detached signature corrupted: %s", err)
+	}
+
+	keyring := sypgp.NewHandle("", sypgp.KeyringHandleOpt(opts.Keyring))
+	trust, err := keyring.LoadTrust()
+	if err != nil {
+		return "", false, fmt.Errorf("could not load key trust levels: %s", err)
+	}
+
+	green := color.New(color.FgGreen).SprintFunc()
+	red := color.New(color.FgRed).SprintFunc()
+
+	author := fmt.Sprintf("Image is signed by 1 key(s):\n\n")
+	author += fmt.Sprintf("Verifying detached signature of manifest %s:\n", sig.Manifest)
+	author += fingerprint + "\n"
+
+	// (1) try to get identity of signer
+	c := checkSigner(ctx, keyring, trust, nil, block, sig.Data, fingerprint, keyServiceURI, authToken, localVerify, true, opts)
+	author += c.report
+	fail := c.err != nil
+
+	// (2) Verify the signature was made for this image, the
+	// integrity of the image is ensured by its digest
+	if *payload == sig.SignaturePayload {
+		author += fmt.Sprintf("%-18s Signed image verified\n", green("[OK]"))
+	} else {
+		author += fmt.Sprintf("%-18s signature made for %s@%s with SIF %s\n", red("[MISMATCH]"), payload.Reference, payload.Manifest, payload.Image)
+		fail = true
+	}
+	author += fmt.Sprintf("\n")
+
+	if fail {
+		return author, c.notLocal, ErrVerificationFail
+	}
+	return author, c.notLocal, nil
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package signing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sylabs/singularity/internal/pkg/oras"
+	"github.com/sylabs/singularity/pkg/sypgp"
+)
+
+func TestVerifyORAS(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+
+	dir, err := ioutil.TempDir("", "oras-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	keyringDir := filepath.Join(dir, "sypgp")
+	os.Setenv("SINGULARITY_SYPGPDIR", keyringDir)
+	defer os.Unsetenv("SINGULARITY_SYPGPDIR")
+
+	keyring := sypgp.NewHandle(keyringDir)
+	if _, err := keyring.GenKeyPair(sypgp.GenKeyPairOptions{Name: "signer", Email: "signer@my.info", KeyLength: 1024}); err != nil {
+		t.Fatalf("failed to generate key pair: %s", err)
+	}
+
+	payload := oras.SignaturePayload{
+		Reference: "registry.example.com/test/image",
+		Manifest:  digest.FromString("manifest"),
+		Image:     digest.FromString("image"),
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatalf("failed to encode payload: %s", err)
+	}
+
+	sign, err := ORASSigner("", 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	signature, err := sign(data)
+	if err != nil {
+		t.Fatalf("unexpected error while signing: %s", err)
+	}
+
+	verify := func(p oras.SignaturePayload, signature []byte) error {
+		sig := &oras.Signature{SignaturePayload: p, Data: signature}
+		_, _, err := VerifyORAS(context.Background(), sig, "", "", true, VerifyOptions{})
+		return err
+	}
+
+	if err := verify(payload, signature); err != nil {
+		t.Fatalf("unexpected verification error: %s", err)
+	}
+
+	// the signature of another image doesn't apply
+	other := payload
+	other.Manifest = digest.FromString("other manifest")
+	if err := verify(other, signature); err != ErrVerificationFail {
+		t.Errorf("unexpected verification result for another manifest: %v", err)
+	}
+	other = payload
+	other.Reference = "registry.example.com/test/other"
+	if err := verify(other, signature); err != ErrVerificationFail {
+		t.Errorf("unexpected verification result for another repository: %v", err)
+	}
+
+	// a modified payload fails
+	tampered := bytes.Replace(signature, []byte(payload.Image.Hex()), []byte(other.Manifest.Hex()), 1)
+	if err := verify(payload, tampered); err != ErrVerificationFail {
+		t.Errorf("unexpected verification result for a modified signature: %v", err)
+	}
+
+	// a signature made with a key missing from the keyring fails
+	if err := os.RemoveAll(keyringDir); err != nil {
+		t.Fatalf("failed to remove keyring: %s", err)
+	}
+	if err := verify(payload, signature); err != ErrVerificationFail {
+		t.Errorf("unexpected verification result: %v", err)
+	}
+}
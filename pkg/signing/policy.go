@@ -0,0 +1,193 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package signing
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/sylabs/sif/pkg/sif"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// PolicySystemPartitions is the partition name of a policy designating the
+// system partitions of every architecture.
+const PolicySystemPartitions = "system"
+
+// policyDatatypes maps the partition names of a policy to SIF data types.
+var policyDatatypes = map[string]sif.Datatype{
+	"deffile":        sif.DataDeffile,
+	"envvar":         sif.DataEnvVar,
+	"labels":         sif.DataLabels,
+	"generic-json":   sif.DataGenericJSON,
+	"generic":        sif.DataGeneric,
+	"crypto-message": sif.DataCryptoMessage,
+}
+
+// Policy describes the signatures a SIF image must carry to be verified.
+type Policy struct {
+	// Partitions lists the data objects which must be present and signed,
+	// either "system" or a data object type: "deffile", "envvar", "labels",
+	// "generic-json", "generic" or "crypto-message". Defaults to "system".
+	Partitions []string `yaml:"Partitions"`
+	// Signers sets the signatures required for each data object.
+	Signers PolicySigners `yaml:"Signers"`
+	// NotBefore rejects signatures created before this date.
+	NotBefore time.Time `yaml:"NotBefore"`
+	// AllowLocalKeys allows PGP signatures verified with keys of the local
+	// keyring. When false, signing keys must be found in the key server.
+	// Defaults to true.
+	AllowLocalKeys *bool `yaml:"AllowLocalKeys"`
+}
+
+// PolicySigners sets the number of signatures required for each data object
+// and the entities allowed to provide them.
+type PolicySigners struct {
+	// Required is the minimum number of signatures from distinct signers,
+	// defaults to 1.
+	Required int `yaml:"Required"`
+	// Fingerprints lists the PGP key or X.509 certificate fingerprints
+	// of the accepted signers, any signer is accepted when empty.
+	Fingerprints []string `yaml:"Fingerprints"`
+}
+
+// PolicyReport is the result of a policy evaluation, used for json output.
+type PolicyReport struct {
+	Satisfied  bool
+	Violations []string
+}
+
+// signatureResult is the result of the verification of a signature block.
+type signatureResult struct {
+	// covers lists the descriptor IDs covered by the signature.
+	covers      []uint32
+	fingerprint string
+	created     time.Time
+	// local is true for PGP signatures verified with a local key.
+	local bool
+	x509  bool
+	// valid is true if the signature, the signer and the data integrity
+	// have been verified.
+	valid bool
+	// verityParams holds the verity parameters covered by the signature.
+	verityParams []byte
+}
+
+// LoadPolicy reads and validates the policy found in the YAML file path.
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read policy file: %s", err)
+	}
+
+	p := &Policy{}
+	if err := yaml.UnmarshalStrict(data, p); err != nil {
+		return nil, fmt.Errorf("while parsing policy %s: %s", path, err)
+	}
+	if err := p.validate(); err != nil {
+		return nil, fmt.Errorf("invalid policy %s: %s", path, err)
+	}
+	return p, nil
+}
+
+// validate checks the policy values and sets defaults.
+func (p *Policy) validate() error {
+	if len(p.Partitions) == 0 {
+		p.Partitions = []string{PolicySystemPartitions}
+	}
+	for _, name := range p.Partitions {
+		if _, ok := policyDatatypes[name]; !ok && name != PolicySystemPartitions {
+			return fmt.Errorf("unknown partition %q", name)
+		}
+	}
+
+	for i, fp := range p.Signers.Fingerprints {
+		decoded, err := hex.DecodeString(fp)
+		if err != nil || len(decoded) != 20 {
+			return fmt.Errorf("expecting a 40 chars hex fingerprint string: %s", fp)
+		}
+		p.Signers.Fingerprints[i] = strings.ToUpper(fp)
+	}
+
+	if p.Signers.Required < 0 {
+		return fmt.Errorf("required signatures can't be negative")
+	} else if p.Signers.Required == 0 {
+		p.Signers.Required = 1
+	}
+	if len(p.Signers.Fingerprints) > 0 && p.Signers.Required > len(p.Signers.Fingerprints) {
+		return fmt.Errorf("%d signatures required from %d fingerprints", p.Signers.Required, len(p.Signers.Fingerprints))
+	}
+	return nil
+}
+
+// allowLocalKeys returns true if signatures verified with local keys
+// are accepted.
+func (p *Policy) allowLocalKeys() bool {
+	return p.AllowLocalKeys == nil || *p.AllowLocalKeys
+}
+
+// accepts returns true if the signature result r is accepted by the policy.
+func (p *Policy) accepts(r *signatureResult) bool {
+	if !r.valid {
+		return false
+	}
+	if r.local && !r.x509 && !p.allowLocalKeys() {
+		return false
+	}
+	if !p.NotBefore.IsZero() && r.created.Before(p.NotBefore) {
+		return false
+	}
+	if len(p.Signers.Fingerprints) == 0 {
+		return true
+	}
+	for _, fp := range p.Signers.Fingerprints {
+		if fp == r.fingerprint {
+			return true
+		}
+	}
+	return false
+}
+
+// evaluate returns the policy violations of the image fimg given the
+// results of the verification of its signatures.
+func (p *Policy) evaluate(fimg *sif.FileImage, results []*signatureResult) []string {
+	var violations []string
+
+	for _, name := range p.Partitions {
+		var descr []*sif.Descriptor
+		if name == PolicySystemPartitions {
+			descr, _ = getSystemPartitions(fimg)
+		} else {
+			descr, _ = getDataPartitionToSign(fimg, policyDatatypes[name])
+		}
+		if len(descr) == 0 {
+			violations = append(violations, fmt.Sprintf("no %s partition found", name))
+			continue
+		}
+
+		for _, d := range descr {
+			signers := make(map[string]bool)
+			for _, r := range results {
+				if !p.accepts(r) {
+					continue
+				}
+				for _, id := range r.covers {
+					if id == d.ID {
+						signers[r.fingerprint] = true
+					}
+				}
+			}
+			if len(signers) < p.Signers.Required {
+				violations = append(violations, fmt.Sprintf("%s partition (ID %d) has %d accepted signature(s), %d required", name, d.ID, len(signers), p.Signers.Required))
+			}
+		}
+	}
+
+	return violations
+}
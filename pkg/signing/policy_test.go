@@ -0,0 +1,248 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package signing
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sylabs/singularity/pkg/sypgp"
+)
+
+func TestLoadPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "policy-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the installed example must be valid once uncommented
+	data, err := ioutil.ReadFile("../../etc/verify-policy.yaml.example")
+	if err != nil {
+		t.Fatalf("failed to read policy example: %s", err)
+	}
+	example := strings.SplitAfter(string(data), "# Example:\n")[1]
+	example = strings.Replace(example, "#", "", -1)
+
+	tests := []struct {
+		name     string
+		policy   string
+		valid    bool
+		required int
+	}{
+		{name: "Empty", policy: "", valid: true, required: 1},
+		{name: "Example", policy: example, valid: true, required: 2},
+		{name: "UnknownPartition", policy: "Partitions: [rootfs]"},
+		{name: "UnknownField", policy: "Required: 1"},
+		{name: "BadFingerprint", policy: "Signers:\n  Fingerprints: [0123]"},
+		{name: "TooManyRequired", policy: "Signers:\n  Required: 2\n  Fingerprints: [5994BE54C31CF1B5E1994F987C52CF6D055F072B]"},
+		{name: "NegativeRequired", policy: "Signers:\n  Required: -1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name+".yaml")
+			if err := ioutil.WriteFile(path, []byte(tt.policy), 0644); err != nil {
+				t.Fatalf("failed to write %s: %s", path, err)
+			}
+
+			p, err := LoadPolicy(path)
+			if !tt.valid {
+				if err == nil {
+					t.Fatalf("unexpected success while loading invalid policy")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error while loading policy: %s", err)
+			}
+			if len(p.Partitions) == 0 || p.Signers.Required != tt.required {
+				t.Errorf("unexpected policy: %+v", p)
+			}
+		})
+	}
+}
+
+func TestVerifyPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "policy-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	root := createCert(t, 1, "root", nil, x509.KeyUsageCertSign, nil)
+	rootPath := filepath.Join(dir, "root.pem")
+	writePEM(t, rootPath, x509CertBlock, root.cert.Raw)
+
+	var fingerprints []string
+	path := filepath.Join(dir, "image.sif")
+	createTestSIF(t, path)
+
+	// sign the image with two distinct signers
+	for i, name := range []string{"alice", "bob"} {
+		signer := createCert(t, int64(i+2), name, root, x509.KeyUsageDigitalSignature, nil)
+		certPath := filepath.Join(dir, name+".pem")
+		writePEM(t, certPath, x509CertBlock, signer.cert.Raw)
+		keyDer, err := x509.MarshalECPrivateKey(signer.key)
+		if err != nil {
+			t.Fatalf("failed to marshal private key: %s", err)
+		}
+		keyPath := filepath.Join(dir, name+"-key.pem")
+		writePEM(t, keyPath, "EC PRIVATE KEY", keyDer)
+
+		if err := SignX509(path, 0, false, false, false, certPath, keyPath); err != nil {
+			t.Fatalf("unexpected error while signing: %s", err)
+		}
+		fp := sha1.Sum(signer.cert.Raw)
+		fingerprints = append(fingerprints, strings.ToUpper(hex.EncodeToString(fp[:])))
+	}
+	other := strings.Repeat("AB", 20)
+
+	tests := []struct {
+		name      string
+		policy    string
+		satisfied bool
+	}{
+		{name: "Default", policy: "", satisfied: true},
+		{name: "OneSigner", policy: "Signers:\n  Fingerprints: [" + fingerprints[1] + "]", satisfied: true},
+		{name: "TwoSigners", policy: "Signers:\n  Required: 2", satisfied: true},
+		{name: "ThreeSigners", policy: "Signers:\n  Required: 3"},
+		{name: "UnknownSigners", policy: "Signers:\n  Required: 2\n  Fingerprints: [" + fingerprints[0] + ", " + other + "]"},
+		{name: "NotBefore", policy: "NotBefore: 2100-01-01T00:00:00Z"},
+		{name: "Deffile", policy: "Partitions: [system, deffile]"},
+		{name: "NoLocalKeys", policy: "AllowLocalKeys: false", satisfied: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policyPath := filepath.Join(dir, tt.name+".yaml")
+			if err := ioutil.WriteFile(policyPath, []byte(tt.policy), 0644); err != nil {
+				t.Fatalf("failed to write %s: %s", policyPath, err)
+			}
+			policy, err := LoadPolicy(policyPath)
+			if err != nil {
+				t.Fatalf("unexpected error while loading policy: %s", err)
+			}
+
+			opts := VerifyOptions{CABundle: rootPath, Policy: policy}
+			out, _, err := Verify(context.Background(), path, "", 0, false, false, "", true, true, opts)
+			if tt.satisfied && err != nil {
+				t.Fatalf("unexpected verification error: %s", err)
+			} else if !tt.satisfied && err != ErrVerificationFail {
+				t.Fatalf("unexpected verification result: %v", err)
+			}
+
+			var list KeyList
+			if err := json.Unmarshal([]byte(out), &list); err != nil {
+				t.Fatalf("failed to decode verify output: %s", err)
+			}
+			if list.Signatures != 2 || list.Policy == nil {
+				t.Fatalf("unexpected verify output: %s", out)
+			}
+			if list.Policy.Satisfied != tt.satisfied || tt.satisfied != (len(list.Policy.Violations) == 0) {
+				t.Errorf("unexpected policy report: %+v", list.Policy)
+			}
+		})
+	}
+}
+
+func TestVerifyPolicyPGP(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+
+	dir, err := ioutil.TempDir("", "policy-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	keyringDir := filepath.Join(dir, "sypgp")
+	os.Setenv("SINGULARITY_SYPGPDIR", keyringDir)
+	defer os.Unsetenv("SINGULARITY_SYPGPDIR")
+
+	type signer struct {
+		fingerprint [20]byte
+		sign        func(sifhash string) ([]byte, error)
+	}
+	signers := make(map[string]signer)
+
+	keyring := sypgp.NewHandle(keyringDir)
+	for _, name := range []string{"alice", "bob"} {
+		e, err := keyring.GenKeyPair(sypgp.GenKeyPairOptions{Name: name, Email: name + "@my.info", KeyType: sypgp.KeyTypeECDSAP256})
+		if err != nil {
+			t.Fatalf("failed to generate key pair: %s", err)
+		}
+		signers[name] = signer{
+			fingerprint: e.PrimaryKey.Fingerprint,
+			sign:        pgpSignature(sypgp.SigningKey(e, time.Now())),
+		}
+	}
+	bob := fmt.Sprintf("%X", signers["bob"].fingerprint)
+
+	tests := []struct {
+		name   string
+		policy string
+		// signatures lists the signers and the entities they claim
+		signatures [][2]string
+		satisfied  bool
+	}{
+		{
+			name:       "TwoSigners",
+			policy:     "Signers:\n  Required: 2",
+			signatures: [][2]string{{"alice", "alice"}, {"bob", "bob"}},
+			satisfied:  true,
+		},
+		{
+			name:       "ForgedEntity",
+			policy:     "Signers:\n  Fingerprints: [" + bob + "]",
+			signatures: [][2]string{{"alice", "bob"}},
+		},
+		{
+			name:       "OneKeyTwoEntities",
+			policy:     "Signers:\n  Required: 2",
+			signatures: [][2]string{{"alice", "alice"}, {"alice", "bob"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name+".sif")
+			createTestSIF(t, path)
+			for _, s := range tt.signatures {
+				if err := addSignatures(path, 0, false, false, false, signers[s[1]].fingerprint, signers[s[0]].sign); err != nil {
+					t.Fatalf("unexpected error while signing: %s", err)
+				}
+			}
+
+			policyPath := filepath.Join(dir, tt.name+".yaml")
+			if err := ioutil.WriteFile(policyPath, []byte(tt.policy), 0644); err != nil {
+				t.Fatalf("failed to write %s: %s", policyPath, err)
+			}
+			policy, err := LoadPolicy(policyPath)
+			if err != nil {
+				t.Fatalf("unexpected error while loading policy: %s", err)
+			}
+
+			out, _, err := Verify(context.Background(), path, "", 0, false, false, "", true, false, VerifyOptions{Policy: policy})
+			if tt.satisfied && err != nil {
+				t.Fatalf("unexpected verification error: %s\n%s", err, out)
+			} else if !tt.satisfied && err != ErrVerificationFail {
+				t.Fatalf("unexpected verification result: %v\n%s", err, out)
+			}
+		})
+	}
+}
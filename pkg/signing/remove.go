@@ -0,0 +1,190 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package signing
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/sylabs/sif/pkg/sif"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+)
+
+// pendingSignature is a signature block waiting to be added to an image.
+type pendingSignature struct {
+	groupid   uint32
+	link      uint32
+	signature []byte
+}
+
+// sifBackup holds the parts of a SIF image modified while updating its
+// signatures, in order to restore the image on error.
+type sifBackup struct {
+	size int64
+	// head holds the global header and the descriptor table.
+	head []byte
+	// data holds the data of the removed objects by file offset.
+	data map[int64][]byte
+}
+
+// RemoveSignatures removes from the container found at cpath the
+// signatures made by the entity fingerprint, or by any entity if
+// fingerprint is empty. Only the signatures of the descriptor id, or of
+// the group id if isGroup is set, are removed when id is not zero. It
+// returns the number of removed signatures.
+func RemoveSignatures(cpath string, id uint32, isGroup bool, fingerprint string) (int, error) {
+	fimg, err := sif.LoadContainer(cpath, false)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load sif container file: %s", err)
+	}
+	defer fimg.UnloadContainer()
+
+	var stale []uint32
+	if id == 0 {
+		stale = findSignatures(&fimg, 0, fingerprint)
+	} else if isGroup {
+		stale = findSignatures(&fimg, id|sif.DescrGroupMask, fingerprint)
+	} else {
+		if _, _, err := fimg.GetFromDescrID(id); err != nil {
+			return 0, fmt.Errorf("no descriptor found for id %d", id)
+		}
+		stale = findSignatures(&fimg, id, fingerprint)
+	}
+	if len(stale) == 0 {
+		return 0, fmt.Errorf("no matching signature found")
+	}
+
+	if err := updateSignatures(&fimg, [20]byte{}, nil, stale); err != nil {
+		return 0, err
+	}
+	return len(stale), nil
+}
+
+// findSignatures returns the descriptor IDs of the signatures linked to
+// the descriptor or group link, or of all the signatures if link is zero,
+// made by the entity fingerprint or by any entity if fingerprint is empty.
+func findSignatures(fimg *sif.FileImage, link uint32, fingerprint string) []uint32 {
+	var ids []uint32
+
+	for _, d := range fimg.DescrArr {
+		if !d.Used || d.Datatype != sif.DataSignature {
+			continue
+		}
+		if link != 0 && d.Link != link {
+			continue
+		}
+		if fingerprint != "" {
+			entity, err := d.GetEntityString()
+			if err != nil || !strings.EqualFold(entity, fingerprint) {
+				continue
+			}
+		}
+		ids = append(ids, d.ID)
+	}
+
+	return ids
+}
+
+// updateSignatures adds the signature blocks sigs made by the entity
+// fingerprint to fimg and removes the signature descriptors stale. The
+// image is restored to its previous state on error.
+func updateSignatures(fimg *sif.FileImage, fingerprint [20]byte, sigs []pendingSignature, stale []uint32) (err error) {
+	backup, err := backupSIF(fimg, stale)
+	if err != nil {
+		return fmt.Errorf("while saving SIF container state: %s", err)
+	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		if rerr := backup.restore(fimg); rerr != nil {
+			sylog.Errorf("Could not restore %s, the image may be corrupted: %s", fimg.Fp.Name(), rerr)
+		}
+	}()
+
+	// new signatures are added first and the data of removed objects
+	// is zeroed in place: DeleteObject compacts the image based on the
+	// file size known at load time, it would shrink the data section
+	// over the new signatures
+	for _, s := range sigs {
+		if err = sifAddSignature(fimg, s.groupid, s.link, fingerprint, s.signature); err != nil {
+			return fmt.Errorf("failed adding signature block to SIF container file: %s", err)
+		}
+	}
+	for _, id := range stale {
+		sylog.Debugf("Removing signature descriptor %d", id)
+		if err = fimg.DeleteObject(id, sif.DelZero); err != nil {
+			return fmt.Errorf("failed removing signature %d from SIF container file: %s", id, err)
+		}
+	}
+
+	return nil
+}
+
+// backupSIF saves the global header, the descriptor table and the data of
+// the objects which will be removed from fimg.
+func backupSIF(fimg *sif.FileImage, remove []uint32) (*sifBackup, error) {
+	fi, err := fimg.Fp.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	b := &sifBackup{
+		size: fi.Size(),
+		head: make([]byte, fimg.Header.Dataoff),
+		data: make(map[int64][]byte),
+	}
+	if err := readAt(fimg.Fp, b.head, 0); err != nil {
+		return nil, err
+	}
+
+	for _, id := range remove {
+		d, _, err := fimg.GetFromDescrID(id)
+		if err != nil {
+			return nil, err
+		}
+		data := make([]byte, d.Filelen)
+		if err := readAt(fimg.Fp, data, d.Fileoff); err != nil {
+			return nil, err
+		}
+		b.data[d.Fileoff] = data
+	}
+
+	return b, nil
+}
+
+// restore writes back the saved state to fimg.
+func (b *sifBackup) restore(fimg *sif.FileImage) error {
+	if err := fimg.Fp.Truncate(b.size); err != nil {
+		return err
+	}
+	if err := writeAt(fimg.Fp, b.head, 0); err != nil {
+		return err
+	}
+	for off, data := range b.data {
+		if err := writeAt(fimg.Fp, data, off); err != nil {
+			return err
+		}
+	}
+	return fimg.Fp.Sync()
+}
+
+func readAt(f sif.ReadWriter, data []byte, off int64) error {
+	if _, err := f.Seek(off, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := io.ReadFull(f, data)
+	return err
+}
+
+func writeAt(f sif.ReadWriter, data []byte, off int64) error {
+	if _, err := f.Seek(off, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := f.Write(data)
+	return err
+}
@@ -0,0 +1,183 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package signing
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sylabs/sif/pkg/sif"
+)
+
+// signatureCount returns the number of signatures of the SIF image path.
+func signatureCount(t *testing.T, path string) int {
+	fimg, err := sif.LoadContainer(path, true)
+	if err != nil {
+		t.Fatalf("failed to load %s: %s", path, err)
+	}
+	defer fimg.UnloadContainer()
+
+	return len(findSignatures(&fimg, 0, ""))
+}
+
+func TestRemoveSignatures(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remove-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	root := createCert(t, 1, "root", nil, x509.KeyUsageCertSign, nil)
+	rootPath := filepath.Join(dir, "root.pem")
+	writePEM(t, rootPath, x509CertBlock, root.cert.Raw)
+
+	var certs, keys, fingerprints []string
+	for i, name := range []string{"alice", "bob"} {
+		signer := createCert(t, int64(i+2), name, root, x509.KeyUsageDigitalSignature, nil)
+		certPath := filepath.Join(dir, name+".pem")
+		writePEM(t, certPath, x509CertBlock, signer.cert.Raw)
+		keyDer, err := x509.MarshalECPrivateKey(signer.key)
+		if err != nil {
+			t.Fatalf("failed to marshal private key: %s", err)
+		}
+		keyPath := filepath.Join(dir, name+"-key.pem")
+		writePEM(t, keyPath, "EC PRIVATE KEY", keyDer)
+
+		fp := sha1.Sum(signer.cert.Raw)
+		certs = append(certs, certPath)
+		keys = append(keys, keyPath)
+		fingerprints = append(fingerprints, hex.EncodeToString(fp[:]))
+	}
+
+	path := filepath.Join(dir, "image.sif")
+	createTestSIF(t, path)
+
+	if _, err := RemoveSignatures(path, 0, false, ""); err == nil {
+		t.Errorf("unexpected success while removing signatures of an unsigned image")
+	}
+
+	for i := range certs {
+		if err := SignX509(path, 0, false, false, false, certs[i], keys[i]); err != nil {
+			t.Fatalf("unexpected error while signing: %s", err)
+		}
+	}
+	if n := signatureCount(t, path); n != 2 {
+		t.Fatalf("unexpected signature count: %d", n)
+	}
+
+	// a signing error must leave the image untouched
+	if err := SignX509(path, 0, false, false, true, certs[0], keys[1]); err == nil {
+		t.Errorf("unexpected success while signing with a key not matching the certificate")
+	}
+	if n := signatureCount(t, path); n != 2 {
+		t.Fatalf("unexpected signature count after signing error: %d", n)
+	}
+
+	// replace the signatures of alice and bob by a new signature of alice
+	if err := SignX509(path, 0, false, false, true, certs[0], keys[0]); err != nil {
+		t.Fatalf("unexpected error while replacing signatures: %s", err)
+	}
+	if n := signatureCount(t, path); n != 1 {
+		t.Fatalf("unexpected signature count after replace: %d", n)
+	}
+	opts := VerifyOptions{CABundle: rootPath}
+	if _, _, err := Verify(context.Background(), path, "", 0, false, false, "", true, false, opts); err != nil {
+		t.Fatalf("unexpected verification error after replace: %s", err)
+	}
+
+	if err := SignX509(path, 0, false, false, false, certs[1], keys[1]); err != nil {
+		t.Fatalf("unexpected error while signing: %s", err)
+	}
+
+	if _, err := RemoveSignatures(path, 2, false, ""); err == nil {
+		t.Errorf("unexpected success while removing signatures of a signature descriptor")
+	}
+	if _, err := RemoveSignatures(path, 1, true, ""); err == nil {
+		t.Errorf("unexpected success while removing signatures of an unsigned group")
+	}
+	if n, err := RemoveSignatures(path, 1, false, fingerprints[1]); err != nil || n != 1 {
+		t.Fatalf("unexpected result while removing signatures of bob: %d, %v", n, err)
+	}
+	out, _, err := Verify(context.Background(), path, "", 0, false, false, "", true, true, opts)
+	if err != nil {
+		t.Fatalf("unexpected verification error after remove: %s", err)
+	}
+	// the signature of alice must be left intact by the signature of bob
+	var list KeyList
+	if err := json.Unmarshal([]byte(out), &list); err != nil {
+		t.Fatalf("failed to decode verify output: %s", err)
+	}
+	if len(list.SignerKeys) != 1 || list.SignerKeys[0].Signer.Name != "CN=alice" {
+		t.Errorf("unexpected signatures after remove: %s", out)
+	}
+	if n, err := RemoveSignatures(path, 0, false, ""); err != nil || n != 1 {
+		t.Fatalf("unexpected result while removing all signatures: %d, %v", n, err)
+	}
+	if n := signatureCount(t, path); n != 0 {
+		t.Fatalf("unexpected signature count after remove: %d", n)
+	}
+}
+
+func TestUpdateSignaturesRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "restore-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "image.sif")
+	createTestSIF(t, path)
+
+	fimg, err := sif.LoadContainer(path, false)
+	if err != nil {
+		t.Fatalf("failed to load %s: %s", path, err)
+	}
+	sig := pendingSignature{groupid: sif.DescrDefaultGroup, link: 1, signature: []byte("signature")}
+	if err := updateSignatures(&fimg, [20]byte{}, []pendingSignature{sig}, nil); err != nil {
+		t.Fatalf("failed to add signature: %s", err)
+	}
+	fimg.UnloadContainer()
+
+	orig, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read %s: %s", path, err)
+	}
+
+	// remove the last object, which truncates the image, and restore it
+	fimg, err = sif.LoadContainer(path, false)
+	if err != nil {
+		t.Fatalf("failed to load %s: %s", path, err)
+	}
+	defer fimg.UnloadContainer()
+
+	stale := findSignatures(&fimg, 0, "")
+	backup, err := backupSIF(&fimg, stale)
+	if err != nil {
+		t.Fatalf("failed to backup %s: %s", path, err)
+	}
+	if err := updateSignatures(&fimg, [20]byte{}, nil, stale); err != nil {
+		t.Fatalf("failed to remove signature: %s", err)
+	}
+	if err := backup.restore(&fimg); err != nil {
+		t.Fatalf("failed to restore %s: %s", path, err)
+	}
+
+	restored, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read %s: %s", path, err)
+	}
+	if !bytes.Equal(orig, restored) {
+		t.Errorf("restored image differs from the original image")
+	}
+}
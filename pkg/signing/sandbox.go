@@ -0,0 +1,398 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package signing
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+	"github.com/sylabs/singularity/pkg/sypgp"
+	"golang.org/x/crypto/openpgp/clearsign"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// SandboxManifestPath is the path of the signed manifest relative to the
+// root of a sandbox image.
+const SandboxManifestPath = ".singularity.d/manifest.sig"
+
+const (
+	manifestHeader      = "# Singularity sandbox manifest v1"
+	manifestFingerprint = "# Fingerprint: "
+)
+
+// SandboxReport lists the differences between a sandbox and its signed
+// manifest, used for json output.
+type SandboxReport struct {
+	Added    []string `json:",omitempty"`
+	Removed  []string `json:",omitempty"`
+	Modified []string `json:",omitempty"`
+}
+
+func (r *SandboxReport) empty() bool {
+	return len(r.Added) == 0 && len(r.Removed) == 0 && len(r.Modified) == 0
+}
+
+// manifestEntry describes a file of a sandbox in its manifest.
+type manifestEntry struct {
+	path   string
+	kind   string
+	mode   uint32
+	digest string
+}
+
+func (e manifestEntry) String() string {
+	return fmt.Sprintf("%s %04o %s %s", e.kind, e.mode, e.digest, strconv.Quote(e.path))
+}
+
+// parseManifestEntry parses a manifest line written by manifestEntry.String.
+func parseManifestEntry(line string) (manifestEntry, error) {
+	fields := strings.SplitN(line, " ", 4)
+	if len(fields) != 4 {
+		return manifestEntry{}, fmt.Errorf("malformed manifest entry: %s", line)
+	}
+	mode, err := strconv.ParseUint(fields[1], 8, 32)
+	if err != nil {
+		return manifestEntry{}, fmt.Errorf("malformed manifest entry mode: %s", line)
+	}
+	path, err := strconv.Unquote(fields[3])
+	if err != nil {
+		return manifestEntry{}, fmt.Errorf("malformed manifest entry path: %s", line)
+	}
+	return manifestEntry{path: path, kind: fields[0], mode: uint32(mode), digest: fields[2]}, nil
+}
+
+// unixMode returns the permission bits of m, including the setuid, setgid
+// and sticky bits, as a unix mode.
+func unixMode(m os.FileMode) uint32 {
+	mode := uint32(m.Perm())
+	if m&os.ModeSetuid != 0 {
+		mode |= 04000
+	}
+	if m&os.ModeSetgid != 0 {
+		mode |= 02000
+	}
+	if m&os.ModeSticky != 0 {
+		mode |= 01000
+	}
+	return mode
+}
+
+// fileDigest returns the hex sha256 digest of the file path.
+func fileDigest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// sandboxEntries returns the manifest entries of all the files of the
+// sandbox dir except the signed manifest, sorted by path. Regular files
+// have the digest of their content and symbolic links the digest of
+// their target.
+func sandboxEntries(dir string) ([]manifestEntry, error) {
+	var entries []manifestEntry
+
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." || rel == SandboxManifestPath {
+			return nil
+		}
+
+		e := manifestEntry{path: rel, mode: unixMode(fi.Mode()), digest: "-"}
+		switch {
+		case fi.Mode().IsRegular():
+			e.kind = "f"
+			e.digest, err = fileDigest(path)
+			if err != nil {
+				return fmt.Errorf("while hashing %s: %s", rel, err)
+			}
+		case fi.IsDir():
+			e.kind = "d"
+		case fi.Mode()&os.ModeSymlink != 0:
+			e.kind = "l"
+			target, err := os.Readlink(path)
+			if err != nil {
+				return fmt.Errorf("while reading link %s: %s", rel, err)
+			}
+			sum := sha256.Sum256([]byte(target))
+			e.digest = hex.EncodeToString(sum[:])
+		default:
+			// devices, pipes and sockets are recorded with their mode only
+			e.kind = "o"
+		}
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// filepath.Walk sorts the names of each directory, sort by full path
+	// so the order doesn't depend on the walk
+	sort.Slice(entries, func(i, j int) bool { return entries[i].path < entries[j].path })
+	return entries, nil
+}
+
+// SandboxManifest returns the manifest of the sandbox dir, one line per
+// file with its type, mode, sha256 digest and path, sorted by path. The
+// manifest only depends on the content of the sandbox.
+func SandboxManifest(dir string) ([]byte, error) {
+	entries, err := sandboxEntries(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	for _, e := range entries {
+		fmt.Fprintln(&b, e.String())
+	}
+	return b.Bytes(), nil
+}
+
+// isSandbox returns an error if dir is not a sandbox image.
+func isSandbox(dir string) error {
+	fi, err := os.Stat(filepath.Join(dir, filepath.Dir(SandboxManifestPath)))
+	if err != nil || !fi.IsDir() {
+		return fmt.Errorf("%s is not a sandbox image", dir)
+	}
+	return nil
+}
+
+// SignSandbox signs the manifest of the sandbox dir with the private key of
+// the named keyring selected like Sign does, and stores the signed manifest
+// in the sandbox at SandboxManifestPath, replacing any previous one.
+func SignSandbox(dir, keyring string, keyIdx int) error {
+	entity, key, err := loadSigningKey(keyring, keyIdx)
+	if err != nil {
+		return err
+	}
+	return signSandbox(dir, entity.PrimaryKey.Fingerprint, key)
+}
+
+// SignSandboxGPGAgent is like SignSandbox but the manifest is signed by
+// gpg-agent with the GnuPG secret key matching keyID, or the default GnuPG
+// secret key if keyID is empty.
+func SignSandboxGPGAgent(dir, keyID string) error {
+	signer, err := sypgp.NewGPGAgentSigner(keyID)
+	if err != nil {
+		return err
+	}
+	defer signer.Close()
+
+	return signSandbox(dir, signer.Entity.PrimaryKey.Fingerprint, signer.PrivateKey)
+}
+
+func signSandbox(dir string, fingerprint [20]byte, key *packet.PrivateKey) error {
+	if err := isSandbox(dir); err != nil {
+		return err
+	}
+
+	manifest, err := SandboxManifest(dir)
+	if err != nil {
+		return fmt.Errorf("while generating sandbox manifest: %s", err)
+	}
+
+	// the signing entity is part of the signed data, like the entity of
+	// SIF signature descriptors
+	var doc bytes.Buffer
+	fmt.Fprintln(&doc, manifestHeader)
+	fmt.Fprintf(&doc, "%s%X\n", manifestFingerprint, fingerprint)
+	doc.Write(manifest)
+
+	signature, err := pgpSignature(key)(doc.String())
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(dir, SandboxManifestPath), signature, 0644)
+}
+
+// parseSignedManifest returns the signing entity fingerprint and the
+// entries of a signed manifest plaintext.
+func parseSignedManifest(plaintext []byte) (string, map[string]manifestEntry, error) {
+	fingerprint := ""
+	entries := make(map[string]manifestEntry)
+
+	scanner := bufio.NewScanner(bytes.NewReader(plaintext))
+	scanner.Buffer(nil, 1024*1024)
+	for n := 0; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if n == 0 {
+			if line != manifestHeader {
+				return "", nil, fmt.Errorf("unsupported manifest format")
+			}
+			continue
+		}
+		if strings.HasPrefix(line, manifestFingerprint) {
+			fingerprint = strings.TrimPrefix(line, manifestFingerprint)
+			continue
+		}
+		if line == "" {
+			continue
+		}
+		e, err := parseManifestEntry(line)
+		if err != nil {
+			return "", nil, err
+		}
+		entries[e.path] = e
+	}
+	if err := scanner.Err(); err != nil {
+		return "", nil, err
+	}
+	if len(fingerprint) != 40 {
+		return "", nil, fmt.Errorf("missing signing entity fingerprint in manifest")
+	}
+	return fingerprint, entries, nil
+}
+
+// compareManifest returns the files added, removed or modified in the
+// sandbox since the manifest signed entries was generated.
+func compareManifest(signed map[string]manifestEntry, current []manifestEntry) *SandboxReport {
+	r := &SandboxReport{}
+
+	seen := make(map[string]bool)
+	for _, e := range current {
+		seen[e.path] = true
+		s, ok := signed[e.path]
+		if !ok {
+			r.Added = append(r.Added, e.path)
+		} else if s != e {
+			r.Modified = append(r.Modified, e.path)
+		}
+	}
+	for path := range signed {
+		if !seen[path] {
+			r.Removed = append(r.Removed, path)
+		}
+	}
+	sort.Strings(r.Removed)
+
+	return r
+}
+
+// VerifySandbox verifies the signed manifest of the sandbox dir, the signing
+// key is looked up like Verify does for PGP signatures, and reports the
+// files added, removed or modified since the manifest was signed. Returns a
+// string of formatted output, or json (if jsonVerify is true), and true, if
+// theres no local key matching the signer entity. Policies and X.509
+// signatures are not supported for sandboxes.
+func VerifySandbox(ctx context.Context, dir, keyServiceURI, authToken string, localVerify, jsonVerify bool, opts VerifyOptions) (string, bool, error) {
+	if opts.Policy != nil {
+		return "", false, fmt.Errorf("verification policies are not supported for sandbox images")
+	}
+	if err := isSandbox(dir); err != nil {
+		return "", false, err
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, SandboxManifestPath))
+	if os.IsNotExist(err) {
+		return "", false, fmt.Errorf("sandbox %s is not signed", dir)
+	} else if err != nil {
+		return "", false, fmt.Errorf("while reading signed manifest: %s", err)
+	}
+
+	block, _ := clearsign.Decode(data)
+	if block == nil {
+		return "", false, fmt.Errorf("signed manifest corrupted, unable to read data")
+	}
+	fingerprint, signed, err := parseSignedManifest(block.Plaintext)
+	if err != nil {
+		return "", false, fmt.Errorf("signed manifest corrupted: %s", err)
+	}
+
+	keyring := sypgp.NewHandle("", sypgp.KeyringHandleOpt(opts.Keyring))
+	trust, err := keyring.LoadTrust()
+	if err != nil {
+		return "", false, fmt.Errorf("could not load key trust levels: %s", err)
+	}
+
+	green := color.New(color.FgGreen).SprintFunc()
+	red := color.New(color.FgRed).SprintFunc()
+
+	author := fmt.Sprintf("Sandbox is signed by 1 key(s):\n\n")
+	author += fmt.Sprintf("Verifying manifest: %s:\n", SandboxManifestPath)
+	author += fingerprint + "\n"
+
+	// (1) try to get identity of signer
+	c := checkSigner(ctx, keyring, trust, nil, block, data, fingerprint, keyServiceURI, authToken, localVerify, true, opts)
+	author += c.report
+	fail := c.err != nil
+
+	// (2) Verify data integrity by comparing the sandbox with the manifest
+	current, err := sandboxEntries(dir)
+	if err != nil {
+		return "", false, fmt.Errorf("while generating sandbox manifest: %s", err)
+	}
+	report := compareManifest(signed, current)
+	for _, p := range report.Added {
+		author += fmt.Sprintf("%-18s %s\n", red("[ADDED]"), p)
+	}
+	for _, p := range report.Removed {
+		author += fmt.Sprintf("%-18s %s\n", red("[REMOVED]"), p)
+	}
+	for _, p := range report.Modified {
+		author += fmt.Sprintf("%-18s %s\n", red("[MODIFIED]"), p)
+	}
+	dataCheck := report.empty()
+	if dataCheck {
+		author += fmt.Sprintf("%-18s Data integrity verified\n", green("[OK]"))
+	} else {
+		sylog.Verbosef("%s sandbox %s differs from its signed manifest", red("error:"), dir)
+		fail = true
+	}
+	author += fmt.Sprintf("\n")
+
+	if jsonVerify {
+		keySigner := makeKeyEntity(SignatureTypePGP, c.identity, "manifest", fingerprint, c.local, true, dataCheck)
+		keySigner.Signer.KeyRevoked = c.err == errKeyRevoked
+		keySigner.Signer.KeyExpired = c.expired
+		if c.signer != nil {
+			keySigner.Signer.Trust = c.level.String()
+		}
+		keyEntityList := KeyList{
+			Signatures: 1,
+			SignerKeys: []*Key{keySigner},
+			Sandbox:    report,
+		}
+		jsonData, err := json.MarshalIndent(keyEntityList, "", "  ")
+		if err != nil {
+			return "", c.notLocal, fmt.Errorf("unable to parse json: %s", err)
+		}
+		author = string(jsonData) + "\n"
+	}
+
+	if fail {
+		return author, c.notLocal, ErrVerificationFail
+	}
+	return author, c.notLocal, nil
+}
@@ -0,0 +1,169 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package signing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sylabs/singularity/pkg/sypgp"
+)
+
+// createTestSandbox creates a minimal sandbox image in dir.
+func createTestSandbox(t *testing.T, dir string) {
+	for _, d := range []string{".singularity.d/env", "bin", "etc"} {
+		if err := os.MkdirAll(filepath.Join(dir, d), 0755); err != nil {
+			t.Fatalf("failed to create %s: %s", d, err)
+		}
+	}
+	files := map[string]string{
+		".singularity.d/runscript":          "#!/bin/sh\nexec /bin/sh\n",
+		".singularity.d/env/90-environment": "export PATH=/bin\n",
+		"bin/sh":                            "shell",
+		"etc/hosts":                         "127.0.0.1 localhost\n",
+		"etc/file with spaces":              "spaces\n",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %s", name, err)
+		}
+	}
+	if err := os.Symlink("sh", filepath.Join(dir, "bin/bash")); err != nil {
+		t.Fatalf("failed to create symlink: %s", err)
+	}
+}
+
+func TestSandboxManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "manifest-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	sandbox := filepath.Join(dir, "sandbox")
+	createTestSandbox(t, sandbox)
+
+	m1, err := SandboxManifest(sandbox)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// the manifest of a copy with other file times is the same
+	other := filepath.Join(dir, "other")
+	createTestSandbox(t, other)
+	if err := os.Chtimes(filepath.Join(other, "etc/hosts"), time.Unix(0, 0), time.Unix(0, 0)); err != nil {
+		t.Fatalf("failed to change file times: %s", err)
+	}
+	m2, err := SandboxManifest(other)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !bytes.Equal(m1, m2) {
+		t.Errorf("manifests differ:\n%s\n%s", m1, m2)
+	}
+
+	for _, line := range bytes.Split(bytes.TrimSpace(m1), []byte("\n")) {
+		e, err := parseManifestEntry(string(line))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if e.String() != string(line) {
+			t.Errorf("entry %q doesn't round trip: %q", line, e.String())
+		}
+	}
+}
+
+func TestSignSandbox(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+
+	dir, err := ioutil.TempDir("", "sandbox-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	keyringDir := filepath.Join(dir, "sypgp")
+	os.Setenv("SINGULARITY_SYPGPDIR", keyringDir)
+	defer os.Unsetenv("SINGULARITY_SYPGPDIR")
+
+	keyring := sypgp.NewHandle(keyringDir)
+	if _, err := keyring.GenKeyPair(sypgp.GenKeyPairOptions{Name: "signer", Email: "signer@my.info", KeyLength: 1024}); err != nil {
+		t.Fatalf("failed to generate key pair: %s", err)
+	}
+
+	sandbox := filepath.Join(dir, "sandbox")
+	createTestSandbox(t, sandbox)
+
+	if err := SignSandbox(dir, "", 0); err == nil {
+		t.Errorf("unexpected success while signing a directory which isn't a sandbox")
+	}
+	if err := SignSandbox(sandbox, "", 0); err != nil {
+		t.Fatalf("unexpected error while signing: %s", err)
+	}
+
+	verify := func() (*SandboxReport, error) {
+		out, _, err := VerifySandbox(context.Background(), sandbox, "", "", true, true, VerifyOptions{})
+		var list KeyList
+		if jerr := json.Unmarshal([]byte(out), &list); jerr != nil {
+			t.Fatalf("failed to decode verify output %q: %s", out, jerr)
+		}
+		if len(list.SignerKeys) != 1 || !list.SignerKeys[0].Signer.KeyLocal || list.Sandbox == nil {
+			t.Fatalf("unexpected verify output: %s", out)
+		}
+		return list.Sandbox, err
+	}
+
+	if report, err := verify(); err != nil || !report.empty() {
+		t.Fatalf("unexpected verification result: %v: %+v", err, report)
+	}
+
+	// add, remove and modify files
+	if err := ioutil.WriteFile(filepath.Join(sandbox, "etc/added"), []byte("added"), 0644); err != nil {
+		t.Fatalf("failed to write file: %s", err)
+	}
+	if err := os.Remove(filepath.Join(sandbox, "etc/file with spaces")); err != nil {
+		t.Fatalf("failed to remove file: %s", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(sandbox, "bin/sh"), []byte("modified"), 0644); err != nil {
+		t.Fatalf("failed to write file: %s", err)
+	}
+	if err := os.Chmod(filepath.Join(sandbox, "etc/hosts"), 04755); err != nil {
+		t.Fatalf("failed to change mode: %s", err)
+	}
+
+	report, err := verify()
+	if err != ErrVerificationFail {
+		t.Fatalf("unexpected verification result: %v", err)
+	}
+	want := &SandboxReport{
+		Added:    []string{"etc/added"},
+		Removed:  []string{"etc/file with spaces"},
+		Modified: []string{"bin/sh", "etc/hosts"},
+	}
+	if !reflect.DeepEqual(report, want) {
+		t.Errorf("got report %+v, expected %+v", report, want)
+	}
+
+	// a signature made with a key missing from the keyring fails
+	if err := SignSandbox(sandbox, "", 0); err != nil {
+		t.Fatalf("unexpected error while signing: %s", err)
+	}
+	if err := os.RemoveAll(keyringDir); err != nil {
+		t.Fatalf("failed to remove keyring: %s", err)
+	}
+	if _, _, err := VerifySandbox(context.Background(), sandbox, "", "", true, false, VerifyOptions{}); err != ErrVerificationFail {
+		t.Errorf("unexpected verification result: %v", err)
+	}
+}
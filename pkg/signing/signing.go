@@ -8,6 +8,7 @@ package signing
 import (
 	"bytes"
 	"context"
+	"crypto/sha1"
 	"crypto/sha512"
 	"encoding/binary"
 	"encoding/hex"
@@ -16,13 +17,17 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/fatih/color"
 	"github.com/sylabs/sif/pkg/sif"
 	"github.com/sylabs/singularity/internal/pkg/sylog"
+	"github.com/sylabs/singularity/pkg/image"
 	"github.com/sylabs/singularity/pkg/sypgp"
+	"github.com/sylabs/singularity/pkg/util/verity"
 	"golang.org/x/crypto/openpgp"
 	"golang.org/x/crypto/openpgp/clearsign"
+	"golang.org/x/crypto/openpgp/packet"
 )
 
 // ErrVerificationFail is the error when the verify fails
@@ -30,26 +35,76 @@ var ErrVerificationFail = errors.New("verification failed")
 
 var errNotFound = errors.New("key does not exist in local, or remote keystore")
 var errNotFoundLocal = errors.New("key not in local keyring")
+var errNotFoundBundle = errors.New("key does not exist in local keyring, or trust bundle")
+var errKeyRevoked = errors.New("signing key has been revoked")
+var errKeyExpired = errors.New("signing key had expired when the signature was made")
+var errKeyUntrusted = errors.New("signing key is not trusted enough, see 'key trust'")
+var errEntityMismatch = errors.New("signing key doesn't match the signing entity of the signature")
 
 // Key is for json formatting.
 type Key struct {
 	Signer KeyEntity
 }
 
+// Signature types reported in KeyEntity.
+const (
+	SignatureTypePGP  = "pgp"
+	SignatureTypeX509 = "x509"
+)
+
 // KeyEntity holds all the key info, used for json output.
 type KeyEntity struct {
+	Type        string
 	Partition   string
 	Name        string
 	Fingerprint string
 	KeyLocal    bool
 	KeyCheck    bool
+	KeyRevoked  bool
+	KeyExpired  bool
 	DataCheck   bool
+	Trust       string `json:",omitempty"`
 }
 
 // KeyList is a list of one or more keys.
 type KeyList struct {
 	Signatures int
 	SignerKeys []*Key
+	Policy     *PolicyReport  `json:",omitempty"`
+	Sandbox    *SandboxReport `json:",omitempty"`
+}
+
+// VerifyOptions holds the optional settings of Verify.
+type VerifyOptions struct {
+	// CABundle is the path of a PEM file with the root certificates
+	// used to validate the certificate chain of X.509 signatures.
+	CABundle string
+	// CRL is the path of a PEM or DER file with the certificate
+	// revocation lists checked while validating certificate chains.
+	CRL string
+	// Policy, if set, is evaluated against all the signatures of the
+	// image and decides the verification result.
+	Policy *Policy
+	// TrustBundle, if set, provides the trusted public keys and
+	// revocations used in place of the key server.
+	TrustBundle *sypgp.TrustBundle
+	// FailExpiredKey makes signatures created after the expiration of
+	// their signing key fail the verification, they are only reported
+	// otherwise.
+	FailExpiredKey bool
+	// Keyring is the name of the local keyring holding the trusted
+	// public keys, the default keyring is used if empty.
+	Keyring string
+	// RequireTrust is the minimum trust level of the signing keys of
+	// PGP signatures. Keys of the local keyring have the level set with
+	// 'key trust', keys of the trust bundle are fully trusted and keys
+	// fetched from a key server aren't trusted.
+	RequireTrust sypgp.TrustLevel
+	// VerityParams, if set, receives the verity parameters of the system
+	// partition as read while verifying a valid signature covering them.
+	// It is left unchanged if the verification fails or if no valid
+	// signature covers verity parameters.
+	VerityParams *[]byte
 }
 
 type signatureLink struct {
@@ -61,15 +116,104 @@ type signatureLink struct {
 // computeHashStr generates a hash from data object(s) and generates a string
 // to be stored in the signature block.
 func computeHashStr(fimg *sif.FileImage, descr []*sif.Descriptor) string {
+	data := make([][]byte, len(descr))
+	for i, v := range descr {
+		data[i] = v.GetData(fimg)
+	}
+	return hashStr(data)
+}
+
+// hashStr generates the hash string of the data of data objects.
+func hashStr(data [][]byte) string {
 	hash := sha512.New384()
-	for _, v := range descr {
-		hash.Write(v.GetData(fimg))
+	for _, d := range data {
+		hash.Write(d)
 	}
 	sum := hash.Sum(nil)
 
 	return fmt.Sprintf("SIFHASH:\n%x", sum)
 }
 
+// computeVerifiedHashStr generates the hash string of data objects like
+// computeHashStr and returns a copy of the verity parameters among them, if
+// any, so that the parameters returned are the ones hashed even if the
+// image is modified meanwhile.
+func computeVerifiedHashStr(fimg *sif.FileImage, descr []*sif.Descriptor) (string, []byte) {
+	var params []byte
+
+	data := make([][]byte, len(descr))
+	for i, v := range descr {
+		data[i] = v.GetData(fimg)
+		if v.Datatype == sif.DataGenericJSON && v.GetName() == verity.ParamsName {
+			params = append([]byte(nil), data[i]...)
+			data[i] = params
+		}
+	}
+	return hashStr(data), params
+}
+
+// verityDescrs returns the descriptors of the verity hash tree and
+// parameters of the partition de, if any.
+func verityDescrs(fimg *sif.FileImage, de *sif.Descriptor) []*sif.Descriptor {
+	var descr []*sif.Descriptor
+	if de.Datatype != sif.DataPartition {
+		return descr
+	}
+
+	for i, d := range fimg.DescrArr {
+		if !d.Used || d.Link != de.ID {
+			continue
+		}
+		if name := d.GetName(); name == verity.HashTreeName || name == verity.ParamsName {
+			descr = append(descr, &fimg.DescrArr[i])
+		}
+	}
+
+	return descr
+}
+
+// withVerity returns descr followed by the descriptors of the verity hash
+// trees and parameters of its partitions not already in descr. They are
+// signed with their own signatures, the signature of a partition only
+// covers the partition like for images without hash tree.
+func withVerity(fimg *sif.FileImage, descr []*sif.Descriptor) []*sif.Descriptor {
+	seen := make(map[uint32]bool)
+	for _, d := range descr {
+		seen[d.ID] = true
+	}
+
+	all := descr
+	for _, d := range descr {
+		for _, v := range verityDescrs(fimg, d) {
+			if !seen[v.ID] {
+				seen[v.ID] = true
+				all = append(all, v)
+			}
+		}
+	}
+	return all
+}
+
+// appendVeritySigs appends to sigLink the signatures of the verity hash
+// tree and parameters of the partition de, so that they are verified
+// along with the partition.
+func appendVeritySigs(fimg *sif.FileImage, sigLink []signatureLink, de *sif.Descriptor) []signatureLink {
+	for _, v := range verityDescrs(fimg, de) {
+		_, didx, err := fimg.GetFromDescrID(v.ID)
+		if err != nil {
+			continue
+		}
+		_, sigIdx, err := fimg.GetLinkedDescrsByType(v.ID, sif.DataSignature)
+		if err != nil {
+			continue
+		}
+		for _, s := range sigIdx {
+			sigLink = append(sigLink, signatureLink{sigIndex: s, dataIndex: didx})
+		}
+	}
+	return sigLink
+}
+
 // sifAddSignature adds a signature block to a SIF file
 func sifAddSignature(fimg *sif.FileImage, groupid, link uint32, fingerprint [20]byte, signature []byte) error {
 	// data we need to create a signature descriptor
@@ -140,9 +284,9 @@ func descrToSign(fimg *sif.FileImage, id uint32, isGroup, signAll bool) ([]*sif.
 	var err error
 
 	if signAll {
-		descr[0], _, err = fimg.GetPartPrimSys()
+		descr, err = getSystemPartitions(fimg)
 		if err != nil {
-			return nil, fmt.Errorf("no primary partition found")
+			return nil, err
 		}
 
 		// signableDatatypes is a list of all the signable Datatypes, all
@@ -174,28 +318,54 @@ func descrToSign(fimg *sif.FileImage, id uint32, isGroup, signAll bool) ([]*sif.
 			return nil, fmt.Errorf("no descriptor found for id %d", id)
 		}
 	} else {
-		descr[0], _, err = fimg.GetPartPrimSys()
+		descr, err = getSystemPartitions(fimg)
 		if err != nil {
-			return nil, fmt.Errorf("no primary partition found")
+			return nil, err
 		}
 	}
 
 	return descr, nil
 }
 
+// getSystemPartitions returns the primary system partition followed by the
+// system partitions of the other architectures of a multi-architecture image.
+func getSystemPartitions(fimg *sif.FileImage) ([]*sif.Descriptor, error) {
+	descr := image.GetSystemPartitions(fimg)
+	if len(descr) == 0 {
+		return nil, fmt.Errorf("no primary partition found")
+	}
+	if ptype, _ := descr[0].GetPartType(); ptype != sif.PartPrimSys {
+		return nil, fmt.Errorf("no primary partition found")
+	}
+	return descr, nil
+}
+
 // Sign takes the path of a container and generates an OpenPGP signature block for
-// its system partition. Sign uses the private keys found in the default
-// location.
-func Sign(cpath string, id uint32, isGroup, signAll bool, keyIdx int) error {
-	keyring := sypgp.NewHandle("")
+// its system partition. Sign uses the private keys of the named keyring, or
+// of the default keyring if keyring is empty. If replace is set, the existing
+// signatures of the signed partitions are removed.
+func Sign(cpath string, id uint32, isGroup, signAll, replace bool, keyring string, keyIdx int) error {
+	entity, key, err := loadSigningKey(keyring, keyIdx)
+	if err != nil {
+		return err
+	}
+
+	return addSignatures(cpath, id, isGroup, signAll, replace, entity.PrimaryKey.Fingerprint, pgpSignature(key))
+}
+
+// loadSigningKey returns the private key of the named keyring selected by
+// keyIdx, or interactively if keyIdx is -1 and the keyring holds several
+// keys, and its key usable for signing.
+func loadSigningKey(keyring string, keyIdx int) (*openpgp.Entity, *packet.PrivateKey, error) {
+	handle := sypgp.NewHandle("", sypgp.KeyringHandleOpt(keyring))
 
 	// Load a private key usable for signing
-	elist, err := keyring.LoadPrivKeyring()
+	elist, err := handle.LoadPrivKeyring()
 	if err != nil {
-		return fmt.Errorf("could not load private keyring: %s", err)
+		return nil, nil, fmt.Errorf("could not load private keyring: %s", err)
 	}
 	if elist == nil {
-		return fmt.Errorf("no private keys in keyring. use 'key newpair' to generate a key, or 'key import' to import a private key from gpg")
+		return nil, nil, fmt.Errorf("no private keys in keyring. use 'key newpair' to generate a key, or 'key import' to import a private key from gpg")
 	}
 
 	var entity *openpgp.Entity
@@ -203,12 +373,12 @@ func Sign(cpath string, id uint32, isGroup, signAll bool, keyIdx int) error {
 		if keyIdx >= 0 && keyIdx < len(elist) {
 			entity = elist[keyIdx]
 		} else {
-			return fmt.Errorf("specified (-k, --keyidx) key index out of range")
+			return nil, nil, fmt.Errorf("specified (-k, --keyidx) key index out of range")
 		}
 	} else if len(elist) > 1 {
 		entity, err = sypgp.SelectPrivKey(elist)
 		if err != nil {
-			return fmt.Errorf("failed while reading selection: %s", err)
+			return nil, nil, fmt.Errorf("failed while reading selection: %s", err)
 		}
 	} else {
 		entity = elist[0]
@@ -218,10 +388,57 @@ func Sign(cpath string, id uint32, isGroup, signAll bool, keyIdx int) error {
 	if entity.PrivateKey.Encrypted {
 		sylog.Debugf("Decrypting key...")
 		if err = sypgp.DecryptKey(entity, ""); err != nil {
-			return fmt.Errorf("could not decrypt private key, wrong password?")
+			return nil, nil, fmt.Errorf("could not decrypt private key, wrong password?")
 		}
 	}
 
+	key := sypgp.SigningKey(entity, time.Now())
+	if key == nil {
+		return nil, nil, fmt.Errorf("key %X can't sign, it has expired or been revoked, see 'key extend'", entity.PrimaryKey.Fingerprint)
+	}
+
+	return entity, key, nil
+}
+
+// SignGPGAgent is like Sign but the signatures are made by gpg-agent with
+// the GnuPG secret key matching keyID, or the default GnuPG secret key if
+// keyID is empty.
+func SignGPGAgent(cpath string, id uint32, isGroup, signAll, replace bool, keyID string) error {
+	signer, err := sypgp.NewGPGAgentSigner(keyID)
+	if err != nil {
+		return err
+	}
+	defer signer.Close()
+
+	return addSignatures(cpath, id, isGroup, signAll, replace, signer.Entity.PrimaryKey.Fingerprint, pgpSignature(signer.PrivateKey))
+}
+
+// pgpSignature returns a function creating an ascii armored signature
+// block of a hash string with the private key key.
+func pgpSignature(key *packet.PrivateKey) func(sifhash string) ([]byte, error) {
+	return func(sifhash string) ([]byte, error) {
+		var signedmsg bytes.Buffer
+		plaintext, err := clearsign.Encode(&signedmsg, key, nil)
+		if err != nil {
+			return nil, fmt.Errorf("could not build a signature block: %s", err)
+		}
+		_, err = plaintext.Write([]byte(sifhash))
+		if err != nil {
+			return nil, fmt.Errorf("failed writing hash value to signature block: %s", err)
+		}
+		if err = plaintext.Close(); err != nil {
+			return nil, fmt.Errorf("I/O error while wrapping up signature block: %s", err)
+		}
+		return signedmsg.Bytes(), nil
+	}
+}
+
+// addSignatures adds to the container found at cpath a signature block for
+// the partitions determined by id, isGroup and signAll. The signature block
+// is returned by sign for the hash string of the signed data objects and is
+// stored with the signing entity fingerprint. If replace is set, the
+// existing signatures of the signed partitions are removed.
+func addSignatures(cpath string, id uint32, isGroup, signAll, replace bool, fingerprint [20]byte, sign func(sifhash string) ([]byte, error)) error {
 	// load the container
 	fimg, err := sif.LoadContainer(cpath, false)
 	if err != nil {
@@ -234,7 +451,13 @@ func Sign(cpath string, id uint32, isGroup, signAll bool, keyIdx int) error {
 	if err != nil {
 		return fmt.Errorf("unable to find a signable partition: %s", err)
 	}
+	if !isGroup {
+		descr = withVerity(&fimg, descr)
+	}
 
+	// all the signature blocks are created before modifying the
+	// container, so that a signing error leaves it untouched
+	var sigs []pendingSignature
 	for _, de := range descr {
 		sylog.Debugf("Signing %s partition...", datatypeStr(de.Datatype))
 
@@ -248,32 +471,16 @@ func Sign(cpath string, id uint32, isGroup, signAll bool, keyIdx int) error {
 		}
 		sylog.Debugf("Signing hash: %s\n", sifhash)
 
-		// create an ascii armored signature block
-		var signedmsg bytes.Buffer
-		plaintext, err := clearsign.Encode(&signedmsg, entity.PrivateKey, nil)
-		if err != nil {
-			return fmt.Errorf("could not build a signature block: %s", err)
-		}
-		_, err = plaintext.Write([]byte(sifhash))
+		signature, err := sign(sifhash)
 		if err != nil {
-			return fmt.Errorf("failed writing hash value to signature block: %s", err)
-		}
-		if err = plaintext.Close(); err != nil {
-			return fmt.Errorf("I/O error while wrapping up signature block: %s", err)
+			return err
 		}
 
-		// finally add the signature block (for descr) as a new SIF data object
-		var groupid, link uint32
+		// the signature block (for descr) is added as a new SIF data object
 		if isGroup {
-			groupid = sif.DescrUnusedGroup
-			link = de.Groupid
+			sigs = append(sigs, pendingSignature{groupid: sif.DescrUnusedGroup, link: de.Groupid, signature: signature})
 		} else {
-			groupid = de.Groupid
-			link = de.ID
-		}
-		err = sifAddSignature(&fimg, groupid, link, entity.PrimaryKey.Fingerprint, signedmsg.Bytes())
-		if err != nil {
-			return fmt.Errorf("failed adding signature block to SIF container file: %s", err)
+			sigs = append(sigs, pendingSignature{groupid: de.Groupid, link: de.ID, signature: signature})
 		}
 
 		// If we are signing a group, then only add one signatrue for all
@@ -283,7 +490,47 @@ func Sign(cpath string, id uint32, isGroup, signAll bool, keyIdx int) error {
 		}
 	}
 
-	return nil
+	var stale []uint32
+	if replace {
+		for _, s := range sigs {
+			stale = append(stale, findSignatures(&fimg, s.link, "")...)
+		}
+	}
+
+	return updateSignatures(&fimg, fingerprint, sigs, stale)
+}
+
+// getSigsAll returns a signatureLink for every signature of the image,
+// whether it applies to a descriptor or to a group.
+func getSigsAll(fimg *sif.FileImage) []signatureLink {
+	var tbl []signatureLink
+
+	for sidx, s := range fimg.DescrArr {
+		if !s.Used || s.Datatype != sif.DataSignature {
+			continue
+		}
+
+		if s.Link&sif.DescrGroupMask == sif.DescrGroupMask {
+			link := signatureLink{sigIndex: sidx}
+			for didx, d := range fimg.DescrArr {
+				if d.Used && d.Groupid == s.Link {
+					link.groupIndex = append(link.groupIndex, didx)
+				}
+			}
+			if link.groupIndex != nil {
+				tbl = append(tbl, link)
+			}
+			continue
+		}
+
+		for didx, d := range fimg.DescrArr {
+			if d.Used && d.ID == s.Link {
+				tbl = append(tbl, signatureLink{sigIndex: sidx, dataIndex: didx})
+			}
+		}
+	}
+
+	return tbl
 }
 
 // getSigsAllPart returns a signatureLink for every non-signature partition.
@@ -349,7 +596,7 @@ func getSigsDescr(fimg *sif.FileImage, id uint32) ([]signatureLink, error) {
 		sigLink[i].dataIndex = int(id) - 1
 	}
 
-	return sigLink, nil
+	return appendVeritySigs(fimg, sigLink, descr[0]), nil
 }
 
 // getSigsGroup returns a signatureLink for specified group.
@@ -402,7 +649,7 @@ func getSigsForSelection(fimg *sif.FileImage, id uint32, isGroup, verifyAll bool
 // if one occures, eg. "the container is not signed", or "container is
 // signed by a unknown signer".
 func IsSigned(ctx context.Context, cpath, keyServerURI string, authToken string) (bool, error) {
-	_, noLocalKey, err := Verify(ctx, cpath, keyServerURI, uint32(0), false, false, authToken, false, false)
+	_, noLocalKey, err := Verify(ctx, cpath, keyServerURI, uint32(0), false, false, authToken, false, false, VerifyOptions{})
 	if err != nil {
 		return false, fmt.Errorf("unable to verify container: %s", cpath)
 	}
@@ -420,9 +667,16 @@ func IsSigned(ctx context.Context, cpath, keyServerURI string, authToken string)
 // keys in the default local keyring, if non is found, it will then looks it up
 // from a key server if access is enabled, or if localVerify is false. Returns
 // a string of formatted output, or json (if jsonVerify is true), and true, if
-// theres no local key matching a signers entity.
-func Verify(ctx context.Context, cpath, keyServiceURI string, id uint32, isGroup, verifyAll bool, authToken string, localVerify, jsonVerify bool) (string, bool, error) {
-	keyring := sypgp.NewHandle("")
+// theres no local key matching a signers entity. X.509 signatures are
+// validated with the CA bundle and the CRL set in opts, they are skipped
+// if no CA bundle is set.
+func Verify(ctx context.Context, cpath, keyServiceURI string, id uint32, isGroup, verifyAll bool, authToken string, localVerify, jsonVerify bool, opts VerifyOptions) (string, bool, error) {
+	keyring := sypgp.NewHandle("", sypgp.KeyringHandleOpt(opts.Keyring))
+
+	verifier, err := newX509Verifier(opts.CABundle, opts.CRL)
+	if err != nil {
+		return "", false, err
+	}
 
 	notLocalKey := false
 
@@ -432,18 +686,34 @@ func Verify(ctx context.Context, cpath, keyServiceURI string, id uint32, isGroup
 	}
 	defer fimg.UnloadContainer()
 
-	// Get all signature blocks (signatures) for ID/GroupID selected (descr) from SIF file.
-	sigsLink, err := getSigsForSelection(&fimg, id, isGroup, verifyAll)
+	var sigsLink []signatureLink
+	if opts.Policy != nil {
+		// a policy is evaluated against all the signatures of the image
+		sigsLink = getSigsAll(&fimg)
+	} else {
+		// Get all signature blocks (signatures) for ID/GroupID selected (descr) from SIF file.
+		sigsLink, err = getSigsForSelection(&fimg, id, isGroup, verifyAll)
+		if err != nil {
+			return "", false, fmt.Errorf("error while searching for signature blocks: %s", err)
+		}
+	}
+
+	trust, err := keyring.LoadTrust()
 	if err != nil {
-		return "", false, fmt.Errorf("error while searching for signature blocks: %s", err)
+		return "", false, fmt.Errorf("could not load key trust levels: %s", err)
 	}
 
+	// keys of the local keyring are ignored if the policy doesn't accept them
+	useLocalKeys := opts.Policy == nil || opts.Policy.allowLocalKeys()
+	var results []*signatureResult
+
 	// Setup some colors.
 	green := color.New(color.FgGreen).SprintFunc()
 	yellow := color.New(color.FgYellow).SprintFunc()
 	red := color.New(color.FgRed).SprintFunc()
 
 	var fail bool
+	var skipped int
 	var errRet error
 	var author string
 
@@ -456,17 +726,21 @@ func Verify(ctx context.Context, cpath, keyServiceURI string, id uint32, isGroup
 	// corresponding partition.
 	for _, part := range sigsLink {
 		sifhash := ""
-		if isGroup {
+		result := &signatureResult{}
+		if part.groupIndex != nil {
 			// If we are verifying a group, then collect all
 			// the group partitions.
 			var groupPart []*sif.Descriptor
 
 			for _, d := range part.groupIndex {
 				groupPart = append(groupPart, &fimg.DescrArr[d])
+				result.covers = append(result.covers, fimg.DescrArr[d].ID)
 			}
-			sifhash = computeHashStr(&fimg, groupPart)
+			sifhash, result.verityParams = computeVerifiedHashStr(&fimg, groupPart)
 		} else {
-			sifhash = computeHashStr(&fimg, []*sif.Descriptor{&fimg.DescrArr[part.dataIndex]})
+			descr := &fimg.DescrArr[part.dataIndex]
+			result.covers = append(result.covers, descr.ID)
+			sifhash, result.verityParams = computeVerifiedHashStr(&fimg, []*sif.Descriptor{descr})
 		}
 		sylog.Debugf("Verifying hash: %s\n", sifhash)
 
@@ -480,8 +754,8 @@ func Verify(ctx context.Context, cpath, keyServiceURI string, id uint32, isGroup
 		}
 
 		verifyPartition := ""
-		if isGroup {
-			verifyPartition = fmt.Sprintf("group: %d", id)
+		if part.groupIndex != nil {
+			verifyPartition = fmt.Sprintf("group: %d", fimg.DescrArr[part.sigIndex].Link&^sif.DescrGroupMask)
 		} else {
 			verifyPartition = datatypeStr(fimg.DescrArr[part.dataIndex].Datatype)
 		}
@@ -490,12 +764,74 @@ func Verify(ctx context.Context, cpath, keyServiceURI string, id uint32, isGroup
 
 		// Extract hash string from signature block
 		data := fimg.DescrArr[part.sigIndex].GetData(&fimg)
+
+		if isX509Signature(data) {
+			sig, err := decodeX509Signature(data)
+			if err != nil {
+				sylog.Verbosef("%s signature certificate (%s) corrupted: %s", red("error:"), fingerprint, err)
+				author += fmt.Sprintf("%-18s Signature corrupted, unable to read data\n\n", red("[FAIL]"))
+
+				keySigner = makeKeyEntity(SignatureTypeX509, "", verifyPartition, fingerprint, false, false, false)
+				keyEntityList.SignerKeys = append(keyEntityList.SignerKeys, keySigner)
+
+				fail = true
+				continue
+			}
+
+			// (1) check the signature and the signer certificate chain
+			keyCheck, trusted := true, false
+			leaf := sha1.Sum(sig.certs[0].Raw)
+			result.fingerprint = fmt.Sprintf("%X", leaf)
+			if err := sig.check(); err != nil {
+				author += fmt.Sprintf("%-18s %s\n", red("[FAIL]"), err)
+				keyCheck = false
+				fail = true
+			} else if result.fingerprint != fingerprint {
+				author += fmt.Sprintf("%-18s %s: %s\n", red("[FAIL]"), sig.signer(), errEntityMismatch)
+				keyCheck = false
+				fail = true
+			} else if err := verifier.verify(sig); err == errNoCABundle {
+				// X.509 signatures can't be validated without CA
+				// bundle, they don't fail the verification of the
+				// other signatures
+				author += fmt.Sprintf("%-18s %s: %s\n", yellow("[SKIPPED]"), sig.signer(), err)
+				skipped++
+			} else if err != nil {
+				author += fmt.Sprintf("%-18s %s: %s\n", red("[FAIL]"), sig.signer(), err)
+				fail = true
+			} else {
+				author += fmt.Sprintf("%-18s %s\n", green("[CERTIFIED]"), sig.signer())
+				trusted = true
+			}
+
+			// (2) Verify data integrity by comparing hashes
+			dataCheck := bytes.Equal(sig.hash, []byte(sifhash))
+			if !dataCheck {
+				sylog.Verbosef("%s certificate (%s) hash differs, data may be corrupted", red("error:"), fingerprint)
+				author += fmt.Sprintf("%-18s system partition hash differs, data may be corrupted\n", red("[FAIL]"))
+				fail = true
+			} else {
+				author += fmt.Sprintf("%-18s Data integrity verified\n", green("[OK]"))
+			}
+			author += fmt.Sprintf("\n")
+
+			keySigner = makeKeyEntity(SignatureTypeX509, sig.signer(), verifyPartition, fingerprint, trusted, keyCheck, dataCheck)
+			keyEntityList.SignerKeys = append(keyEntityList.SignerKeys, keySigner)
+
+			result.x509 = true
+			result.local = trusted
+			result.created = sig.created()
+			result.valid = keyCheck && trusted && dataCheck
+			results = append(results, result)
+			continue
+		}
+
 		block, _ := clearsign.Decode(data)
 		if block == nil {
 			sylog.Verbosef("%s signature key (%s) corrupted, unable to read data", red("error:"), fingerprint)
 			author += fmt.Sprintf("%-18s Signature corrupted, unable to read data\n\n", red("[FAIL]"))
 
-			keySigner = makeKeyEntity("", verifyPartition, fingerprint, false, false, false)
+			keySigner = makeKeyEntity(SignatureTypePGP, "", verifyPartition, fingerprint, false, false, false)
 			keyEntityList.SignerKeys = append(keyEntityList.SignerKeys, keySigner)
 
 			fail = true
@@ -503,23 +839,15 @@ func Verify(ctx context.Context, cpath, keyServiceURI string, id uint32, isGroup
 		}
 
 		// (1) try to get identity of signer
-		i, local, err := getSignerIdentity(ctx, keyring, &fimg.DescrArr[part.sigIndex], block, data, fingerprint, keyServiceURI, authToken, localVerify)
-		if err != nil {
-			// use [MISSING] if we get an error we expect
-			if err == errNotFound || err == errNotFoundLocal {
-				author += fmt.Sprintf("%-18s %s\n", red("[MISSING]"), err)
-			} else {
-				author += fmt.Sprintf("%-18s %s\n", red("[FAIL]"), err)
-			}
+		c := checkSigner(ctx, keyring, trust, &fimg.DescrArr[part.sigIndex], block, data, fingerprint, keyServiceURI, authToken, localVerify, useLocalKeys, opts)
+		author += c.report
+		result.fingerprint = c.fingerprint
+		result.valid = c.err == nil
+		if c.err != nil {
 			fail = true
-		} else {
-			prefix := green("[LOCAL]")
-			if !local {
-				prefix = yellow("[REMOTE]")
-				notLocalKey = true
-			}
-
-			author += fmt.Sprintf("%-18s %s\n", prefix, i)
+		}
+		if c.notLocal {
+			notLocalKey = true
 		}
 
 		// (2) Verify data integrity by comparing hashes
@@ -533,13 +861,45 @@ func Verify(ctx context.Context, cpath, keyServiceURI string, id uint32, isGroup
 		}
 		author += fmt.Sprintf("\n")
 
-		keySigner = makeKeyEntity(i, verifyPartition, fingerprint, local, true, dataCheck)
+		keySigner = makeKeyEntity(SignatureTypePGP, c.identity, verifyPartition, fingerprint, c.local, true, dataCheck)
+		keySigner.Signer.KeyRevoked = c.err == errKeyRevoked
+		keySigner.Signer.KeyExpired = c.expired
+		if c.signer != nil {
+			keySigner.Signer.Trust = c.level.String()
+		}
 		keyEntityList.SignerKeys = append(keyEntityList.SignerKeys, keySigner)
 
+		result.local = c.local
+		result.created = pgpSignatureTime(data)
+		result.valid = result.valid && dataCheck
+		results = append(results, result)
 	}
 
 	keyEntityList.Signatures = len(sigsLink)
 
+	if skipped == len(sigsLink) {
+		author += fmt.Sprintf("%-18s no signature could be validated\n", red("[FAIL]"))
+		fail = true
+	}
+
+	if opts.Policy != nil {
+		// the policy alone decides if the image is verified
+		violations := opts.Policy.evaluate(&fimg, results)
+		fail = len(violations) > 0
+		keyEntityList.Policy = &PolicyReport{
+			Satisfied:  !fail,
+			Violations: violations,
+		}
+
+		author += fmt.Sprintf("Verification policy:\n")
+		for _, v := range violations {
+			author += fmt.Sprintf("%-18s %s\n", red("[FAIL]"), v)
+		}
+		if !fail {
+			author += fmt.Sprintf("%-18s Policy satisfied\n", green("[OK]"))
+		}
+	}
+
 	if jsonVerify {
 		jsonData, err := json.MarshalIndent(keyEntityList, "", "  ")
 		if err != nil {
@@ -550,18 +910,26 @@ func Verify(ctx context.Context, cpath, keyServiceURI string, id uint32, isGroup
 
 	if fail {
 		errRet = ErrVerificationFail
+	} else if opts.VerityParams != nil {
+		for _, r := range results {
+			if r.valid && r.verityParams != nil {
+				*opts.VerityParams = r.verityParams
+				break
+			}
+		}
 	}
 
 	return author, notLocalKey, errRet
 }
 
-func makeKeyEntity(name, partition, fingerprint string, local, corrupted, dataCheck bool) *Key {
+func makeKeyEntity(sigType, name, partition, fingerprint string, local, corrupted, dataCheck bool) *Key {
 	if name == "" {
 		name = "unknown"
 	}
 
 	keySigner := &Key{
 		Signer: KeyEntity{
+			Type:        sigType,
 			Partition:   partition,
 			Name:        name,
 			Fingerprint: fingerprint,
@@ -574,6 +942,54 @@ func makeKeyEntity(name, partition, fingerprint string, local, corrupted, dataCh
 	return keySigner
 }
 
+// pgpSignaturePacket returns the PGP signature of the clear-signed message
+// data, nil if it can't be read.
+func pgpSignaturePacket(data []byte) *packet.Signature {
+	block, _ := clearsign.Decode(data)
+	if block == nil {
+		return nil
+	}
+	p, err := packet.Read(block.ArmoredSignature.Body)
+	if err != nil {
+		return nil
+	}
+	sig, _ := p.(*packet.Signature)
+	return sig
+}
+
+// pgpSignatureTime returns the creation time of the PGP signature of the
+// clear-signed message data, the zero time if it can't be read.
+func pgpSignatureTime(data []byte) time.Time {
+	if sig := pgpSignaturePacket(data); sig != nil {
+		return sig.CreationTime
+	}
+	return time.Time{}
+}
+
+// signingKeyExpiry returns the expiration time of the key of signer which
+// made the PGP signature of the clear-signed message data, and whether the
+// key had expired when the signature was made.
+func signingKeyExpiry(signer *openpgp.Entity, data []byte) (time.Time, bool) {
+	sig := pgpSignaturePacket(data)
+	if sig == nil || sig.IssuerKeyId == nil {
+		return time.Time{}, false
+	}
+	expiry := sypgp.KeyExpiry(signer, *sig.IssuerKeyId)
+	return expiry, !expiry.IsZero() && sig.CreationTime.After(expiry)
+}
+
+// signerTrust returns the trust level of the key signer. Keys of the local
+// keyring have the level found in trust, keys of the trust bundle are fully
+// trusted and keys fetched from a key server aren't trusted.
+func signerTrust(trust map[string]sypgp.TrustLevel, signer *openpgp.Entity, local bool, bundle *sypgp.TrustBundle) sypgp.TrustLevel {
+	if local {
+		return trust[fmt.Sprintf("%X", signer.PrimaryKey.Fingerprint)]
+	} else if bundle != nil {
+		return sypgp.TrustFull
+	}
+	return sypgp.TrustNone
+}
+
 // Get first Identity data for convenience
 func getFirstIdentity(e *openpgp.Entity) string {
 	for _, i := range e.Identities {
@@ -582,78 +998,253 @@ func getFirstIdentity(e *openpgp.Entity) string {
 	return ""
 }
 
-func getSignerIdentity(ctx context.Context, keyring *sypgp.Handle, v *sif.Descriptor, block *clearsign.Block, data []byte, fingerprint, keyServiceURI, authToken string, local bool) (string, bool, error) {
-	// load the public keys available locally from the cache
-	elist, err := keyring.LoadPubKeyring()
+// getRevokedSigner checks the signature of the clear-signed message data
+// against the revoked keys of elist, which are ignored by
+// openpgp.CheckDetachedSignature, and returns the revoked key that made
+// the signature.
+func getRevokedSigner(elist openpgp.EntityList, data []byte) (*openpgp.Entity, bool) {
+	var revoked openpgp.EntityList
+	for _, e := range elist {
+		if sypgp.IsRevoked(e) {
+			// check the signature with a copy free of revocations
+			c := *e
+			c.Revocations = nil
+			revoked = append(revoked, &c)
+		}
+	}
+	if len(revoked) == 0 {
+		return nil, false
+	}
+
+	block, _ := clearsign.Decode(data)
+	if block == nil {
+		return nil, false
+	}
+	signer, err := openpgp.CheckDetachedSignature(revoked, bytes.NewBuffer(block.Bytes), block.ArmoredSignature.Body)
 	if err != nil {
-		return "", false, fmt.Errorf("could not load public keyring: %s", err)
+		return nil, false
 	}
+	return signer, true
+}
 
-	// search local keyring for key that matches signature first
-	signer, err := openpgp.CheckDetachedSignature(elist, bytes.NewBuffer(block.Bytes), block.ArmoredSignature.Body)
-	if err == nil {
-		return getFirstIdentity(signer), true, nil
+// checkRemoteRevocation returns true if the key matching fingerprint has
+// been revoked according to the key server. Key server errors are ignored.
+func checkRemoteRevocation(ctx context.Context, fingerprint, keyServiceURI, authToken string) bool {
+	netlist, err := sypgp.FetchPubkey(ctx, http.DefaultClient, fingerprint, keyServiceURI, authToken, true)
+	if err != nil {
+		sylog.Verbosef("Could not check key revocation with remote keystore: %s", err)
+		return false
 	}
+	for _, e := range netlist {
+		if sypgp.IsRevoked(e) {
+			return true
+		}
+	}
+	return false
+}
 
-	// if theres a error, thats probably because we dont have a local key. So download it and try again
-	// skip downloading and say we failed
-	if local {
-		return "", false, errNotFoundLocal
+// getSigner returns the key which made the signature of the clear-signed
+// message data, and whether it was found in the local keyring.
+func getSigner(ctx context.Context, keyring *sypgp.Handle, v *sif.Descriptor, block *clearsign.Block, data []byte, fingerprint, keyServiceURI, authToken string, local, useLocalKeys bool, bundle *sypgp.TrustBundle) (*openpgp.Entity, bool, error) {
+	if useLocalKeys {
+		// load the public keys available locally from the cache
+		elist, err := keyring.LoadPubKeyring()
+		if err != nil {
+			return nil, false, fmt.Errorf("could not load public keyring: %s", err)
+		}
+
+		// search local keyring for key that matches signature first
+		signer, err := openpgp.CheckDetachedSignature(elist, bytes.NewBuffer(block.Bytes), block.ArmoredSignature.Body)
+		if err == nil {
+			// the key may have been revoked since it was added to
+			// the local keyring, it's looked up by the fingerprint
+			// of the key which made the signature, not the declared one
+			signerFP := fmt.Sprintf("%X", signer.PrimaryKey.Fingerprint)
+			if bundle != nil {
+				if bundle.FindRevoked(signerFP) != nil {
+					return signer, true, errKeyRevoked
+				}
+			} else if !local && checkRemoteRevocation(ctx, signerFP, keyServiceURI, authToken) {
+				return signer, false, errKeyRevoked
+			}
+			return signer, true, nil
+		}
+
+		if signer, ok := getRevokedSigner(elist, data); ok {
+			return signer, true, errKeyRevoked
+		}
 	}
 
 	// this is needed to reset the block objects reader since it is consumed in the last call
 	block, _ = clearsign.Decode(data)
 	if block == nil {
-		return "", false, fmt.Errorf("failed to parse signature block")
+		return nil, false, fmt.Errorf("failed to parse signature block")
+	}
+
+	// the trust bundle replaces the key server
+	if bundle != nil {
+		signer, err := openpgp.CheckDetachedSignature(bundle.Keys, bytes.NewBuffer(block.Bytes), block.ArmoredSignature.Body)
+		if err == nil {
+			return signer, false, nil
+		}
+		if signer, ok := getRevokedSigner(bundle.Keys, data); ok {
+			return signer, false, errKeyRevoked
+		}
+		return nil, false, errNotFoundBundle
+	}
+
+	// if theres a error, thats probably because we dont have a local key. So download it and try again
+	// skip downloading and say we failed
+	if local {
+		return nil, false, errNotFoundLocal
 	}
 
 	// download the key
 	sylog.Verbosef("Key not found in local keyring, checking remote keystore: %s\n", fingerprint[32:])
 	netlist, err := sypgp.FetchPubkey(ctx, http.DefaultClient, fingerprint, keyServiceURI, authToken, true)
 	if err != nil {
-		return "", false, errNotFound
+		return nil, false, errNotFound
 	}
 
 	sylog.Verbosef("Found key in remote keystore: %s", fingerprint[32:])
 	// search remote keyring for key that matches signature
-	signer, err = openpgp.CheckDetachedSignature(netlist, bytes.NewBuffer(block.Bytes), block.ArmoredSignature.Body)
+	signer, err := openpgp.CheckDetachedSignature(netlist, bytes.NewBuffer(block.Bytes), block.ArmoredSignature.Body)
 	if err == nil {
-		return getFirstIdentity(signer), false, nil
+		return signer, false, nil
 	}
 
-	return "", false, err
+	if signer, ok := getRevokedSigner(netlist, data); ok {
+		return signer, false, errKeyRevoked
+	}
+
+	return nil, false, err
 }
 
-// getSigsLinkPrimPart is just like getSigsPrimPart, but returns a []signatureLink
-// instead of descriptors.
-func getSigsLinkPrimPart(fimg *sif.FileImage) ([]signatureLink, error) {
-	_, systemPartID, err := fimg.GetPartPrimSys()
+// signerCheck is the result of the checks of the key of a signer.
+type signerCheck struct {
+	signer *openpgp.Entity
+	// fingerprint is the fingerprint of the key which made
+	// the signature, empty if the key wasn't found
+	fingerprint string
+	identity    string
+	local       bool
+	notLocal    bool
+	expired     bool
+	level       sypgp.TrustLevel
+	// report holds the lines describing the result
+	report string
+	err    error
+}
+
+// checkSigner looks up the key of the signer with fingerprint of the
+// clearsigned block with getSigner, and checks its expiry and trust level
+// against opts.
+func checkSigner(ctx context.Context, keyring *sypgp.Handle, trust map[string]sypgp.TrustLevel, v *sif.Descriptor, block *clearsign.Block, data []byte, fingerprint, keyServiceURI, authToken string, localVerify, useLocalKeys bool, opts VerifyOptions) *signerCheck {
+	green := color.New(color.FgGreen).SprintFunc()
+	yellow := color.New(color.FgYellow).SprintFunc()
+	red := color.New(color.FgRed).SprintFunc()
+
+	c := &signerCheck{}
+	var expiry time.Time
+
+	signer, local, err := getSigner(ctx, keyring, v, block, data, fingerprint, keyServiceURI, authToken, localVerify, useLocalKeys, opts.TrustBundle)
+	if signer != nil {
+		c.fingerprint = fmt.Sprintf("%X", signer.PrimaryKey.Fingerprint)
+		if err == nil && c.fingerprint != fingerprint {
+			err = errEntityMismatch
+		}
+		c.identity = getFirstIdentity(signer)
+		expiry, c.expired = signingKeyExpiry(signer, data)
+		if c.expired && err == nil && opts.FailExpiredKey {
+			err = errKeyExpired
+		}
+		c.level = signerTrust(trust, signer, local, opts.TrustBundle)
+		if err == nil && c.level < opts.RequireTrust {
+			err = errKeyUntrusted
+		}
+	}
 	if err != nil {
-		return nil, fmt.Errorf("no primary partition found")
+		if err == errNotFound || err == errNotFoundLocal || err == errNotFoundBundle {
+			c.report += fmt.Sprintf("%-18s %s\n", red("[MISSING]"), err)
+		} else if err == errKeyRevoked {
+			c.report += fmt.Sprintf("%-18s %s: %s\n", red("[REVOKED]"), c.identity, err)
+		} else if err == errKeyExpired {
+			c.report += fmt.Sprintf("%-18s %s: %s\n", red("[EXPIRED]"), c.identity, err)
+		} else if err == errKeyUntrusted {
+			c.report += fmt.Sprintf("%-18s %s: trust level %s, %s required: %s\n", red("[UNTRUSTED]"), c.identity, c.level, opts.RequireTrust, err)
+		} else {
+			c.report += fmt.Sprintf("%-18s %s\n", red("[FAIL]"), err)
+		}
+	} else {
+		prefix := green("[LOCAL]")
+		if !local && opts.TrustBundle != nil {
+			prefix = green("[BUNDLE]")
+			c.notLocal = true
+		} else if !local {
+			prefix = yellow("[REMOTE]")
+			c.notLocal = true
+		}
+		c.report += fmt.Sprintf("%-18s %s\n", prefix, c.identity)
+		if c.level == sypgp.TrustFull {
+			c.report += fmt.Sprintf("%-18s %s\n", green("[TRUST]"), c.level)
+		} else {
+			c.report += fmt.Sprintf("%-18s %s\n", yellow("[TRUST]"), c.level)
+		}
+		if c.expired {
+			c.report += fmt.Sprintf("%-18s signing key expired on %s, before the signature was made\n", yellow("[EXPIRED]"), expiry)
+		}
 	}
 
-	_, sigIdx, err := fimg.GetLinkedDescrsByType(uint32(systemPartID+1), sif.DataSignature)
+	c.signer, c.local, c.err = signer, local, err
+	return c
+}
+
+// getSigsLinkPrimPart is just like getSigsPrimPart, but returns a []signatureLink
+// instead of descriptors. For multi-architecture images, signatures of the system
+// partitions of every architecture are returned.
+func getSigsLinkPrimPart(fimg *sif.FileImage) ([]signatureLink, error) {
+	descr, err := getSystemPartitions(fimg)
 	if err != nil {
-		return nil, fmt.Errorf("no signatures found for system partition")
+		return nil, err
 	}
 
-	sigLink := make([]signatureLink, len(sigIdx))
+	var sigLink []signatureLink
 
-	for i, s := range sigIdx {
-		sigLink[i].sigIndex = s
-		sigLink[i].dataIndex = systemPartID
+	for i, d := range descr {
+		_, sigIdx, err := fimg.GetLinkedDescrsByType(d.ID, sif.DataSignature)
+		if err != nil && i == 0 {
+			return nil, fmt.Errorf("no signatures found for system partition")
+		} else if err != nil {
+			arch, _ := d.GetArch()
+			return nil, fmt.Errorf("no signatures found for %s system partition", sif.GetGoArch(string(arch[:sif.HdrArchLen-1])))
+		}
+
+		for _, s := range sigIdx {
+			sigLink = append(sigLink, signatureLink{sigIndex: s, dataIndex: int(d.ID) - 1})
+		}
+		sigLink = appendVeritySigs(fimg, sigLink, d)
 	}
 
 	return sigLink, nil
 }
 
-// return all signatures for the primary partition
-func getSigsPrimPart(fimg *sif.FileImage) (sigs []*sif.Descriptor, descr []*sif.Descriptor, err error) {
+// return all signatures for the system partition used to run the image on
+// arch, or for the primary partition if arch is empty
+func getSigsPrimPart(fimg *sif.FileImage, arch string) (sigs []*sif.Descriptor, descr []*sif.Descriptor, err error) {
 	descr = make([]*sif.Descriptor, 1)
 
-	descr[0], _, err = fimg.GetPartPrimSys()
-	if err != nil {
-		return nil, nil, fmt.Errorf("no primary partition found")
+	if arch == "" {
+		descr[0], _, err = fimg.GetPartPrimSys()
+		if err != nil {
+			return nil, nil, fmt.Errorf("no primary partition found")
+		}
+	} else {
+		descr[0], err = image.GetArchSystemPartition(fimg, arch)
+		if err != nil {
+			return nil, nil, err
+		} else if descr[0] == nil {
+			return nil, nil, fmt.Errorf("no primary partition found")
+		}
 	}
 
 	sigs, _, err = fimg.GetLinkedDescrsByType(descr[0].ID, sif.DataSignature)
@@ -664,9 +1255,9 @@ func getSigsPrimPart(fimg *sif.FileImage) (sigs []*sif.Descriptor, descr []*sif.
 	return
 }
 
-func getSignEntities(fimg *sif.FileImage) ([]string, error) {
+func getSignEntities(fimg *sif.FileImage, arch string) ([]string, error) {
 	// get all signature blocks (signatures) for ID/GroupID selected (descr) from SIF file
-	signatures, _, err := getSigsPrimPart(fimg)
+	signatures, _, err := getSigsPrimPart(fimg, arch)
 	if err != nil {
 		return nil, err
 	}
@@ -692,15 +1283,22 @@ func GetSignEntities(cpath string) ([]string, error) {
 	}
 	defer fimg.UnloadContainer()
 
-	return getSignEntities(&fimg)
+	return getSignEntities(&fimg, "")
 }
 
 // GetSignEntitiesFp returns all signing entities for an ID/Groupid
 func GetSignEntitiesFp(fp *os.File) ([]string, error) {
+	return GetSignEntitiesArchFp(fp, "")
+}
+
+// GetSignEntitiesArchFp returns all signing entities of the system partition
+// used to run the image on arch, as selected by the --arch option, or of the
+// primary partition if arch is empty
+func GetSignEntitiesArchFp(fp *os.File, arch string) ([]string, error) {
 	fimg, err := sif.LoadContainerFp(fp, true)
 	if err != nil {
 		return nil, err
 	}
 
-	return getSignEntities(&fimg)
+	return getSignEntities(&fimg, arch)
 }
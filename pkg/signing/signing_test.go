@@ -0,0 +1,550 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package signing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sylabs/sif/pkg/sif"
+	"github.com/sylabs/singularity/pkg/sypgp"
+	"github.com/sylabs/singularity/pkg/util/verity"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/clearsign"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+func TestVerifyRevokedKey(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+
+	dir, err := ioutil.TempDir("", "revoked-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	keyringDir := filepath.Join(dir, "sypgp")
+	os.Setenv("SINGULARITY_SYPGPDIR", keyringDir)
+	defer os.Unsetenv("SINGULARITY_SYPGPDIR")
+
+	keyring := sypgp.NewHandle(keyringDir)
+	e, err := keyring.GenKeyPair(sypgp.GenKeyPairOptions{Name: "signer", Email: "signer@my.info", KeyLength: 1024})
+	if err != nil {
+		t.Fatalf("failed to generate key pair: %s", err)
+	}
+
+	path := filepath.Join(dir, "image.sif")
+	createTestSIF(t, path)
+	if err := Sign(path, 0, false, false, false, "", 0); err != nil {
+		t.Fatalf("unexpected error while signing: %s", err)
+	}
+
+	verify := func() (*KeyEntity, error) {
+		out, _, err := Verify(context.Background(), path, "", 0, false, false, "", true, true, VerifyOptions{})
+		var list KeyList
+		if jerr := json.Unmarshal([]byte(out), &list); jerr != nil {
+			t.Fatalf("failed to decode verify output: %s", jerr)
+		}
+		if len(list.SignerKeys) != 1 {
+			t.Fatalf("unexpected signatures: %s", out)
+		}
+		return &list.SignerKeys[0].Signer, err
+	}
+
+	signer, err := verify()
+	if err != nil {
+		t.Fatalf("unexpected verification error: %s", err)
+	}
+	if signer.KeyRevoked || !signer.KeyLocal {
+		t.Errorf("unexpected signer: %+v", signer)
+	}
+
+	if _, err := keyring.RevokeKey(fmt.Sprintf("%X", e.PrimaryKey.Fingerprint)); err != nil {
+		t.Fatalf("failed to revoke key: %s", err)
+	}
+
+	signer, err = verify()
+	if err != ErrVerificationFail {
+		t.Fatalf("unexpected verification result: %v", err)
+	}
+	if !signer.KeyRevoked || signer.Name == "unknown" {
+		t.Errorf("unexpected signer: %+v", signer)
+	}
+}
+
+func TestVerifyExpiredKey(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+
+	dir, err := ioutil.TempDir("", "expired-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	keyringDir := filepath.Join(dir, "sypgp")
+	os.Setenv("SINGULARITY_SYPGPDIR", keyringDir)
+	defer os.Unsetenv("SINGULARITY_SYPGPDIR")
+
+	keyring := sypgp.NewHandle(keyringDir)
+	opts := sypgp.GenKeyPairOptions{Name: "signer", Email: "signer@my.info", KeyType: sypgp.KeyTypeECDSAP256, Expire: 24 * time.Hour}
+	e, err := keyring.GenKeyPair(opts)
+	if err != nil {
+		t.Fatalf("failed to generate key pair: %s", err)
+	}
+
+	// Sign refuses expired keys, date the signature after the key expiration
+	key := sypgp.SigningKey(e, time.Now())
+	if key == nil {
+		t.Fatalf("no signing key found")
+	}
+	config := &packet.Config{Time: func() time.Time { return time.Now().Add(48 * time.Hour) }}
+	sign := func(sifhash string) ([]byte, error) {
+		var buf bytes.Buffer
+		w, err := clearsign.Encode(&buf, key, config)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write([]byte(sifhash)); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	path := filepath.Join(dir, "image.sif")
+	createTestSIF(t, path)
+	if err := addSignatures(path, 0, false, false, false, e.PrimaryKey.Fingerprint, sign); err != nil {
+		t.Fatalf("unexpected error while signing: %s", err)
+	}
+
+	verify := func(opts VerifyOptions) (*KeyEntity, error) {
+		out, _, err := Verify(context.Background(), path, "", 0, false, false, "", true, true, opts)
+		var list KeyList
+		if jerr := json.Unmarshal([]byte(out), &list); jerr != nil {
+			t.Fatalf("failed to decode verify output: %s", jerr)
+		}
+		if len(list.SignerKeys) != 1 {
+			t.Fatalf("unexpected signatures: %s", out)
+		}
+		return &list.SignerKeys[0].Signer, err
+	}
+
+	signer, err := verify(VerifyOptions{})
+	if err != nil {
+		t.Fatalf("unexpected verification error: %s", err)
+	}
+	if !signer.KeyExpired {
+		t.Errorf("unexpected signer: %+v", signer)
+	}
+
+	signer, err = verify(VerifyOptions{FailExpiredKey: true})
+	if err != ErrVerificationFail {
+		t.Fatalf("unexpected verification result: %v", err)
+	}
+	if !signer.KeyExpired {
+		t.Errorf("unexpected signer: %+v", signer)
+	}
+}
+
+func TestVerifyRequireTrust(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+
+	dir, err := ioutil.TempDir("", "trust-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	keyringDir := filepath.Join(dir, "sypgp")
+	os.Setenv("SINGULARITY_SYPGPDIR", keyringDir)
+	defer os.Unsetenv("SINGULARITY_SYPGPDIR")
+
+	keyring := sypgp.NewHandle(keyringDir)
+	e, err := keyring.GenKeyPair(sypgp.GenKeyPairOptions{Name: "signer", Email: "signer@my.info", KeyLength: 1024})
+	if err != nil {
+		t.Fatalf("failed to generate key pair: %s", err)
+	}
+	fingerprint := fmt.Sprintf("%X", e.PrimaryKey.Fingerprint)
+
+	path := filepath.Join(dir, "image.sif")
+	createTestSIF(t, path)
+	if err := Sign(path, 0, false, false, false, "", 0); err != nil {
+		t.Fatalf("unexpected error while signing: %s", err)
+	}
+
+	tests := []struct {
+		trust   sypgp.TrustLevel
+		require sypgp.TrustLevel
+		pass    bool
+	}{
+		{sypgp.TrustFull, sypgp.TrustFull, true},
+		{sypgp.TrustMarginal, sypgp.TrustFull, false},
+		{sypgp.TrustMarginal, sypgp.TrustMarginal, true},
+		{sypgp.TrustNone, sypgp.TrustMarginal, false},
+		{sypgp.TrustNone, sypgp.TrustNone, true},
+	}
+
+	for _, tt := range tests {
+		if err := keyring.SetKeyTrust(fingerprint, tt.trust); err != nil {
+			t.Fatalf("failed to set key trust level: %s", err)
+		}
+
+		out, _, err := Verify(context.Background(), path, "", 0, false, false, "", true, true, VerifyOptions{RequireTrust: tt.require})
+		var list KeyList
+		if jerr := json.Unmarshal([]byte(out), &list); jerr != nil {
+			t.Fatalf("failed to decode verify output: %s", jerr)
+		}
+		if len(list.SignerKeys) != 1 {
+			t.Fatalf("unexpected signatures: %s", out)
+		}
+		if trust := list.SignerKeys[0].Signer.Trust; trust != tt.trust.String() {
+			t.Errorf("unexpected signer trust level %s, expected %s", trust, tt.trust)
+		}
+		if tt.pass && err != nil {
+			t.Errorf("%s trust with %s required: unexpected verification error: %s", tt.trust, tt.require, err)
+		} else if !tt.pass && err != ErrVerificationFail {
+			t.Errorf("%s trust with %s required: unexpected verification result: %v", tt.trust, tt.require, err)
+		}
+	}
+}
+
+func TestVerifyTrustBundle(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+
+	dir, err := ioutil.TempDir("", "bundle-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	keyringDir := filepath.Join(dir, "sypgp")
+	os.Setenv("SINGULARITY_SYPGPDIR", keyringDir)
+	defer os.Unsetenv("SINGULARITY_SYPGPDIR")
+
+	keyring := sypgp.NewHandle(keyringDir)
+	e, err := keyring.GenKeyPair(sypgp.GenKeyPairOptions{Name: "signer", Email: "signer@my.info", KeyLength: 1024})
+	if err != nil {
+		t.Fatalf("failed to generate key pair: %s", err)
+	}
+	fingerprint := fmt.Sprintf("%X", e.PrimaryKey.Fingerprint)
+
+	path := filepath.Join(dir, "image.sif")
+	createTestSIF(t, path)
+	if err := Sign(path, 0, false, false, false, "", 0); err != nil {
+		t.Fatalf("unexpected error while signing: %s", err)
+	}
+
+	// the bundle is signed by another key, revoked keys can't sign bundles
+	admin, err := keyring.GenKeyPair(sypgp.GenKeyPairOptions{Name: "admin", Email: "admin@my.info", KeyLength: 1024})
+	if err != nil {
+		t.Fatalf("failed to generate key pair: %s", err)
+	}
+
+	// the signing key is only known by the trust bundle
+	loadBundle := func() *sypgp.TrustBundle {
+		bundlePath := filepath.Join(dir, "trust.bundle")
+		f, err := os.Create(bundlePath)
+		if err != nil {
+			t.Fatalf("failed to create trust bundle: %s", err)
+		}
+		defer f.Close()
+		if err := keyring.CreateTrustBundle(f, []string{fingerprint}, admin); err != nil {
+			t.Fatalf("failed to create trust bundle: %s", err)
+		}
+		if err := keyring.RemovePubKey(fingerprint); err != nil {
+			t.Fatalf("failed to remove public key: %s", err)
+		}
+		b, err := sypgp.LoadTrustBundle(bundlePath, openpgp.EntityList{admin})
+		if err != nil {
+			t.Fatalf("failed to load trust bundle: %s", err)
+		}
+		return b
+	}
+
+	verify := func(b *sypgp.TrustBundle) (*KeyEntity, error) {
+		out, _, err := Verify(context.Background(), path, "", 0, false, false, "", false, true, VerifyOptions{TrustBundle: b})
+		var list KeyList
+		if jerr := json.Unmarshal([]byte(out), &list); jerr != nil {
+			t.Fatalf("failed to decode verify output: %s", jerr)
+		}
+		if len(list.SignerKeys) != 1 {
+			t.Fatalf("unexpected signatures: %s", out)
+		}
+		return &list.SignerKeys[0].Signer, err
+	}
+
+	b := loadBundle()
+	signer, err := verify(b)
+	if err != nil {
+		t.Fatalf("unexpected verification error: %s", err)
+	}
+	if signer.KeyRevoked || signer.KeyLocal || signer.Name == "unknown" {
+		t.Errorf("unexpected signer: %+v", signer)
+	}
+
+	// keys missing from the bundle are never fetched from the key server
+	b.Keys = nil
+	if _, err := verify(b); err != ErrVerificationFail {
+		t.Errorf("unexpected verification result: %v", err)
+	}
+
+	if _, err := keyring.RevokeKey(fingerprint); err != nil {
+		t.Fatalf("failed to revoke key: %s", err)
+	}
+
+	signer, err = verify(loadBundle())
+	if err != ErrVerificationFail {
+		t.Fatalf("unexpected verification result: %v", err)
+	}
+	if !signer.KeyRevoked {
+		t.Errorf("unexpected signer: %+v", signer)
+	}
+}
+
+func TestSignVerity(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+
+	dir, err := ioutil.TempDir("", "verity-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	keyringDir := filepath.Join(dir, "sypgp")
+	os.Setenv("SINGULARITY_SYPGPDIR", keyringDir)
+	defer os.Unsetenv("SINGULARITY_SYPGPDIR")
+
+	keyring := sypgp.NewHandle(keyringDir)
+	if _, err := keyring.GenKeyPair(sypgp.GenKeyPairOptions{Name: "signer", Email: "signer@my.info", KeyLength: 1024}); err != nil {
+		t.Fatalf("failed to generate key pair: %s", err)
+	}
+
+	path := filepath.Join(dir, "image.sif")
+	createTestSIF(t, path)
+
+	// add verity objects linked to the system partition
+	fimg, err := sif.LoadContainer(path, false)
+	if err != nil {
+		t.Fatalf("failed to load SIF: %s", err)
+	}
+	objects := []struct {
+		name     string
+		datatype sif.Datatype
+		data     []byte
+	}{
+		{verity.HashTreeName, sif.DataGeneric, []byte("tree")},
+		{verity.ParamsName, sif.DataGenericJSON, []byte(`{"rootHash":"00"}`)},
+	}
+	for _, o := range objects {
+		input := sif.DescriptorInput{
+			Datatype: o.datatype,
+			Groupid:  sif.DescrDefaultGroup,
+			Link:     1,
+			Fname:    o.name,
+			Data:     o.data,
+			Size:     int64(len(o.data)),
+		}
+		if err := fimg.AddObject(input); err != nil {
+			t.Fatalf("failed to add %s: %s", o.name, err)
+		}
+	}
+	fimg.UnloadContainer()
+
+	if err := Sign(path, 0, false, false, false, "", 0); err != nil {
+		t.Fatalf("unexpected error while signing: %s", err)
+	}
+
+	// the system partition and each verity object have their own
+	// signature, the signature of the partition only covers it
+	fimg, err = sif.LoadContainer(path, true)
+	if err != nil {
+		t.Fatalf("failed to load SIF: %s", err)
+	}
+	descr, _, err := fimg.GetPartPrimSys()
+	if err != nil {
+		t.Fatalf("failed to get system partition: %s", err)
+	}
+	signed := append([]*sif.Descriptor{descr}, verityDescrs(&fimg, descr)...)
+	if len(signed) != 3 {
+		t.Errorf("unexpected number of verity objects %d", len(signed)-1)
+	}
+	for _, d := range signed {
+		sigs, _, err := fimg.GetLinkedDescrsByType(d.ID, sif.DataSignature)
+		if err != nil || len(sigs) != 1 {
+			t.Errorf("descriptor %d should have one signature: %v", d.ID, err)
+			continue
+		}
+		block, _ := clearsign.Decode(sigs[0].GetData(&fimg))
+		if block == nil {
+			t.Errorf("failed to decode signature of descriptor %d", d.ID)
+		} else if hash := computeHashStr(&fimg, []*sif.Descriptor{d}); string(bytes.TrimRight(block.Plaintext, "\n")) != hash {
+			t.Errorf("signature of descriptor %d doesn't cover only its data", d.ID)
+		}
+	}
+	fimg.UnloadContainer()
+
+	var params []byte
+	verify := func() error {
+		params = nil
+		_, _, err := Verify(context.Background(), path, "", 0, false, false, "", true, false, VerifyOptions{VerityParams: &params})
+		return err
+	}
+	if err := verify(); err != nil {
+		t.Fatalf("unexpected verification error: %s", err)
+	}
+	if string(params) != `{"rootHash":"00"}` {
+		t.Errorf("unexpected verified verity parameters %q", params)
+	}
+
+	// the root hash is covered by the signature of the parameters
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read SIF: %s", err)
+	}
+	content = bytes.Replace(content, []byte(`"rootHash":"00"`), []byte(`"rootHash":"ff"`), 1)
+	if err := ioutil.WriteFile(path, content, 0644); err != nil {
+		t.Fatalf("failed to write SIF: %s", err)
+	}
+	if err := verify(); err != ErrVerificationFail {
+		t.Errorf("unexpected verification result: %v", err)
+	}
+	if params != nil {
+		t.Errorf("verity parameters returned after a failed verification: %q", params)
+	}
+}
+
+func TestSignGPGAgent(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	for _, bin := range []string{"gpg", "gpg-agent", "gpgconf"} {
+		if _, err := exec.LookPath(bin); err != nil {
+			t.Skipf("%s not found", bin)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "gpg-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// use a throwaway GnuPG home and Singularity keyring
+	gnupgHome := filepath.Join(dir, "gnupg")
+	if err := os.Mkdir(gnupgHome, 0700); err != nil {
+		t.Fatalf("failed to create GnuPG home: %s", err)
+	}
+	os.Setenv("GNUPGHOME", gnupgHome)
+	defer os.Unsetenv("GNUPGHOME")
+	defer exec.Command("gpgconf", "--kill", "gpg-agent").Run()
+
+	keyringDir := filepath.Join(dir, "sypgp")
+	os.Setenv("SINGULARITY_SYPGPDIR", keyringDir)
+	defer os.Unsetenv("SINGULARITY_SYPGPDIR")
+
+	gpg := func(t *testing.T, args ...string) []byte {
+		args = append([]string{"--batch", "--pinentry-mode", "loopback", "--passphrase", ""}, args...)
+		out, err := exec.Command("gpg", args...).Output()
+		if err != nil {
+			t.Fatalf("gpg %v failed: %s", args, err)
+		}
+		return out
+	}
+	gpg(t, "--quick-gen-key", "GnuPG Signer <gnupg@my.info>", "rsa2048", "cert", "never")
+	keys, err := gpgKeyFingerprints(gpg(t, "--with-colons", "--list-keys"))
+	if err != nil || len(keys) != 1 {
+		t.Fatalf("failed to get the GnuPG key fingerprint: %v", err)
+	}
+	keyring := sypgp.NewHandle(keyringDir)
+	if err := keyring.PathsCheck(); err != nil {
+		t.Fatalf("failed to create keyring: %s", err)
+	}
+
+	// the signing subkey added last is used by gpg
+	for _, tt := range []struct{ name, algo string }{{"RSA", "rsa2048"}, {"ECDSA", "nistp256/ecdsa"}} {
+		t.Run(tt.name, func(t *testing.T) {
+			gpg(t, "--quick-add-key", keys[0], tt.algo, "sign", "never")
+
+			path := filepath.Join(dir, tt.name+".sif")
+			createTestSIF(t, path)
+
+			if err := SignGPGAgent(path, 0, false, false, false, "unknown@my.info"); err == nil {
+				t.Errorf("unexpected success while signing with an unknown GnuPG key")
+			}
+			if err := SignGPGAgent(path, 0, false, false, false, "gnupg@my.info"); err != nil {
+				t.Fatalf("unexpected error while signing with gpg-agent: %s", err)
+			}
+
+			// the public key with the new subkey is imported again
+			keyring.RemovePubKey(keys[0])
+			if _, _, err := Verify(context.Background(), path, "", 0, false, false, "", true, false, VerifyOptions{}); err != ErrVerificationFail {
+				t.Fatalf("unexpected verification result without public key: %v", err)
+			}
+
+			pubPath := filepath.Join(dir, tt.name+".asc")
+			if err := ioutil.WriteFile(pubPath, gpg(t, "--armor", "--export", keys[0]), 0644); err != nil {
+				t.Fatalf("failed to write public key: %s", err)
+			}
+			if err := keyring.ImportKey(pubPath, false); err != nil {
+				t.Fatalf("failed to import public key: %s", err)
+			}
+
+			out, _, err := Verify(context.Background(), path, "", 0, false, false, "", true, true, VerifyOptions{})
+			if err != nil {
+				t.Fatalf("unexpected verification error: %s\n%s", err, out)
+			}
+			var list KeyList
+			if err := json.Unmarshal([]byte(out), &list); err != nil {
+				t.Fatalf("failed to decode verify output: %s", err)
+			}
+			if len(list.SignerKeys) != 1 || list.SignerKeys[0].Signer.Fingerprint != keys[0] {
+				t.Errorf("unexpected signer: %s", out)
+			}
+		})
+	}
+}
+
+// gpgKeyFingerprints returns the primary key fingerprints of the gpg
+// colon listing out.
+func gpgKeyFingerprints(out []byte) ([]string, error) {
+	var fps []string
+	primary := false
+	for _, line := range strings.Split(string(out), "\n") {
+		f := strings.Split(line, ":")
+		switch {
+		case f[0] == "pub":
+			primary = true
+		case f[0] == "fpr" && primary && len(f) > 9:
+			fps = append(fps, f[9])
+			primary = false
+		}
+	}
+	if len(fps) == 0 {
+		return nil, fmt.Errorf("no key found")
+	}
+	return fps, nil
+}
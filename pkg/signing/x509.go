@@ -0,0 +1,422 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package signing
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+)
+
+// PEM block types of an X.509 signature block. A signature block is made
+// of the signed hash string, the signature and the signer certificate
+// followed by the intermediate certificates of its chain.
+const (
+	x509HashBlock      = "SIF SIGNED HASH"
+	x509SignatureBlock = "SIF SIGNATURE"
+	x509CertBlock      = "CERTIFICATE"
+	x509CRLBlock       = "X509 CRL"
+
+	// x509SigningTimeHeader is the signature block header holding the
+	// signing time, which is signed along with the hash string.
+	x509SigningTimeHeader = "Signing-Time"
+)
+
+// x509Algorithms lists the signature algorithms used to create X.509
+// signature blocks.
+var x509Algorithms = []x509.SignatureAlgorithm{
+	x509.SHA384WithRSA,
+	x509.ECDSAWithSHA384,
+	x509.PureEd25519,
+}
+
+// x509Signature is a decoded X.509 signature block.
+type x509Signature struct {
+	hash        []byte
+	signingTime string
+	algorithm   x509.SignatureAlgorithm
+	signature   []byte
+	certs       []*x509.Certificate
+}
+
+var errNoCABundle = errors.New("no CA bundle provided to validate the certificate chain")
+
+// x509Verifier validates the certificate chain of X.509 signature blocks.
+type x509Verifier struct {
+	roots *x509.CertPool
+	crls  []*pkix.CertificateList
+}
+
+// SignX509 takes the path of a container and generates an X.509 signature
+// block for its system partition, or for the partitions determined by id,
+// isGroup and signAll. The signature is created with the private key found
+// in the PEM file keyPath and the signature block carries the certificate
+// chain found in the PEM file certPath, which must start with the
+// certificate of the signing key. If replace is set, the existing
+// signatures of the signed partitions are removed.
+func SignX509(cpath string, id uint32, isGroup, signAll, replace bool, certPath, keyPath string) error {
+	certs, err := loadCertificates(certPath)
+	if err != nil {
+		return err
+	}
+	leaf := certs[0]
+
+	signer, err := loadPrivateKey(keyPath)
+	if err != nil {
+		return err
+	}
+
+	pub, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return fmt.Errorf("while reading public key: %s", err)
+	}
+	if !bytes.Equal(pub, leaf.RawSubjectPublicKeyInfo) {
+		return fmt.Errorf("private key %s doesn't match certificate %s", keyPath, certPath)
+	}
+
+	now := time.Now()
+	if now.Before(leaf.NotBefore) || now.After(leaf.NotAfter) {
+		return fmt.Errorf("certificate %s is not valid before %s or after %s", certPath, leaf.NotBefore, leaf.NotAfter)
+	}
+	if err := checkKeyUsage(leaf); err != nil {
+		return err
+	}
+
+	algorithm, opts := x509Algorithm(signer.Public())
+	if algorithm == x509.UnknownSignatureAlgorithm {
+		return fmt.Errorf("unsupported private key type %T", signer.Public())
+	}
+
+	return addSignatures(cpath, id, isGroup, signAll, replace, sha1.Sum(leaf.Raw), func(sifhash string) ([]byte, error) {
+		signingTime := time.Now().UTC().Format(time.RFC3339)
+		msg := signedMessage([]byte(sifhash), signingTime)
+		if opts != crypto.Hash(0) {
+			digest := sha512.Sum384(msg)
+			msg = digest[:]
+		}
+
+		signature, err := signer.Sign(rand.Reader, msg, opts)
+		if err != nil {
+			return nil, fmt.Errorf("could not sign hash: %s", err)
+		}
+
+		var block bytes.Buffer
+		pem.Encode(&block, &pem.Block{Type: x509HashBlock, Bytes: []byte(sifhash)})
+		pem.Encode(&block, &pem.Block{
+			Type: x509SignatureBlock,
+			Headers: map[string]string{
+				"Algorithm":           algorithm.String(),
+				x509SigningTimeHeader: signingTime,
+			},
+			Bytes: signature,
+		})
+		for _, c := range certs {
+			pem.Encode(&block, &pem.Block{Type: x509CertBlock, Bytes: c.Raw})
+		}
+		return block.Bytes(), nil
+	})
+}
+
+// signedMessage returns the message signed for the hash string and the
+// signing time of a signature block.
+func signedMessage(hash []byte, signingTime string) []byte {
+	if signingTime == "" {
+		return hash
+	}
+	msg := append([]byte{}, hash...)
+	return append(msg, []byte("\n"+x509SigningTimeHeader+": "+signingTime)...)
+}
+
+// isX509Signature returns true if data is an X.509 signature block.
+func isX509Signature(data []byte) bool {
+	block, _ := pem.Decode(data)
+	return block != nil && block.Type == x509HashBlock
+}
+
+// decodeX509Signature decodes the X.509 signature block data.
+func decodeX509Signature(data []byte) (*x509Signature, error) {
+	sig := &x509Signature{algorithm: x509.UnknownSignatureAlgorithm}
+
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		switch block.Type {
+		case x509HashBlock:
+			sig.hash = block.Bytes
+		case x509SignatureBlock:
+			for _, a := range x509Algorithms {
+				if a.String() == block.Headers["Algorithm"] {
+					sig.algorithm = a
+				}
+			}
+			sig.signingTime = block.Headers[x509SigningTimeHeader]
+			sig.signature = block.Bytes
+		case x509CertBlock:
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("while parsing certificate: %s", err)
+			}
+			sig.certs = append(sig.certs, cert)
+		}
+	}
+
+	if sig.hash == nil || sig.signature == nil || len(sig.certs) == 0 {
+		return nil, fmt.Errorf("incomplete signature block")
+	}
+	if sig.algorithm == x509.UnknownSignatureAlgorithm {
+		return nil, fmt.Errorf("unsupported signature algorithm")
+	}
+	return sig, nil
+}
+
+// newX509Verifier returns a verifier validating certificate chains with
+// the root certificates found in the PEM file caBundle and checking
+// revocation with the CRLs found in the file crlPath, if not empty.
+func newX509Verifier(caBundle, crlPath string) (*x509Verifier, error) {
+	if caBundle == "" {
+		if crlPath != "" {
+			return nil, fmt.Errorf("a CA bundle is required to check certificate revocation")
+		}
+		return nil, nil
+	}
+
+	certs, err := loadCertificates(caBundle)
+	if err != nil {
+		return nil, err
+	}
+	v := &x509Verifier{roots: x509.NewCertPool()}
+	for _, c := range certs {
+		v.roots.AddCert(c)
+	}
+
+	if crlPath == "" {
+		return v, nil
+	}
+
+	data, err := ioutil.ReadFile(crlPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not read CRL file: %s", err)
+	}
+	if !bytes.Contains(data, []byte("-----BEGIN "+x509CRLBlock)) {
+		// DER encoded CRL
+		crl, err := x509.ParseCRL(data)
+		if err != nil {
+			return nil, fmt.Errorf("while parsing CRL %s: %s", crlPath, err)
+		}
+		v.crls = append(v.crls, crl)
+		return v, nil
+	}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != x509CRLBlock {
+			continue
+		}
+		crl, err := x509.ParseDERCRL(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("while parsing CRL %s: %s", crlPath, err)
+		}
+		v.crls = append(v.crls, crl)
+	}
+	return v, nil
+}
+
+// signer returns the subject of the signer certificate.
+func (s *x509Signature) signer() string {
+	return s.certs[0].Subject.String()
+}
+
+// created returns the signing time, the zero time if unknown.
+func (s *x509Signature) created() time.Time {
+	t, err := time.Parse(time.RFC3339, s.signingTime)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// check verifies that the signed hash and signing time have been signed
+// by the key of the signer certificate.
+func (s *x509Signature) check() error {
+	msg := signedMessage(s.hash, s.signingTime)
+	if err := s.certs[0].CheckSignature(s.algorithm, msg, s.signature); err != nil {
+		return fmt.Errorf("invalid signature: %s", err)
+	}
+	return nil
+}
+
+// verify validates the certificate chain of sig, a nil verifier reports
+// an error as no CA bundle has been provided.
+func (v *x509Verifier) verify(sig *x509Signature) error {
+	leaf := sig.certs[0]
+
+	if v == nil {
+		return errNoCABundle
+	}
+
+	if err := checkKeyUsage(leaf); err != nil {
+		return err
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, c := range sig.certs[1:] {
+		intermediates.AddCert(c)
+	}
+	// certificates must be valid when the image was signed, the
+	// signing time is covered by the signature
+	chains, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         v.roots,
+		Intermediates: intermediates,
+		CurrentTime:   sig.created(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	})
+	if err != nil {
+		return fmt.Errorf("certificate chain not trusted: %s", err)
+	}
+
+	return v.checkRevocation(chains[0])
+}
+
+// checkRevocation returns an error if a certificate of chain is listed
+// in a CRL issued by its issuer.
+func (v *x509Verifier) checkRevocation(chain []*x509.Certificate) error {
+	for i := 0; i < len(chain)-1; i++ {
+		cert, issuer := chain[i], chain[i+1]
+
+		for _, crl := range v.crls {
+			if issuer.CheckCRLSignature(crl) != nil {
+				continue
+			}
+			if crl.HasExpired(time.Now()) {
+				sylog.Warningf("CRL issued by %s is expired", issuer.Subject)
+			}
+			for _, r := range crl.TBSCertList.RevokedCertificates {
+				if r.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+					return fmt.Errorf("certificate %s revoked on %s", cert.Subject, r.RevocationTime)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// checkKeyUsage returns an error if cert restricts its key usage and
+// doesn't allow digital signatures.
+func checkKeyUsage(cert *x509.Certificate) error {
+	if cert.KeyUsage != 0 && cert.KeyUsage&x509.KeyUsageDigitalSignature == 0 {
+		return fmt.Errorf("certificate %s is not allowed to sign data", cert.Subject)
+	}
+	return nil
+}
+
+// x509Algorithm returns the signature algorithm and the signer options
+// used to sign with a private key matching pub.
+func x509Algorithm(pub crypto.PublicKey) (x509.SignatureAlgorithm, crypto.SignerOpts) {
+	switch pub.(type) {
+	case *rsa.PublicKey:
+		return x509.SHA384WithRSA, crypto.SHA384
+	case *ecdsa.PublicKey:
+		return x509.ECDSAWithSHA384, crypto.SHA384
+	case ed25519.PublicKey:
+		return x509.PureEd25519, crypto.Hash(0)
+	}
+	return x509.UnknownSignatureAlgorithm, nil
+}
+
+// loadCertificates returns the certificates found in the PEM file path.
+func loadCertificates(path string) ([]*x509.Certificate, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read certificate file: %s", err)
+	}
+
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != x509CertBlock {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("while parsing certificate %s: %s", path, err)
+		}
+		certs = append(certs, cert)
+	}
+
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificate found in %s", path)
+	}
+	return certs, nil
+}
+
+// loadPrivateKey returns the private key found in the PEM file path.
+func loadPrivateKey(path string) (crypto.Signer, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read private key file: %s", err)
+	}
+
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		if _, ok := block.Headers["DEK-Info"]; ok {
+			return nil, fmt.Errorf("encrypted private key %s is not supported", path)
+		}
+
+		var key interface{}
+		switch block.Type {
+		case "RSA PRIVATE KEY":
+			key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "EC PRIVATE KEY":
+			key, err = x509.ParseECPrivateKey(block.Bytes)
+		case "PRIVATE KEY":
+			key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		case "ENCRYPTED PRIVATE KEY":
+			return nil, fmt.Errorf("encrypted private key %s is not supported", path)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("while parsing private key %s: %s", path, err)
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type %T", key)
+		}
+		return signer, nil
+	}
+
+	return nil, fmt.Errorf("no private key found in %s", path)
+}
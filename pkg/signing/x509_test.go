@@ -0,0 +1,311 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package signing
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/sylabs/sif/pkg/sif"
+	"github.com/sylabs/singularity/pkg/sypgp"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// createCert creates a certificate issued by parent, a self-signed
+// certificate if parent is nil.
+func createCert(t *testing.T, serial int64, name string, parent *testCert, usage x509.KeyUsage, extUsage []x509.ExtKeyUsage) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %s", err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     usage,
+		ExtKeyUsage:  extUsage,
+	}
+	issuer, issuerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+	} else {
+		issuer, issuerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, issuer, &key.PublicKey, issuerKey)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %s", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %s", err)
+	}
+	return &testCert{cert: cert, key: key}
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("failed to write %s: %s", path, err)
+	}
+}
+
+func createTestSIF(t *testing.T, path string) {
+	parinput := sif.DescriptorInput{
+		Datatype: sif.DataPartition,
+		Groupid:  sif.DescrDefaultGroup,
+		Link:     sif.DescrUnusedLink,
+		Fname:    "rootfs",
+		Data:     []byte("root filesystem"),
+		Size:     15,
+	}
+	if err := parinput.SetPartExtra(sif.FsSquash, sif.PartPrimSys, sif.GetSIFArch("amd64")); err != nil {
+		t.Fatalf("failed to set partition extra data: %s", err)
+	}
+
+	cinfo := sif.CreateInfo{
+		Pathname:   path,
+		Launchstr:  sif.HdrLaunch,
+		Sifversion: sif.HdrVersion,
+		ID:         uuid.NewV4(),
+		InputDescr: []sif.DescriptorInput{parinput},
+	}
+	if _, err := sif.CreateContainer(cinfo); err != nil {
+		t.Fatalf("failed to create SIF %s: %s", path, err)
+	}
+}
+
+func TestX509(t *testing.T) {
+	dir, err := ioutil.TempDir("", "x509-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	root := createCert(t, 1, "root", nil, x509.KeyUsageCertSign|x509.KeyUsageCRLSign, nil)
+	signer := createCert(t, 2, "signer", root, x509.KeyUsageDigitalSignature, []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning})
+	encipher := createCert(t, 3, "encipher", root, x509.KeyUsageKeyEncipherment, nil)
+	other := createCert(t, 4, "other", nil, x509.KeyUsageCertSign, nil)
+
+	rootPath := filepath.Join(dir, "root.pem")
+	writePEM(t, rootPath, x509CertBlock, root.cert.Raw)
+	otherPath := filepath.Join(dir, "other.pem")
+	writePEM(t, otherPath, x509CertBlock, other.cert.Raw)
+
+	certPath := filepath.Join(dir, "signer.pem")
+	writePEM(t, certPath, x509CertBlock, signer.cert.Raw)
+	keyDer, err := x509.MarshalECPrivateKey(signer.key)
+	if err != nil {
+		t.Fatalf("failed to marshal private key: %s", err)
+	}
+	keyPath := filepath.Join(dir, "signer-key.pem")
+	writePEM(t, keyPath, "EC PRIVATE KEY", keyDer)
+
+	encipherPath := filepath.Join(dir, "encipher.pem")
+	writePEM(t, encipherPath, x509CertBlock, encipher.cert.Raw)
+	encipherDer, err := x509.MarshalPKCS8PrivateKey(encipher.key)
+	if err != nil {
+		t.Fatalf("failed to marshal private key: %s", err)
+	}
+	encipherKeyPath := filepath.Join(dir, "encipher-key.pem")
+	writePEM(t, encipherKeyPath, "PRIVATE KEY", encipherDer)
+
+	revoked := []pkix.RevokedCertificate{{SerialNumber: signer.cert.SerialNumber, RevocationTime: time.Now()}}
+	crlDer, err := root.cert.CreateCRL(rand.Reader, root.key, revoked, time.Now(), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("failed to create CRL: %s", err)
+	}
+	crlPath := filepath.Join(dir, "revoked.crl")
+	writePEM(t, crlPath, x509CRLBlock, crlDer)
+	emptyCrlDer, err := root.cert.CreateCRL(rand.Reader, root.key, nil, time.Now(), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("failed to create CRL: %s", err)
+	}
+	emptyCrlPath := filepath.Join(dir, "empty.crl")
+	if err := ioutil.WriteFile(emptyCrlPath, emptyCrlDer, 0644); err != nil {
+		t.Fatalf("failed to write %s: %s", emptyCrlPath, err)
+	}
+
+	path := filepath.Join(dir, "image.sif")
+	createTestSIF(t, path)
+
+	if err := SignX509(path, 0, false, false, false, certPath, encipherKeyPath); err == nil {
+		t.Errorf("unexpected success while signing with a key not matching the certificate")
+	}
+	if err := SignX509(path, 0, false, false, false, encipherPath, encipherKeyPath); err == nil {
+		t.Errorf("unexpected success while signing with a certificate not allowed to sign")
+	}
+	if err := SignX509(path, 0, false, false, false, certPath, keyPath); err != nil {
+		t.Fatalf("unexpected error while signing: %s", err)
+	}
+
+	tests := []struct {
+		name    string
+		opts    VerifyOptions
+		trusted bool
+	}{
+		{name: "NoCABundle", opts: VerifyOptions{}},
+		{name: "UnknownCA", opts: VerifyOptions{CABundle: otherPath}},
+		{name: "Trusted", opts: VerifyOptions{CABundle: rootPath}, trusted: true},
+		{name: "NotRevoked", opts: VerifyOptions{CABundle: rootPath, CRL: emptyCrlPath}, trusted: true},
+		{name: "Revoked", opts: VerifyOptions{CABundle: rootPath, CRL: crlPath}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, _, err := Verify(context.Background(), path, "", 0, false, false, "", true, true, tt.opts)
+			if tt.trusted && err != nil {
+				t.Fatalf("unexpected verification error: %s", err)
+			} else if !tt.trusted && err != ErrVerificationFail {
+				t.Fatalf("unexpected verification result: %v", err)
+			}
+
+			var list KeyList
+			if err := json.Unmarshal([]byte(out), &list); err != nil {
+				t.Fatalf("failed to decode verify output: %s", err)
+			}
+			if list.Signatures != 1 || len(list.SignerKeys) != 1 {
+				t.Fatalf("unexpected signatures: %s", out)
+			}
+			signer := list.SignerKeys[0].Signer
+			if signer.Type != SignatureTypeX509 || signer.Name != "CN=signer" {
+				t.Errorf("unexpected signer: %+v", signer)
+			}
+			if !signer.KeyCheck || !signer.DataCheck || signer.KeyLocal != tt.trusted {
+				t.Errorf("unexpected signer checks: %+v", signer)
+			}
+		})
+	}
+
+	if _, _, err := Verify(context.Background(), path, "", 0, false, false, "", true, false, VerifyOptions{CRL: crlPath}); err == nil || err == ErrVerificationFail {
+		t.Errorf("unexpected result while checking CRL without CA bundle: %v", err)
+	}
+}
+
+func TestX509SigningTime(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+
+	dir, err := ioutil.TempDir("", "x509-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	root := createCert(t, 1, "root", nil, x509.KeyUsageCertSign, nil)
+	rootPath := filepath.Join(dir, "root.pem")
+	writePEM(t, rootPath, x509CertBlock, root.cert.Raw)
+
+	// a signer certificate expiring right after signing
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %s", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "signer"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(2 * time.Second),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, root.cert, &key.PublicKey, root.key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %s", err)
+	}
+	certPath := filepath.Join(dir, "signer.pem")
+	writePEM(t, certPath, x509CertBlock, der)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal private key: %s", err)
+	}
+	keyPath := filepath.Join(dir, "signer-key.pem")
+	writePEM(t, keyPath, "EC PRIVATE KEY", keyDer)
+
+	path := filepath.Join(dir, "image.sif")
+	createTestSIF(t, path)
+	if err := SignX509(path, 0, false, false, false, certPath, keyPath); err != nil {
+		t.Fatalf("unexpected error while signing: %s", err)
+	}
+
+	time.Sleep(3 * time.Second)
+
+	// the certificate was valid when the image was signed
+	if _, _, err := Verify(context.Background(), path, "", 0, false, false, "", true, false, VerifyOptions{CABundle: rootPath}); err != nil {
+		t.Errorf("unexpected verification error: %s", err)
+	}
+}
+
+func TestX509Skipped(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+
+	dir, err := ioutil.TempDir("", "x509-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	keyringDir := filepath.Join(dir, "sypgp")
+	os.Setenv("SINGULARITY_SYPGPDIR", keyringDir)
+	defer os.Unsetenv("SINGULARITY_SYPGPDIR")
+
+	keyring := sypgp.NewHandle(keyringDir)
+	if _, err := keyring.GenKeyPair(sypgp.GenKeyPairOptions{Name: "signer", Email: "signer@my.info", KeyType: sypgp.KeyTypeECDSAP256}); err != nil {
+		t.Fatalf("failed to generate key pair: %s", err)
+	}
+
+	root := createCert(t, 1, "root", nil, x509.KeyUsageCertSign, nil)
+	signer := createCert(t, 2, "signer", root, x509.KeyUsageDigitalSignature, nil)
+	certPath := filepath.Join(dir, "signer.pem")
+	writePEM(t, certPath, x509CertBlock, signer.cert.Raw)
+	keyDer, err := x509.MarshalECPrivateKey(signer.key)
+	if err != nil {
+		t.Fatalf("failed to marshal private key: %s", err)
+	}
+	keyPath := filepath.Join(dir, "signer-key.pem")
+	writePEM(t, keyPath, "EC PRIVATE KEY", keyDer)
+
+	path := filepath.Join(dir, "image.sif")
+	createTestSIF(t, path)
+	if err := SignX509(path, 0, false, false, false, certPath, keyPath); err != nil {
+		t.Fatalf("unexpected error while signing: %s", err)
+	}
+	if err := Sign(path, 0, false, false, false, "", 0); err != nil {
+		t.Fatalf("unexpected error while signing: %s", err)
+	}
+
+	// without CA bundle, the PGP signature alone is verified
+	out, _, err := Verify(context.Background(), path, "", 0, false, false, "", true, false, VerifyOptions{})
+	if err != nil {
+		t.Fatalf("unexpected verification error: %s", err)
+	}
+	if !strings.Contains(out, "[SKIPPED]") {
+		t.Errorf("X.509 signature not reported as skipped: %s", out)
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sypgp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/clearsign"
+)
+
+// TrustBundle is a set of trusted public keys, along with their
+// revocations, distributed as a signed file for verification without
+// access to a key server.
+type TrustBundle struct {
+	// Keys are the trusted public keys of the bundle.
+	Keys openpgp.EntityList
+	// Signer is the key which signed the bundle.
+	Signer *openpgp.Entity
+}
+
+// CreateTrustBundle writes to w a trust bundle holding the public keys of the
+// local keyring matching fingerprints, signed with the private key signer.
+// The public key of signer is always part of the bundle.
+func (keyring *Handle) CreateTrustBundle(w io.Writer, fingerprints []string, signer *openpgp.Entity) error {
+	key := SigningKey(signer, time.Now())
+	if key == nil {
+		return fmt.Errorf("key %X has no valid signing key", signer.PrimaryKey.Fingerprint)
+	}
+	if key.Encrypted {
+		return fmt.Errorf("signing key %X is not decrypted", signer.PrimaryKey.Fingerprint)
+	}
+
+	elist, err := keyring.LoadPubKeyring()
+	if err != nil {
+		return fmt.Errorf("could not load public keyring: %s", err)
+	}
+
+	var keys openpgp.EntityList
+	for _, fp := range fingerprints {
+		fp = strings.ToUpper(fp)
+		e := findKeyByFingerprint(elist, fp)
+		if e == nil {
+			return fmt.Errorf("no public key matching fingerprint %s found", fp)
+		}
+		if findEntityByFingerprint(keys, e.PrimaryKey.Fingerprint) == nil {
+			keys = append(keys, e)
+		}
+	}
+	if findEntityByFingerprint(keys, signer.PrimaryKey.Fingerprint) == nil {
+		// prefer the copy of the local keyring, which carries revocations
+		if e := findEntityByFingerprint(elist, signer.PrimaryKey.Fingerprint); e != nil {
+			keys = append(keys, e)
+		} else {
+			keys = append(keys, signer)
+		}
+	}
+
+	var plaintext bytes.Buffer
+	wr, err := armor.Encode(&plaintext, openpgp.PublicKeyType, nil)
+	if err != nil {
+		return err
+	}
+	if err := storePubKeys(wr, keys); err != nil {
+		wr.Close()
+		return fmt.Errorf("could not serialize public keys: %s", err)
+	}
+	wr.Close()
+
+	cw, err := clearsign.Encode(w, key, nil)
+	if err != nil {
+		return fmt.Errorf("could not sign trust bundle: %s", err)
+	}
+	if _, err := cw.Write(plaintext.Bytes()); err != nil {
+		cw.Close()
+		return fmt.Errorf("could not sign trust bundle: %s", err)
+	}
+	return cw.Close()
+}
+
+// LoadTrustBundle reads the trust bundle at path and checks its signature,
+// the bundle must be signed by one of the keys of signers.
+func LoadTrustBundle(path string, signers openpgp.EntityList) (*TrustBundle, error) {
+	if len(signers) == 0 {
+		return nil, fmt.Errorf("no public keys to check the trust bundle signature with")
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read trust bundle: %s", err)
+	}
+
+	block, _ := clearsign.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not a signed trust bundle", path)
+	}
+
+	keys, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(block.Plaintext))
+	if err != nil {
+		return nil, fmt.Errorf("could not read trust bundle keys: %s", err)
+	}
+
+	signer, err := openpgp.CheckDetachedSignature(signers, bytes.NewReader(block.Bytes), block.ArmoredSignature.Body)
+	if err != nil {
+		return nil, fmt.Errorf("trust bundle signature is not valid: %s", err)
+	}
+
+	return &TrustBundle{Keys: keys, Signer: signer}, nil
+}
+
+// LoadSystemTrustBundle reads the trust bundle at path set by the
+// administrator, which must be signed by one of the ASCII armored public
+// keys found in the file signersPath.
+func LoadSystemTrustBundle(path, signersPath string) (*TrustBundle, error) {
+	if signersPath == "" {
+		return nil, fmt.Errorf("no trust bundle signers set to check the trust bundle signature with")
+	}
+
+	f, err := os.Open(signersPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not read trust bundle signers: %s", err)
+	}
+	defer f.Close()
+
+	signers, err := openpgp.ReadArmoredKeyRing(f)
+	if err != nil {
+		return nil, fmt.Errorf("could not read trust bundle signers: %s", err)
+	}
+	return LoadTrustBundle(path, signers)
+}
+
+// FindRevoked returns the key of the bundle matching fingerprint if it has
+// been revoked, nil otherwise.
+func (b *TrustBundle) FindRevoked(fingerprint string) *openpgp.Entity {
+	e := findKeyByFingerprint(b.Keys, strings.ToUpper(fingerprint))
+	if e != nil && IsRevoked(e) {
+		return e
+	}
+	return nil
+}
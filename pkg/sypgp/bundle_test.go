@@ -0,0 +1,122 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sypgp
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sylabs/singularity/internal/pkg/test"
+	"golang.org/x/crypto/openpgp"
+)
+
+func TestTrustBundle(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+
+	test.DropPrivilege(t)
+	defer test.ResetPrivilege(t)
+
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory")
+	}
+	defer os.RemoveAll(dir)
+
+	keyring := NewHandle(filepath.Join(dir, "keyring"))
+	other := NewHandle(filepath.Join(dir, "other"))
+
+	signer, err := keyring.GenKeyPair(GenKeyPairOptions{Name: "signer", Email: "signer@my.info", KeyLength: 1024})
+	if err != nil {
+		t.Fatalf("failed to generate key pair: %s", err)
+	}
+	trusted, err := other.GenKeyPair(GenKeyPairOptions{Name: "trusted", Email: "trusted@my.info", KeyLength: 1024})
+	if err != nil {
+		t.Fatalf("failed to generate key pair: %s", err)
+	}
+	fingerprint := fmt.Sprintf("%X", trusted.PrimaryKey.Fingerprint)
+
+	create := func(t *testing.T, fingerprints ...string) string {
+		var buf bytes.Buffer
+		if err := keyring.CreateTrustBundle(&buf, fingerprints, signer); err != nil {
+			t.Fatalf("failed to create trust bundle: %s", err)
+		}
+		path := filepath.Join(dir, "trust.bundle")
+		if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+			t.Fatalf("failed to write trust bundle: %s", err)
+		}
+		return path
+	}
+
+	// the keys of the bundle must be in the public keyring
+	var buf bytes.Buffer
+	if err := keyring.CreateTrustBundle(&buf, []string{fingerprint}, signer); err == nil {
+		t.Errorf("unexpected success with an unknown key")
+	}
+	if err := keyring.appendPubKey(trusted); err != nil {
+		t.Fatalf("failed to store public key: %s", err)
+	}
+
+	path := create(t, strings.ToLower(fingerprint))
+
+	// the bundle keys don't authenticate the bundle
+	if _, err := LoadTrustBundle(path, nil); err == nil {
+		t.Errorf("unexpected success without bundle signers")
+	}
+
+	b, err := LoadTrustBundle(path, openpgp.EntityList{signer})
+	if err != nil {
+		t.Fatalf("failed to load trust bundle: %s", err)
+	}
+	if len(b.Keys) != 2 || b.Signer.PrimaryKey.KeyId != signer.PrimaryKey.KeyId {
+		t.Errorf("unexpected trust bundle: %d keys, signed by %X", len(b.Keys), b.Signer.PrimaryKey.Fingerprint)
+	}
+	if b.FindRevoked(fingerprint) != nil {
+		t.Errorf("unexpected revoked key")
+	}
+
+	if _, err := LoadTrustBundle(path, openpgp.EntityList{trusted}); err == nil {
+		t.Errorf("unexpected success with a key which didn't sign the bundle")
+	}
+
+	// a modified bundle must be rejected
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read trust bundle: %s", err)
+	}
+	tampered := filepath.Join(dir, "tampered.bundle")
+	begin := []byte("-----BEGIN PGP PUBLIC KEY BLOCK-----\n")
+	data = bytes.Replace(data, begin, append(begin, "Comment: modified\n"...), 1)
+	if err := ioutil.WriteFile(tampered, data, 0644); err != nil {
+		t.Fatalf("failed to write trust bundle: %s", err)
+	}
+	if _, err := LoadTrustBundle(tampered, openpgp.EntityList{signer}); err == nil {
+		t.Errorf("unexpected success with a modified trust bundle")
+	}
+
+	// revocations are part of the bundle
+	revoked, err := other.RevokeKey(fingerprint)
+	if err != nil {
+		t.Fatalf("failed to revoke key: %s", err)
+	}
+	if err := keyring.storePubKeyring(openpgp.EntityList{signer, revoked}); err != nil {
+		t.Fatalf("failed to store public keyring: %s", err)
+	}
+
+	b, err = LoadTrustBundle(create(t, fingerprint), openpgp.EntityList{signer})
+	if err != nil {
+		t.Fatalf("failed to load trust bundle: %s", err)
+	}
+	if b.FindRevoked(fingerprint) == nil {
+		t.Errorf("revocation not found in the trust bundle")
+	}
+}
@@ -0,0 +1,455 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sypgp
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/asn1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// gcryptHashAlgos maps hash functions to the libgcrypt algorithm numbers
+// used by the SETHASH command of gpg-agent.
+var gcryptHashAlgos = map[crypto.Hash]int{
+	crypto.SHA1:   2,
+	crypto.SHA256: 8,
+	crypto.SHA384: 9,
+	crypto.SHA512: 10,
+	crypto.SHA224: 11,
+}
+
+// GPGAgentSigner signs with a GnuPG secret key held by gpg-agent.
+type GPGAgentSigner struct {
+	// Entity is the public key of the GnuPG key, as exported by gpg.
+	Entity *openpgp.Entity
+	// PrivateKey is the signing key, or signing subkey, of Entity. Its
+	// signatures are made by gpg-agent.
+	PrivateKey *packet.PrivateKey
+
+	conn *assuanConn
+}
+
+// gpgKey is a secret key, or subkey, listed by gpg.
+type gpgKey struct {
+	fingerprint  string
+	keygrip      string
+	capabilities string
+	subkey       bool
+	invalid      bool
+}
+
+// agentKey is a crypto.Signer using a key held by gpg-agent.
+type agentKey struct {
+	conn    *assuanConn
+	keygrip string
+	public  crypto.PublicKey
+}
+
+// assuanConn is a connection to a server speaking the Assuan protocol.
+type assuanConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// NewGPGAgentSigner returns a signer using the GnuPG secret key matching
+// keyID, or the default GnuPG secret key if keyID is empty. The secret key
+// is used through the gpg-agent of the GnuPG home directory, which is
+// started if needed. Only RSA and ECDSA keys are supported.
+func NewGPGAgentSigner(keyID string) (*GPGAgentSigner, error) {
+	keys, err := listGPGSecretKeys(keyID)
+	if err != nil {
+		return nil, err
+	}
+	key, err := selectGPGKey(keys, keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	entity, err := exportGPGKey(keys[0].fingerprint)
+	if err != nil {
+		return nil, err
+	}
+	pub := entity.PrimaryKey
+	if key.subkey {
+		pub = nil
+		for _, s := range entity.Subkeys {
+			if fmt.Sprintf("%X", s.PublicKey.Fingerprint) == key.fingerprint {
+				pub = s.PublicKey
+			}
+		}
+		if pub == nil {
+			return nil, fmt.Errorf("subkey %s not found in exported key", key.fingerprint)
+		}
+	}
+
+	switch pub.PublicKey.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+	default:
+		return nil, fmt.Errorf("unsupported public key algorithm %d for gpg-agent signatures", pub.PubKeyAlgo)
+	}
+
+	conn, err := dialGPGAgent()
+	if err != nil {
+		return nil, err
+	}
+
+	signer := &agentKey{
+		conn:    conn,
+		keygrip: key.keygrip,
+		public:  pub.PublicKey,
+	}
+	if _, err := conn.transact("HAVEKEY " + key.keygrip); err != nil {
+		conn.close()
+		return nil, fmt.Errorf("secret key %s not available in gpg-agent: %s", key.fingerprint, err)
+	}
+
+	sylog.Verbosef("Signing with GnuPG key %s through gpg-agent", key.fingerprint)
+
+	return &GPGAgentSigner{
+		Entity:     entity,
+		PrivateKey: &packet.PrivateKey{PublicKey: *pub, PrivateKey: signer},
+		conn:       conn,
+	}, nil
+}
+
+// Close closes the connection to gpg-agent.
+func (s *GPGAgentSigner) Close() error {
+	return s.conn.close()
+}
+
+// listGPGSecretKeys returns the keys of the first GnuPG secret key matching
+// keyID, the primary key first.
+func listGPGSecretKeys(keyID string) ([]gpgKey, error) {
+	args := []string{"--batch", "--with-colons", "--with-keygrip", "--list-secret-keys"}
+	if keyID != "" {
+		args = append(args, keyID)
+	}
+	out, err := exec.Command("gpg", args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("could not list GnuPG secret keys: %s", err)
+	}
+
+	var keys []gpgKey
+	for _, line := range strings.Split(string(out), "\n") {
+		f := strings.Split(line, ":")
+		if len(f) < 10 || (f[0] == "sec" || f[0] == "ssb") && len(f) < 12 {
+			continue
+		}
+		switch f[0] {
+		case "sec":
+			if len(keys) > 0 {
+				// only the first matching key is used
+				return keys, nil
+			}
+			fallthrough
+		case "ssb":
+			keys = append(keys, gpgKey{
+				capabilities: f[11],
+				subkey:       f[0] == "ssb",
+				invalid:      strings.ContainsAny(f[1], "deir"),
+			})
+		case "fpr":
+			if len(keys) > 0 && keys[len(keys)-1].fingerprint == "" {
+				keys[len(keys)-1].fingerprint = f[9]
+			}
+		case "grp":
+			if len(keys) > 0 && keys[len(keys)-1].keygrip == "" {
+				keys[len(keys)-1].keygrip = f[9]
+			}
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no GnuPG secret key found")
+	}
+	return keys, nil
+}
+
+// selectGPGKey returns the signing key of keys like gpg does: the subkey
+// matching keyID if any, else the most recent signing subkey or the primary
+// key if it can sign.
+func selectGPGKey(keys []gpgKey, keyID string) (*gpgKey, error) {
+	canSign := func(k *gpgKey) bool {
+		return !k.invalid && strings.Contains(k.capabilities, "s")
+	}
+
+	id := strings.ToUpper(strings.TrimPrefix(keyID, "0x"))
+	if _, err := hex.DecodeString(id); err == nil && id != "" {
+		for i := range keys {
+			if keys[i].subkey && strings.HasSuffix(keys[i].fingerprint, id) && canSign(&keys[i]) {
+				return &keys[i], nil
+			}
+		}
+	}
+
+	// subkeys are listed from the oldest to the most recent one
+	for i := len(keys) - 1; i >= 0; i-- {
+		if canSign(&keys[i]) {
+			return &keys[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no valid signing key found for GnuPG key %s", keys[0].fingerprint)
+}
+
+// exportGPGKey returns the public key entity of the GnuPG key matching
+// fingerprint.
+func exportGPGKey(fingerprint string) (*openpgp.Entity, error) {
+	out, err := exec.Command("gpg", "--batch", "--export", fingerprint).Output()
+	if err != nil {
+		return nil, fmt.Errorf("could not export GnuPG key %s: %s", fingerprint, err)
+	}
+	el, err := openpgp.ReadKeyRing(bytes.NewReader(out))
+	if err != nil {
+		return nil, fmt.Errorf("could not read GnuPG key %s: %s", fingerprint, err)
+	}
+	if len(el) != 1 {
+		return nil, fmt.Errorf("unexpected number of exported GnuPG keys: %d", len(el))
+	}
+	return el[0], nil
+}
+
+// dialGPGAgent starts gpg-agent if needed and connects to its socket.
+func dialGPGAgent() (*assuanConn, error) {
+	if err := exec.Command("gpgconf", "--launch", "gpg-agent").Run(); err != nil {
+		return nil, fmt.Errorf("could not start gpg-agent: %s", err)
+	}
+	out, err := exec.Command("gpgconf", "--list-dirs", "agent-socket").Output()
+	if err != nil {
+		return nil, fmt.Errorf("could not find gpg-agent socket: %s", err)
+	}
+	socket := strings.TrimSpace(string(out))
+
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to gpg-agent: %s", err)
+	}
+	c := &assuanConn{conn: conn, r: bufio.NewReader(conn)}
+
+	// read the server greeting
+	if _, err := c.response(); err != nil {
+		c.close()
+		return nil, fmt.Errorf("unexpected gpg-agent greeting: %s", err)
+	}
+
+	// let pinentry ask the passphrase on our terminal or display
+	options := map[string]string{
+		"ttyname": os.Getenv("GPG_TTY"),
+		"ttytype": os.Getenv("TERM"),
+		"display": os.Getenv("DISPLAY"),
+	}
+	if options["ttyname"] == "" {
+		if tty, err := os.Readlink("/proc/self/fd/0"); err == nil && strings.HasPrefix(tty, "/dev/") {
+			options["ttyname"] = tty
+		}
+	}
+	for name, value := range options {
+		if value == "" {
+			continue
+		}
+		if _, err := c.transact("OPTION " + name + "=" + value); err != nil {
+			sylog.Debugf("gpg-agent option %s not set: %s", name, err)
+		}
+	}
+
+	return c, nil
+}
+
+// transact sends the command cmd and returns the data of the response.
+func (c *assuanConn) transact(cmd string) ([]byte, error) {
+	if _, err := io.WriteString(c.conn, cmd+"\n"); err != nil {
+		return nil, err
+	}
+	return c.response()
+}
+
+// response reads the lines of a response until its OK or ERR line.
+func (c *assuanConn) response() ([]byte, error) {
+	var data []byte
+
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSuffix(line, "\n")
+
+		switch {
+		case line == "OK" || strings.HasPrefix(line, "OK "):
+			return data, nil
+		case strings.HasPrefix(line, "ERR "):
+			return nil, fmt.Errorf("%s", line[4:])
+		case strings.HasPrefix(line, "D "):
+			d, err := assuanUnescape(line[2:])
+			if err != nil {
+				return nil, err
+			}
+			data = append(data, d...)
+		case strings.HasPrefix(line, "INQUIRE "):
+			// no inquiry requires data from us, like PINENTRY_LAUNCHED
+			if _, err := io.WriteString(c.conn, "END\n"); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+func (c *assuanConn) close() error {
+	io.WriteString(c.conn, "BYE\n")
+	return c.conn.Close()
+}
+
+// assuanUnescape decodes the percent escaped data line s.
+func assuanUnescape(s string) ([]byte, error) {
+	var b []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			b = append(b, s[i])
+			continue
+		}
+		if i+2 >= len(s) {
+			return nil, fmt.Errorf("invalid escape sequence in assuan data")
+		}
+		v, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid escape sequence in assuan data: %s", err)
+		}
+		b = append(b, byte(v))
+		i += 2
+	}
+	return b, nil
+}
+
+// assuanEscape encodes the command parameter s, spaces are encoded with '+'.
+func assuanEscape(s string) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case c == ' ':
+			b.WriteByte('+')
+		case c == '+' || c == '%' || c == '"' || c < 0x20:
+			fmt.Fprintf(&b, "%%%02X", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// sexpValues returns the values of the (name value) lists found in the
+// canonical S-expression data.
+func sexpValues(data []byte) (map[string][]byte, error) {
+	// tokens are atoms, nil for parentheses
+	var tokens [][]byte
+	var parens []byte
+
+	for i := 0; i < len(data); {
+		switch data[i] {
+		case '(', ')':
+			tokens = append(tokens, nil)
+			parens = append(parens, data[i])
+			i++
+			continue
+		}
+		colon := bytes.IndexByte(data[i:], ':')
+		if colon <= 0 {
+			return nil, fmt.Errorf("invalid S-expression")
+		}
+		n, err := strconv.Atoi(string(data[i : i+colon]))
+		if err != nil || i+colon+1+n > len(data) {
+			return nil, fmt.Errorf("invalid S-expression atom length")
+		}
+		i += colon + 1
+		tokens = append(tokens, data[i:i+n])
+		parens = append(parens, 0)
+		i += n
+	}
+
+	values := make(map[string][]byte)
+	for i := 0; i+3 < len(tokens); i++ {
+		if parens[i] == '(' && tokens[i+1] != nil && tokens[i+2] != nil && parens[i+3] == ')' {
+			values[string(tokens[i+1])] = tokens[i+2]
+		}
+	}
+	return values, nil
+}
+
+// Public returns the public key of the agent key.
+func (k *agentKey) Public() crypto.PublicKey {
+	return k.public
+}
+
+// Sign asks gpg-agent to sign digest, a digest computed with opts.HashFunc().
+// ECDSA signatures are returned ASN.1 encoded, as crypto/ecdsa does.
+func (k *agentKey) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	var h crypto.Hash
+	if opts != nil {
+		h = opts.HashFunc()
+	} else {
+		// ECDSA signers are called without options by openpgp,
+		// the hash function is deduced from the digest length
+		for hash := range gcryptHashAlgos {
+			if hash.Size() == len(digest) {
+				h = hash
+			}
+		}
+	}
+	algo, ok := gcryptHashAlgos[h]
+	if !ok {
+		return nil, fmt.Errorf("hash function %v not supported by gpg-agent", h)
+	}
+
+	cmds := []string{
+		"SIGKEY " + k.keygrip,
+		"SETKEYDESC " + assuanEscape("Please enter the passphrase to sign a SIF image with Singularity"),
+		fmt.Sprintf("SETHASH %d %X", algo, digest),
+	}
+	for _, cmd := range cmds {
+		if _, err := k.conn.transact(cmd); err != nil {
+			return nil, fmt.Errorf("gpg-agent: %s", err)
+		}
+	}
+	data, err := k.conn.transact("PKSIGN")
+	if err != nil {
+		return nil, fmt.Errorf("gpg-agent signature failed: %s", err)
+	}
+
+	values, err := sexpValues(data)
+	if err != nil {
+		return nil, fmt.Errorf("could not read gpg-agent signature: %s", err)
+	}
+
+	switch k.public.(type) {
+	case *rsa.PublicKey:
+		if values["s"] == nil {
+			return nil, fmt.Errorf("no RSA signature returned by gpg-agent")
+		}
+		return values["s"], nil
+	case *ecdsa.PublicKey:
+		if values["r"] == nil || values["s"] == nil {
+			return nil, fmt.Errorf("no ECDSA signature returned by gpg-agent")
+		}
+		return asn1.Marshal(struct {
+			R, S *big.Int
+		}{new(big.Int).SetBytes(values["r"]), new(big.Int).SetBytes(values["s"])})
+	}
+	return nil, fmt.Errorf("unsupported gpg-agent key type")
+}
@@ -0,0 +1,99 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sypgp
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSexpValues(t *testing.T) {
+	tests := []struct {
+		name    string
+		sexp    string
+		values  map[string]string
+		wantErr bool
+	}{
+		{"RSA", "(7:sig-val(3:rsa(1:s3:\x01\x02\x03)))", map[string]string{"s": "\x01\x02\x03"}, false},
+		{"ECDSA", "(7:sig-val(5:ecdsa(1:r2:\x01()(1:s2:)\x02)))", map[string]string{"r": "\x01(", "s": ")\x02"}, false},
+		{"BadLength", "(7:sig-val(3:rsa(1:s9:\x01)))", nil, true},
+		{"NoLength", "(:sig-val)", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := sexpValues([]byte(tt.sexp))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("unexpected success")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			for k, v := range tt.values {
+				if !bytes.Equal(values[k], []byte(v)) {
+					t.Errorf("unexpected value for %s: %q", k, values[k])
+				}
+			}
+		})
+	}
+}
+
+func TestAssuanEscape(t *testing.T) {
+	if s := assuanEscape("100% sure+\n"); s != "100%25+sure%2B%0A" {
+		t.Errorf("unexpected escaped string: %s", s)
+	}
+	b, err := assuanUnescape("a%25b%0A%0d")
+	if err != nil || string(b) != "a%b\n\r" {
+		t.Errorf("unexpected unescaped data: %q, %v", b, err)
+	}
+	if _, err := assuanUnescape("a%2"); err == nil {
+		t.Errorf("unexpected success with a truncated escape sequence")
+	}
+}
+
+func TestSelectGPGKey(t *testing.T) {
+	keys := []gpgKey{
+		{fingerprint: "AAAA", capabilities: "cSC"},
+		{fingerprint: "BBBB", capabilities: "s", subkey: true},
+		{fingerprint: "CCCC", capabilities: "s", subkey: true},
+		{fingerprint: "DDDD", capabilities: "s", subkey: true, invalid: true},
+		{fingerprint: "EEEE", capabilities: "e", subkey: true},
+	}
+
+	tests := []struct {
+		name        string
+		keys        []gpgKey
+		keyID       string
+		fingerprint string
+	}{
+		{"Default", keys, "", "CCCC"},
+		{"Subkey", keys, "0xbbbb", "BBBB"},
+		{"InvalidSubkey", keys, "DDDD", "CCCC"},
+		{"UserID", keys, "test@my.info", "CCCC"},
+		{"Primary", []gpgKey{{fingerprint: "AAAA", capabilities: "scSC"}, keys[4]}, "", "AAAA"},
+		{"NoSigningKey", []gpgKey{{fingerprint: "AAAA", capabilities: "cSC"}, keys[4]}, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, err := selectGPGKey(tt.keys, tt.keyID)
+			if tt.fingerprint == "" {
+				if err == nil {
+					t.Fatalf("unexpected success")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if k.fingerprint != tt.fingerprint {
+				t.Errorf("unexpected key %s selected", k.fingerprint)
+			}
+		})
+	}
+}
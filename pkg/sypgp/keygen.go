@@ -0,0 +1,538 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sypgp
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+	"github.com/sylabs/singularity/internal/pkg/util/interactive"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+	"golang.org/x/crypto/openpgp/s2k"
+)
+
+// Key types of GenKeyPairOptions.
+const (
+	KeyTypeRSA2048   = "rsa2048"
+	KeyTypeRSA3072   = "rsa3072"
+	KeyTypeRSA4096   = "rsa4096"
+	KeyTypeECDSAP256 = "ecdsa-p256"
+	KeyTypeECDSAP384 = "ecdsa-p384"
+)
+
+// KeyTypes lists the key types supported by GenKeyPair.
+var KeyTypes = []string{KeyTypeRSA2048, KeyTypeRSA3072, KeyTypeRSA4096, KeyTypeECDSAP256, KeyTypeECDSAP384}
+
+var rsaKeyBits = map[string]int{
+	KeyTypeRSA2048: 2048,
+	KeyTypeRSA3072: 3072,
+	KeyTypeRSA4096: 4096,
+}
+
+const defaultRSAKeyBits = 2048
+
+// embeddedSignatureSubpacket is the type of the signature subpacket holding
+// the cross-signature of a signing subkey (RFC 4880, section 5.2.3.26).
+const embeddedSignatureSubpacket = 32
+
+var expiryRegexp = regexp.MustCompile(`^([0-9]+)([dwmy]?)$`)
+
+// ParseExpiry returns the validity period described by s, a number of days,
+// or a number followed by d (days), w (weeks), m (months) or y (years). A
+// zero period, or "never", means the key never expires.
+func ParseExpiry(s string) (time.Duration, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "never" {
+		return 0, nil
+	}
+
+	m := expiryRegexp.FindStringSubmatch(s)
+	if m == nil {
+		return 0, fmt.Errorf("invalid expiry %q: use a number of days, or a number followed by d, w, m or y", s)
+	}
+	n, err := strconv.ParseUint(m[1], 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid expiry %q: %s", s, err)
+	}
+
+	day := 24 * time.Hour
+	unit := map[string]time.Duration{"": day, "d": day, "w": 7 * day, "m": 30 * day, "y": 365 * day}[m[2]]
+	if n > uint64(math.MaxUint32*time.Second/unit) {
+		return 0, fmt.Errorf("invalid expiry %q: period too long", s)
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// keyLifetime returns the key lifetime, counted from the key creation time,
+// for a key expiring expire after now. Nil is returned if expire is zero.
+func keyLifetime(created, now time.Time, expire time.Duration) (*uint32, error) {
+	if expire == 0 {
+		return nil, nil
+	}
+	secs := now.Add(expire).Sub(created) / time.Second
+	if secs <= 0 || secs > math.MaxUint32 {
+		return nil, fmt.Errorf("key lifetime out of range")
+	}
+	l := uint32(secs)
+	return &l, nil
+}
+
+// keyExpiry returns the expiration time of a key created at created with the
+// self-signature sig, or the zero time if the key doesn't expire.
+func keyExpiry(created time.Time, sig *packet.Signature) time.Time {
+	if sig == nil || sig.KeyLifetimeSecs == nil || *sig.KeyLifetimeSecs == 0 {
+		return time.Time{}
+	}
+	return created.Add(time.Duration(*sig.KeyLifetimeSecs) * time.Second)
+}
+
+// primarySelfSignature returns the self-signature of the primary identity
+// of e, or the most recent self-signature if no identity is primary.
+func primarySelfSignature(e *openpgp.Entity) *packet.Signature {
+	var sig *packet.Signature
+	for _, ident := range e.Identities {
+		s := ident.SelfSignature
+		if s == nil {
+			continue
+		}
+		if s.IsPrimaryId != nil && *s.IsPrimaryId {
+			return s
+		}
+		if sig == nil || s.CreationTime.After(sig.CreationTime) {
+			sig = s
+		}
+	}
+	return sig
+}
+
+// KeyExpiry returns the expiration time of the key of e identified by keyID,
+// which is either the primary key or one of its subkeys. A subkey expires
+// at the latest with its primary key. The zero time is returned if the key
+// never expires.
+func KeyExpiry(e *openpgp.Entity, keyID uint64) time.Time {
+	expiry := keyExpiry(e.PrimaryKey.CreationTime, primarySelfSignature(e))
+	if keyID == e.PrimaryKey.KeyId {
+		return expiry
+	}
+	for _, subkey := range e.Subkeys {
+		if subkey.PublicKey.KeyId != keyID {
+			continue
+		}
+		sub := keyExpiry(subkey.PublicKey.CreationTime, subkey.Sig)
+		if expiry.IsZero() || !sub.IsZero() && sub.Before(expiry) {
+			expiry = sub
+		}
+		break
+	}
+	return expiry
+}
+
+// SigningKey returns the private key of e used to sign at time now: the most
+// recent valid signing subkey, or the primary key if e has none. Nil is
+// returned if the key has expired or has no private key usable to sign.
+func SigningKey(e *openpgp.Entity, now time.Time) *packet.PrivateKey {
+	if IsRevoked(e) {
+		return nil
+	}
+	if expiry := KeyExpiry(e, e.PrimaryKey.KeyId); !expiry.IsZero() && now.After(expiry) {
+		return nil
+	}
+
+	var key *packet.PrivateKey
+	var created time.Time
+	for _, subkey := range e.Subkeys {
+		sig := subkey.Sig
+		if subkey.PrivateKey == nil || sig.SigType != packet.SigTypeSubkeyBinding || !sig.FlagsValid || !sig.FlagSign {
+			continue
+		}
+		if expiry := KeyExpiry(e, subkey.PublicKey.KeyId); !expiry.IsZero() && now.After(expiry) {
+			continue
+		}
+		if key == nil || subkey.PublicKey.CreationTime.After(created) {
+			key = subkey.PrivateKey
+			created = subkey.PublicKey.CreationTime
+		}
+	}
+	if key != nil {
+		return key
+	}
+
+	if sig := primarySelfSignature(e); sig != nil && sig.FlagsValid && !sig.FlagSign {
+		return nil
+	}
+	return e.PrivateKey
+}
+
+// generateKey generates a private key of type keyType, or an RSA key of bits
+// bits if keyType is empty.
+func generateKey(keyType string, bits int, config *packet.Config) (*packet.PrivateKey, error) {
+	now := config.Now()
+
+	switch keyType {
+	case "":
+		if bits == 0 {
+			bits = defaultRSAKeyBits
+		}
+	case KeyTypeECDSAP256, KeyTypeECDSAP384:
+		curve := elliptic.P256()
+		if keyType == KeyTypeECDSAP384 {
+			curve = elliptic.P384()
+		}
+		k, err := ecdsa.GenerateKey(curve, config.Random())
+		if err != nil {
+			return nil, err
+		}
+		return packet.NewECDSAPrivateKey(now, k), nil
+	default:
+		var ok bool
+		if bits, ok = rsaKeyBits[keyType]; !ok {
+			return nil, fmt.Errorf("unknown key type %q, supported types: %s", keyType, strings.Join(KeyTypes, ", "))
+		}
+	}
+
+	k, err := rsa.GenerateKey(config.Random(), bits)
+	if err != nil {
+		return nil, err
+	}
+	return packet.NewRSAPrivateKey(now, k), nil
+}
+
+// generateEntity returns a new entity for opts made of a certification
+// primary key, a signing subkey and, for RSA keys, an encryption subkey.
+func generateEntity(opts GenKeyPairOptions, config *packet.Config) (*openpgp.Entity, error) {
+	uid := packet.NewUserId(opts.Name, opts.Comment, opts.Email)
+	if uid == nil {
+		return nil, fmt.Errorf("user id field contained invalid characters")
+	}
+
+	primary, err := generateKey(opts.KeyType, opts.KeyLength, config)
+	if err != nil {
+		return nil, err
+	}
+	now := primary.CreationTime
+
+	lifetime, err := keyLifetime(now, now, opts.Expire)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &openpgp.Entity{
+		PrimaryKey: &primary.PublicKey,
+		PrivateKey: primary,
+		Identities: make(map[string]*openpgp.Identity),
+	}
+
+	isPrimaryID := true
+	sig := &packet.Signature{
+		CreationTime:    now,
+		SigType:         packet.SigTypePositiveCert,
+		PubKeyAlgo:      primary.PubKeyAlgo,
+		Hash:            config.Hash(),
+		IsPrimaryId:     &isPrimaryID,
+		FlagsValid:      true,
+		FlagCertify:     true,
+		KeyLifetimeSecs: lifetime,
+		IssuerKeyId:     &e.PrimaryKey.KeyId,
+	}
+	if id, ok := s2k.HashToHashId(config.Hash()); ok {
+		sig.PreferredHash = []uint8{id}
+	}
+	if err := sig.SignUserId(uid.Id, e.PrimaryKey, e.PrivateKey, config); err != nil {
+		return nil, err
+	}
+	e.Identities[uid.Id] = &openpgp.Identity{Name: uid.Id, UserId: uid, SelfSignature: sig}
+
+	if err := addSubkey(e, opts, true, lifetime, config); err != nil {
+		return nil, fmt.Errorf("could not create signing subkey: %s", err)
+	}
+	if primary.PubKeyAlgo == packet.PubKeyAlgoRSA {
+		if err := addSubkey(e, opts, false, lifetime, config); err != nil {
+			return nil, fmt.Errorf("could not create encryption subkey: %s", err)
+		}
+	}
+
+	return e, nil
+}
+
+// addSubkey adds to e a new signing or encryption subkey of the key type
+// of opts.
+func addSubkey(e *openpgp.Entity, opts GenKeyPairOptions, signing bool, lifetime *uint32, config *packet.Config) error {
+	priv, err := generateKey(opts.KeyType, opts.KeyLength, config)
+	if err != nil {
+		return err
+	}
+	priv.IsSubkey = true
+	priv.PublicKey.IsSubkey = true
+
+	sig := &packet.Signature{
+		CreationTime:              priv.CreationTime,
+		SigType:                   packet.SigTypeSubkeyBinding,
+		PubKeyAlgo:                e.PrimaryKey.PubKeyAlgo,
+		Hash:                      config.Hash(),
+		FlagsValid:                true,
+		FlagSign:                  signing,
+		FlagEncryptStorage:        !signing,
+		FlagEncryptCommunications: !signing,
+		KeyLifetimeSecs:           lifetime,
+		IssuerKeyId:               &e.PrimaryKey.KeyId,
+	}
+	if sig, err = bindSubkey(e, &priv.PublicKey, priv, sig, config); err != nil {
+		return err
+	}
+
+	e.Subkeys = append(e.Subkeys, openpgp.Subkey{PublicKey: &priv.PublicKey, PrivateKey: priv, Sig: sig})
+	return nil
+}
+
+// bindSubkey signs the binding signature sig of the subkey pub with the
+// primary key of e. Signing subkeys must also cross-sign the primary key
+// with their private key priv, the returned signature holds the
+// cross-signature which isn't serialized by the OpenPGP implementation.
+func bindSubkey(e *openpgp.Entity, pub *packet.PublicKey, priv *packet.PrivateKey, sig *packet.Signature, config *packet.Config) (*packet.Signature, error) {
+	if err := sig.SignKey(pub, e.PrivateKey, config); err != nil {
+		return nil, err
+	}
+	if !sig.FlagSign {
+		return sig, nil
+	}
+	if priv == nil || priv.Encrypted {
+		return nil, fmt.Errorf("a decrypted private key is required to bind signing subkey %X", pub.Fingerprint)
+	}
+
+	cross := &packet.Signature{
+		CreationTime: sig.CreationTime,
+		SigType:      packet.SigTypePrimaryKeyBinding,
+		PubKeyAlgo:   priv.PubKeyAlgo,
+		Hash:         config.Hash(),
+		IssuerKeyId:  &priv.KeyId,
+	}
+	h := cross.Hash.New()
+	if err := hashPublicKey(h, e.PrimaryKey); err != nil {
+		return nil, err
+	}
+	if err := hashPublicKey(h, pub); err != nil {
+		return nil, err
+	}
+	if err := cross.Sign(h, priv, config); err != nil {
+		return nil, err
+	}
+
+	return embedSignature(sig, cross)
+}
+
+// embedSignature returns a copy of the signed signature sig holding the
+// signature embedded in its unhashed subpackets.
+func embedSignature(sig, embedded *packet.Signature) (*packet.Signature, error) {
+	var sigBuf, embeddedBuf bytes.Buffer
+	if err := sig.Serialize(&sigBuf); err != nil {
+		return nil, err
+	}
+	if err := embedded.Serialize(&embeddedBuf); err != nil {
+		return nil, err
+	}
+	body, err := packetBody(sigBuf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	content, err := packetBody(embeddedBuf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	// the unhashed subpackets follow the version, the types, the hash
+	// algorithm and the hashed subpackets (RFC 4880, section 5.2.3)
+	off := len(sig.HashSuffix) - 6
+	if off < 0 || len(body) < off+2 {
+		return nil, fmt.Errorf("unexpected signature packet length")
+	}
+	n := int(body[off])<<8 | int(body[off+1])
+	if len(body) < off+2+n {
+		return nil, fmt.Errorf("unexpected signature packet length")
+	}
+
+	unhashed := append([]byte{}, body[off+2:off+2+n]...)
+	unhashed = appendLength(unhashed, len(content)+1)
+	unhashed = append(unhashed, embeddedSignatureSubpacket)
+	unhashed = append(unhashed, content...)
+	if len(unhashed) > math.MaxUint16 {
+		return nil, fmt.Errorf("unhashed subpackets too long")
+	}
+
+	var newBody []byte
+	newBody = append(newBody, body[:off]...)
+	newBody = append(newBody, byte(len(unhashed)>>8), byte(len(unhashed)))
+	newBody = append(newBody, unhashed...)
+	newBody = append(newBody, body[off+2+n:]...)
+
+	// signature packet with a new format header (RFC 4880, section 4.2)
+	pkt := appendLength([]byte{0xc0 | 2}, len(newBody))
+	pkt = append(pkt, newBody...)
+
+	p, err := packet.Read(bytes.NewReader(pkt))
+	if err != nil {
+		return nil, err
+	}
+	s, ok := p.(*packet.Signature)
+	if !ok {
+		return nil, fmt.Errorf("unexpected packet type %T", p)
+	}
+	return s, nil
+}
+
+// packetBody returns the body of the new format packet pkt.
+func packetBody(pkt []byte) ([]byte, error) {
+	if len(pkt) < 2 || pkt[0]&0xc0 != 0xc0 {
+		return nil, fmt.Errorf("unexpected packet format")
+	}
+
+	hdr := 2
+	switch l := pkt[1]; {
+	case l < 192:
+	case l < 224:
+		hdr = 3
+	case l == 255:
+		hdr = 6
+	default:
+		return nil, fmt.Errorf("unexpected partial packet length")
+	}
+	if len(pkt) < hdr {
+		return nil, fmt.Errorf("packet truncated")
+	}
+	return pkt[hdr:], nil
+}
+
+// appendLength appends to b the length n, encoded like new format packet
+// and signature subpacket lengths (RFC 4880, section 4.2.2).
+func appendLength(b []byte, n int) []byte {
+	switch {
+	case n < 192:
+		return append(b, byte(n))
+	case n < 8384:
+		n -= 192
+		return append(b, byte(n>>8)+192, byte(n))
+	default:
+		return append(b, 255, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+}
+
+// extendEntity changes the expiration of the decrypted private key e, and
+// of its subkeys, to expire after the current time, or never if expire is
+// zero. The self-signatures of e are replaced by new ones.
+func extendEntity(e *openpgp.Entity, expire time.Duration, config *packet.Config) error {
+	if e.PrivateKey == nil || e.PrivateKey.Encrypted {
+		return fmt.Errorf("a decrypted private key is required to extend a key")
+	}
+	now := config.Now()
+
+	lifetime, err := keyLifetime(e.PrimaryKey.CreationTime, now, expire)
+	if err != nil {
+		return err
+	}
+	for _, ident := range e.Identities {
+		sig := *ident.SelfSignature
+		sig.CreationTime = now
+		sig.KeyLifetimeSecs = lifetime
+		if err := sig.SignUserId(ident.UserId.Id, e.PrimaryKey, e.PrivateKey, config); err != nil {
+			return err
+		}
+		ident.SelfSignature = &sig
+	}
+
+	for i := range e.Subkeys {
+		subkey := &e.Subkeys[i]
+		if subkey.Sig.SigType != packet.SigTypeSubkeyBinding {
+			// revoked subkey
+			continue
+		}
+		lifetime, err := keyLifetime(subkey.PublicKey.CreationTime, now, expire)
+		if err != nil {
+			return err
+		}
+		sig := *subkey.Sig
+		sig.CreationTime = now
+		sig.KeyLifetimeSecs = lifetime
+		sig.EmbeddedSignature = nil
+		if subkey.Sig, err = bindSubkey(e, subkey.PublicKey, subkey.PrivateKey, &sig, config); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ExtendKey changes the expiration of the key matching fingerprint to
+// expire after the current time, or never if expire is zero. The key is
+// updated in the private and public keyrings and the public key is
+// returned, it can be published to make the new expiration known.
+func (keyring *Handle) ExtendKey(fingerprint string, expire time.Duration) (*openpgp.Entity, error) {
+	if err := keyring.PathsCheck(); err != nil {
+		return nil, err
+	}
+
+	privEntlist, err := keyring.LoadPrivKeyring()
+	if err != nil {
+		return nil, err
+	}
+
+	fingerprint = strings.ToUpper(fingerprint)
+	entity := findKeyByFingerprint(privEntlist, fingerprint)
+	if entity == nil {
+		return nil, fmt.Errorf("no private key matching given fingerprint found")
+	}
+
+	var pass []byte
+	if entity.PrivateKey.Encrypted {
+		p, err := interactive.AskQuestionNoEcho("Enter key passphrase : ")
+		if err != nil {
+			return nil, err
+		}
+		pass = []byte(p)
+		if err := decryptEntity(entity, pass); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := extendEntity(entity, expire, nil); err != nil {
+		return nil, fmt.Errorf("could not extend key: %s", err)
+	}
+
+	if pass != nil {
+		if err := encryptEntity(entity, pass); err != nil {
+			return nil, err
+		}
+	}
+
+	sylog.Verbosef("Updating local keyring: %v", keyring.SecretPath())
+	if err := keyring.storePrivKeyring(privEntlist); err != nil {
+		return nil, err
+	}
+
+	pubEntlist, err := keyring.LoadPubKeyring()
+	if err != nil {
+		return nil, err
+	}
+
+	sylog.Verbosef("Updating local keyring: %v", keyring.PublicPath())
+
+	for i, pub := range pubEntlist {
+		if compareKeyEntity(pub, fingerprint) {
+			entity.Revocations = pub.Revocations
+			pubEntlist[i] = entity
+			return entity, keyring.storePubKeyring(pubEntlist)
+		}
+	}
+	return entity, keyring.appendPubKey(entity)
+}
@@ -0,0 +1,199 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sypgp
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sylabs/singularity/internal/pkg/test"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+func TestParseExpiry(t *testing.T) {
+	day := 24 * time.Hour
+
+	tests := []struct {
+		expiry   string
+		duration time.Duration
+		wantErr  bool
+	}{
+		{"never", 0, false},
+		{"0", 0, false},
+		{"10", 10 * day, false},
+		{"10d", 10 * day, false},
+		{"2w", 14 * day, false},
+		{"6m", 180 * day, false},
+		{"2Y", 730 * day, false},
+		{"", 0, true},
+		{"-1", 0, true},
+		{"2h", 0, true},
+		{"1.5y", 0, true},
+		{"200y", 0, true},
+	}
+
+	for _, tt := range tests {
+		d, err := ParseExpiry(tt.expiry)
+		if tt.wantErr && err == nil {
+			t.Errorf("%q: unexpected success", tt.expiry)
+		} else if !tt.wantErr && err != nil {
+			t.Errorf("%q: unexpected error: %s", tt.expiry, err)
+		} else if d != tt.duration {
+			t.Errorf("%q: got %s, expected %s", tt.expiry, d, tt.duration)
+		}
+	}
+}
+
+func TestGenKeyPairTypes(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+
+	test.DropPrivilege(t)
+	defer test.ResetPrivilege(t)
+
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory")
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err := NewHandle(dir).GenKeyPair(GenKeyPairOptions{Name: "teste", KeyType: "ed25519"}); err == nil {
+		t.Errorf("unexpected success with an unsupported key type")
+	}
+	if _, err := NewHandle(dir).GenKeyPair(GenKeyPairOptions{Name: "teste", KeyType: "dsa"}); err == nil {
+		t.Errorf("unexpected success with an unknown key type")
+	}
+
+	tests := []struct {
+		keyType string
+		algo    packet.PublicKeyAlgorithm
+		bits    uint16
+		subkeys int
+	}{
+		{"", packet.PubKeyAlgoRSA, 1024, 2},
+		{KeyTypeRSA2048, packet.PubKeyAlgoRSA, 2048, 2},
+		{KeyTypeECDSAP256, packet.PubKeyAlgoECDSA, 0, 1},
+		{KeyTypeECDSAP384, packet.PubKeyAlgoECDSA, 0, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.keyType, func(t *testing.T) {
+			keyring := NewHandle(filepath.Join(dir, "keyring-"+tt.keyType))
+
+			opts := GenKeyPairOptions{Name: "teste", Email: "test@my.info", KeyLength: 1024, KeyType: tt.keyType, Expire: 48 * time.Hour}
+			if _, err := keyring.GenKeyPair(opts); err != nil {
+				t.Fatalf("failed to generate key pair: %s", err)
+			}
+
+			// the keys must survive the keyring round trip
+			priv, err := keyring.LoadPrivKeyring()
+			if err != nil {
+				t.Fatalf("failed to load private keyring: %s", err)
+			}
+			pub, err := keyring.LoadPubKeyring()
+			if err != nil {
+				t.Fatalf("failed to load public keyring: %s", err)
+			}
+			if len(priv) != 1 || len(pub) != 1 {
+				t.Fatalf("unexpected keyrings: %d private keys, %d public keys", len(priv), len(pub))
+			}
+
+			for name, k := range map[string]int{"private": len(priv[0].Subkeys), "public": len(pub[0].Subkeys)} {
+				if k != tt.subkeys {
+					t.Errorf("%s key has %d subkeys, expected %d", name, k, tt.subkeys)
+				}
+			}
+
+			if algo := pub[0].PrimaryKey.PubKeyAlgo; algo != tt.algo {
+				t.Errorf("unexpected key algorithm %d", algo)
+			}
+			if bits, _ := pub[0].PrimaryKey.BitLength(); tt.bits != 0 && bits != tt.bits {
+				t.Errorf("unexpected key length %d", bits)
+			}
+
+			// signatures are made by the signing subkey
+			key := SigningKey(priv[0], time.Now())
+			if key == nil || !key.IsSubkey || !pub[0].Subkeys[0].Sig.FlagSign {
+				t.Fatalf("signing subkey not found")
+			}
+			if SigningKey(priv[0], time.Now().Add(72*time.Hour)) != nil {
+				t.Errorf("unexpected signing key after the key expiration")
+			}
+
+			expiry := KeyExpiry(pub[0], pub[0].PrimaryKey.KeyId)
+			if want := pub[0].PrimaryKey.CreationTime.Add(48 * time.Hour); !expiry.Equal(want) {
+				t.Errorf("unexpected expiration %s, expected %s", expiry, want)
+			}
+			if sub := KeyExpiry(pub[0], key.KeyId); !sub.Equal(expiry) {
+				t.Errorf("unexpected subkey expiration %s, expected %s", sub, expiry)
+			}
+		})
+	}
+}
+
+func TestExtendKey(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+
+	test.DropPrivilege(t)
+	defer test.ResetPrivilege(t)
+
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory")
+	}
+	defer os.RemoveAll(dir)
+
+	keyring := NewHandle(dir)
+
+	e, err := keyring.GenKeyPair(GenKeyPairOptions{Name: "teste", Email: "test@my.info", KeyType: KeyTypeECDSAP256, Expire: 24 * time.Hour})
+	if err != nil {
+		t.Fatalf("failed to generate key pair: %s", err)
+	}
+	fingerprint := fmt.Sprintf("%X", e.PrimaryKey.Fingerprint)
+
+	if _, err := keyring.RevokeKey(fingerprint); err != nil {
+		t.Fatalf("failed to revoke key: %s", err)
+	}
+
+	expected := map[time.Duration]bool{730 * 24 * time.Hour: true, 0: false}
+	for _, expire := range []time.Duration{730 * 24 * time.Hour, 0} {
+		before := time.Now().Truncate(time.Second)
+		if _, err := keyring.ExtendKey(fingerprint, expire); err != nil {
+			t.Fatalf("failed to extend key: %s", err)
+		}
+
+		priv, err := keyring.LoadPrivKeyring()
+		if err != nil {
+			t.Fatalf("failed to load private keyring: %s", err)
+		}
+		pub, err := keyring.LoadPubKeyring()
+		if err != nil {
+			t.Fatalf("failed to load public keyring: %s", err)
+		}
+		if len(priv) != 1 || len(pub) != 1 {
+			t.Fatalf("unexpected keyrings: %d private keys, %d public keys", len(priv), len(pub))
+		}
+		if !IsRevoked(pub[0]) {
+			t.Errorf("revocation lost while extending the key")
+		}
+
+		for _, k := range []uint64{pub[0].PrimaryKey.KeyId, pub[0].Subkeys[0].PublicKey.KeyId} {
+			expiry := KeyExpiry(pub[0], k)
+			if expected[expire] && expiry.Before(before.Add(expire)) {
+				t.Errorf("key %X expires on %s, expected after %s", k, expiry, before.Add(expire))
+			} else if !expected[expire] && !expiry.IsZero() {
+				t.Errorf("key %X expires on %s, expected no expiration", k, expiry)
+			}
+		}
+	}
+}
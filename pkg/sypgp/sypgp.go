@@ -13,10 +13,13 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 	"syscall"
@@ -42,11 +45,21 @@ const (
 `
 	helpPush = `  4) Push key using "singularity key push %[1]X"
 `
+
+	// DefaultKeyring is the name of the keyring stored at the root of the
+	// sypgp folder.
+	DefaultKeyring = "default"
+
+	// keyringsDir is the directory of the sypgp folder holding the named
+	// keyrings.
+	keyringsDir = "keyrings"
 )
 
 var (
 	errNotEncrypted = errors.New("key is not encrypted")
 
+	keyringNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+
 	// ErrEmptyKeyring is the error when the public, or private keyring
 	// empty.
 	ErrEmptyKeyring = errors.New("keyring is empty")
@@ -60,6 +73,11 @@ type KeyExistsError struct {
 // Handle is a structure representing a keyring
 type Handle struct {
 	path string
+	// name is the name of the keyring selected with KeyringHandleOpt,
+	// empty for the default keyring
+	name string
+	// err is set when the keyring name is invalid
+	err error
 }
 
 // GenKeyPairOptions parameters needed for generating new key pair.
@@ -69,6 +87,11 @@ type GenKeyPairOptions struct {
 	Comment   string
 	Password  string
 	KeyLength int
+	// KeyType is one of the KeyType constants, an RSA key of KeyLength
+	// bits is generated if empty.
+	KeyType string
+	// Expire is the validity period of the key, which never expires if zero.
+	Expire time.Duration
 }
 
 // mrKeyList contains all the key info, used for decoding
@@ -103,8 +126,32 @@ func dirPath() string {
 	return sypgpDir
 }
 
-// NewHandle initializes a new keyring in path.
-func NewHandle(path string) *Handle {
+// HandleOpt is an option modifying the keyring selected by NewHandle.
+type HandleOpt func(*Handle)
+
+// KeyringHandleOpt selects the named keyring stored in the keyrings
+// directory of the sypgp folder. The default keyring is selected if name is
+// empty or DefaultKeyring. The name must have been validated with
+// CheckKeyringName.
+func KeyringHandleOpt(name string) HandleOpt {
+	return func(h *Handle) {
+		if name == "" || name == DefaultKeyring {
+			return
+		}
+		// an invalid name could point outside of the keyrings
+		// directory, the keyring is then unusable
+		if err := CheckKeyringName(name); err != nil {
+			h.err = err
+			return
+		}
+		h.name = name
+		h.path = filepath.Join(h.path, keyringsDir, name)
+	}
+}
+
+// NewHandle initializes a new keyring in path, the sypgp folder is used if
+// path is empty.
+func NewHandle(path string, opts ...HandleOpt) *Handle {
 	if path == "" {
 		path = dirPath()
 	}
@@ -112,9 +159,48 @@ func NewHandle(path string) *Handle {
 	newHandle := new(Handle)
 	newHandle.path = path
 
+	for _, opt := range opts {
+		opt(newHandle)
+	}
+
 	return newHandle
 }
 
+// CheckKeyringName returns an error if name can't be used as a keyring name.
+func CheckKeyringName(name string) error {
+	if name == "" {
+		return nil
+	}
+	if !keyringNameRegexp.MatchString(name) {
+		return fmt.Errorf("invalid keyring name %q, it must start with a letter or a digit followed by letters, digits, '.', '_' or '-'", name)
+	}
+	return nil
+}
+
+// Keyrings returns the names of the keyrings found in the sypgp folder path,
+// or in the default sypgp folder if path is empty. The default keyring is
+// always part of the list.
+func Keyrings(path string) ([]string, error) {
+	if path == "" {
+		path = dirPath()
+	}
+
+	names := []string{DefaultKeyring}
+
+	fi, err := ioutil.ReadDir(filepath.Join(path, keyringsDir))
+	if os.IsNotExist(err) {
+		return names, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("could not list keyrings: %s", err)
+	}
+	for _, f := range fi {
+		if f.IsDir() && CheckKeyringName(f.Name()) == nil && f.Name() != DefaultKeyring {
+			names = append(names, f.Name())
+		}
+	}
+	return names, nil
+}
+
 // SecretPath returns a string describing the path to the private keys store
 func (keyring *Handle) SecretPath() string {
 	return filepath.Join(keyring.path, "pgp-secret")
@@ -170,6 +256,10 @@ func createOrAppendPrivateFile(fn string) (*os.File, error) {
 
 // PathsCheck creates the sypgp home folder, secret and public keyring files
 func (keyring *Handle) PathsCheck() error {
+	if keyring.err != nil {
+		return keyring.err
+	}
+
 	if err := ensureDirPrivate(keyring.path); err != nil {
 		return err
 	}
@@ -206,6 +296,16 @@ func (keyring *Handle) LoadPrivKeyring() (openpgp.EntityList, error) {
 
 // LoadPubKeyring loads the public keys from local store into an EntityList
 func (keyring *Handle) LoadPubKeyring() (openpgp.EntityList, error) {
+	if keyring.err != nil {
+		return nil, keyring.err
+	}
+	// a missing named keyring is most likely a mistyped name
+	if keyring.name != "" {
+		if _, err := os.Stat(keyring.path); os.IsNotExist(err) {
+			return nil, fmt.Errorf("keyring %s doesn't exist", keyring.name)
+		}
+	}
+
 	if err := keyring.PathsCheck(); err != nil {
 		return nil, err
 	}
@@ -248,6 +348,12 @@ func printEntity(w io.Writer, index int, e *openpgp.Entity) {
 	fmt.Fprintf(w, "   F: %0X\n", e.PrimaryKey.Fingerprint)
 	bits, _ := e.PrimaryKey.BitLength()
 	fmt.Fprintf(w, "   L: %d\n", bits)
+	if expiry := KeyExpiry(e, e.PrimaryKey.KeyId); !expiry.IsZero() {
+		fmt.Fprintf(w, "   E: %s\n", expiry)
+	}
+	for _, r := range e.Revocations {
+		fmt.Fprintf(w, "   R: %s\n", r.CreationTime)
+	}
 
 }
 
@@ -263,14 +369,24 @@ func PrintEntity(index int, e *openpgp.Entity) {
 	printEntity(os.Stdout, index, e)
 }
 
-// PrintPubKeyring prints the public keyring read from the public local store
+// PrintPubKeyring prints the public keyring read from the public local store,
+// along with the trust level of the keys
 func (keyring *Handle) PrintPubKeyring() error {
 	pubEntlist, err := keyring.LoadPubKeyring()
 	if err != nil {
 		return err
 	}
 
-	printEntities(os.Stdout, pubEntlist)
+	trust, err := keyring.LoadTrust()
+	if err != nil {
+		return err
+	}
+
+	for i, e := range pubEntlist {
+		printEntity(os.Stdout, i, e)
+		fmt.Printf("   T: %s\n", trust[fmt.Sprintf("%X", e.PrimaryKey.Fingerprint)])
+		fmt.Print("   --------\n")
+	}
 
 	return nil
 }
@@ -290,7 +406,7 @@ func (keyring *Handle) PrintPrivKeyring() error {
 // storePrivKeys writes all the private keys in list to the writer w.
 func storePrivKeys(w io.Writer, list openpgp.EntityList) error {
 	for _, e := range list {
-		if err := e.SerializePrivate(w, nil); err != nil {
+		if err := serializePrivateKeys(w, e); err != nil {
 			return err
 		}
 	}
@@ -298,6 +414,36 @@ func storePrivKeys(w io.Writer, list openpgp.EntityList) error {
 	return nil
 }
 
+// serializePrivateKeys writes the private parts of e to the writer w. Unlike
+// openpgp.Entity.SerializePrivate, the self-signatures are written as they
+// are rather than signed again, which would drop the cross-signatures of
+// signing subkeys and requires a decrypted private key.
+func serializePrivateKeys(w io.Writer, e *openpgp.Entity) error {
+	if err := e.PrivateKey.Serialize(w); err != nil {
+		return err
+	}
+	for _, ident := range e.Identities {
+		if err := ident.UserId.Serialize(w); err != nil {
+			return err
+		}
+		if err := ident.SelfSignature.Serialize(w); err != nil {
+			return err
+		}
+	}
+	for _, subkey := range e.Subkeys {
+		if subkey.PrivateKey == nil {
+			continue
+		}
+		if err := subkey.PrivateKey.Serialize(w); err != nil {
+			return err
+		}
+		if err := subkey.Sig.Serialize(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // appendPrivateKey appends a private key entity to the local keyring
 func (keyring *Handle) appendPrivateKey(e *openpgp.Entity) error {
 	f, err := createOrAppendPrivateFile(keyring.SecretPath())
@@ -309,10 +455,38 @@ func (keyring *Handle) appendPrivateKey(e *openpgp.Entity) error {
 	return storePrivKeys(f, openpgp.EntityList{e})
 }
 
+// serializePublicEntity writes the public parts of e to the writer w,
+// including the key revocation signatures which are not written by
+// openpgp.Entity.Serialize.
+func serializePublicEntity(w io.Writer, e *openpgp.Entity) error {
+	if len(e.Revocations) == 0 {
+		return e.Serialize(w)
+	}
+
+	// revocation signatures must directly follow the primary key
+	var pk, buf bytes.Buffer
+	if err := e.PrimaryKey.Serialize(&pk); err != nil {
+		return err
+	}
+	if err := e.Serialize(&buf); err != nil {
+		return err
+	}
+	if _, err := w.Write(buf.Next(pk.Len())); err != nil {
+		return err
+	}
+	for _, r := range e.Revocations {
+		if err := r.Serialize(w); err != nil {
+			return err
+		}
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
 // storePubKeys writes all the public keys in list to the writer w.
 func storePubKeys(w io.Writer, list openpgp.EntityList) error {
 	for _, e := range list {
-		if err := e.Serialize(w); err != nil {
+		if err := serializePublicEntity(w, e); err != nil {
 			return err
 		}
 	}
@@ -340,7 +514,7 @@ func (keyring *Handle) storePubKeyring(keys openpgp.EntityList) error {
 	defer f.Close()
 
 	for _, k := range keys {
-		if err := k.Serialize(f); err != nil {
+		if err := serializePublicEntity(f, k); err != nil {
 			return fmt.Errorf("could not store public key: %s", err)
 		}
 	}
@@ -348,6 +522,17 @@ func (keyring *Handle) storePubKeyring(keys openpgp.EntityList) error {
 	return nil
 }
 
+// storePrivKeyring overwrites the private keyring with the keys of list.
+// The keys are serialized first, to not truncate the keyring on error.
+func (keyring *Handle) storePrivKeyring(list openpgp.EntityList) error {
+	var buf bytes.Buffer
+	if err := storePrivKeys(&buf, list); err != nil {
+		return fmt.Errorf("could not store private key: %s", err)
+	}
+
+	return ioutil.WriteFile(keyring.SecretPath(), buf.Bytes(), 0600)
+}
+
 // compareKeyEntity compares a key ID with a string, returning true if the
 // key and oldToken match.
 func compareKeyEntity(e *openpgp.Entity, oldToken string) bool {
@@ -365,6 +550,25 @@ func findKeyByFingerprint(entities openpgp.EntityList, fingerprint string) *open
 	return nil
 }
 
+// FindPubKeys returns the keys of the public keyring matching fingerprints.
+func (keyring *Handle) FindPubKeys(fingerprints []string) (openpgp.EntityList, error) {
+	elist, err := keyring.LoadPubKeyring()
+	if err != nil {
+		return nil, fmt.Errorf("could not load public keyring: %s", err)
+	}
+
+	var keys openpgp.EntityList
+	for _, fp := range fingerprints {
+		fp = strings.ToUpper(fp)
+		e := findKeyByFingerprint(elist, fp)
+		if e == nil {
+			return nil, fmt.Errorf("no public key matching fingerprint %s found", fp)
+		}
+		keys = append(keys, e)
+	}
+	return keys, nil
+}
+
 // CheckLocalPubKey will check if we have a local public key matching ckey string
 // returns true if there's a match.
 func (keyring *Handle) CheckLocalPubKey(ckey string) (bool, error) {
@@ -418,13 +622,140 @@ func (keyring *Handle) RemovePubKey(toDelete string) error {
 
 	sylog.Verbosef("Updating local keyring: %v", keyring.PublicPath())
 
-	return keyring.storePubKeyring(newKeyList)
+	if err := keyring.storePubKeyring(newKeyList); err != nil {
+		return err
+	}
+	return keyring.removeKeyTrust(toDelete)
+}
+
+// IsRevoked returns true if the key e has been revoked. The revocation
+// signatures of an entity are verified when it is read.
+func IsRevoked(e *openpgp.Entity) bool {
+	return len(e.Revocations) > 0
+}
+
+// keyRevocationHash returns the hash of the public key pk signed by a key
+// revocation signature (RFC 4880, section 5.2.4).
+func keyRevocationHash(pk *packet.PublicKey, hashFunc crypto.Hash) (hash.Hash, error) {
+	if !hashFunc.Available() {
+		return nil, fmt.Errorf("hash function %v not available", hashFunc)
+	}
+
+	h := hashFunc.New()
+	if err := hashPublicKey(h, pk); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// hashPublicKey writes the public key pk to h as it is hashed by key
+// signatures (RFC 4880, section 5.2.4).
+func hashPublicKey(h hash.Hash, pk *packet.PublicKey) error {
+	var prefix, body bytes.Buffer
+	pk.SerializeSignaturePrefix(&prefix)
+	if err := pk.Serialize(&body); err != nil {
+		return err
+	}
+
+	// the prefix holds the length of the packet body, which follows
+	// the packet header
+	p := prefix.Bytes()
+	n := int(p[1])<<8 | int(p[2])
+	if body.Len() < n {
+		return fmt.Errorf("unexpected public key packet length")
+	}
+
+	h.Write(p)
+	h.Write(body.Bytes()[body.Len()-n:])
+	return nil
+}
+
+// revokeEntity creates a key revocation signature with the decrypted
+// private key of e and adds it to e.
+func revokeEntity(e *openpgp.Entity, config *packet.Config) (*packet.Signature, error) {
+	if e.PrivateKey == nil || e.PrivateKey.Encrypted {
+		return nil, fmt.Errorf("a decrypted private key is required to revoke a key")
+	}
+
+	sig := &packet.Signature{
+		SigType:      packet.SigTypeKeyRevocation,
+		PubKeyAlgo:   e.PrimaryKey.PubKeyAlgo,
+		Hash:         config.Hash(),
+		CreationTime: config.Now(),
+		IssuerKeyId:  &e.PrimaryKey.KeyId,
+	}
+
+	h, err := keyRevocationHash(e.PrimaryKey, sig.Hash)
+	if err != nil {
+		return nil, err
+	}
+	if err := sig.Sign(h, e.PrivateKey, config); err != nil {
+		return nil, err
+	}
+
+	e.Revocations = append(e.Revocations, sig)
+	return sig, nil
+}
+
+// RevokeKey revokes the key matching fingerprint with its private key and
+// stores the revoked public key in the public keyring. The revoked public
+// key is returned, it can be published to make the revocation known.
+func (keyring *Handle) RevokeKey(fingerprint string) (*openpgp.Entity, error) {
+	if err := keyring.PathsCheck(); err != nil {
+		return nil, err
+	}
+
+	privEntlist, err := keyring.LoadPrivKeyring()
+	if err != nil {
+		return nil, err
+	}
+
+	fingerprint = strings.ToUpper(fingerprint)
+	entity := findKeyByFingerprint(privEntlist, fingerprint)
+	if entity == nil {
+		return nil, fmt.Errorf("no private key matching given fingerprint found")
+	}
+
+	if entity.PrivateKey.Encrypted {
+		if err := DecryptKey(entity, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	sig, err := revokeEntity(entity, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not create revocation signature: %s", err)
+	}
+
+	pubEntlist, err := keyring.LoadPubKeyring()
+	if err != nil {
+		return nil, err
+	}
+
+	sylog.Verbosef("Updating local keyring: %v", keyring.PublicPath())
+
+	pub := findKeyByFingerprint(pubEntlist, fingerprint)
+	if pub == nil {
+		return entity, keyring.appendPubKey(entity)
+	}
+	pub.Revocations = append(pub.Revocations, sig)
+
+	return pub, keyring.storePubKeyring(pubEntlist)
+}
+
+// SerializeRevokedKey returns the armored public key of e with its
+// revocation signatures, which can be imported as a revocation certificate.
+func SerializeRevokedKey(e *openpgp.Entity) (string, error) {
+	if !IsRevoked(e) {
+		return "", fmt.Errorf("key %X is not revoked", e.PrimaryKey.Fingerprint)
+	}
+	return serializeEntity(e, openpgp.PublicKeyType)
 }
 
 func (keyring *Handle) genKeyPair(opts GenKeyPairOptions) (*openpgp.Entity, error) {
 	conf := &packet.Config{RSABits: opts.KeyLength, DefaultHash: crypto.SHA384}
 
-	entity, err := openpgp.NewEntity(opts.Name, opts.Comment, opts.Email, conf)
+	entity, err := generateEntity(opts, conf)
 	if err != nil {
 		return nil, err
 	}
@@ -461,6 +792,11 @@ func (keyring *Handle) GenKeyPair(opts GenKeyPairOptions) (*openpgp.Entity, erro
 		return nil, err
 	}
 
+	// keys created locally are fully trusted
+	if err := keyring.SetKeyTrust(fmt.Sprintf("%X", entity.PrimaryKey.Fingerprint), TrustFull); err != nil {
+		return nil, fmt.Errorf("could not set key trust level: %s", err)
+	}
+
 	return entity, nil
 }
 
@@ -475,7 +811,7 @@ func DecryptKey(k *openpgp.Entity, message string) error {
 		return err
 	}
 
-	return k.PrivateKey.Decrypt([]byte(pass))
+	return decryptEntity(k, []byte(pass))
 }
 
 // EncryptKey encrypts a private key using a pass phrase
@@ -483,7 +819,41 @@ func EncryptKey(k *openpgp.Entity, pass string) error {
 	if k.PrivateKey.Encrypted {
 		return fmt.Errorf("key already encrypted")
 	}
-	return k.PrivateKey.Encrypt([]byte(pass))
+	return encryptEntity(k, []byte(pass))
+}
+
+// decryptEntity decrypts the private key of e and its encrypted
+// private subkeys with passphrase.
+func decryptEntity(e *openpgp.Entity, passphrase []byte) error {
+	if e.PrivateKey.Encrypted {
+		if err := e.PrivateKey.Decrypt(passphrase); err != nil {
+			return err
+		}
+	}
+	for _, subkey := range e.Subkeys {
+		if subkey.PrivateKey != nil && subkey.PrivateKey.Encrypted {
+			if err := subkey.PrivateKey.Decrypt(passphrase); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// encryptEntity encrypts the private key of e and its private
+// subkeys with passphrase.
+func encryptEntity(e *openpgp.Entity, passphrase []byte) error {
+	if err := e.PrivateKey.Encrypt(passphrase); err != nil {
+		return err
+	}
+	for _, subkey := range e.Subkeys {
+		if subkey.PrivateKey != nil && !subkey.PrivateKey.Encrypted {
+			if err := subkey.PrivateKey.Encrypt(passphrase); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
 
 // selectPubKey prints a public key list to user and returns the choice
@@ -819,7 +1189,7 @@ func serializeEntity(e *openpgp.Entity, blockType string) (string, error) {
 		return "", err
 	}
 
-	if err = e.Serialize(wr); err != nil {
+	if err = serializePublicEntity(wr, e); err != nil {
 		wr.Close()
 		return "", err
 	}
@@ -836,7 +1206,7 @@ func serializePrivateEntity(e *openpgp.Entity, blockType string) (string, error)
 		return "", err
 	}
 
-	if err = e.SerializePrivate(wr, nil); err != nil {
+	if err = serializePrivateKeys(wr, e); err != nil {
 		wr.Close()
 		return "", err
 	}
@@ -852,15 +1222,11 @@ func RecryptKey(k *openpgp.Entity, passphrase []byte) error {
 		return errNotEncrypted
 	}
 
-	if err := k.PrivateKey.Decrypt(passphrase); err != nil {
+	if err := decryptEntity(k, passphrase); err != nil {
 		return err
 	}
 
-	if err := k.PrivateKey.Encrypt(passphrase); err != nil {
-		return err
-	}
-
-	return nil
+	return encryptEntity(k, passphrase)
 }
 
 // ExportPrivateKey Will export a private key into a file (kpath).
@@ -900,7 +1266,7 @@ func (keyring *Handle) ExportPrivateKey(kpath string, armor bool) error {
 
 	if !armor {
 		// Export the key to the file
-		err = entityToExport.SerializePrivate(file, nil)
+		err = serializePrivateKeys(file, entityToExport)
 	} else {
 		var keyText string
 		keyText, err = serializePrivateEntity(entityToExport, openpgp.PrivateKeyType)
@@ -945,7 +1311,7 @@ func (keyring *Handle) ExportPubKey(kpath string, armor bool) error {
 		keyText, err = serializeEntity(entityToExport, openpgp.PublicKeyType)
 		file.WriteString(keyText)
 	} else {
-		err = entityToExport.Serialize(file)
+		err = serializePublicEntity(file, entityToExport)
 	}
 
 	if err != nil {
@@ -991,7 +1357,7 @@ func (keyring *Handle) importPrivateKey(entity *openpgp.Entity, setNewPassword b
 		if err != nil {
 			return err
 		}
-		if err := newEntity.PrivateKey.Decrypt([]byte(password)); err != nil {
+		if err := decryptEntity(&newEntity, []byte(password)); err != nil {
 			return err
 		}
 	}
@@ -1005,7 +1371,7 @@ func (keyring *Handle) importPrivateKey(entity *openpgp.Entity, setNewPassword b
 	}
 
 	if password != "" {
-		if err := newEntity.PrivateKey.Encrypt([]byte(password)); err != nil {
+		if err := encryptEntity(&newEntity, []byte(password)); err != nil {
 			return err
 		}
 	}
@@ -1027,8 +1393,13 @@ func (keyring *Handle) importPublicKey(entity *openpgp.Entity) error {
 		return err
 	}
 
-	if findEntityByFingerprint(publicEntityList, entity.PrimaryKey.Fingerprint) != nil {
-		return &KeyExistsError{fingerprint: entity.PrimaryKey.Fingerprint}
+	if e := findEntityByFingerprint(publicEntityList, entity.PrimaryKey.Fingerprint); e != nil {
+		if IsRevoked(e) || !IsRevoked(entity) {
+			return &KeyExistsError{fingerprint: entity.PrimaryKey.Fingerprint}
+		}
+		// importing a revocation certificate of a known key
+		e.Revocations = entity.Revocations
+		return keyring.storePubKeyring(publicEntityList)
 	}
 
 	if err := keyring.appendPubKey(entity); err != nil {
@@ -1042,6 +1413,10 @@ func (keyring *Handle) importPublicKey(entity *openpgp.Entity) error {
 // can be either a public or private keys, and the file can be either in
 // binary or ascii-armored format.
 func (keyring *Handle) ImportKey(kpath string, setNewPassword bool) error {
+	if err := keyring.PathsCheck(); err != nil {
+		return err
+	}
+
 	// Load the private key as an entitylist
 	pathEntityList, err := loadKeysFromFile(kpath)
 	if err != nil {
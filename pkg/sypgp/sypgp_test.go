@@ -9,12 +9,14 @@ import (
 	"bytes"
 	"context"
 	"encoding/hex"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/sylabs/singularity/internal/pkg/test"
@@ -495,6 +497,85 @@ func TestGenKeyPair(t *testing.T) {
 	}
 }
 
+func TestRevokeKey(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+
+	test.DropPrivilege(t)
+	defer test.ResetPrivilege(t)
+
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory")
+	}
+	defer os.RemoveAll(dir)
+
+	keyring := NewHandle(filepath.Join(dir, "keyring"))
+	other := NewHandle(filepath.Join(dir, "other"))
+
+	e, err := keyring.GenKeyPair(GenKeyPairOptions{Name: "teste", Email: "test@my.info", KeyLength: 1024})
+	if err != nil {
+		t.Fatalf("failed to generate key pair: %s", err)
+	}
+	fingerprint := fmt.Sprintf("%X", e.PrimaryKey.Fingerprint)
+
+	// the other keyring knows the key before its revocation
+	if err := other.PathsCheck(); err != nil {
+		t.Fatalf("failed to create keyring: %s", err)
+	}
+	if err := other.appendPubKey(e); err != nil {
+		t.Fatalf("failed to store public key: %s", err)
+	}
+
+	if _, err := keyring.RevokeKey(strings.Repeat("AB", 20)); err == nil {
+		t.Errorf("unexpected success while revoking an unknown key")
+	}
+
+	revoked, err := keyring.RevokeKey(fingerprint)
+	if err != nil {
+		t.Fatalf("failed to revoke key: %s", err)
+	}
+	if !IsRevoked(revoked) {
+		t.Fatalf("key is not revoked")
+	}
+
+	// the revocation must survive the public keyring round trip
+	el, err := keyring.LoadPubKeyring()
+	if err != nil {
+		t.Fatalf("failed to load public keyring: %s", err)
+	}
+	if len(el) != 1 || !IsRevoked(el[0]) {
+		t.Fatalf("revocation not stored in the public keyring")
+	}
+	if err := el[0].PrimaryKey.VerifyRevocationSignature(el[0].Revocations[0]); err != nil {
+		t.Errorf("invalid revocation signature: %s", err)
+	}
+
+	// import the revocation certificate in the other keyring
+	cert, err := SerializeRevokedKey(revoked)
+	if err != nil {
+		t.Fatalf("failed to serialize revocation certificate: %s", err)
+	}
+	certPath := filepath.Join(dir, "revocation.asc")
+	if err := ioutil.WriteFile(certPath, []byte(cert), 0644); err != nil {
+		t.Fatalf("failed to write revocation certificate: %s", err)
+	}
+	if err := other.ImportKey(certPath, false); err != nil {
+		t.Fatalf("failed to import revocation certificate: %s", err)
+	}
+	el, err = other.LoadPubKeyring()
+	if err != nil {
+		t.Fatalf("failed to load public keyring: %s", err)
+	}
+	if len(el) != 1 || !IsRevoked(el[0]) {
+		t.Errorf("revocation certificate not imported")
+	}
+	if err := other.ImportKey(certPath, false); err == nil {
+		t.Errorf("unexpected success while importing a revoked key twice")
+	}
+}
+
 func TestCompareKeyEntity(t *testing.T) {
 	cases := []struct {
 		name        string
@@ -769,6 +850,88 @@ func TestRemoveKey(t *testing.T) {
 	}
 }
 
+func TestNamedKeyrings(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+
+	test.DropPrivilege(t)
+	defer test.ResetPrivilege(t)
+
+	for _, name := range []string{"", "release", "team-1", "my.keys_2"} {
+		if err := CheckKeyringName(name); err != nil {
+			t.Errorf("unexpected error for keyring name %q: %s", name, err)
+		}
+	}
+	for _, name := range []string{".", "..", "../release", "team/release", "-team", "team key"} {
+		if err := CheckKeyringName(name); err == nil {
+			t.Errorf("unexpected success for keyring name %q", name)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory")
+	}
+	defer os.RemoveAll(dir)
+
+	if path := NewHandle(dir, KeyringHandleOpt(DefaultKeyring)).PublicPath(); path != filepath.Join(dir, "pgp-public") {
+		t.Errorf("unexpected default keyring path %s", path)
+	}
+
+	if names, err := Keyrings(dir); err != nil {
+		t.Fatalf("failed to list keyrings: %s", err)
+	} else if len(names) != 1 || names[0] != DefaultKeyring {
+		t.Errorf("unexpected keyrings %v", names)
+	}
+
+	release := NewHandle(dir, KeyringHandleOpt("release"))
+	if path := release.SecretPath(); path != filepath.Join(dir, "keyrings", "release", "pgp-secret") {
+		t.Errorf("unexpected release keyring path %s", path)
+	}
+	if _, err := release.GenKeyPair(GenKeyPairOptions{Name: "release", Email: "release@my.info", KeyLength: 1024}); err != nil {
+		t.Fatalf("failed to generate key pair: %s", err)
+	}
+
+	// keys of a named keyring are not part of the default keyring
+	for _, keyring := range []*Handle{NewHandle(dir), release} {
+		elist, err := keyring.LoadPubKeyring()
+		if err != nil {
+			t.Fatalf("failed to load public keyring: %s", err)
+		}
+		if expected := keyring == release; (len(elist) == 1) != expected {
+			t.Errorf("unexpected public keyring %s with %d keys", keyring.PublicPath(), len(elist))
+		}
+	}
+
+	if names, err := Keyrings(dir); err != nil {
+		t.Fatalf("failed to list keyrings: %s", err)
+	} else if len(names) != 2 || names[1] != "release" {
+		t.Errorf("unexpected keyrings %v", names)
+	}
+
+	// invalid keyring names are rejected instead of resolving a path
+	invalid := NewHandle(dir, KeyringHandleOpt("../release"))
+	if err := invalid.PathsCheck(); err == nil {
+		t.Errorf("unexpected success checking paths of an invalid keyring")
+	}
+	if _, err := invalid.LoadPubKeyring(); err == nil {
+		t.Errorf("unexpected success loading an invalid keyring")
+	}
+	if _, err := invalid.LoadTrust(); err == nil {
+		t.Errorf("unexpected success loading trust of an invalid keyring")
+	}
+
+	// a missing named keyring is an error and is not created
+	missing := NewHandle(dir, KeyringHandleOpt("relaese"))
+	if _, err := missing.LoadPubKeyring(); err == nil {
+		t.Errorf("unexpected success loading a missing keyring")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "keyrings", "relaese")); !os.IsNotExist(err) {
+		t.Errorf("missing keyring directory was created")
+	}
+}
+
 func TestMain(m *testing.M) {
 	// Set TZ to UTC so that the code converting a time.Time value
 	// to a string produces consistent output.
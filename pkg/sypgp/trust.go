@@ -0,0 +1,170 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sypgp
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// TrustLevel is the level of trust given to a public key of the keyring,
+// levels are ordered from TrustNone to TrustFull.
+type TrustLevel int
+
+const (
+	// TrustNone is the trust level of the keys which weren't promoted by
+	// the user, including the keys fetched from a key server.
+	TrustNone TrustLevel = iota
+	// TrustMarginal is the trust level of partially trusted keys.
+	TrustMarginal
+	// TrustFull is the trust level of fully trusted keys, including the
+	// keys created with GenKeyPair.
+	TrustFull
+)
+
+var trustLevelNames = map[TrustLevel]string{
+	TrustNone:     "none",
+	TrustMarginal: "marginal",
+	TrustFull:     "full",
+}
+
+func (t TrustLevel) String() string {
+	if name, ok := trustLevelNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", int(t))
+}
+
+// ParseTrustLevel returns the trust level named s: full, marginal or none.
+func ParseTrustLevel(s string) (TrustLevel, error) {
+	for t, name := range trustLevelNames {
+		if strings.EqualFold(s, name) {
+			return t, nil
+		}
+	}
+	return TrustNone, fmt.Errorf("invalid trust level %q, must be one of full, marginal or none", s)
+}
+
+// TrustPath returns a string describing the path to the trust levels store
+func (keyring *Handle) TrustPath() string {
+	return filepath.Join(keyring.path, "pgp-trust")
+}
+
+// LoadTrust returns the trust levels of the keyring indexed by upper case
+// key fingerprints. Keys without an entry have the TrustNone level.
+func (keyring *Handle) LoadTrust() (map[string]TrustLevel, error) {
+	if keyring.err != nil {
+		return nil, keyring.err
+	}
+
+	trust := make(map[string]TrustLevel)
+
+	f, err := os.Open(keyring.TrustPath())
+	if os.IsNotExist(err) {
+		return trust, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("could not open trust levels: %s", err)
+	}
+	defer f.Close()
+
+	// each line holds a fingerprint followed by its trust level
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 || len(fields[0]) != 40 {
+			return nil, fmt.Errorf("%s:%d: malformed trust level entry", keyring.TrustPath(), n)
+		}
+		t, err := ParseTrustLevel(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", keyring.TrustPath(), n, err)
+		}
+		trust[strings.ToUpper(fields[0])] = t
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read trust levels: %s", err)
+	}
+	return trust, nil
+}
+
+// storeTrust replaces the trust levels of the keyring with trust.
+func (keyring *Handle) storeTrust(trust map[string]TrustLevel) error {
+	fingerprints := make([]string, 0, len(trust))
+	for fp, t := range trust {
+		// keys without an entry aren't trusted
+		if t != TrustNone {
+			fingerprints = append(fingerprints, fp)
+		}
+	}
+	sort.Strings(fingerprints)
+
+	var buf bytes.Buffer
+	buf.WriteString("# Trust levels of the public keys, managed with 'singularity key trust'\n")
+	for _, fp := range fingerprints {
+		fmt.Fprintf(&buf, "%s %s\n", fp, trust[fp])
+	}
+
+	if err := keyring.PathsCheck(); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(keyring.TrustPath(), buf.Bytes(), 0600)
+}
+
+// KeyTrust returns the trust level of the key matching fingerprint.
+func (keyring *Handle) KeyTrust(fingerprint string) (TrustLevel, error) {
+	trust, err := keyring.LoadTrust()
+	if err != nil {
+		return TrustNone, err
+	}
+	return trust[strings.ToUpper(fingerprint)], nil
+}
+
+// SetKeyTrust sets the trust level of the key of the public keyring matching
+// fingerprint.
+func (keyring *Handle) SetKeyTrust(fingerprint string, level TrustLevel) error {
+	if _, ok := trustLevelNames[level]; !ok {
+		return fmt.Errorf("invalid trust level %s", level)
+	}
+	fingerprint = strings.ToUpper(fingerprint)
+
+	elist, err := keyring.LoadPubKeyring()
+	if err != nil {
+		return fmt.Errorf("could not load public keyring: %s", err)
+	}
+	if findKeyByFingerprint(elist, fingerprint) == nil {
+		return fmt.Errorf("no public key matching fingerprint %s found", fingerprint)
+	}
+
+	trust, err := keyring.LoadTrust()
+	if err != nil {
+		return err
+	}
+	trust[fingerprint] = level
+	return keyring.storeTrust(trust)
+}
+
+// removeKeyTrust removes the trust level of the key matching fingerprint.
+func (keyring *Handle) removeKeyTrust(fingerprint string) error {
+	trust, err := keyring.LoadTrust()
+	if err != nil {
+		return err
+	}
+	fingerprint = strings.ToUpper(fingerprint)
+	if _, ok := trust[fingerprint]; !ok {
+		return nil
+	}
+	delete(trust, fingerprint)
+	return keyring.storeTrust(trust)
+}
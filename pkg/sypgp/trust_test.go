@@ -0,0 +1,105 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sypgp
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/sylabs/singularity/internal/pkg/test"
+)
+
+func TestParseTrustLevel(t *testing.T) {
+	tests := []struct {
+		level   string
+		trust   TrustLevel
+		wantErr bool
+	}{
+		{"full", TrustFull, false},
+		{"Marginal", TrustMarginal, false},
+		{"none", TrustNone, false},
+		{"ultimate", TrustNone, true},
+		{"", TrustNone, true},
+	}
+
+	for _, tt := range tests {
+		trust, err := ParseTrustLevel(tt.level)
+		if tt.wantErr && err == nil {
+			t.Errorf("%q: unexpected success", tt.level)
+		} else if !tt.wantErr && err != nil {
+			t.Errorf("%q: unexpected error: %s", tt.level, err)
+		} else if trust != tt.trust {
+			t.Errorf("%q: got %s, expected %s", tt.level, trust, tt.trust)
+		}
+	}
+}
+
+func TestKeyTrust(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+
+	test.DropPrivilege(t)
+	defer test.ResetPrivilege(t)
+
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory")
+	}
+	defer os.RemoveAll(dir)
+
+	keyring := NewHandle(dir)
+
+	e, err := keyring.GenKeyPair(GenKeyPairOptions{Name: "teste", Email: "test@my.info", KeyLength: 1024})
+	if err != nil {
+		t.Fatalf("failed to generate key pair: %s", err)
+	}
+	fingerprint := fmt.Sprintf("%X", e.PrimaryKey.Fingerprint)
+
+	check := func(expected TrustLevel) {
+		t.Helper()
+		trust, err := keyring.KeyTrust(strings.ToLower(fingerprint))
+		if err != nil {
+			t.Fatalf("failed to get key trust level: %s", err)
+		}
+		if trust != expected {
+			t.Errorf("unexpected trust level %s, expected %s", trust, expected)
+		}
+	}
+
+	// keys created locally are fully trusted
+	check(TrustFull)
+
+	for _, level := range []TrustLevel{TrustMarginal, TrustNone, TrustFull} {
+		if err := keyring.SetKeyTrust(strings.ToLower(fingerprint), level); err != nil {
+			t.Fatalf("failed to set key trust level: %s", err)
+		}
+		check(level)
+	}
+
+	if err := keyring.SetKeyTrust(strings.Repeat("0", 40), TrustFull); err == nil {
+		t.Errorf("unexpected success with an unknown key")
+	}
+	if err := keyring.SetKeyTrust(fingerprint, TrustLevel(42)); err == nil {
+		t.Errorf("unexpected success with an invalid trust level")
+	}
+
+	// the trust level goes away with the key
+	if err := keyring.RemovePubKey(fingerprint); err != nil {
+		t.Fatalf("failed to remove public key: %s", err)
+	}
+	check(TrustNone)
+
+	if err := ioutil.WriteFile(keyring.TrustPath(), []byte(fingerprint+" ultimate\n"), 0600); err != nil {
+		t.Fatalf("failed to write trust levels: %s", err)
+	}
+	if _, err := keyring.LoadTrust(); err == nil {
+		t.Errorf("unexpected success with a malformed trust level")
+	}
+}
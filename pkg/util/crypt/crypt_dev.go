@@ -9,6 +9,7 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
@@ -305,3 +306,50 @@ func (crypt *Device) Open(key []byte, path string) (string, error) {
 
 	return "", errors.New("unable to open crypt device")
 }
+
+// luksHeaderSize is the size of the LUKS2 header and keyslots area written
+// by EncryptFilesystem.
+const luksHeaderSize = 16 * 1024 * 1024
+
+// TestKey checks that key opens the encrypted filesystem of size bytes found
+// at offset in the file path, without opening a crypt device. The LUKS
+// header is copied to a temporary file so that no loop device is needed.
+func (crypt *Device) TestKey(key []byte, path string, offset, size int64) error {
+	cryptsetup, err := bin.Cryptsetup()
+	if err != nil {
+		return err
+	}
+
+	if size > luksHeaderSize {
+		size = luksHeaderSize
+	}
+
+	src, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("unable to open %s: %s", path, err)
+	}
+	defer src.Close()
+
+	header, err := ioutil.TempFile("", "crypt-header-")
+	if err != nil {
+		return fmt.Errorf("unable to create temporary header file: %s", err)
+	}
+	defer os.Remove(header.Name())
+	defer header.Close()
+
+	if _, err := io.Copy(header, io.NewSectionReader(src, offset, size)); err != nil {
+		return fmt.Errorf("unable to copy LUKS header of %s: %s", path, err)
+	}
+
+	cmd := exec.Command(cryptsetup, "open", "--test-passphrase", "--disable-locks", "--type", "luks2", "--key-file", "-", header.Name())
+	cmd.Stdin = bytes.NewBuffer(key)
+	sylog.Debugf("Running %s %s", cmd.Path, strings.Join(cmd.Args, " "))
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if strings.Contains(string(out), "No key available") {
+			return ErrInvalidPassphrase
+		}
+		return fmt.Errorf("cryptsetup open failed: %s: %v", string(out), err)
+	}
+	return nil
+}
@@ -18,6 +18,8 @@ import (
 
 	"github.com/pkg/errors"
 	"github.com/sylabs/sif/pkg/sif"
+	"github.com/sylabs/singularity/pkg/image"
+	"golang.org/x/crypto/openpgp"
 )
 
 var (
@@ -25,20 +27,30 @@ var (
 	ErrUnsupportedKeyURI    = errors.New("unsupported key URI")
 	ErrNoEncryptedKeyData   = errors.New("no encrypted key data")
 	ErrNoPEMData            = errors.New("No PEM data")
+	ErrKeyMismatch          = errors.New("key doesn't match the encrypted key of the image")
+	ErrKeyFormatMismatch    = errors.New("key format doesn't match the encrypted key of the image")
 )
 
 const (
 	Unknown = iota
 	Passphrase
 	PEM
+	PGP
 )
 
 // KeyInfo contains information for passing around
 // or extracting a passphrase for an encrypted container
 type KeyInfo struct {
-	Format   int
+	Format int
+	// Material holds the passphrase, or the PEM encoded RSA key when
+	// Path is empty.
 	Material string
 	Path     string
+	// Keys holds the public keys of the recipients when encrypting with
+	// the PGP format, and the private keys tried when decrypting.
+	Keys openpgp.EntityList `json:"-"`
+	// Prompt is called to decrypt the private keys of Keys.
+	Prompt openpgp.PromptFunction `json:"-"`
 }
 
 func getRandomBytes(size int) ([]byte, error) {
@@ -52,7 +64,7 @@ func getRandomBytes(size int) ([]byte, error) {
 
 func NewPlaintextKey(k KeyInfo) ([]byte, error) {
 	switch k.Format {
-	case PEM:
+	case PEM, PGP:
 		// in this case we will generate a random secret and
 		// encrypt it using the PEM key.use the PEM key to
 		// encrypt a secret
@@ -70,7 +82,7 @@ func NewPlaintextKey(k KeyInfo) ([]byte, error) {
 func EncryptKey(k KeyInfo, plaintext []byte) ([]byte, error) {
 	switch k.Format {
 	case PEM:
-		pubKey, err := loadPEMPublicKey(k.Path)
+		pubKey, err := loadPEMPublicKey(k)
 		if err != nil {
 			return nil, errors.Wrap(err, "loading public key for key encryption")
 		}
@@ -88,6 +100,9 @@ func EncryptKey(k KeyInfo, plaintext []byte) ([]byte, error) {
 
 		return buf.Bytes(), nil
 
+	case PGP:
+		return encryptPGPKey(k.Keys, plaintext)
+
 	case Passphrase:
 		return nil, nil
 
@@ -96,15 +111,27 @@ func EncryptKey(k KeyInfo, plaintext []byte) ([]byte, error) {
 	}
 }
 
+// PlaintextKey returns the filesystem key of the encrypted root filesystem
+// of the SIF image unwrapped with k.
 func PlaintextKey(k KeyInfo, image string) ([]byte, error) {
+	return plaintextKey(k, image, primaryPartition)
+}
+
+// PlaintextOverlayKey returns the filesystem key of the encrypted overlay
+// partition of the SIF image unwrapped with k.
+func PlaintextOverlayKey(k KeyInfo, image string) ([]byte, error) {
+	return plaintextKey(k, image, overlayPartition)
+}
+
+func plaintextKey(k KeyInfo, image string, find partitionFinder) ([]byte, error) {
 	switch k.Format {
 	case PEM:
-		privateKey, err := loadPEMPrivateKey(k.Path)
+		privateKey, err := loadPEMPrivateKey(k)
 		if err != nil {
 			return nil, errors.Wrap(err, "loading private key for key decryption")
 		}
 
-		pemKey, err := getEncryptionKeyFromImage(image)
+		pemKey, err := getCryptoMessageFromImage(image, find, PEM)
 		if err != nil {
 			return nil, errors.Wrapf(err, "loading encrypted key SIF image %s", image)
 		}
@@ -117,6 +144,19 @@ func PlaintextKey(k KeyInfo, image string) ([]byte, error) {
 		}
 
 		plaintext, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, privateKey, encKey, nil)
+		if err != nil {
+			return nil, errors.Wrapf(ErrKeyMismatch, "decrypting key from image %s", image)
+		}
+
+		return plaintext, nil
+
+	case PGP:
+		msg, err := getCryptoMessageFromImage(image, find, PGP)
+		if err != nil {
+			return nil, errors.Wrapf(err, "loading encrypted key SIF image %s", image)
+		}
+
+		plaintext, err := decryptPGPKey(msg, k.Keys, k.Prompt)
 		if err != nil {
 			return nil, errors.Wrapf(err, "decrypting key from image %s", image)
 		}
@@ -124,36 +164,63 @@ func PlaintextKey(k KeyInfo, image string) ([]byte, error) {
 		return plaintext, nil
 
 	case Passphrase:
-		return []byte(k.Material), nil
+		// the passphrase is the filesystem key, unless the image was
+		// rekeyed with a passphrase wrapping the filesystem key
+		msg, err := getCryptoMessageFromImage(image, find, Passphrase)
+		if errors.Cause(err) == ErrEncryptedKeyNotFound {
+			return []byte(k.Material), nil
+		} else if err != nil {
+			return nil, errors.Wrapf(err, "loading encrypted key SIF image %s", image)
+		}
+
+		plaintext, err := decryptPassphraseKey(msg, k.Material)
+		if err != nil {
+			return nil, errors.Wrapf(err, "decrypting key from image %s", image)
+		}
+
+		return plaintext, nil
 
 	default:
 		return nil, ErrUnsupportedKeyURI
 	}
 }
 
-func loadPEMPrivateKey(fn string) (*rsa.PrivateKey, error) {
-	b, err := ioutil.ReadFile(fn)
-	if err != nil {
-		return nil, err
+// loadPEMBlock returns the PEM block of the key file k.Path, or of
+// k.Material if no path is set.
+func loadPEMBlock(k KeyInfo) (*pem.Block, error) {
+	b := []byte(k.Material)
+	source := "key material"
+
+	if k.Path != "" {
+		var err error
+
+		b, err = ioutil.ReadFile(k.Path)
+		if err != nil {
+			return nil, err
+		}
+		source = k.Path
 	}
 
 	block, _ := pem.Decode(b)
 	if block == nil {
-		return nil, errors.Wrapf(ErrNoPEMData, "reading %s", fn)
+		return nil, errors.Wrapf(ErrNoPEMData, "reading %s", source)
 	}
-
-	return x509.ParsePKCS1PrivateKey(block.Bytes)
+	return block, nil
 }
 
-func loadPEMPublicKey(fn string) (*rsa.PublicKey, error) {
-	b, err := ioutil.ReadFile(fn)
+func loadPEMPrivateKey(k KeyInfo) (*rsa.PrivateKey, error) {
+	block, err := loadPEMBlock(k)
 	if err != nil {
 		return nil, err
 	}
 
-	block, _ := pem.Decode(b)
-	if block == nil {
-		return nil, errors.Wrapf(ErrNoPEMData, "reading %s", fn)
+	return x509.ParsePKCS1PrivateKey(block.Bytes)
+}
+
+func loadPEMPublicKey(k KeyInfo) (*rsa.PublicKey, error) {
+	block, err := loadPEMBlock(k)
+	if err != nil {
+		return nil, err
 	}
 
 	return x509.ParsePKCS1PublicKey(block.Bytes)
@@ -192,52 +259,238 @@ func savePEMMessage(w io.Writer, msg []byte) error {
 	return pem.Encode(w, b)
 }
 
-func getEncryptionKeyFromImage(fn string) ([]byte, error) {
+// HasPGPKey returns whether the filesystem key of the SIF image fn is
+// encrypted for OpenPGP recipients.
+func HasPGPKey(fn string) (bool, error) {
 	img, err := sif.LoadContainer(fn, true)
 	if err != nil {
-		return nil, errors.Wrapf(err, "loading container image from %s", fn)
+		return false, errors.Wrapf(err, "loading container image from %s", fn)
 	}
 	defer img.UnloadContainer()
 
+	format, _, err := findCryptoMessage(&img, primaryPartition)
+	if err != nil {
+		return false, errors.Wrapf(err, "reading from %s", fn)
+	}
+	return format == PGP, nil
+}
+
+// keyFormatNames maps the key formats to their name in the encryption info
+// of the partitions. Passphrase only names the passphrase wrapping the
+// filesystem key of a rekeyed image, the encryption info of partitions
+// using the passphrase as filesystem key has no key format.
+var keyFormatNames = map[int]string{
+	PEM:        "pem",
+	PGP:        "pgp",
+	Passphrase: "passphrase",
+}
+
+// keyFormat returns the key format named name in the encryption info.
+func keyFormat(name string) int {
+	for format, n := range keyFormatNames {
+		if n == name {
+			return format
+		}
+	}
+	return Unknown
+}
+
+// messageFormat returns the SIF format of the cryptographic message holding
+// a filesystem key wrapped with the key format. SIF only defines the
+// RSA-OAEP message type for wrapped keys, it is used by every key message
+// and the encryption info of the partition tells the OpenPGP ones apart.
+func messageFormat(format int) sif.Formattype {
+	if format == PEM {
+		return sif.FormatPEM
+	}
+	return sif.FormatOpenPGP
+}
+
+// KeyInputs returns the descriptor inputs stored along the partition partID
+// holding filesystem encrypted with a key created by NewPlaintextKey for k:
+// the cryptographic message holding data, the key wrapped by EncryptKey, and
+// the encryption info of the partition.
+func KeyInputs(k KeyInfo, filesystem string, data []byte, groupID, partID uint32) ([]sif.DescriptorInput, error) {
+	var inputs []sif.DescriptorInput
+
+	info := image.EncryptionInfo{Filesystem: filesystem}
+
+	if data != nil {
+		name, ok := keyFormatNames[k.Format]
+		if !ok {
+			return nil, ErrUnsupportedKeyURI
+		}
+		info.Key = name
+
+		input := sif.DescriptorInput{
+			Datatype: sif.DataCryptoMessage,
+			Groupid:  groupID,
+			Link:     partID,
+			Data:     data,
+			Size:     int64(len(data)),
+		}
+		if err := input.SetCryptoMsgExtra(messageFormat(k.Format), sif.MessageRSAOAEP); err != nil {
+			return nil, err
+		}
+		inputs = append(inputs, input)
+	}
+
+	input, err := image.EncryptionInfoInput(info, groupID, partID)
+	if err != nil {
+		return nil, err
+	}
+	return append(inputs, input), nil
+}
+
+// partitionFinder returns the encrypted partition of a SIF image whose
+// filesystem key is wrapped in the linked cryptographic messages.
+type partitionFinder func(img *sif.FileImage) (*sif.Descriptor, error)
+
+// primaryPartition returns the primary system partition of img.
+func primaryPartition(img *sif.FileImage) (*sif.Descriptor, error) {
 	primDescr, _, err := img.GetPartPrimSys()
 	if err != nil {
-		return nil, errors.Wrapf(err, "retrieving primary system partition from %s", fn)
+		return nil, errors.Wrapf(err, "retrieving primary system partition")
 	}
+	return primDescr, nil
+}
 
-	descr, _, err := img.GetLinkedDescrsByType(primDescr.ID, sif.DataCryptoMessage)
+// findCryptoMessage returns the key format wrapping the filesystem key of
+// the partition returned by find and the cryptographic message holding it.
+// Unknown and no message are returned when the passphrase is the filesystem
+// key. Images built without encryption info only wrap PEM keys.
+func findCryptoMessage(img *sif.FileImage, find partitionFinder) (int, *sif.Descriptor, error) {
+	part, err := find(img)
 	if err != nil {
-		return nil, errors.Wrapf(err, "retrieving linked descriptors for primary system partition from %s", fn)
+		return Unknown, nil, err
+	}
+
+	format := PEM
+	info, _, err := image.GetEncryptionInfo(img, part)
+	if err == nil {
+		if info.Key == "" {
+			return Unknown, nil, nil
+		}
+		if format = keyFormat(info.Key); format == Unknown {
+			return Unknown, nil, errors.Errorf("unknown key format %q", info.Key)
+		}
+	} else if err != image.ErrNoEncryptionInfo {
+		return Unknown, nil, err
+	}
+
+	descr, _, err := img.GetLinkedDescrsByType(part.ID, sif.DataCryptoMessage)
+	if err != nil && err != sif.ErrNotFound {
+		return Unknown, nil, errors.Wrapf(err, "retrieving linked descriptors for encrypted partition")
 	}
 
 	for _, d := range descr {
-		format, err := d.GetFormatType()
+		f, err := d.GetFormatType()
 		if err != nil {
-			return nil, errors.Wrapf(err, "while retrieving cryptographic message format")
+			return Unknown, nil, errors.Wrapf(err, "while retrieving cryptographic message format")
 		}
 
-		message, err := d.GetMessageType()
+		m, err := d.GetMessageType()
 		if err != nil {
-			return nil, errors.Wrapf(err, "while retrieving cryptographic message type")
+			return Unknown, nil, errors.Wrapf(err, "while retrieving cryptographic message type")
 		}
 
-		// currently only support one type of message
-		if format != sif.FormatPEM || message != sif.MessageRSAOAEP {
+		if f != messageFormat(format) || m != sif.MessageRSAOAEP {
 			continue
 		}
 
 		// TODO(ian): For now, assume the first linked message is what we
 		// are looking for. We should consider what we want to do in the
 		// case of multiple linked messages
-		data := d.GetData(&img)
-		if data == nil {
-			return nil, errors.Wrapf(ErrNoEncryptedKeyData, "retrieving encrypted key data from %s", fn)
-		}
+		return format, d, nil
+	}
 
-		key := make([]byte, len(data))
-		copy(key, data)
+	if info == nil {
+		return Unknown, nil, nil
+	}
+	return Unknown, nil, errors.Wrapf(ErrNoEncryptedKeyData, "%s key message not found", info.Key)
+}
 
-		return key, nil
+// getCryptoMessageFromImage returns the filesystem key of the partition
+// returned by find wrapped with the key format. ErrEncryptedKeyNotFound is
+// returned when the passphrase is the filesystem key, ErrKeyFormatMismatch
+// when the key is wrapped with another format.
+func getCryptoMessageFromImage(fn string, find partitionFinder, format int) ([]byte, error) {
+	img, err := sif.LoadContainer(fn, true)
+	if err != nil {
+		return nil, errors.Wrapf(err, "loading container image from %s", fn)
 	}
+	defer img.UnloadContainer()
 
-	return nil, errors.Wrapf(ErrEncryptedKeyNotFound, "reading from %s", fn)
+	found, d, err := findCryptoMessage(&img, find)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading from %s", fn)
+	} else if found == Unknown {
+		return nil, errors.Wrapf(ErrEncryptedKeyNotFound, "reading from %s", fn)
+	} else if found != format {
+		return nil, errors.Wrapf(ErrKeyFormatMismatch, "reading from %s", fn)
+	}
+
+	data := d.GetData(&img)
+	if data == nil {
+		return nil, errors.Wrapf(ErrNoEncryptedKeyData, "retrieving encrypted key data from %s", fn)
+	}
+
+	key := make([]byte, len(data))
+	copy(key, data)
+
+	return key, nil
+}
+
+// replaceCryptoMessage replaces the wrapped filesystem key of the partition
+// returned by find by data, the key wrapped with the key format, and updates
+// the encryption info of the partition accordingly. The new objects are
+// added before the previous ones are zeroed and removed.
+func replaceCryptoMessage(fn string, find partitionFinder, format int, data []byte) error {
+	img, err := sif.LoadContainer(fn, false)
+	if err != nil {
+		return errors.Wrapf(err, "loading container image from %s", fn)
+	}
+	defer img.UnloadContainer()
+
+	part, err := find(&img)
+	if err != nil {
+		return errors.Wrapf(err, "reading from %s", fn)
+	}
+	partID, groupID := part.ID, part.Groupid
+
+	var oldIDs []uint32
+
+	_, old, err := findCryptoMessage(&img, find)
+	if err != nil {
+		return errors.Wrapf(err, "reading from %s", fn)
+	} else if old != nil {
+		oldIDs = append(oldIDs, old.ID)
+	}
+
+	info, oldInfo, err := image.GetEncryptionInfo(&img, part)
+	if err == image.ErrNoEncryptionInfo {
+		// only encrypted squashfs partitions were built without it
+		info = &image.EncryptionInfo{Filesystem: image.EncryptedSquashfs}
+	} else if err != nil {
+		return errors.Wrapf(err, "reading from %s", fn)
+	} else {
+		oldIDs = append(oldIDs, oldInfo.ID)
+	}
+
+	inputs, err := KeyInputs(KeyInfo{Format: format}, info.Filesystem, data, groupID, partID)
+	if err != nil {
+		return err
+	}
+
+	for _, input := range inputs {
+		if err := img.AddObject(input); err != nil {
+			return errors.Wrap(err, "adding wrapped key")
+		}
+	}
+	for _, id := range oldIDs {
+		if err := img.DeleteObject(id, sif.DelZero); err != nil {
+			return errors.Wrap(err, "removing previous wrapped key")
+		}
+	}
+	return nil
 }
@@ -123,7 +123,7 @@ func TestPlaintextKey(t *testing.T) {
 		{
 			name:          "passphrase",
 			keyInfo:       KeyInfo{Format: Passphrase, Material: testPassphrase},
-			expectedError: nil,
+			expectedError: fmt.Errorf("loading encrypted key SIF image : loading container image from : opening(RDONLY) container file: open : no such file or directory"),
 		},
 		{
 			name:          "invalid pem",
@@ -0,0 +1,36 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package crypt
+
+import (
+	"github.com/pkg/errors"
+	"github.com/sylabs/sif/pkg/sif"
+	"github.com/sylabs/singularity/pkg/image"
+)
+
+// ErrNoEncryptedOverlay is returned when a SIF image doesn't contain an
+// encrypted overlay partition.
+var ErrNoEncryptedOverlay = errors.New("no encrypted overlay partition found")
+
+// overlayPartition returns the first encrypted overlay partition of img.
+func overlayPartition(img *sif.FileImage) (*sif.Descriptor, error) {
+	for i, d := range img.DescrArr {
+		if !d.Used || d.Datatype != sif.DataPartition {
+			continue
+		}
+		if ptype, err := d.GetPartType(); err != nil || ptype != sif.PartOverlay {
+			continue
+		}
+		if fstype, err := d.GetFsType(); err != nil || fstype != sif.FsRaw {
+			continue
+		}
+		if info, _, err := image.GetEncryptionInfo(img, &img.DescrArr[i]); err != nil || info.Filesystem != image.EncryptedExt3 {
+			continue
+		}
+		return &img.DescrArr[i], nil
+	}
+	return nil, ErrNoEncryptedOverlay
+}
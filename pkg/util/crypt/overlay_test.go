@@ -0,0 +1,230 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package crypt
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/pkg/errors"
+	uuid "github.com/satori/go.uuid"
+	"github.com/sylabs/sif/pkg/sif"
+	"github.com/sylabs/singularity/internal/pkg/test"
+	"github.com/sylabs/singularity/pkg/image"
+)
+
+func TestPlaintextOverlayKey(t *testing.T) {
+	test.DropPrivilege(t)
+	defer test.ResetPrivilege(t)
+
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory")
+	}
+	defer os.RemoveAll(dir)
+
+	rootPriv, rootPub := writePEMKeys(t, dir, "root")
+	overlayPriv, overlayPub := writePEMKeys(t, dir, "overlay")
+
+	keys := make(map[string][]byte)
+	messages := make(map[string][]byte)
+	for name, pub := range map[string]KeyInfo{"root": rootPub, "overlay": overlayPub} {
+		plaintext, err := NewPlaintextKey(pub)
+		if err != nil {
+			t.Fatalf("failed to generate key: %s", err)
+		}
+		data, err := EncryptKey(pub, plaintext)
+		if err != nil {
+			t.Fatalf("failed to encrypt key: %s", err)
+		}
+		keys[name] = plaintext
+		messages[name] = data
+	}
+
+	partition := func(fstype sif.Fstype, ptype sif.Parttype) sif.DescriptorInput {
+		part := sif.DescriptorInput{
+			Datatype: sif.DataPartition,
+			Groupid:  sif.DescrDefaultGroup,
+			Link:     sif.DescrUnusedLink,
+			Data:     []byte("encrypted partition"),
+		}
+		part.Size = int64(len(part.Data))
+		if err := part.SetPartExtra(fstype, ptype, sif.GetSIFArch(runtime.GOARCH)); err != nil {
+			t.Fatalf("failed to set partition extra data: %s", err)
+		}
+		return part
+	}
+	message := func(data []byte, link uint32) sif.DescriptorInput {
+		msg := sif.DescriptorInput{
+			Datatype: sif.DataCryptoMessage,
+			Groupid:  sif.DescrDefaultGroup,
+			Link:     link,
+			Data:     data,
+			Size:     int64(len(data)),
+		}
+		if err := msg.SetCryptoMsgExtra(sif.FormatPEM, sif.MessageRSAOAEP); err != nil {
+			t.Fatalf("failed to set message extra data: %s", err)
+		}
+		return msg
+	}
+	info := func(key string, link uint32) sif.DescriptorInput {
+		input, err := image.EncryptionInfoInput(image.EncryptionInfo{Filesystem: image.EncryptedExt3, Key: key}, sif.DescrDefaultGroup, link)
+		if err != nil {
+			t.Fatalf("failed to create encryption info: %s", err)
+		}
+		return input
+	}
+	create := func(path string, inputs ...sif.DescriptorInput) {
+		fimg, err := sif.CreateContainer(sif.CreateInfo{
+			Pathname:   path,
+			Launchstr:  sif.HdrLaunch,
+			Sifversion: sif.HdrVersion,
+			ID:         uuid.NewV4(),
+			InputDescr: inputs,
+		})
+		if err != nil {
+			t.Fatalf("failed to create SIF image: %s", err)
+		}
+		fimg.UnloadContainer()
+	}
+
+	// encrypted container built without encryption info with an
+	// embedded encrypted overlay
+	embedded := filepath.Join(dir, "embedded.sif")
+	create(embedded,
+		partition(sif.FsEncryptedSquashfs, sif.PartPrimSys),
+		message(messages["root"], 1),
+		partition(sif.FsRaw, sif.PartOverlay),
+		message(messages["overlay"], 3),
+		info("pem", 3),
+	)
+
+	// overlay image
+	overlay := filepath.Join(dir, "overlay.sif")
+	create(overlay,
+		partition(sif.FsRaw, sif.PartOverlay),
+		message(messages["overlay"], 1),
+		info("pem", 1),
+	)
+
+	// overlay image using the passphrase as filesystem key
+	passphrase := filepath.Join(dir, "passphrase.sif")
+	create(passphrase,
+		partition(sif.FsRaw, sif.PartOverlay),
+		info("", 1),
+	)
+
+	// raw overlay partition which isn't encrypted
+	raw := filepath.Join(dir, "raw.sif")
+	create(raw,
+		partition(sif.FsRaw, sif.PartOverlay),
+		message(messages["overlay"], 1),
+	)
+
+	// encrypted container without overlay
+	container := filepath.Join(dir, "container.sif")
+	create(container,
+		partition(sif.FsEncryptedSquashfs, sif.PartPrimSys),
+		message(messages["root"], 1),
+	)
+
+	tests := []struct {
+		name    string
+		image   string
+		key     KeyInfo
+		overlay bool
+		want    []byte
+		wantErr error
+	}{
+		{
+			name:  "embedded root filesystem key",
+			image: embedded,
+			key:   rootPriv,
+			want:  keys["root"],
+		},
+		{
+			name:    "embedded overlay key",
+			image:   embedded,
+			key:     overlayPriv,
+			overlay: true,
+			want:    keys["overlay"],
+		},
+		{
+			name:    "embedded overlay wrong key",
+			image:   embedded,
+			key:     rootPriv,
+			overlay: true,
+			wantErr: ErrKeyMismatch,
+		},
+		{
+			name:    "overlay image key",
+			image:   overlay,
+			key:     overlayPriv,
+			overlay: true,
+			want:    keys["overlay"],
+		},
+		{
+			name:    "overlay image passphrase",
+			image:   passphrase,
+			key:     KeyInfo{Format: Passphrase, Material: "passphrase"},
+			overlay: true,
+			want:    []byte("passphrase"),
+		},
+		{
+			name:    "overlay image passphrase instead of PEM key",
+			image:   overlay,
+			key:     KeyInfo{Format: Passphrase, Material: "passphrase"},
+			overlay: true,
+			wantErr: ErrKeyFormatMismatch,
+		},
+		{
+			name:    "overlay image PEM key instead of passphrase",
+			image:   passphrase,
+			key:     overlayPriv,
+			overlay: true,
+			wantErr: ErrEncryptedKeyNotFound,
+		},
+		{
+			name:    "raw overlay without encryption info",
+			image:   raw,
+			key:     overlayPriv,
+			overlay: true,
+			wantErr: ErrNoEncryptedOverlay,
+		},
+		{
+			name:    "no overlay",
+			image:   container,
+			key:     overlayPriv,
+			overlay: true,
+			wantErr: ErrNoEncryptedOverlay,
+		},
+	}
+
+	for _, tt := range tests {
+		var key []byte
+		var err error
+
+		if tt.overlay {
+			key, err = PlaintextOverlayKey(tt.key, tt.image)
+		} else {
+			key, err = PlaintextKey(tt.key, tt.image)
+		}
+
+		if tt.wantErr != nil {
+			if errors.Cause(err) != tt.wantErr {
+				t.Errorf("%s: unexpected error %v, expected %s", tt.name, err, tt.wantErr)
+			}
+		} else if err != nil {
+			t.Errorf("%s: failed to decrypt key: %s", tt.name, err)
+		} else if !bytes.Equal(key, tt.want) {
+			t.Errorf("%s: decrypted key doesn't match", tt.name)
+		}
+	}
+}
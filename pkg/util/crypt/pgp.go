@@ -0,0 +1,324 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package crypt
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/openpgp"
+	pgperrors "golang.org/x/crypto/openpgp/errors"
+	"golang.org/x/crypto/openpgp/packet"
+	// openpgp.Encrypt requires a hash function shared by the recipients
+	// even without signing, RIPEMD-160 is assumed for keys without
+	// preferences
+	_ "golang.org/x/crypto/ripemd160"
+)
+
+var (
+	ErrNoRecipients        = errors.New("no recipients")
+	ErrNoEncryptionKey     = errors.New("no usable RSA encryption key")
+	ErrNoMatchingKey       = errors.New("no private key matching a recipient of the image")
+	ErrRecipientNotFound   = errors.New("not a recipient of the image")
+	ErrLastRecipient       = errors.New("cannot remove the last recipient of the image")
+	ErrMalformedPGPMessage = errors.New("malformed OpenPGP message")
+)
+
+// pgpKeyMessage is a parsed OpenPGP message holding an encrypted
+// filesystem key. The message is made of a public key encrypted session
+// key packet per recipient, followed by the data packet encrypted with the
+// session key, which is never decoded.
+type pgpKeyMessage struct {
+	keys []*packet.EncryptedKey
+	raw  [][]byte
+	data []byte
+}
+
+// encryptionKey returns the public key used to encrypt a message for e, the
+// newest valid subkey flagged for encryption is preferred to the primary
+// key. Only the RSA keys are supported by the key wrapping.
+func encryptionKey(e *openpgp.Entity, now time.Time) (*packet.PublicKey, error) {
+	var key *packet.PublicKey
+	var created time.Time
+
+	for _, subkey := range e.Subkeys {
+		sig := subkey.Sig
+		if !sig.FlagsValid || !(sig.FlagEncryptCommunications || sig.FlagEncryptStorage) || sig.KeyExpired(now) {
+			continue
+		}
+		if key == nil || sig.CreationTime.After(created) {
+			key = subkey.PublicKey
+			created = sig.CreationTime
+		}
+	}
+
+	if key == nil {
+		for _, id := range e.Identities {
+			sig := id.SelfSignature
+			if sig == nil || sig.KeyExpired(now) {
+				continue
+			}
+			if !sig.FlagsValid || sig.FlagEncryptCommunications || sig.FlagEncryptStorage {
+				key = e.PrimaryKey
+				break
+			}
+		}
+	}
+
+	if key == nil || key.PubKeyAlgo != packet.PubKeyAlgoRSA {
+		return nil, errors.Wrapf(ErrNoEncryptionKey, "key %X", e.PrimaryKey.Fingerprint)
+	}
+	return key, nil
+}
+
+// encryptPGPKey returns an OpenPGP message holding plaintext encrypted
+// for each of the recipients.
+func encryptPGPKey(recipients openpgp.EntityList, plaintext []byte) ([]byte, error) {
+	if len(recipients) == 0 {
+		return nil, ErrNoRecipients
+	}
+
+	// openpgp.Encrypt would also accept the ElGamal keys, check them
+	// first so that every recipient can be rewrapped later on
+	for _, e := range recipients {
+		if _, err := encryptionKey(e, time.Now()); err != nil {
+			return nil, err
+		}
+	}
+
+	var buf bytes.Buffer
+
+	w, err := openpgp.Encrypt(&buf, recipients, nil, nil, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "encrypting key")
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		return nil, errors.Wrap(err, "encrypting key")
+	}
+	if err := w.Close(); err != nil {
+		return nil, errors.Wrap(err, "encrypting key")
+	}
+
+	return buf.Bytes(), nil
+}
+
+// decryptPGPKey returns the key held by the OpenPGP message msg decrypted
+// with one of the private keys of keys, prompt is called to decrypt them.
+func decryptPGPKey(msg []byte, keys openpgp.EntityList, prompt openpgp.PromptFunction) ([]byte, error) {
+	md, err := openpgp.ReadMessage(bytes.NewReader(msg), keys, prompt, nil)
+	if err == pgperrors.ErrKeyIncorrect {
+		return nil, ErrNoMatchingKey
+	} else if err != nil {
+		return nil, errors.Wrap(err, "reading OpenPGP message")
+	}
+
+	plaintext, err := ioutil.ReadAll(md.UnverifiedBody)
+	if err != nil {
+		return nil, errors.Wrap(err, "decrypting key")
+	}
+	return plaintext, nil
+}
+
+// parsePGPKeyMessage splits the OpenPGP message msg into its session key
+// packets and the raw encrypted data packet.
+func parsePGPKeyMessage(msg []byte) (*pgpKeyMessage, error) {
+	m := &pgpKeyMessage{}
+	r := bytes.NewReader(msg)
+
+	for {
+		offset := len(msg) - r.Len()
+
+		p, err := packet.Read(r)
+		if err == io.EOF {
+			return nil, errors.Wrap(ErrMalformedPGPMessage, "missing encrypted data")
+		} else if err != nil {
+			return nil, errors.Wrap(err, "parsing OpenPGP message")
+		}
+
+		switch p := p.(type) {
+		case *packet.EncryptedKey:
+			m.keys = append(m.keys, p)
+			m.raw = append(m.raw, msg[offset:len(msg)-r.Len()])
+		case *packet.SymmetricallyEncrypted:
+			// the data packet ends the message, only its header was read
+			m.data = msg[offset:]
+			return m, nil
+		default:
+			return nil, errors.Wrapf(ErrMalformedPGPMessage, "unexpected packet %T", p)
+		}
+	}
+}
+
+// bytes returns the serialized message.
+func (m *pgpKeyMessage) bytes() []byte {
+	var buf bytes.Buffer
+	for _, raw := range m.raw {
+		buf.Write(raw)
+	}
+	buf.Write(m.data)
+	return buf.Bytes()
+}
+
+// hasRecipient returns whether the session key is encrypted with the key
+// matching id.
+func (m *pgpKeyMessage) hasRecipient(id uint64) bool {
+	for _, k := range m.keys {
+		if k.KeyId == id {
+			return true
+		}
+	}
+	return false
+}
+
+// sessionKey decrypts the session key of the message with one of the
+// private keys of keys, prompt is called with the matching keys which are
+// encrypted until one of them is decrypted, as done by openpgp.ReadMessage.
+func (m *pgpKeyMessage) sessionKey(keys openpgp.EntityList, prompt openpgp.PromptFunction) (*packet.EncryptedKey, error) {
+	var candidates []openpgp.Key
+
+	for _, ek := range m.keys {
+		for _, k := range keys.KeysById(ek.KeyId) {
+			if k.PrivateKey == nil {
+				continue
+			}
+			if !k.PrivateKey.Encrypted {
+				if err := ek.Decrypt(k.PrivateKey, nil); err == nil {
+					return ek, nil
+				}
+				continue
+			}
+			candidates = append(candidates, k)
+		}
+	}
+
+	for len(candidates) > 0 {
+		if prompt == nil {
+			return nil, errors.Wrap(ErrNoMatchingKey, "private keys are encrypted")
+		}
+		if _, err := prompt(candidates, false); err != nil {
+			return nil, err
+		}
+
+		var encrypted []openpgp.Key
+		for _, k := range candidates {
+			if k.PrivateKey.Encrypted {
+				encrypted = append(encrypted, k)
+				continue
+			}
+			for _, ek := range m.keys {
+				if ek.KeyId != k.PublicKey.KeyId {
+					continue
+				}
+				if err := ek.Decrypt(k.PrivateKey, nil); err == nil {
+					return ek, nil
+				}
+			}
+		}
+		if len(encrypted) == len(candidates) {
+			return nil, errors.Wrap(ErrNoMatchingKey, "private keys were not decrypted")
+		}
+		candidates = encrypted
+	}
+
+	return nil, ErrNoMatchingKey
+}
+
+// AddPGPRecipients wraps the filesystem key of the encrypted SIF image for
+// recipients, without re-encrypting the filesystem. The key is unwrapped with
+// one of the private keys of keys, prompt is called to decrypt them. It
+// returns the number of recipients added, the existing recipients are skipped.
+func AddPGPRecipients(image string, keys openpgp.EntityList, prompt openpgp.PromptFunction, recipients openpgp.EntityList) (int, error) {
+	if len(recipients) == 0 {
+		return 0, ErrNoRecipients
+	}
+
+	data, err := getCryptoMessageFromImage(image, primaryPartition, PGP)
+	if err != nil {
+		return 0, errors.Wrapf(err, "loading encrypted key from SIF image %s", image)
+	}
+
+	m, err := parsePGPKeyMessage(data)
+	if err != nil {
+		return 0, errors.Wrapf(err, "unpacking OpenPGP message from SIF image %s", image)
+	}
+
+	var added []*packet.PublicKey
+	for _, e := range recipients {
+		pub, err := encryptionKey(e, time.Now())
+		if err != nil {
+			return 0, err
+		}
+		if !m.hasRecipient(pub.KeyId) {
+			added = append(added, pub)
+		}
+	}
+	if len(added) == 0 {
+		return 0, nil
+	}
+
+	ek, err := m.sessionKey(keys, prompt)
+	if err != nil {
+		return 0, errors.Wrapf(err, "decrypting key from image %s", image)
+	}
+
+	for _, pub := range added {
+		var buf bytes.Buffer
+		if err := packet.SerializeEncryptedKey(&buf, pub, ek.CipherFunc, ek.Key, nil); err != nil {
+			return 0, errors.Wrapf(err, "encrypting key for %X", pub.Fingerprint)
+		}
+		m.raw = append(m.raw, buf.Bytes())
+		m.keys = append(m.keys, &packet.EncryptedKey{KeyId: pub.KeyId, Algo: pub.PubKeyAlgo})
+	}
+
+	if err := replaceCryptoMessage(image, primaryPartition, PGP, m.bytes()); err != nil {
+		return 0, errors.Wrapf(err, "storing encrypted key in SIF image %s", image)
+	}
+	return len(added), nil
+}
+
+// RemovePGPRecipients removes the filesystem key of the encrypted SIF image
+// wrapped for recipients. No private key is needed, but a recipient who
+// already obtained the filesystem key can still decrypt the image. It
+// returns the number of wrapped keys removed.
+func RemovePGPRecipients(image string, recipients openpgp.EntityList) (int, error) {
+	if len(recipients) == 0 {
+		return 0, ErrNoRecipients
+	}
+
+	data, err := getCryptoMessageFromImage(image, primaryPartition, PGP)
+	if err != nil {
+		return 0, errors.Wrapf(err, "loading encrypted key from SIF image %s", image)
+	}
+
+	m, err := parsePGPKeyMessage(data)
+	if err != nil {
+		return 0, errors.Wrapf(err, "unpacking OpenPGP message from SIF image %s", image)
+	}
+
+	kept := &pgpKeyMessage{data: m.data}
+	for i, ek := range m.keys {
+		if len(recipients.KeysById(ek.KeyId)) > 0 {
+			continue
+		}
+		kept.keys = append(kept.keys, ek)
+		kept.raw = append(kept.raw, m.raw[i])
+	}
+
+	removed := len(m.keys) - len(kept.keys)
+	if removed == 0 {
+		return 0, ErrRecipientNotFound
+	} else if len(kept.keys) == 0 {
+		return 0, ErrLastRecipient
+	}
+
+	if err := replaceCryptoMessage(image, primaryPartition, PGP, kept.bytes()); err != nil {
+		return 0, errors.Wrapf(err, "storing encrypted key in SIF image %s", image)
+	}
+	return removed, nil
+}
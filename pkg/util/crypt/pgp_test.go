@@ -0,0 +1,208 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package crypt
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	pkgerrors "github.com/pkg/errors"
+	uuid "github.com/satori/go.uuid"
+	"github.com/sylabs/sif/pkg/sif"
+	"github.com/sylabs/singularity/internal/pkg/test"
+	"github.com/sylabs/singularity/pkg/image"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+func newTestEntity(t *testing.T, name string) *openpgp.Entity {
+	e, err := openpgp.NewEntity(name, "", name+"@my.info", &packet.Config{RSABits: 1024})
+	if err != nil {
+		t.Fatalf("failed to generate key pair: %s", err)
+	}
+	return e
+}
+
+// createEncryptedSIF creates a SIF image with a fake encrypted primary
+// partition, its encryption info and its key wrapped with the key format in
+// a cryptographic message, no message is stored when data is nil.
+func createEncryptedSIF(t *testing.T, path string, format int, data []byte) {
+	createEncryptedPartSIF(t, path, []byte("encrypted squashfs"), format, data)
+}
+
+// createEncryptedPartSIF creates a SIF image like createEncryptedSIF with
+// partData as encrypted partition.
+func createEncryptedPartSIF(t *testing.T, path string, partData []byte, format int, data []byte) {
+	part := sif.DescriptorInput{
+		Datatype: sif.DataPartition,
+		Groupid:  sif.DescrDefaultGroup,
+		Link:     sif.DescrUnusedLink,
+		Data:     partData,
+	}
+	part.Size = int64(len(part.Data))
+	if err := part.SetPartExtra(sif.FsEncryptedSquashfs, sif.PartPrimSys, sif.GetSIFArch(runtime.GOARCH)); err != nil {
+		t.Fatalf("failed to set partition extra data: %s", err)
+	}
+
+	inputs, err := KeyInputs(KeyInfo{Format: format}, image.EncryptedSquashfs, data, sif.DescrDefaultGroup, 1)
+	if err != nil {
+		t.Fatalf("failed to create key inputs: %s", err)
+	}
+
+	fimg, err := sif.CreateContainer(sif.CreateInfo{
+		Pathname:   path,
+		Launchstr:  sif.HdrLaunch,
+		Sifversion: sif.HdrVersion,
+		ID:         uuid.NewV4(),
+		InputDescr: append([]sif.DescriptorInput{part}, inputs...),
+	})
+	if err != nil {
+		t.Fatalf("failed to create SIF image: %s", err)
+	}
+	fimg.UnloadContainer()
+}
+
+func TestPGPRecipients(t *testing.T) {
+	test.DropPrivilege(t)
+	defer test.ResetPrivilege(t)
+
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory")
+	}
+	defer os.RemoveAll(dir)
+
+	alice := newTestEntity(t, "alice")
+	bob := newTestEntity(t, "bob")
+	carol := newTestEntity(t, "carol")
+
+	image := filepath.Join(dir, "image.sif")
+	plaintext, err := NewPlaintextKey(KeyInfo{Format: PGP})
+	if err != nil {
+		t.Fatalf("failed to generate key: %s", err)
+	}
+	data, err := EncryptKey(KeyInfo{Format: PGP, Keys: openpgp.EntityList{alice, bob}}, plaintext)
+	if err != nil {
+		t.Fatalf("failed to encrypt key: %s", err)
+	}
+	createEncryptedSIF(t, image, PGP, data)
+
+	if ok, err := HasPGPKey(image); err != nil || !ok {
+		t.Fatalf("PGP key not found: %v", err)
+	}
+
+	checkDecrypt := func(name string, e *openpgp.Entity, expected error) {
+		t.Helper()
+
+		key, err := PlaintextKey(KeyInfo{Format: PGP, Keys: openpgp.EntityList{e}}, image)
+		if expected != nil {
+			if pkgerrors.Cause(err) != expected {
+				t.Errorf("%s: unexpected error %v, expected %s", name, err, expected)
+			}
+		} else if err != nil {
+			t.Errorf("%s: failed to decrypt key: %s", name, err)
+		} else if !bytes.Equal(key, plaintext) {
+			t.Errorf("%s: decrypted key doesn't match", name)
+		}
+	}
+
+	checkDecrypt("alice", alice, nil)
+	checkDecrypt("bob", bob, nil)
+	checkDecrypt("carol", carol, ErrNoMatchingKey)
+
+	// only a recipient can add recipients
+	if _, err := AddPGPRecipients(image, openpgp.EntityList{carol}, nil, openpgp.EntityList{carol}); pkgerrors.Cause(err) != ErrNoMatchingKey {
+		t.Errorf("unexpected error adding a recipient without a matching key: %v", err)
+	}
+
+	// the private keys of bob are encrypted and decrypted by the prompt
+	if err := bob.PrivateKey.Encrypt([]byte("passphrase")); err != nil {
+		t.Fatalf("failed to encrypt private key: %s", err)
+	}
+	for _, subkey := range bob.Subkeys {
+		if err := subkey.PrivateKey.Encrypt([]byte("passphrase")); err != nil {
+			t.Fatalf("failed to encrypt private subkey: %s", err)
+		}
+	}
+	prompted := false
+	prompt := func(keys []openpgp.Key, symmetric bool) ([]byte, error) {
+		prompted = true
+		for _, k := range keys {
+			if err := k.PrivateKey.Decrypt([]byte("passphrase")); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	}
+
+	n, err := AddPGPRecipients(image, openpgp.EntityList{bob}, prompt, openpgp.EntityList{carol, alice})
+	if err != nil {
+		t.Fatalf("failed to add recipient: %s", err)
+	} else if n != 1 {
+		t.Errorf("unexpected number of recipients added: %d", n)
+	}
+	if !prompted {
+		t.Errorf("private key decrypted without prompt")
+	}
+	checkDecrypt("carol added", carol, nil)
+	checkDecrypt("alice added", alice, nil)
+
+	if n, err := AddPGPRecipients(image, openpgp.EntityList{alice}, nil, openpgp.EntityList{carol}); err != nil || n != 0 {
+		t.Errorf("unexpected result adding an existing recipient: %d, %v", n, err)
+	}
+
+	n, err = RemovePGPRecipients(image, openpgp.EntityList{alice})
+	if err != nil {
+		t.Fatalf("failed to remove recipient: %s", err)
+	} else if n != 1 {
+		t.Errorf("unexpected number of recipients removed: %d", n)
+	}
+	checkDecrypt("alice removed", alice, ErrNoMatchingKey)
+	checkDecrypt("bob kept", bob, nil)
+	checkDecrypt("carol kept", carol, nil)
+
+	if _, err := RemovePGPRecipients(image, openpgp.EntityList{alice}); pkgerrors.Cause(err) != ErrRecipientNotFound {
+		t.Errorf("unexpected error removing a missing recipient: %v", err)
+	}
+	if _, err := RemovePGPRecipients(image, openpgp.EntityList{bob, carol}); pkgerrors.Cause(err) != ErrLastRecipient {
+		t.Errorf("unexpected error removing all recipients: %v", err)
+	}
+
+	// the previous messages are zeroed, a single message is left
+	fimg, err := sif.LoadContainer(image, true)
+	if err != nil {
+		t.Fatalf("failed to load SIF image: %s", err)
+	}
+	defer fimg.UnloadContainer()
+
+	descrs, _, err := fimg.GetLinkedDescrsByType(1, sif.DataCryptoMessage)
+	if err != nil {
+		t.Fatalf("failed to get cryptographic messages: %s", err)
+	}
+	if len(descrs) != 1 {
+		t.Errorf("unexpected number of cryptographic messages: %d", len(descrs))
+	}
+}
+
+func TestEncryptPGPKey(t *testing.T) {
+	test.DropPrivilege(t)
+	defer test.ResetPrivilege(t)
+
+	if _, err := encryptPGPKey(nil, []byte("key")); err != ErrNoRecipients {
+		t.Errorf("unexpected error without recipients: %v", err)
+	}
+
+	// keys without an RSA encryption key can't be recipients
+	e := newTestEntity(t, "alice")
+	e.Subkeys = nil
+	if _, err := encryptPGPKey(openpgp.EntityList{e}, []byte("key")); pkgerrors.Cause(err) != ErrNoEncryptionKey {
+		t.Errorf("unexpected error without encryption key: %v", err)
+	}
+}
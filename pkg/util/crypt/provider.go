@@ -0,0 +1,148 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package crypt
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sylabs/sif/pkg/sif"
+)
+
+// DefaultKeyEnv is the environment variable read by the env key provider
+// when no variable name is given.
+const DefaultKeyEnv = "SINGULARITY_ENCRYPTION_KEY"
+
+var (
+	ErrUnknownKeyProvider = errors.New("unknown key provider")
+	ErrNoKeyHelper        = errors.New("no key provider helper configured")
+	ErrEmptyKey           = errors.New("key provider returned an empty key")
+)
+
+// KeyProvider returns the key information of encrypted images without
+// user interaction.
+type KeyProvider interface {
+	// Key returns the key information for the image identified by
+	// imageID, imageID is empty for images being created.
+	Key(imageID string) (KeyInfo, error)
+}
+
+// ExecProvider runs a helper program receiving the image ID on its
+// standard input and writing the key on its standard output.
+type ExecProvider struct {
+	Path string
+}
+
+// Key implements KeyProvider.
+func (p *ExecProvider) Key(imageID string) (KeyInfo, error) {
+	var stdout bytes.Buffer
+
+	cmd := exec.Command(p.Path)
+	cmd.Stdin = strings.NewReader(imageID + "\n")
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return KeyInfo{}, errors.Wrapf(err, "running key provider helper %s", p.Path)
+	}
+	return parseProvidedKey(stdout.Bytes())
+}
+
+// EnvProvider reads the key from the environment variable Name.
+type EnvProvider struct {
+	Name string
+}
+
+// Key implements KeyProvider.
+func (p *EnvProvider) Key(imageID string) (KeyInfo, error) {
+	return parseProvidedKey([]byte(os.Getenv(p.Name)))
+}
+
+// FdProvider reads the key from the file descriptor Fd, the file
+// descriptor is closed after reading.
+type FdProvider struct {
+	Fd int
+}
+
+// Key implements KeyProvider.
+func (p *FdProvider) Key(imageID string) (KeyInfo, error) {
+	f := os.NewFile(uintptr(p.Fd), "key-provider")
+	if f == nil {
+		return KeyInfo{}, errors.Errorf("invalid file descriptor %d", p.Fd)
+	}
+	defer f.Close()
+
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		return KeyInfo{}, errors.Wrapf(err, "reading key from file descriptor %d", p.Fd)
+	}
+	return parseProvidedKey(b)
+}
+
+// parseProvidedKey returns the key information of a key returned by a
+// provider, PEM encoded RSA keys are returned with the PEM format and
+// anything else is a passphrase.
+func parseProvidedKey(b []byte) (KeyInfo, error) {
+	key := strings.TrimRight(string(b), "\r\n")
+	if key == "" {
+		return KeyInfo{}, ErrEmptyKey
+	}
+	if strings.HasPrefix(key, "-----BEGIN ") {
+		return KeyInfo{Format: PEM, Material: key}, nil
+	}
+	return KeyInfo{Format: Passphrase, Material: key}, nil
+}
+
+// NewKeyProvider returns the key provider described by spec, one of:
+//
+//	exec[:path]  runs the helper path, or helper if no path is given
+//	env[:name]   reads the environment variable name, or DefaultKeyEnv
+//	fd:n         reads the file descriptor n
+func NewKeyProvider(spec, helper string) (KeyProvider, error) {
+	name, arg := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		name, arg = spec[:i], spec[i+1:]
+	}
+
+	switch name {
+	case "exec":
+		if arg == "" {
+			arg = helper
+		}
+		if arg == "" {
+			return nil, ErrNoKeyHelper
+		}
+		return &ExecProvider{Path: arg}, nil
+	case "env":
+		if arg == "" {
+			arg = DefaultKeyEnv
+		}
+		return &EnvProvider{Name: arg}, nil
+	case "fd":
+		fd, err := strconv.Atoi(arg)
+		if err != nil || fd < 0 {
+			return nil, errors.Errorf("invalid file descriptor %q for key provider fd", arg)
+		}
+		return &FdProvider{Fd: fd}, nil
+	}
+	return nil, errors.Wrapf(ErrUnknownKeyProvider, "%q", name)
+}
+
+// ImageID returns the ID of the SIF image fn passed to key providers.
+func ImageID(fn string) (string, error) {
+	img, err := sif.LoadContainer(fn, true)
+	if err != nil {
+		return "", errors.Wrapf(err, "loading container image from %s", fn)
+	}
+	defer img.UnloadContainer()
+
+	return img.Header.ID.String(), nil
+}
@@ -0,0 +1,179 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package crypt
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/sylabs/singularity/internal/pkg/test"
+)
+
+func TestNewKeyProvider(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		helper  string
+		want    KeyProvider
+		wantErr bool
+	}{
+		{"exec path", "exec:/bin/helper", "", &ExecProvider{Path: "/bin/helper"}, false},
+		{"exec configured helper", "exec", "/etc/helper", &ExecProvider{Path: "/etc/helper"}, false},
+		{"exec path over configured helper", "exec:/bin/helper", "/etc/helper", &ExecProvider{Path: "/bin/helper"}, false},
+		{"exec without helper", "exec", "", nil, true},
+		{"env default", "env", "", &EnvProvider{Name: DefaultKeyEnv}, false},
+		{"env variable", "env:MY_KEY", "", &EnvProvider{Name: "MY_KEY"}, false},
+		{"fd", "fd:3", "", &FdProvider{Fd: 3}, false},
+		{"fd without number", "fd", "", nil, true},
+		{"fd invalid number", "fd:-1", "", nil, true},
+		{"unknown", "vault", "", nil, true},
+	}
+
+	for _, tt := range tests {
+		p, err := NewKeyProvider(tt.spec, tt.helper)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+
+		switch want := tt.want.(type) {
+		case *ExecProvider:
+			if got, ok := p.(*ExecProvider); !ok || *got != *want {
+				t.Errorf("%s: got %#v, expected %#v", tt.name, p, want)
+			}
+		case *EnvProvider:
+			if got, ok := p.(*EnvProvider); !ok || *got != *want {
+				t.Errorf("%s: got %#v, expected %#v", tt.name, p, want)
+			}
+		case *FdProvider:
+			if got, ok := p.(*FdProvider); !ok || *got != *want {
+				t.Errorf("%s: got %#v, expected %#v", tt.name, p, want)
+			}
+		}
+	}
+}
+
+func TestKeyProviders(t *testing.T) {
+	test.DropPrivilege(t)
+	defer test.ResetPrivilege(t)
+
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory")
+	}
+	defer os.RemoveAll(dir)
+
+	priv, pub := writePEMKeys(t, dir, "provider")
+	pemKey, err := ioutil.ReadFile(priv.Path)
+	if err != nil {
+		t.Fatalf("failed to read private key: %s", err)
+	}
+
+	plaintext, err := NewPlaintextKey(pub)
+	if err != nil {
+		t.Fatalf("failed to generate key: %s", err)
+	}
+	data, err := EncryptKey(pub, plaintext)
+	if err != nil {
+		t.Fatalf("failed to encrypt key: %s", err)
+	}
+	image := filepath.Join(dir, "image.sif")
+	createEncryptedSIF(t, image, PEM, data)
+
+	imageID, err := ImageID(image)
+	if err != nil {
+		t.Fatalf("failed to get image ID: %s", err)
+	}
+
+	// the helper returns the private key for the image ID only
+	helper := filepath.Join(dir, "helper.sh")
+	script := "#!/bin/sh\nread id\nif [ \"$id\" = \"" + imageID + "\" ]; then cat " + priv.Path + "; else echo wrong passphrase; fi\n"
+	if err := ioutil.WriteFile(helper, []byte(script), 0700); err != nil {
+		t.Fatalf("failed to write helper: %s", err)
+	}
+	failing := filepath.Join(dir, "failing.sh")
+	if err := ioutil.WriteFile(failing, []byte("#!/bin/sh\nexit 1\n"), 0700); err != nil {
+		t.Fatalf("failed to write helper: %s", err)
+	}
+
+	os.Setenv("TEST_KEY_PROVIDER", string(pemKey))
+	defer os.Unsetenv("TEST_KEY_PROVIDER")
+	os.Setenv("TEST_KEY_PROVIDER_PASSPHRASE", "passphrase\n")
+	defer os.Unsetenv("TEST_KEY_PROVIDER_PASSPHRASE")
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("failed to create pipe: %s", err)
+	}
+	w.Write(pemKey)
+	w.Close()
+	// the provider closes the file descriptor, give it a
+	// duplicate not owned by r
+	fd, err := syscall.Dup(int(r.Fd()))
+	if err != nil {
+		t.Fatalf("failed to duplicate file descriptor: %s", err)
+	}
+	r.Close()
+
+	// Unknown format means an error is expected, passphrases are
+	// compared with wantPassphrase and PEM keys must unwrap the key
+	tests := []struct {
+		name           string
+		provider       KeyProvider
+		imageID        string
+		wantFormat     int
+		wantPassphrase string
+		wantErr        error
+	}{
+		{"exec", &ExecProvider{Path: helper}, imageID, PEM, "", nil},
+		{"exec other image", &ExecProvider{Path: helper}, "other", Passphrase, "wrong passphrase", nil},
+		{"exec failing helper", &ExecProvider{Path: failing}, imageID, Unknown, "", nil},
+		{"env", &EnvProvider{Name: "TEST_KEY_PROVIDER"}, imageID, PEM, "", nil},
+		{"env passphrase", &EnvProvider{Name: "TEST_KEY_PROVIDER_PASSPHRASE"}, imageID, Passphrase, "passphrase", nil},
+		{"env unset", &EnvProvider{Name: "TEST_KEY_PROVIDER_UNSET"}, imageID, Unknown, "", ErrEmptyKey},
+		{"fd", &FdProvider{Fd: fd}, imageID, PEM, "", nil},
+	}
+
+	for _, tt := range tests {
+		k, err := tt.provider.Key(tt.imageID)
+		if tt.wantFormat == Unknown {
+			if err == nil {
+				t.Errorf("%s: unexpected success", tt.name)
+			} else if tt.wantErr != nil && err != tt.wantErr {
+				t.Errorf("%s: unexpected error %v, expected %v", tt.name, err, tt.wantErr)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.name, err)
+			continue
+		}
+		if k.Format != tt.wantFormat {
+			t.Errorf("%s: got key format %d, expected %d", tt.name, k.Format, tt.wantFormat)
+			continue
+		}
+
+		if k.Format == Passphrase {
+			if k.Material != tt.wantPassphrase {
+				t.Errorf("%s: got passphrase %q, expected %q", tt.name, k.Material, tt.wantPassphrase)
+			}
+			continue
+		}
+
+		key, err := PlaintextKey(k, image)
+		if err != nil {
+			t.Errorf("%s: failed to decrypt key: %s", tt.name, err)
+		} else if !bytes.Equal(key, plaintext) {
+			t.Errorf("%s: decrypted key doesn't match", tt.name)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package crypt
+
+import (
+	"bytes"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+	"github.com/sylabs/sif/pkg/sif"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+var (
+	ErrRekeyRecipients       = errors.New("the key of the image is wrapped for PGP recipients, use add-recipient and remove-recipient instead")
+	ErrRekeyFormatMismatch   = errors.New("old key format doesn't match the encrypted key of the image")
+	ErrEmptyPassphrase       = errors.New("empty passphrase")
+	errPassphraseAlreadyUsed = errors.New("passphrase already tried")
+)
+
+// encryptPassphraseKey returns an OpenPGP message holding plaintext
+// encrypted with passphrase.
+func encryptPassphraseKey(passphrase string, plaintext []byte) ([]byte, error) {
+	if passphrase == "" {
+		return nil, ErrEmptyPassphrase
+	}
+
+	var buf bytes.Buffer
+
+	config := &packet.Config{DefaultCipher: packet.CipherAES256}
+	w, err := openpgp.SymmetricallyEncrypt(&buf, []byte(passphrase), nil, config)
+	if err != nil {
+		return nil, errors.Wrap(err, "encrypting key")
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		return nil, errors.Wrap(err, "encrypting key")
+	}
+	if err := w.Close(); err != nil {
+		return nil, errors.Wrap(err, "encrypting key")
+	}
+
+	return buf.Bytes(), nil
+}
+
+// decryptPassphraseKey returns the key held by the OpenPGP message msg
+// decrypted with passphrase.
+func decryptPassphraseKey(msg []byte, passphrase string) ([]byte, error) {
+	// openpgp.ReadMessage prompts again while the passphrase is wrong
+	tried := false
+	prompt := func(keys []openpgp.Key, symmetric bool) ([]byte, error) {
+		if !symmetric || tried {
+			return nil, errPassphraseAlreadyUsed
+		}
+		tried = true
+		return []byte(passphrase), nil
+	}
+
+	md, err := openpgp.ReadMessage(bytes.NewReader(msg), nil, prompt, nil)
+	if err == errPassphraseAlreadyUsed {
+		return nil, ErrKeyMismatch
+	} else if err != nil {
+		return nil, errors.Wrap(err, "reading OpenPGP message")
+	}
+
+	plaintext, err := ioutil.ReadAll(md.UnverifiedBody)
+	if err != nil {
+		// a wrong key may pass the quick check of the encrypted data
+		return nil, errors.Wrap(ErrKeyMismatch, err.Error())
+	}
+	return plaintext, nil
+}
+
+// imageKeyFormat returns the key format unwrapping the filesystem key of the
+// SIF image fn. Unknown is returned for images encrypted with the passphrase
+// as filesystem key.
+func imageKeyFormat(fn string) (int, error) {
+	img, err := sif.LoadContainer(fn, true)
+	if err != nil {
+		return Unknown, errors.Wrapf(err, "loading container image from %s", fn)
+	}
+	defer img.UnloadContainer()
+
+	primDescr, _, err := img.GetPartPrimSys()
+	if err != nil {
+		return Unknown, errors.Wrapf(err, "retrieving primary system partition from %s", fn)
+	}
+	fstype, err := primDescr.GetFsType()
+	if err != nil {
+		return Unknown, errors.Wrapf(err, "retrieving primary system partition type from %s", fn)
+	}
+	if fstype != sif.FsEncryptedSquashfs {
+		return Unknown, errors.Errorf("%s is not an encrypted image", fn)
+	}
+
+	format, _, err := findCryptoMessage(&img, primaryPartition)
+	if err != nil {
+		return Unknown, errors.Wrapf(err, "reading from %s", fn)
+	}
+	return format, nil
+}
+
+// checkBuildPassphrase returns ErrKeyMismatch if passphrase isn't the
+// filesystem key of the primary system partition of the SIF image fn.
+func checkBuildPassphrase(fn, passphrase string) error {
+	img, err := sif.LoadContainer(fn, true)
+	if err != nil {
+		return errors.Wrapf(err, "loading container image from %s", fn)
+	}
+	defer img.UnloadContainer()
+
+	part, err := primaryPartition(&img)
+	if err != nil {
+		return errors.Wrapf(err, "reading from %s", fn)
+	}
+
+	dev := &Device{}
+	err = dev.TestKey([]byte(passphrase), fn, part.Fileoff, part.Filelen)
+	if err == ErrInvalidPassphrase {
+		return ErrKeyMismatch
+	}
+	return err
+}
+
+// Rekey replaces the wrapped filesystem key of the encrypted SIF image by
+// the same key wrapped with newKey, oldKey must unwrap the current key. The
+// encrypted partition is left untouched: the passphrase of an image built
+// with a passphrase remains its filesystem key, it is only wrapped with
+// newKey and no longer accepted as key by PlaintextKey.
+func Rekey(image string, oldKey, newKey KeyInfo) error {
+	format, err := imageKeyFormat(image)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case PGP:
+		return ErrRekeyRecipients
+	case Unknown:
+		if oldKey.Format != Passphrase {
+			return ErrRekeyFormatMismatch
+		}
+		// nothing else would catch a wrong passphrase before it is wrapped
+		if err := checkBuildPassphrase(image, oldKey.Material); err != nil {
+			return errors.Wrap(err, "checking the old passphrase")
+		}
+	default:
+		if oldKey.Format != format {
+			return ErrRekeyFormatMismatch
+		}
+	}
+
+	plaintext, err := PlaintextKey(oldKey, image)
+	if err != nil {
+		return errors.Wrap(err, "unwrapping key with the old key")
+	}
+
+	var data []byte
+
+	switch newKey.Format {
+	case PEM:
+		data, err = EncryptKey(newKey, plaintext)
+	case Passphrase:
+		data, err = encryptPassphraseKey(newKey.Material, plaintext)
+	default:
+		err = ErrUnsupportedKeyURI
+	}
+	if err != nil {
+		return errors.Wrap(err, "wrapping key with the new key")
+	}
+
+	if err := replaceCryptoMessage(image, primaryPartition, newKey.Format, data); err != nil {
+		return errors.Wrapf(err, "storing encrypted key in SIF image %s", image)
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package crypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/sylabs/singularity/internal/pkg/test"
+	"github.com/sylabs/singularity/internal/pkg/util/bin"
+	"golang.org/x/crypto/openpgp"
+)
+
+// writePEMKeys generates an RSA key pair and writes its PKCS1 private and
+// public keys to dir.
+func writePEMKeys(t *testing.T, dir, name string) (KeyInfo, KeyInfo) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate RSA key: %s", err)
+	}
+
+	priv := filepath.Join(dir, name+".pem")
+	pub := filepath.Join(dir, name+".pub.pem")
+
+	blocks := map[string]*pem.Block{
+		priv: {Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)},
+		pub:  {Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&key.PublicKey)},
+	}
+	for path, b := range blocks {
+		if err := ioutil.WriteFile(path, pem.EncodeToMemory(b), 0600); err != nil {
+			t.Fatalf("failed to write %s: %s", path, err)
+		}
+	}
+
+	return KeyInfo{Format: PEM, Path: priv}, KeyInfo{Format: PEM, Path: pub}
+}
+
+func TestRekey(t *testing.T) {
+	test.DropPrivilege(t)
+	defer test.ResetPrivilege(t)
+
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory")
+	}
+	defer os.RemoveAll(dir)
+
+	oldPriv, oldPub := writePEMKeys(t, dir, "old")
+	newPriv, newPub := writePEMKeys(t, dir, "new")
+	passphrase := KeyInfo{Format: Passphrase, Material: "new passphrase"}
+	wrongPassphrase := KeyInfo{Format: Passphrase, Material: "wrong passphrase"}
+
+	plaintext, err := NewPlaintextKey(oldPub)
+	if err != nil {
+		t.Fatalf("failed to generate key: %s", err)
+	}
+	data, err := EncryptKey(oldPub, plaintext)
+	if err != nil {
+		t.Fatalf("failed to encrypt key: %s", err)
+	}
+
+	image := filepath.Join(dir, "image.sif")
+	createEncryptedSIF(t, image, PEM, data)
+
+	checkKey := func(name string, k KeyInfo, expected error) {
+		t.Helper()
+
+		key, err := PlaintextKey(k, image)
+		if expected != nil {
+			if errors.Cause(err) != expected {
+				t.Errorf("%s: unexpected error %v, expected %s", name, err, expected)
+			}
+		} else if err != nil {
+			t.Errorf("%s: failed to decrypt key: %s", name, err)
+		} else if !bytes.Equal(key, plaintext) {
+			t.Errorf("%s: decrypted key doesn't match", name)
+		}
+	}
+
+	steps := []struct {
+		name    string
+		oldKey  KeyInfo
+		newKey  KeyInfo
+		wantErr error
+	}{
+		{
+			name:    "wrong old PEM key",
+			oldKey:  newPriv,
+			newKey:  newPub,
+			wantErr: ErrKeyMismatch,
+		},
+		{
+			name:    "old key format mismatch",
+			oldKey:  passphrase,
+			newKey:  newPub,
+			wantErr: ErrRekeyFormatMismatch,
+		},
+		{
+			name:   "PEM to PEM",
+			oldKey: oldPriv,
+			newKey: newPub,
+		},
+		{
+			name:   "PEM to passphrase",
+			oldKey: newPriv,
+			newKey: passphrase,
+		},
+		{
+			name:    "wrong old passphrase",
+			oldKey:  wrongPassphrase,
+			newKey:  oldPub,
+			wantErr: ErrKeyMismatch,
+		},
+		{
+			name:    "empty new passphrase",
+			oldKey:  passphrase,
+			newKey:  KeyInfo{Format: Passphrase},
+			wantErr: ErrEmptyPassphrase,
+		},
+		{
+			name:   "passphrase to PEM",
+			oldKey: passphrase,
+			newKey: oldPub,
+		},
+	}
+
+	for _, s := range steps {
+		err := Rekey(image, s.oldKey, s.newKey)
+		if errors.Cause(err) != s.wantErr {
+			t.Fatalf("%s: unexpected error %v, expected %v", s.name, err, s.wantErr)
+		}
+		if err != nil {
+			continue
+		}
+
+		if s.newKey.Format == PEM {
+			// the private key matching the new public key
+			priv := oldPriv
+			if s.newKey.Path == newPub.Path {
+				priv = newPriv
+			}
+			checkKey(s.name, priv, nil)
+		} else {
+			checkKey(s.name, s.newKey, nil)
+			checkKey(s.name+" wrong passphrase", wrongPassphrase, ErrKeyMismatch)
+		}
+		if s.oldKey.Format == s.newKey.Format {
+			checkKey(s.name+" old key", s.oldKey, ErrKeyMismatch)
+		}
+	}
+
+	// images using PGP recipients can't be rekeyed
+	e, err := openpgp.NewEntity("alice", "", "alice@my.info", nil)
+	if err != nil {
+		t.Fatalf("failed to generate key pair: %s", err)
+	}
+	data, err = encryptPGPKey(openpgp.EntityList{e}, plaintext)
+	if err != nil {
+		t.Fatalf("failed to encrypt key: %s", err)
+	}
+	recipients := filepath.Join(dir, "recipients.sif")
+	createEncryptedSIF(t, recipients, PGP, data)
+	if err := Rekey(recipients, KeyInfo{Format: PGP, Keys: openpgp.EntityList{e}}, oldPub); err != ErrRekeyRecipients {
+		t.Errorf("unexpected error rekeying a PGP image: %v", err)
+	}
+}
+
+func TestRekeyBuildPassphrase(t *testing.T) {
+	cryptsetup, err := bin.Cryptsetup()
+	if err != nil {
+		t.Skipf("cryptsetup not available: %s", err)
+	}
+
+	test.DropPrivilege(t)
+	defer test.ResetPrivilege(t)
+
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory")
+	}
+	defer os.RemoveAll(dir)
+
+	// a LUKS header formatted with the build passphrase
+	luks := filepath.Join(dir, "luks")
+	if err := ioutil.WriteFile(luks, make([]byte, 2*luksHeaderSize), 0600); err != nil {
+		t.Fatalf("failed to create %s: %s", luks, err)
+	}
+	cmd := exec.Command(cryptsetup, "luksFormat", "--batch-mode", "--disable-locks", "--type", "luks2", "--pbkdf-memory", "32", "--key-file", "-", luks)
+	cmd.Stdin = strings.NewReader("build passphrase")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Skipf("failed to format %s: %s: %s", luks, err, out)
+	}
+	data, err := ioutil.ReadFile(luks)
+	if err != nil {
+		t.Fatalf("failed to read %s: %s", luks, err)
+	}
+
+	image := filepath.Join(dir, "image.sif")
+	createEncryptedPartSIF(t, image, data, Passphrase, nil)
+
+	build := KeyInfo{Format: Passphrase, Material: "build passphrase"}
+	wrong := KeyInfo{Format: Passphrase, Material: "wrong passphrase"}
+	newKey := KeyInfo{Format: Passphrase, Material: "new passphrase"}
+
+	if err := Rekey(image, wrong, newKey); errors.Cause(err) != ErrKeyMismatch {
+		t.Fatalf("unexpected error rekeying with a wrong passphrase: %v", err)
+	}
+	if err := Rekey(image, build, newKey); err != nil {
+		t.Fatalf("failed to rekey image built with a passphrase: %s", err)
+	}
+
+	key, err := PlaintextKey(newKey, image)
+	if err != nil {
+		t.Fatalf("failed to decrypt key: %s", err)
+	} else if !bytes.Equal(key, []byte(build.Material)) {
+		t.Errorf("decrypted key doesn't match the build passphrase")
+	}
+	if _, err := PlaintextKey(build, image); errors.Cause(err) != ErrKeyMismatch {
+		t.Errorf("unexpected error using the build passphrase after rekey: %v", err)
+	}
+}
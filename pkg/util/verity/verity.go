@@ -0,0 +1,252 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// Package verity computes dm-verity hash trees of filesystem images and
+// opens the verity devices checking every block read from them.
+package verity
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"syscall"
+
+	"github.com/sylabs/singularity/pkg/image"
+)
+
+const (
+	// ParamsName is the name of the SIF data object holding the
+	// verity parameters of a system partition.
+	ParamsName = "verity-params.json"
+	// HashTreeName is the name of the SIF data object holding the
+	// verity hash tree of a system partition.
+	HashTreeName = "verity-hashtree"
+	// BlockSize is the size of both the data and the hash blocks.
+	BlockSize = 4096
+
+	// hashType is the on-disk format of the hash tree, the type 1 of
+	// veritysetup salts the data before hashing it
+	hashType  = 1
+	algorithm = "sha256"
+	saltSize  = 32
+)
+
+// Params holds the parameters of a hash tree, they are stored along
+// with the tree and are required to open the verity device.
+type Params struct {
+	HashType      int    `json:"hashType"`
+	Algorithm     string `json:"algorithm"`
+	DataBlockSize uint32 `json:"dataBlockSize"`
+	HashBlockSize uint32 `json:"hashBlockSize"`
+	DataBlocks    uint64 `json:"dataBlocks"`
+	Salt          string `json:"salt"`
+	RootHash      string `json:"rootHash"`
+}
+
+// DataSize returns the size of a filesystem image of size bytes once
+// padded with zeroes to be protected by a hash tree: a multiple of the
+// block size, and two blocks at least so that the tree isn't empty.
+func DataSize(size int64) int64 {
+	if size < 2*BlockSize {
+		return 2 * BlockSize
+	}
+	return (size + BlockSize - 1) / BlockSize * BlockSize
+}
+
+// HashTree reads the size bytes of a filesystem image from r and returns
+// its hash tree with the parameters describing it. The size must be
+// as returned by DataSize.
+func HashTree(r io.Reader, size int64) ([]byte, *Params, error) {
+	if size != DataSize(size) {
+		return nil, nil, fmt.Errorf("image size %d is not a multiple of %d bytes", size, BlockSize)
+	}
+
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, nil, fmt.Errorf("while generating salt: %s", err)
+	}
+
+	dataBlocks := uint64(size / BlockSize)
+
+	tree, root, err := hashTree(r, dataBlocks, salt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	p := &Params{
+		HashType:      hashType,
+		Algorithm:     algorithm,
+		DataBlockSize: BlockSize,
+		HashBlockSize: BlockSize,
+		DataBlocks:    dataBlocks,
+		Salt:          hex.EncodeToString(salt),
+		RootHash:      hex.EncodeToString(root),
+	}
+
+	return tree, p, nil
+}
+
+// digest returns the salted digest of a block.
+func digest(salt, block []byte) []byte {
+	h := sha256.New()
+	h.Write(salt)
+	h.Write(block)
+	return h.Sum(nil)
+}
+
+// hashTree computes the hash tree of dataBlocks blocks read from r in the
+// format of veritysetup without superblock: the digests of the blocks
+// of a level are stored in the hash blocks of the level above, padded with
+// zeroes, up to the level made of a single block whose digest is the
+// root hash. Levels are stored from the top one to the lowest one.
+func hashTree(r io.Reader, dataBlocks uint64, salt []byte) ([]byte, []byte, error) {
+	var digests []byte
+
+	block := make([]byte, BlockSize)
+	for i := uint64(0); i < dataBlocks; i++ {
+		if _, err := io.ReadFull(r, block); err != nil {
+			return nil, nil, fmt.Errorf("while reading data block %d: %s", i, err)
+		}
+		digests = append(digests, digest(salt, block)...)
+	}
+
+	var levels [][]byte
+	var root []byte
+
+	for root == nil {
+		// hash blocks of the level are zero padded
+		size := (len(digests) + BlockSize - 1) / BlockSize * BlockSize
+		level := make([]byte, size)
+		copy(level, digests)
+		levels = append(levels, level)
+
+		digests = nil
+		for off := 0; off < len(level); off += BlockSize {
+			digests = append(digests, digest(salt, level[off:off+BlockSize])...)
+		}
+		if len(level) == BlockSize {
+			root = digests
+		}
+	}
+
+	var tree []byte
+	for i := len(levels) - 1; i >= 0; i-- {
+		tree = append(tree, levels[i]...)
+	}
+
+	return tree, root, nil
+}
+
+// TreeSize returns the size of the hash tree of dataBlocks blocks.
+func TreeSize(dataBlocks uint64) uint64 {
+	hashes := uint64(BlockSize / sha256.Size)
+
+	var blocks uint64
+	for n := dataBlocks; ; {
+		n = (n + hashes - 1) / hashes
+		blocks += n
+		if n == 1 {
+			break
+		}
+	}
+
+	return blocks * BlockSize
+}
+
+// check ensures the parameters describe a hash tree computed by HashTree
+// for a data partition of dataSize bytes and a tree of treeSize bytes.
+func (p *Params) check(dataSize, treeSize uint64) error {
+	if p.HashType != hashType || p.Algorithm != algorithm {
+		return fmt.Errorf("unsupported hash type %d with algorithm %s", p.HashType, p.Algorithm)
+	}
+	if p.DataBlockSize != BlockSize || p.HashBlockSize != BlockSize {
+		return fmt.Errorf("unsupported block sizes %d and %d", p.DataBlockSize, p.HashBlockSize)
+	}
+	if p.DataBlocks < 2 || p.DataBlocks*BlockSize != dataSize {
+		return fmt.Errorf("%d data blocks don't match a partition of %d bytes", p.DataBlocks, dataSize)
+	}
+	if TreeSize(p.DataBlocks) != treeSize {
+		return fmt.Errorf("hash tree of %d bytes doesn't match %d data blocks", treeSize, p.DataBlocks)
+	}
+	if salt, err := hex.DecodeString(p.Salt); err != nil || len(salt) == 0 {
+		return fmt.Errorf("invalid salt %q", p.Salt)
+	}
+	if root, err := hex.DecodeString(p.RootHash); err != nil || len(root) != sha256.Size {
+		return fmt.Errorf("invalid root hash %q", p.RootHash)
+	}
+	return nil
+}
+
+// FromImage returns the verity parameters and the section holding the hash
+// tree of the root filesystem partition of a SIF image. Nil is returned
+// if the partition isn't protected by a hash tree.
+func FromImage(img *image.Image) (*Params, *image.Section, error) {
+	if img.Type != image.SIF || len(img.Partitions) == 0 || img.Partitions[0].Type != image.SQUASHFS {
+		return nil, nil, nil
+	}
+
+	var params, tree *image.Section
+	for i, s := range img.Sections {
+		switch s.Name {
+		case ParamsName:
+			params = &img.Sections[i]
+		case HashTreeName:
+			tree = &img.Sections[i]
+		}
+	}
+	if params == nil && tree == nil {
+		return nil, nil, nil
+	} else if params == nil || tree == nil {
+		return nil, nil, fmt.Errorf("image contains an incomplete verity hash tree")
+	}
+
+	data := make([]byte, params.Size)
+	if err := readAt(img, data, int64(params.Offset)); err != nil {
+		return nil, nil, fmt.Errorf("while reading verity parameters: %s", err)
+	}
+
+	p, err := ParseParams(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := p.check(img.Partitions[0].Size, tree.Size); err != nil {
+		return nil, nil, fmt.Errorf("invalid verity parameters: %s", err)
+	}
+
+	return p, tree, nil
+}
+
+// ParseParams decodes the verity parameters data stored in a SIF image.
+func ParseParams(data []byte) (*Params, error) {
+	p := new(Params)
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(p); err != nil {
+		return nil, fmt.Errorf("while decoding verity parameters: %s", err)
+	}
+	return p, nil
+}
+
+// readAt reads len(data) bytes of the image at offset off, through the
+// image file descriptor when the image file isn't set as it's the case
+// once the image list was passed to the runtime.
+func readAt(img *image.Image, data []byte, off int64) error {
+	if img.File != nil {
+		_, err := img.File.ReadAt(data, off)
+		return err
+	}
+
+	n, err := syscall.Pread(int(img.Fd), data, off)
+	if err != nil {
+		return err
+	} else if n != len(data) {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package verity
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+	"github.com/sylabs/singularity/internal/pkg/util/bin"
+	"github.com/sylabs/singularity/pkg/util/fs/lock"
+)
+
+// Device describes a verity device
+type Device struct{}
+
+// Open opens a verity device checking the blocks read from the data device
+// against the hash tree of the hash device, both are usually loop devices,
+// and returns the name assigned to it that can be later used to close the
+// device.
+func (v *Device) Open(dataDev, hashDev string, p *Params) (string, error) {
+	fd, err := lock.Exclusive("/dev/mapper")
+	if err != nil {
+		return "", fmt.Errorf("unable to acquire lock on /dev/mapper")
+	}
+	defer lock.Release(fd)
+
+	maxRetries := 3 // Arbitrary number of retries.
+
+	veritysetup, err := bin.Veritysetup()
+	if err != nil {
+		return "", err
+	}
+
+	for i := 0; i < maxRetries; i++ {
+		name := uuid.NewV4().String()
+
+		cmd := exec.Command(veritysetup, "open",
+			"--no-superblock",
+			"--format", strconv.Itoa(p.HashType),
+			"--hash", p.Algorithm,
+			"--data-block-size", strconv.FormatUint(uint64(p.DataBlockSize), 10),
+			"--hash-block-size", strconv.FormatUint(uint64(p.HashBlockSize), 10),
+			"--data-blocks", strconv.FormatUint(p.DataBlocks, 10),
+			"--salt", p.Salt,
+			dataDev, name, hashDev, p.RootHash)
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+		cmd.SysProcAttr.Credential = &syscall.Credential{Uid: 0, Gid: 0}
+		sylog.Debugf("Running %s %s", cmd.Path, strings.Join(cmd.Args, " "))
+
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			if strings.Contains(string(out), "Device already exists") {
+				continue
+			}
+			return "", fmt.Errorf("veritysetup open failed: %s: %v", string(out), err)
+		}
+		sylog.Debugf("Successfully opened verity device for %s", dataDev)
+		return name, nil
+	}
+
+	return "", errors.New("unable to open verity device")
+}
+
+// Close closes the verity device
+func (v *Device) Close(name string) error {
+	veritysetup, err := bin.Veritysetup()
+	if err != nil {
+		return err
+	}
+
+	fd, err := lock.Exclusive("/dev/mapper")
+	if err != nil {
+		return err
+	}
+	defer lock.Release(fd)
+
+	cmd := exec.Command(veritysetup, "close", name)
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Credential: &syscall.Credential{Uid: 0, Gid: 0},
+	}
+	sylog.Debugf("Running %s %s", cmd.Path, strings.Join(cmd.Args, " "))
+	if err := cmd.Run(); err != nil {
+		sylog.Debugf("Unable to delete the verity device %s", err)
+		return err
+	}
+
+	return nil
+}
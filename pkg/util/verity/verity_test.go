@@ -0,0 +1,167 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package verity
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/sylabs/singularity/pkg/image"
+)
+
+func TestDataSize(t *testing.T) {
+	tests := []struct {
+		size     int64
+		expected int64
+	}{
+		{1, 2 * BlockSize},
+		{BlockSize, 2 * BlockSize},
+		{2 * BlockSize, 2 * BlockSize},
+		{2*BlockSize + 1, 3 * BlockSize},
+		{100 * BlockSize, 100 * BlockSize},
+	}
+	for _, tt := range tests {
+		if size := DataSize(tt.size); size != tt.expected {
+			t.Errorf("unexpected data size for %d: got %d instead of %d", tt.size, size, tt.expected)
+		}
+	}
+}
+
+// testData returns blocks data blocks filled with their index.
+func testData(blocks int) []byte {
+	data := make([]byte, blocks*BlockSize)
+	for i := range data {
+		data[i] = byte(i / BlockSize)
+	}
+	return data
+}
+
+func TestHashTree(t *testing.T) {
+	salt := []byte("salt")
+	hashes := BlockSize / 32
+
+	// a single level tree
+	data := testData(2)
+	tree, root, err := hashTree(bytes.NewReader(data), 2, salt)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	level := make([]byte, BlockSize)
+	copy(level, digest(salt, data[:BlockSize]))
+	copy(level[32:], digest(salt, data[BlockSize:]))
+	if !bytes.Equal(tree, level) {
+		t.Errorf("unexpected hash tree")
+	}
+	if !bytes.Equal(root, digest(salt, level)) {
+		t.Errorf("unexpected root hash")
+	}
+
+	// a two levels tree, the top level is stored first
+	blocks := hashes + 1
+	data = testData(blocks)
+	tree, root, err = hashTree(bytes.NewReader(data), uint64(blocks), salt)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if uint64(len(tree)) != TreeSize(uint64(blocks)) || len(tree) != 3*BlockSize {
+		t.Fatalf("unexpected hash tree size %d", len(tree))
+	}
+	lower := make([]byte, 2*BlockSize)
+	for i := 0; i < blocks; i++ {
+		copy(lower[i*32:], digest(salt, data[i*BlockSize:(i+1)*BlockSize]))
+	}
+	top := make([]byte, BlockSize)
+	copy(top, digest(salt, lower[:BlockSize]))
+	copy(top[32:], digest(salt, lower[BlockSize:]))
+	if !bytes.Equal(tree[:BlockSize], top) || !bytes.Equal(tree[BlockSize:], lower) {
+		t.Errorf("unexpected hash tree")
+	}
+	if !bytes.Equal(root, digest(salt, top)) {
+		t.Errorf("unexpected root hash")
+	}
+
+	// data is missing
+	if _, _, err := hashTree(bytes.NewReader(data[:BlockSize]), 2, salt); err == nil {
+		t.Errorf("unexpected success with truncated data")
+	}
+}
+
+func TestFromImage(t *testing.T) {
+	data := testData(3)
+	tree, p, err := HashTree(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := hex.DecodeString(p.RootHash); err != nil {
+		t.Fatalf("unexpected root hash %q", p.RootHash)
+	}
+	if _, _, err := HashTree(bytes.NewReader(data), int64(len(data)-1)); err == nil {
+		t.Errorf("unexpected success with a size which isn't a multiple of the block size")
+	}
+
+	params, err := json.Marshal(p)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	f, err := ioutil.TempFile("", "verity-")
+	if err != nil {
+		t.Fatalf("failed to create temporary file: %s", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	content := append(append(append([]byte{}, data...), tree...), params...)
+	if _, err := f.Write(content); err != nil {
+		t.Fatalf("failed to write image: %s", err)
+	}
+
+	newImage := func(sections ...image.Section) *image.Image {
+		return &image.Image{
+			Type: image.SIF,
+			File: f,
+			Partitions: []image.Section{
+				{Offset: 0, Size: uint64(len(data)), Type: image.SQUASHFS, Name: image.RootFs},
+			},
+			Sections: sections,
+		}
+	}
+	treeSection := image.Section{Offset: uint64(len(data)), Size: uint64(len(tree)), Name: HashTreeName}
+	paramsSection := image.Section{Offset: uint64(len(data) + len(tree)), Size: uint64(len(params)), Name: ParamsName}
+
+	got, section, err := FromImage(newImage(treeSection, paramsSection))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if *got != *p || *section != treeSection {
+		t.Errorf("unexpected verity parameters %+v and section %+v", got, section)
+	}
+
+	if got, _, err := FromImage(newImage()); err != nil || got != nil {
+		t.Errorf("unexpected verity parameters for an image without hash tree: %+v: %v", got, err)
+	}
+	// the image is read through its file descriptor
+	img := newImage(treeSection, paramsSection)
+	img.File, img.Fd = nil, f.Fd()
+	if got, _, err := FromImage(img); err != nil || *got != *p {
+		t.Errorf("unexpected verity parameters %+v: %v", got, err)
+	}
+
+	if _, _, err := FromImage(newImage(paramsSection)); err == nil {
+		t.Errorf("unexpected success with a missing hash tree")
+	}
+
+	// the tree doesn't match the partition size
+	img = newImage(treeSection, paramsSection)
+	img.Partitions[0].Size -= BlockSize
+	if _, _, err := FromImage(img); err == nil {
+		t.Errorf("unexpected success with parameters not matching the partition")
+	}
+}